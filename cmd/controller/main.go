@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+	// Embed the timezone database for workspace schedules
+	_ "time/tzdata"
+
+	"github.com/rahulvramesh/justup/pkg/controller"
+	"github.com/rahulvramesh/justup/pkg/kubernetes"
+	"github.com/rahulvramesh/justup/pkg/scheduler"
+)
+
+func main() {
+	// Parse flags
+	workers := flag.Int("workers", 2, "Number of workspaces reconciled concurrently")
+	resync := flag.Duration("resync", 5*time.Minute, "Interval at which all workspaces are reconciled")
+	flag.Parse()
+
+	// Create Kubernetes client
+	k8sClient, err := kubernetes.NewClient()
+	if err != nil {
+		log.Fatalf("Failed to create Kubernetes client: %v", err)
+	}
+
+	// Create controller
+	ctrl, err := controller.New(&controller.Config{
+		Workers:      *workers,
+		ResyncPeriod: *resync,
+	}, k8sClient)
+	if err != nil {
+		log.Fatalf("Failed to create controller: %v", err)
+	}
+
+	// Handle shutdown gracefully
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
+
+	go func() {
+		sig := <-sigCh
+		log.Printf("Received signal %v, shutting down...", sig)
+		cancel()
+	}()
+
+	// Apply workspace start/stop schedules
+	go scheduler.New(k8sClient).Run(ctx)
+
+	log.Printf("Starting workspace controller with %d workers", *workers)
+	if err := ctrl.Run(ctx); err != nil {
+		log.Fatalf("Controller error: %v", err)
+	}
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+	// Embed the timezone database so schedules validate in any image
+	_ "time/tzdata"
+
+	"github.com/rahulvramesh/justup/pkg/api"
+	"github.com/rahulvramesh/justup/pkg/database"
+	"github.com/rahulvramesh/justup/pkg/kubernetes"
+	"github.com/rahulvramesh/justup/pkg/oidc"
+	"github.com/rahulvramesh/justup/pkg/sshca"
+	"golang.org/x/crypto/ssh"
+)
+
+func main() {
+	// Parse flags
+	addr := flag.String("addr", ":8080", "HTTPS listen address")
+	tlsCert := flag.String("tls-cert", "/etc/justup-api-tls/tls.crt", "TLS certificate served to clients, reloaded when it changes")
+	tlsKey := flag.String("tls-key", "/etc/justup-api-tls/tls.key", "Private key of the TLS certificate")
+	insecureHTTP := flag.Bool("insecure-http", false, "Serve plain HTTP instead of HTTPS; only behind a proxy or Ingress that terminates TLS, since requests carry API tokens")
+	dbPath := flag.String("db", "/var/lib/justup/justup.db", "Path to SQLite database (shared with the SSH proxy)")
+	tokenPath := flag.String("token-file", "/etc/justup-api/token", "Path to the bearer token API clients must present")
+	oidcIssuer := flag.String("oidc-issuer", "", "OIDC issuer URL users log in with, exactly as the provider reports it (disabled if empty)")
+	oidcClientID := flag.String("oidc-client-id", "justup", "OIDC client ID of the justup CLI")
+	oidcTokenTTL := flag.Duration("oidc-token-ttl", 30*24*time.Hour, "Lifetime of API tokens issued by OIDC login (0 = never expire)")
+	sshCAKeyPath := flag.String("ssh-ca-key", "/etc/justup-ca/ca_key", "CA private key for signing SSH user certificates (disabled if the file is missing)")
+	maxCertValidity := flag.Duration("ssh-cert-max-validity", 12*time.Hour, "Longest validity of issued SSH certificates")
+	allowDinD := flag.Bool("allow-dind", false, "Let users without the admin scope enable the privileged Docker-in-Docker sidecar")
+	allowedImages := flag.String("allowed-images", "", "Comma-separated image prefixes users without the admin scope may run besides the default image (any if empty)")
+	recordingDir := flag.String("recording-dir", "/var/lib/justup/recordings", "Directory of the SSH proxy's session recordings, served for replay (disabled if empty)")
+	flag.Parse()
+
+	token, err := api.LoadToken(*tokenPath)
+	if err != nil {
+		log.Fatalf("Failed to load API token: %v", err)
+	}
+
+	// Open database
+	db, err := database.Open(*dbPath)
+	if err != nil {
+		log.Fatalf("Failed to open database: %v", err)
+	}
+	defer db.Close()
+
+	// Create Kubernetes client
+	k8sClient, err := kubernetes.NewClient()
+	if err != nil {
+		log.Fatalf("Failed to create Kubernetes client: %v", err)
+	}
+
+	// Handle shutdown gracefully
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
+
+	go func() {
+		sig := <-sigCh
+		log.Printf("Received signal %v, shutting down...", sig)
+		cancel()
+	}()
+
+	server := api.NewServer(db, k8sClient, token)
+
+	if *oidcIssuer != "" {
+		provider, err := oidc.Discover(ctx, *oidcIssuer)
+		if err != nil {
+			log.Fatalf("Failed to set up OIDC login: %v", err)
+		}
+		server.EnableOIDC(provider, *oidcClientID, *oidcTokenTTL)
+		log.Printf("OIDC login enabled with issuer %s", provider.Issuer)
+	}
+
+	if *sshCAKeyPath != "" {
+		ca, err := sshca.LoadSigner(*sshCAKeyPath)
+		switch {
+		case errors.Is(err, os.ErrNotExist):
+			log.Printf("No SSH CA key at %s; certificate signing disabled", *sshCAKeyPath)
+		case err != nil:
+			log.Fatalf("Failed to load SSH CA key: %v", err)
+		default:
+			server.EnableCertificates(ca, *maxCertValidity)
+			log.Printf("Signing SSH certificates with CA %s", ssh.FingerprintSHA256(ca.PublicKey()))
+		}
+	}
+
+	if *allowDinD {
+		server.AllowDinD()
+	}
+	if *allowedImages != "" {
+		server.RestrictImages(strings.Split(*allowedImages, ","))
+	}
+
+	if *recordingDir != "" {
+		server.EnableRecordings(*recordingDir)
+	}
+
+	if *insecureHTTP {
+		*tlsCert, *tlsKey = "", ""
+		log.Printf("Starting justup API server on %s over plain HTTP; TLS must be terminated in front of it", *addr)
+	} else {
+		if _, err := os.Stat(*tlsCert); err != nil {
+			log.Fatalf("No TLS certificate at %s (%v); mount one, or pass --insecure-http behind a proxy that terminates TLS", *tlsCert, err)
+		}
+		log.Printf("Starting justup API server on %s", *addr)
+	}
+	if err := server.ListenAndServe(ctx, *addr, *tlsCert, *tlsKey); err != nil {
+		log.Fatalf("Server error: %v", err)
+	}
+}
@@ -6,7 +6,9 @@ import (
 	"log"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
+	"time"
 
 	"github.com/rahulvramesh/justup/pkg/sshproxy"
 )
@@ -16,13 +18,57 @@ func main() {
 	addr := flag.String("addr", ":2222", "SSH listen address")
 	hostKeyPath := flag.String("host-key", "/etc/justup/ssh_host_ed25519_key", "Path to SSH host key")
 	dbPath := flag.String("db", "/var/lib/justup/justup.db", "Path to SQLite database")
+	clientKeyPath := flag.String("client-key", "/var/lib/justup/proxy_client_ed25519_key", "Path to the key used to authenticate to workspaces")
+	rotateClientKey := flag.Bool("rotate-client-key", false, "Generate a new client key, keeping the current one as previous")
+	wakeTimeout := flag.Duration("wake-timeout", 3*time.Minute, "How long a connection waits for a stopped workspace to start (0 disables starting on connect)")
+	idleTimeout := flag.Duration("idle-timeout", 0, "Stop workspaces after this long without SSH activity, unless set per workspace (0 disables)")
+	userCAKeysPath := flag.String("user-ca-keys", "/etc/justup-ca/ca_key.pub", "CA public keys whose user certificates are accepted (disabled if the file is missing)")
+	recordingDir := flag.String("recording-dir", "", "Record interactive sessions in asciicast format to this directory (disabled if empty)")
+	metricsAddr := flag.String("metrics-addr", ":9090", "HTTP address for /metrics, /healthz and /readyz (disabled if empty)")
+	maxConnections := flag.Int("max-connections", 1000, "Maximum concurrent connections (0 = unlimited)")
+	connectionsPerMinute := flag.Int("connections-per-minute", 60, "New connections allowed per source IP per minute (0 = unlimited)")
+	connectionBurst := flag.Int("connection-burst", 20, "Connections a source IP may open at once before the rate limit applies")
+	handshakeTimeout := flag.Duration("handshake-timeout", 30*time.Second, "Time allowed for the SSH handshake, not counting waking a workspace (0 = unlimited)")
+	authFailureLimit := flag.Int("auth-failure-limit", 5, "Ban a source IP after this many consecutive failed authentications (0 disables bans)")
+	banDuration := flag.Duration("ban-duration", time.Minute, "Length of a first ban; each further ban doubles it")
+	maxBanDuration := flag.Duration("max-ban-duration", time.Hour, "Longest ban")
+	allowCIDRs := flag.String("allow-cidrs", "", "Only accept clients from these networks (comma-separated CIDRs; empty allows all)")
+	denyCIDRs := flag.String("deny-cidrs", "", "Refuse clients from these networks (comma-separated CIDRs)")
+	disableForwarding := flag.Bool("disable-forwarding", false, "Refuse local and remote port forwarding for every workspace")
+	forwardAnyHost := flag.Bool("forward-any-host", false, "Allow local forwards to hosts other than the workspace's localhost")
+	backend := flag.String("backend", sshproxy.BackendSSH, "How sessions reach workspaces: ssh (the workspace's sshd) or exec (the Kubernetes exec API, for images without sshd, running sessions as --exec-user)")
+	execUser := flag.String("exec-user", "dev", "User that exec backend sessions and proxy-served SFTP run as, through su, where the container runs as root and has the user (empty runs them as the container's user, often root)")
+	sftpMode := flag.String("sftp", sshproxy.SFTPFallback, "Who serves the sftp subsystem: upstream (the workspace's sshd), proxy (the proxy, over the Kubernetes exec API) or fallback (the proxy when sshd refuses it)")
 	flag.Parse()
 
 	// Create server config
 	config := &sshproxy.Config{
-		ListenAddr:  *addr,
-		HostKeyPath: *hostKeyPath,
-		DatabasePath: *dbPath,
+		ListenAddr:      *addr,
+		HostKeyPath:     *hostKeyPath,
+		DatabasePath:    *dbPath,
+		ClientKeyPath:   *clientKeyPath,
+		RotateClientKey: *rotateClientKey,
+		WakeTimeout:     *wakeTimeout,
+		IdleTimeout:     *idleTimeout,
+		UserCAKeysPath:  *userCAKeysPath,
+		RecordingDir:    *recordingDir,
+		MetricsAddr:     *metricsAddr,
+
+		MaxConnections:       *maxConnections,
+		ConnectionsPerMinute: *connectionsPerMinute,
+		ConnectionBurst:      *connectionBurst,
+		HandshakeTimeout:     *handshakeTimeout,
+		AuthFailureLimit:     *authFailureLimit,
+		BanDuration:          *banDuration,
+		MaxBanDuration:       *maxBanDuration,
+		AllowCIDRs:           splitList(*allowCIDRs),
+		DenyCIDRs:            splitList(*denyCIDRs),
+
+		DisableForwarding: *disableForwarding,
+		ForwardAnyHost:    *forwardAnyHost,
+		SFTP:              *sftpMode,
+		Backend:           *backend,
+		ExecUser:          *execUser,
 	}
 
 	// Create and start server
@@ -49,3 +95,14 @@ func main() {
 		log.Fatalf("Server error: %v", err)
 	}
 }
+
+// splitList splits a comma-separated flag value, dropping empty items
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
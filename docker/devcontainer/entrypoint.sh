@@ -9,6 +9,16 @@ chmod 755 /run/sshd
 # The account is locked by default since no password is set
 passwd -u dev 2>/dev/null || usermod -p '*' dev 2>/dev/null || true
 
+# Install the workspace host key from the mounted secret. The SSH proxy and
+# CLI pin this key, so it must be the one sshd serves.
+if [ -f /etc/justup/ssh-keys/ssh_host_ed25519_key ]; then
+    echo "Installing workspace host key..."
+    cp /etc/justup/ssh-keys/ssh_host_ed25519_key /etc/ssh/ssh_host_ed25519_key
+    cp /etc/justup/ssh-keys/ssh_host_ed25519_key.pub /etc/ssh/ssh_host_ed25519_key.pub
+    chmod 600 /etc/ssh/ssh_host_ed25519_key
+    chmod 644 /etc/ssh/ssh_host_ed25519_key.pub
+fi
+
 # Generate SSH host keys if they don't exist
 if [ ! -f /etc/ssh/ssh_host_rsa_key ]; then
     echo "Generating RSA host key..."
@@ -0,0 +1,209 @@
+package cli
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/rahulvramesh/justup/pkg/api"
+	"github.com/spf13/cobra"
+)
+
+var (
+	auditSession   string
+	auditUser      string
+	auditWorkspace string
+	auditEvent     string
+	auditSince     time.Duration
+	auditLimit     int
+
+	auditReplayChannel int
+	auditReplaySpeed   float64
+	auditReplayMaxIdle time.Duration
+	auditReplayRaw     bool
+)
+
+var auditCmd = &cobra.Command{
+	Use:   "audit",
+	Short: "Inspect the SSH proxy's audit trail",
+	Long: `Inspect the audit trail the SSH proxy keeps of connections, authentication,
+channels and the commands run in workspaces.
+
+You see your own sessions; tokens with the admin scope see everyone's.
+Requires 'justup login'.`,
+}
+
+var auditListCmd = &cobra.Command{
+	Use:     "list",
+	Aliases: []string{"ls"},
+	Short:   "List audit events",
+	Long: `List audit events, oldest first.
+
+Events: connect, auth, auth_failed, channel_open, channel_rejected,
+channel_close, forward, forward_rejected, pty, shell, exec, subsystem,
+recording, error, disconnect.
+
+Examples:
+  justup audit list
+  justup audit list --workspace my-project --since 24h
+  justup audit list --session 3f9a1c0b7d2e4f51
+  justup audit list --event auth_failed --user alice`,
+	Args: cobra.NoArgs,
+	Run:  runAuditList,
+}
+
+var auditReplayCmd = &cobra.Command{
+	Use:   "replay <session-id>",
+	Short: "Replay a recorded SSH session",
+	Long: `Replay the recording of an interactive SSH session in the terminal.
+
+Sessions are recorded when the SSH proxy runs with --recording-dir. Only
+output is recorded, never what was typed. A connection with several
+interactive channels has a recording per channel; the first is replayed
+unless --channel is given. Use --raw to save the asciicast file instead,
+e.g. for 'asciinema play'.
+
+Examples:
+  justup audit replay 3f9a1c0b7d2e4f51
+  justup audit replay 3f9a1c0b7d2e4f51 --speed 2 --max-idle 1s
+  justup audit replay 3f9a1c0b7d2e4f51 --raw > session.cast`,
+	Args: cobra.ExactArgs(1),
+	Run:  runAuditReplay,
+}
+
+func init() {
+	auditListCmd.Flags().StringVar(&auditSession, "session", "", "Only events of this session")
+	auditListCmd.Flags().StringVar(&auditUser, "user", "", "Only events of this user (others require admin)")
+	auditListCmd.Flags().StringVarP(&auditWorkspace, "workspace", "w", "", "Only events of this workspace")
+	auditListCmd.Flags().StringVar(&auditEvent, "event", "", "Only events of this type")
+	auditListCmd.Flags().DurationVar(&auditSince, "since", 0, "Only events from the last duration, e.g. 24h")
+	auditListCmd.Flags().IntVar(&auditLimit, "limit", 100, "Show at most this many of the most recent events")
+
+	auditReplayCmd.Flags().IntVar(&auditReplayChannel, "channel", 0, "Channel of the session to replay (defaults to the first recorded)")
+	auditReplayCmd.Flags().Float64Var(&auditReplaySpeed, "speed", 1, "Playback speed multiplier")
+	auditReplayCmd.Flags().DurationVar(&auditReplayMaxIdle, "max-idle", 2*time.Second, "Cap pauses between output at this duration (0 keeps them)")
+	auditReplayCmd.Flags().BoolVar(&auditReplayRaw, "raw", false, "Write the asciicast recording to stdout instead of playing it")
+
+	auditCmd.AddCommand(auditListCmd)
+	auditCmd.AddCommand(auditReplayCmd)
+
+	rootCmd.AddCommand(auditCmd)
+}
+
+func runAuditList(cmd *cobra.Command, args []string) {
+	query := api.AuditQuery{
+		SessionID: auditSession,
+		Username:  auditUser,
+		Workspace: auditWorkspace,
+		Event:     auditEvent,
+		Limit:     auditLimit,
+	}
+	if auditSince > 0 {
+		query.Since = time.Now().Add(-auditSince)
+	}
+
+	events, err := requireAPIClient().ListAuditEvents(context.Background(), query)
+	if err != nil {
+		exitError("failed to list audit events", err)
+	}
+
+	if len(events) == 0 {
+		fmt.Println("No audit events.")
+		return
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "TIME\tSESSION\tUSER\tWORKSPACE\tEVENT\tDETAIL")
+	for _, event := range events {
+		user := event.Username
+		if user == "" {
+			user = "-"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n",
+			event.Time.Local().Format("2006-01-02 15:04:05"), event.SessionID, user, event.Workspace, event.Event, event.Detail)
+	}
+	w.Flush()
+}
+
+func runAuditReplay(cmd *cobra.Command, args []string) {
+	if auditReplaySpeed <= 0 {
+		exitError("--speed must be positive", nil)
+	}
+
+	recording, err := requireAPIClient().GetRecording(context.Background(), args[0], auditReplayChannel)
+	if err != nil {
+		if api.IsStatus(err, http.StatusNotFound) {
+			exitError(err.Error(), nil)
+		}
+		exitError("failed to get recording", err)
+	}
+
+	if auditReplayRaw {
+		os.Stdout.Write(recording)
+		return
+	}
+
+	if err := playRecording(recording, auditReplaySpeed, auditReplayMaxIdle); err != nil {
+		exitError("failed to replay recording", err)
+	}
+}
+
+// playRecording writes the output events of an asciicast v2 recording to
+// the terminal with their original timing
+func playRecording(recording []byte, speed float64, maxIdle time.Duration) error {
+	scanner := bufio.NewScanner(bytes.NewReader(recording))
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+
+	if !scanner.Scan() {
+		return fmt.Errorf("empty recording")
+	}
+	var header struct {
+		Version int `json:"version"`
+		Width   int `json:"width"`
+		Height  int `json:"height"`
+	}
+	if err := json.Unmarshal(scanner.Bytes(), &header); err != nil || header.Version != 2 {
+		return fmt.Errorf("not an asciicast v2 recording")
+	}
+	fmt.Fprintf(os.Stderr, "Replaying %dx%d session; resize your terminal to match.\n", header.Width, header.Height)
+
+	var last float64
+	for scanner.Scan() {
+		var (
+			event []json.RawMessage
+			at    float64
+			code  string
+			data  string
+		)
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil || len(event) != 3 {
+			return fmt.Errorf("invalid event: %s", scanner.Text())
+		}
+		if json.Unmarshal(event[0], &at) != nil || json.Unmarshal(event[1], &code) != nil || json.Unmarshal(event[2], &data) != nil {
+			return fmt.Errorf("invalid event: %s", scanner.Text())
+		}
+		if code != "o" {
+			continue
+		}
+
+		delay := time.Duration((at - last) / speed * float64(time.Second))
+		if maxIdle > 0 && delay > maxIdle {
+			delay = maxIdle
+		}
+		time.Sleep(delay)
+		last = at
+
+		os.Stdout.WriteString(data)
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	fmt.Fprintln(os.Stderr, "\nEnd of recording.")
+	return nil
+}
@@ -0,0 +1,36 @@
+package cli
+
+import (
+	"context"
+
+	"github.com/rahulvramesh/justup/pkg/kubernetes"
+)
+
+// workspaceBackend manages workspaces either directly in the cluster or
+// through the justup API server
+type workspaceBackend interface {
+	CreateWorkspace(ctx context.Context, opts kubernetes.WorkspaceOptions) (*kubernetes.Workspace, error)
+	GetWorkspace(ctx context.Context, name string) (*kubernetes.Workspace, error)
+	ListWorkspaces(ctx context.Context, includeAll bool) ([]kubernetes.Workspace, error)
+	StartWorkspace(ctx context.Context, name string, overrides kubernetes.StartOptions) error
+	StopWorkspace(ctx context.Context, name string) error
+	DeleteWorkspace(ctx context.Context, opts kubernetes.DeleteOptions) error
+	SetWorkspaceSchedule(ctx context.Context, name string, schedule *kubernetes.WorkspaceSchedule) error
+	SetWorkspaceForwarding(ctx context.Context, name string, forwarding *kubernetes.WorkspaceForwarding) error
+}
+
+// newWorkspaceBackend returns the API client when a server is configured
+// (see 'justup login'), otherwise a Kubernetes client using the local
+// kubeconfig. The server keeps its own workspace records; only the
+// Kubernetes backend records workspaces in the local database.
+func newWorkspaceBackend() workspaceBackend {
+	if client := apiClient(); client != nil {
+		return client
+	}
+
+	client, err := kubernetes.NewClient()
+	if err != nil {
+		exitError("failed to create Kubernetes client", err)
+	}
+	return client
+}
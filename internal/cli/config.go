@@ -0,0 +1,105 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/rahulvramesh/justup/pkg/api"
+	"sigs.k8s.io/yaml"
+)
+
+// Config is the CLI configuration stored in ~/.justup/config.yaml
+type Config struct {
+	// Server is the URL of the justup API server. When set, workspaces and
+	// SSH keys are managed through the server, and the local database only
+	// caches keys.
+	Server string `json:"server,omitempty"`
+	// Token authenticates to the API server
+	Token string `json:"token,omitempty"`
+	// User is the name of the token's user, for display
+	User string `json:"user,omitempty"`
+	// SSHProxy is the proxy address the installed SSH configuration
+	// connects through (see 'justup ssh-config --install'); empty connects
+	// with 'justup ssh --stdio'
+	SSHProxy string `json:"sshProxy,omitempty"`
+}
+
+func getConfigPath() string {
+	home, _ := os.UserHomeDir()
+	return filepath.Join(home, ".justup", "config.yaml")
+}
+
+// loadConfig reads the CLI configuration. JUSTUP_SERVER and JUSTUP_TOKEN
+// override the file.
+func loadConfig() (*Config, error) {
+	config := &Config{}
+	if err := readConfigFile(config); err != nil {
+		return nil, err
+	}
+
+	if server := os.Getenv("JUSTUP_SERVER"); server != "" {
+		config.Server = server
+	}
+	if token := os.Getenv("JUSTUP_TOKEN"); token != "" {
+		config.Token = token
+	}
+
+	return config, nil
+}
+
+// readConfigFile reads the config file, if it exists, into config
+func readConfigFile(config *Config) error {
+	data, err := os.ReadFile(getConfigPath())
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read config: %w", err)
+	}
+	if err := yaml.Unmarshal(data, config); err != nil {
+		return fmt.Errorf("failed to parse %s: %w", getConfigPath(), err)
+	}
+	return nil
+}
+
+// saveConfig writes the CLI configuration, readable only by the user since
+// it holds credentials
+func saveConfig(config *Config) error {
+	data, err := yaml.Marshal(config)
+	if err != nil {
+		return err
+	}
+
+	path := getConfigPath()
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return fmt.Errorf("failed to create %s: %w", filepath.Dir(path), err)
+	}
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		return fmt.Errorf("failed to write config: %w", err)
+	}
+	return nil
+}
+
+// apiClient returns a client for the configured API server, or nil if no
+// server is configured
+func apiClient() *api.Client {
+	config, err := loadConfig()
+	if err != nil {
+		exitError("failed to load config", err)
+	}
+	if config.Server == "" {
+		return nil
+	}
+	return api.NewClient(config.Server, config.Token)
+}
+
+// requireAPIClient returns a client for the configured API server, or exits
+// if the CLI is not logged in to one
+func requireAPIClient() *api.Client {
+	client := apiClient()
+	if client == nil {
+		exitError("not logged in to a justup server; run 'justup login <server-url>'", nil)
+	}
+	return client
+}
@@ -18,6 +18,9 @@ var (
 	createMemory  string
 	createStorage string
 	createDinD    bool
+	createEnv     []string
+	createIdle    string
+	createOwner   string
 )
 
 var createCmd = &cobra.Command{
@@ -34,19 +37,24 @@ Examples:
   justup create github.com/user/repo
   justup create github.com/user/repo --name myproject
   justup create github.com/user/repo --name myproject --dind
-  justup create https://github.com/user/repo --branch develop`,
+  justup create https://github.com/user/repo --branch develop
+  justup create github.com/user/repo --cpu 4 --memory 8Gi --env NODE_ENV=development
+  justup create github.com/user/repo --idle-timeout 2h`,
 	Args: cobra.ExactArgs(1),
 	Run:  runCreate,
 }
 
 func init() {
 	createCmd.Flags().StringVarP(&createName, "name", "n", "", "Workspace name (defaults to repo name)")
-	createCmd.Flags().StringVarP(&createBranch, "branch", "b", "main", "Git branch to clone")
-	createCmd.Flags().StringVar(&createImage, "image", "ghcr.io/rahulvramesh/justup/devcontainer:latest", "Container image to use")
-	createCmd.Flags().StringVar(&createCPU, "cpu", "1", "CPU limit")
-	createCmd.Flags().StringVar(&createMemory, "memory", "2Gi", "Memory limit")
-	createCmd.Flags().StringVar(&createStorage, "storage", "10Gi", "Persistent storage size")
+	createCmd.Flags().StringVarP(&createBranch, "branch", "b", kubernetes.DefaultBranch, "Git branch to clone")
+	createCmd.Flags().StringVar(&createImage, "image", kubernetes.DefaultImage, "Container image to use")
+	createCmd.Flags().StringVar(&createCPU, "cpu", kubernetes.DefaultCPU, "CPU limit")
+	createCmd.Flags().StringVar(&createMemory, "memory", kubernetes.DefaultMemory, "Memory limit")
+	createCmd.Flags().StringVar(&createStorage, "storage", kubernetes.DefaultStorage, "Persistent storage size")
 	createCmd.Flags().BoolVar(&createDinD, "dind", false, "Enable Docker-in-Docker")
+	createCmd.Flags().StringArrayVarP(&createEnv, "env", "e", nil, "Environment variable for the workspace (KEY=VALUE, repeatable)")
+	createCmd.Flags().StringVar(&createIdle, "idle-timeout", "", "Stop the workspace after this long without SSH activity, e.g. 2h (0 disables, default: proxy setting)")
+	createCmd.Flags().StringVar(&createOwner, "owner", "", "SSH proxy user that owns the workspace when creating it with a kubeconfig (default: the proxy's default user)")
 }
 
 func runCreate(cmd *cobra.Command, args []string) {
@@ -58,56 +66,62 @@ func runCreate(cmd *cobra.Command, args []string) {
 	}
 
 	// Validate workspace name
-	if !isValidWorkspaceName(createName) {
+	if !kubernetes.IsValidWorkspaceName(createName) {
 		exitError("invalid workspace name (must be lowercase alphanumeric with dashes)", nil)
 	}
 
+	env, err := parseKeyValues(createEnv)
+	if err != nil {
+		exitError("invalid --env", err)
+	}
+
 	fmt.Printf("Creating workspace '%s' from %s...\n", createName, githubURL)
 
-	// Load SSH keys from database
+	backend := newWorkspaceBackend()
+	k8sClient, direct := backend.(*kubernetes.Client)
+
+	// Load SSH keys from database; a server uses the keys registered with it
+	// and records the caller as the owner
 	sshPubKeys := ""
-	db, err := database.Open(getDBPath())
-	if err == nil {
-		defer db.Close()
-		user, err := db.GetOrCreateDefaultUser()
-		if err == nil {
-			keys, err := db.ListSSHKeys(user.ID)
-			if err == nil && len(keys) > 0 {
-				var keyLines []string
-				for _, key := range keys {
-					keyLines = append(keyLines, key.PublicKey)
-				}
-				sshPubKeys = strings.Join(keyLines, "\n")
-			}
+	owner := ""
+	if direct {
+		sshPubKeys = localAuthorizedKeys()
+		owner = createOwner
+		if owner == "" {
+			owner = database.DefaultUsername
 		}
-	}
-
-	// Create Kubernetes client
-	client, err := kubernetes.NewClient()
-	if err != nil {
-		exitError("failed to create Kubernetes client", err)
+	} else if createOwner != "" {
+		exitError("--owner only applies when creating workspaces with a kubeconfig; the server makes you the owner", nil)
 	}
 
 	// Create workspace options
 	opts := kubernetes.WorkspaceOptions{
-		Name:       createName,
-		GitURL:     githubURL,
-		Branch:     createBranch,
-		Image:      createImage,
-		CPU:        createCPU,
-		Memory:     createMemory,
-		Storage:    createStorage,
-		EnableDinD: createDinD,
-		SSHPubKey:  sshPubKeys,
+		Name:        createName,
+		GitURL:      githubURL,
+		Branch:      createBranch,
+		Image:       createImage,
+		CPU:         createCPU,
+		Memory:      createMemory,
+		Storage:     createStorage,
+		EnableDinD:  createDinD,
+		Env:         env,
+		IdleTimeout: createIdle,
+		SSHPubKey:   sshPubKeys,
+		Owner:       owner,
 	}
 
 	// Create the workspace
 	ctx := context.Background()
-	ws, err := client.CreateWorkspace(ctx, opts)
+	ws, err := backend.CreateWorkspace(ctx, opts)
 	if err != nil {
 		exitError("failed to create workspace", err)
 	}
 
+	if direct {
+		saveWorkspaceRecord(ctx, k8sClient, ws.Name)
+	}
+	refreshSSHConfig(ctx, backend, "")
+
 	fmt.Printf("\nWorkspace created successfully!\n")
 	fmt.Printf("  Name:   %s\n", ws.Name)
 	fmt.Printf("  Status: %s\n", ws.Status)
@@ -115,6 +129,31 @@ func runCreate(cmd *cobra.Command, args []string) {
 	fmt.Printf("  justup ssh %s\n", ws.Name)
 }
 
+// localAuthorizedKeys returns the SSH keys in the local database as
+// authorized_keys lines
+func localAuthorizedKeys() string {
+	db, err := database.Open(getDBPath())
+	if err != nil {
+		return ""
+	}
+	defer db.Close()
+
+	user, err := db.GetOrCreateDefaultUser()
+	if err != nil {
+		return ""
+	}
+	keys, err := db.ListSSHKeys(user.ID)
+	if err != nil {
+		return ""
+	}
+
+	var keyLines []string
+	for _, key := range keys {
+		keyLines = append(keyLines, key.PublicKey)
+	}
+	return strings.Join(keyLines, "\n")
+}
+
 // normalizeGitHubURL ensures the URL is in a consistent format
 func normalizeGitHubURL(url string) string {
 	// Remove https:// or http:// prefix if present
@@ -147,22 +186,19 @@ func extractRepoName(url string) string {
 	return "workspace"
 }
 
-// isValidWorkspaceName checks if the name is valid for Kubernetes
-func isValidWorkspaceName(name string) bool {
-	if len(name) == 0 || len(name) > 63 {
-		return false
+// parseKeyValues parses KEY=VALUE pairs
+func parseKeyValues(pairs []string) (map[string]string, error) {
+	if len(pairs) == 0 {
+		return nil, nil
 	}
-	for i, c := range name {
-		if c >= 'a' && c <= 'z' {
-			continue
-		}
-		if c >= '0' && c <= '9' {
-			continue
-		}
-		if c == '-' && i > 0 && i < len(name)-1 {
-			continue
+
+	values := make(map[string]string, len(pairs))
+	for _, pair := range pairs {
+		key, value, ok := strings.Cut(pair, "=")
+		if !ok || key == "" {
+			return nil, fmt.Errorf("expected KEY=VALUE, got %q", pair)
 		}
-		return false
+		values[key] = value
 	}
-	return true
+	return values, nil
 }
@@ -7,6 +7,7 @@ import (
 	"os"
 	"strings"
 
+	"github.com/rahulvramesh/justup/pkg/database"
 	"github.com/rahulvramesh/justup/pkg/kubernetes"
 	"github.com/spf13/cobra"
 )
@@ -64,10 +65,7 @@ func runDelete(cmd *cobra.Command, args []string) {
 		}
 	}
 
-	client, err := kubernetes.NewClient()
-	if err != nil {
-		exitError("failed to create Kubernetes client", err)
-	}
+	client := newWorkspaceBackend()
 
 	ctx := context.Background()
 	opts := kubernetes.DeleteOptions{
@@ -80,5 +78,29 @@ func runDelete(cmd *cobra.Command, args []string) {
 		exitError("failed to delete workspace", err)
 	}
 
+	// Keep the record of a workspace whose volume was kept, since it can
+	// still be restored with 'justup start'
+	if _, direct := client.(*kubernetes.Client); direct {
+		recordWorkspace(func(db *database.DB, user *database.User) error {
+			if deleteKeepPVC {
+				return db.MarkWorkspaceStopped(name)
+			}
+			return db.DeleteWorkspace(name)
+		})
+	}
+
+	// The host key goes with the workspace secret; a restored workspace
+	// gets a new one
+	if err := unpinHostKey(name); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: failed to remove pinned host key: %v\n", err)
+	}
+
+	// A workspace whose volume was kept keeps its host entry
+	deleted := name
+	if deleteKeepPVC {
+		deleted = ""
+	}
+	refreshSSHConfig(ctx, client, deleted)
+
 	fmt.Println("Workspace deleted.")
 }
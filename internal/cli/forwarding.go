@@ -0,0 +1,154 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/rahulvramesh/justup/pkg/kubernetes"
+	"github.com/spf13/cobra"
+)
+
+var (
+	forwardingLocal  []string
+	forwardingRemote []string
+)
+
+var forwardingCmd = &cobra.Command{
+	Use:   "forwarding",
+	Short: "Manage which ports may be forwarded through the SSH proxy",
+	Long: `Restrict the ports clients may forward when connected through the SSH proxy.
+
+Without an allowlist every port may be forwarded:
+  ssh -L 3000:localhost:3000 myworkspace@<proxy-host>   # reach port 3000 in the workspace
+  ssh -R 8080:localhost:8080 myworkspace@<proxy-host>   # expose a local port in the workspace
+
+Local forwards must target localhost unless the proxy runs with
+--forward-any-host, and the proxy's --disable-forwarding turns forwarding
+off for every workspace.`,
+}
+
+var forwardingSetCmd = &cobra.Command{
+	Use:   "set <workspace>",
+	Short: "Set a workspace's forwarding allowlists",
+	Long: `Set the ports that may be forwarded to and from a workspace.
+
+Ports are given as numbers or ranges. An omitted flag leaves that direction
+unrestricted; pass an empty list (--remote "") to refuse it entirely.
+
+Examples:
+  justup forwarding set myworkspace --local 3000,8000-8099
+  justup forwarding set myworkspace --local 3000 --remote ""`,
+	Args: cobra.ExactArgs(1),
+	Run:  runForwardingSet,
+}
+
+var forwardingClearCmd = &cobra.Command{
+	Use:   "clear <workspace>",
+	Short: "Allow forwarding any port to and from a workspace",
+	Args:  cobra.ExactArgs(1),
+	Run:   runForwardingClear,
+}
+
+var forwardingListCmd = &cobra.Command{
+	Use:     "list",
+	Aliases: []string{"ls"},
+	Short:   "List workspace forwarding allowlists",
+	Args:    cobra.NoArgs,
+	Run:     runForwardingList,
+}
+
+func init() {
+	forwardingSetCmd.Flags().StringSliceVar(&forwardingLocal, "local", nil, "Workspace ports clients may reach with ssh -L")
+	forwardingSetCmd.Flags().StringSliceVar(&forwardingRemote, "remote", nil, "Ports clients may listen on in the workspace with ssh -R")
+
+	forwardingCmd.AddCommand(forwardingSetCmd)
+	forwardingCmd.AddCommand(forwardingClearCmd)
+	forwardingCmd.AddCommand(forwardingListCmd)
+	rootCmd.AddCommand(forwardingCmd)
+}
+
+func runForwardingSet(cmd *cobra.Command, args []string) {
+	name := args[0]
+
+	localSet, remoteSet := cmd.Flags().Changed("local"), cmd.Flags().Changed("remote")
+	if !localSet && !remoteSet {
+		exitError("set --local, --remote or both; use 'justup forwarding clear' to remove restrictions", nil)
+	}
+
+	forwarding := &kubernetes.WorkspaceForwarding{}
+	for _, entries := range []struct {
+		set    bool
+		values []string
+		field  *[]string
+	}{
+		{localSet, forwardingLocal, &forwarding.Local},
+		{remoteSet, forwardingRemote, &forwarding.Remote},
+	} {
+		if !entries.set {
+			continue
+		}
+		if _, err := kubernetes.ParsePortRanges(entries.values); err != nil {
+			exitError("invalid ports", err)
+		}
+		// A non-nil empty list refuses every port
+		*entries.field = append([]string{}, entries.values...)
+	}
+
+	if err := newWorkspaceBackend().SetWorkspaceForwarding(context.Background(), name, forwarding); err != nil {
+		exitError("failed to set forwarding allowlists", err)
+	}
+
+	fmt.Printf("Forwarding for workspace '%s':\n", name)
+	fmt.Printf("  Local:  %s\n", formatPorts(forwarding.Local))
+	fmt.Printf("  Remote: %s\n", formatPorts(forwarding.Remote))
+}
+
+func runForwardingClear(cmd *cobra.Command, args []string) {
+	name := args[0]
+
+	if err := newWorkspaceBackend().SetWorkspaceForwarding(context.Background(), name, nil); err != nil {
+		exitError("failed to clear forwarding allowlists", err)
+	}
+
+	fmt.Printf("Any port may be forwarded for workspace '%s'.\n", name)
+}
+
+func runForwardingList(cmd *cobra.Command, args []string) {
+	workspaces, err := newWorkspaceBackend().ListWorkspaces(context.Background(), true)
+	if err != nil {
+		exitError("failed to list workspaces", err)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "WORKSPACE\tLOCAL\tREMOTE")
+
+	count := 0
+	for _, ws := range workspaces {
+		forwarding := ws.Forwarding
+		if forwarding == nil {
+			continue
+		}
+		count++
+		fmt.Fprintf(w, "%s\t%s\t%s\n", ws.Name, formatPorts(forwarding.Local), formatPorts(forwarding.Remote))
+	}
+
+	if count == 0 {
+		fmt.Println("No forwarding allowlists; any port may be forwarded.")
+		return
+	}
+	w.Flush()
+}
+
+// formatPorts renders a forwarding allowlist
+func formatPorts(ports []string) string {
+	if ports == nil {
+		return "any"
+	}
+	if len(ports) == 0 {
+		return "none"
+	}
+	return strings.Join(ports, ",")
+}
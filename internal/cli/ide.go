@@ -62,18 +62,13 @@ func runIDEVSCode(cmd *cobra.Command, args []string) {
 	workspaceName := args[0]
 
 	// Verify workspace exists and is running
-	client, err := kubernetes.NewClient()
-	if err != nil {
-		exitError("failed to create Kubernetes client", err)
-	}
-
 	ctx := context.Background()
-	ws, err := client.GetWorkspace(ctx, workspaceName)
+	ws, err := newWorkspaceBackend().GetWorkspace(ctx, workspaceName)
 	if err != nil {
 		exitError("failed to get workspace", err)
 	}
 
-	if ws.Status != "Running" {
+	if ws.Status != kubernetes.PhaseRunning {
 		exitError(fmt.Sprintf("workspace is not running (status: %s)", ws.Status), nil)
 	}
 
@@ -86,6 +81,7 @@ func runIDEVSCode(cmd *cobra.Command, args []string) {
 		// Use direct connection (requires port-forward or in-cluster access)
 		fmt.Println("Note: No --proxy specified. Using direct connection.")
 		fmt.Println("For remote access, use: justup ide vscode myworkspace --proxy proxy.justup.local")
+		requirePodIP(ws)
 		sshTarget = fmt.Sprintf("dev@%s", ws.PodIP)
 	}
 
@@ -112,18 +108,13 @@ func runIDEJetBrains(cmd *cobra.Command, args []string) {
 	workspaceName := args[0]
 
 	// Verify workspace exists and is running
-	client, err := kubernetes.NewClient()
-	if err != nil {
-		exitError("failed to create Kubernetes client", err)
-	}
-
 	ctx := context.Background()
-	ws, err := client.GetWorkspace(ctx, workspaceName)
+	ws, err := newWorkspaceBackend().GetWorkspace(ctx, workspaceName)
 	if err != nil {
 		exitError("failed to get workspace", err)
 	}
 
-	if ws.Status != "Running" {
+	if ws.Status != kubernetes.PhaseRunning {
 		exitError(fmt.Sprintf("workspace is not running (status: %s)", ws.Status), nil)
 	}
 
@@ -132,6 +123,7 @@ func runIDEJetBrains(cmd *cobra.Command, args []string) {
 	if proxyHost != "" {
 		sshHost = proxyHost
 	} else {
+		requirePodIP(ws)
 		sshHost = ws.PodIP
 	}
 
@@ -148,6 +140,14 @@ func runIDEJetBrains(cmd *cobra.Command, args []string) {
 	fmt.Printf("\nOpen JetBrains Gateway and create a new SSH connection with these details.\n")
 }
 
+// requirePodIP exits if a direct connection is impossible because the pod's
+// address is unknown, as it is for workspaces listed by the API server
+func requirePodIP(ws *kubernetes.Workspace) {
+	if ws.PodIP == "" {
+		exitError("the workspace's pod address is unknown; connect through the SSH proxy with --proxy", nil)
+	}
+}
+
 // openURL opens a URL in the default browser/application
 func openURL(url string) error {
 	var cmd *exec.Cmd
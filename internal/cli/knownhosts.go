@@ -0,0 +1,180 @@
+package cli
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/rahulvramesh/justup/pkg/kubernetes"
+	"golang.org/x/crypto/ssh"
+)
+
+// getKnownHostsPath returns the known_hosts file justup pins workspace host
+// keys in, kept separate from ~/.ssh/known_hosts
+func getKnownHostsPath() string {
+	home, _ := os.UserHomeDir()
+	return filepath.Join(home, ".justup", "known_hosts")
+}
+
+// hostKeyAlias is the name a workspace's host key is recorded under, so the
+// key stays pinned regardless of the local port or proxy address used
+func hostKeyAlias(workspaceName string) string {
+	return "justup-" + workspaceName
+}
+
+// proxyHostKeyAlias is the name the host key of the SSH proxy at
+// host[:port] is recorded under. Workspace names have no dots, so it never
+// clashes with a workspace's.
+func proxyHostKeyAlias(proxy string) string {
+	host, port := proxy, ""
+	if h, p, err := net.SplitHostPort(proxy); err == nil {
+		host, port = h, p
+	}
+	if port == "" || port == "22" {
+		return "justup-proxy." + host
+	}
+	return "justup-proxy." + host + "." + port
+}
+
+// knownHostsLine renders a workspace host key as a known_hosts entry
+func knownHostsLine(workspaceName, hostKey string) (string, error) {
+	key, err := kubernetes.ParseHostKey(hostKey)
+	if err != nil {
+		return "", err
+	}
+	return knownHostsEntry(hostKeyAlias(workspaceName), key), nil
+}
+
+func knownHostsEntry(host string, key ssh.PublicKey) string {
+	return host + " " + strings.TrimSpace(string(ssh.MarshalAuthorizedKey(key)))
+}
+
+// pinHostKey records a workspace's host key in the justup known_hosts file,
+// replacing any previous entry for the workspace
+func pinHostKey(workspaceName, hostKey string) error {
+	line, err := knownHostsLine(workspaceName, hostKey)
+	if err != nil {
+		return err
+	}
+	return rewriteKnownHosts(hostKeyAlias(workspaceName), line)
+}
+
+// pinProxyHostKey records the host key of the SSH proxy at host[:port],
+// fetched from the proxy itself, unless one is already pinned. A pinned key
+// is kept so that ssh reports a proxy whose key has changed.
+func pinProxyHostKey(proxy string) error {
+	alias := proxyHostKeyAlias(proxy)
+	pinned, err := isHostKeyPinned(alias)
+	if err != nil || pinned {
+		return err
+	}
+
+	addr := proxy
+	if _, _, err := net.SplitHostPort(proxy); err != nil {
+		addr = net.JoinHostPort(proxy, "22")
+	}
+	key, err := scanHostKey(addr)
+	if err != nil {
+		return err
+	}
+	return rewriteKnownHosts(alias, knownHostsEntry(alias, key))
+}
+
+// scanHostKey returns the host key the SSH server at addr presents,
+// leaving before authenticating
+func scanHostKey(addr string) (ssh.PublicKey, error) {
+	var key ssh.PublicKey
+	errScanned := errors.New("host key received")
+	conn, err := ssh.Dial("tcp", addr, &ssh.ClientConfig{
+		User: "justup",
+		HostKeyCallback: func(hostname string, remote net.Addr, k ssh.PublicKey) error {
+			key = k
+			return errScanned
+		},
+		Timeout: 10 * time.Second,
+	})
+	if key != nil {
+		return key, nil
+	}
+	if err == nil {
+		conn.Close()
+		return nil, fmt.Errorf("%s presented no host key", addr)
+	}
+	return nil, fmt.Errorf("failed to reach %s: %w", addr, err)
+}
+
+// isHostKeyPinned reports whether the justup known_hosts file has an entry
+// for host
+func isHostKeyPinned(host string) (bool, error) {
+	path := getKnownHostsPath()
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if fields := strings.Fields(scanner.Text()); len(fields) > 0 && fields[0] == host {
+			return true, nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return false, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	return false, nil
+}
+
+// unpinHostKey removes a workspace's entry from the justup known_hosts file
+func unpinHostKey(workspaceName string) error {
+	return rewriteKnownHosts(hostKeyAlias(workspaceName), "")
+}
+
+// rewriteKnownHosts drops every entry for host and, if line is non-empty,
+// appends it
+func rewriteKnownHosts(host, line string) error {
+	path := getKnownHostsPath()
+
+	var lines []string
+	if f, err := os.Open(path); err == nil {
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			existing := scanner.Text()
+			if fields := strings.Fields(existing); len(fields) > 0 && fields[0] == host {
+				continue
+			}
+			lines = append(lines, existing)
+		}
+		f.Close()
+		if err := scanner.Err(); err != nil {
+			return fmt.Errorf("failed to read %s: %w", path, err)
+		}
+	} else if !os.IsNotExist(err) {
+		return fmt.Errorf("failed to read %s: %w", path, err)
+	}
+
+	if line != "" {
+		lines = append(lines, line)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return fmt.Errorf("failed to create %s: %w", filepath.Dir(path), err)
+	}
+
+	content := ""
+	if len(lines) > 0 {
+		content = strings.Join(lines, "\n") + "\n"
+	}
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	return nil
+}
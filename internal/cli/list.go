@@ -6,6 +6,7 @@ import (
 	"os"
 	"text/tabwriter"
 
+	"github.com/rahulvramesh/justup/pkg/database"
 	"github.com/rahulvramesh/justup/pkg/kubernetes"
 	"github.com/spf13/cobra"
 )
@@ -18,6 +19,11 @@ var listCmd = &cobra.Command{
 	Short:   "List all workspaces",
 	Long: `List all workspaces in the cluster.
 
+By default only starting, running and stopping workspaces are shown. With
+--all, stopped workspaces (volume kept, no pod), failed workspaces,
+orphaned pods not managed by a Workspace resource and deleted workspaces
+still in your records are included.
+
 Examples:
   justup list
   justup ls
@@ -26,21 +32,31 @@ Examples:
 }
 
 func init() {
-	listCmd.Flags().BoolVarP(&listAll, "all", "a", false, "Include stopped workspaces")
+	listCmd.Flags().BoolVarP(&listAll, "all", "a", false, "Include stopped, failed and orphaned workspaces")
 }
 
-func runList(cmd *cobra.Command, args []string) {
-	client, err := kubernetes.NewClient()
-	if err != nil {
-		exitError("failed to create Kubernetes client", err)
+// orDash renders an unknown value as "-"
+func orDash(s string) string {
+	if s == "" {
+		return "-"
 	}
+	return s
+}
+
+func runList(cmd *cobra.Command, args []string) {
+	backend := newWorkspaceBackend()
 
 	ctx := context.Background()
-	workspaces, err := client.ListWorkspaces(ctx, listAll)
+	workspaces, err := backend.ListWorkspaces(ctx, listAll)
 	if err != nil {
 		exitError("failed to list workspaces", err)
 	}
 
+	// A server merges its own records
+	if _, direct := backend.(*kubernetes.Client); direct && listAll {
+		workspaces = mergeWorkspaceRecords(workspaces)
+	}
+
 	if len(workspaces) == 0 {
 		fmt.Println("No workspaces found.")
 		fmt.Println("\nCreate one with:")
@@ -50,9 +66,23 @@ func runList(cmd *cobra.Command, args []string) {
 
 	// Print table
 	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
-	fmt.Fprintln(w, "NAME\tSTATUS\tAGE\tGIT URL")
+	fmt.Fprintln(w, "NAME\tSTATUS\tBRANCH\tCPU\tMEMORY\tSTORAGE\tAGE\tGIT URL")
 	for _, ws := range workspaces {
-		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", ws.Name, ws.Status, ws.Age, ws.GitURL)
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+			ws.Name, ws.Status, orDash(ws.Branch), orDash(ws.CPU), orDash(ws.Memory), orDash(ws.Storage), ws.Age, ws.GitURL)
 	}
 	w.Flush()
 }
+
+// mergeWorkspaceRecords adds workspaces known from the local database but no
+// longer present in the cluster, shown as deleted; 'justup delete' removes
+// their records.
+func mergeWorkspaceRecords(workspaces []kubernetes.Workspace) []kubernetes.Workspace {
+	var records []database.Workspace
+	recordWorkspace(func(db *database.DB, user *database.User) error {
+		var err error
+		records, err = db.ListWorkspaces(user.ID)
+		return err
+	})
+	return kubernetes.MergeWorkspaceRecords(workspaces, records)
+}
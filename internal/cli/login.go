@@ -0,0 +1,210 @@
+package cli
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/rahulvramesh/justup/pkg/api"
+	"github.com/rahulvramesh/justup/pkg/oidc"
+	"github.com/spf13/cobra"
+)
+
+var (
+	loginToken   string
+	loginOIDC    bool
+	logoutRevoke bool
+)
+
+var loginCmd = &cobra.Command{
+	Use:   "login <server-url>",
+	Short: "Use a justup API server instead of the Kubernetes API",
+	Long: `Point the CLI at a justup API server.
+
+Once logged in, create, list, start, stop, delete and ssh-key commands go
+through the server, so no kubeconfig is needed for them. The server URL,
+token and user are saved to ~/.justup/config.yaml.
+
+Use a personal token issued with 'justup token create'. Without --token,
+the token is read from standard input.
+
+With --oidc, log in through the server's single sign-on provider instead:
+the CLI prints a URL and code to confirm in a browser, and the server issues
+a token for the signed-in user, creating the user on first login.
+
+Examples:
+  justup login https://justup.example.com:8080
+  justup login https://justup.example.com:8080 --token <token>
+  justup login https://justup.example.com:8080 --oidc`,
+	Args: cobra.ExactArgs(1),
+	Run:  runLogin,
+}
+
+var logoutCmd = &cobra.Command{
+	Use:   "logout",
+	Short: "Forget the justup API server credentials",
+	Long: `Remove the server and token from ~/.justup/config.yaml, so commands use
+the local kubeconfig again.
+
+With --revoke, the token is also revoked on the server first.
+
+Examples:
+  justup logout
+  justup logout --revoke`,
+	Args: cobra.NoArgs,
+	Run:  runLogout,
+}
+
+func init() {
+	loginCmd.Flags().StringVar(&loginToken, "token", "", "API token (read from standard input if not set)")
+	loginCmd.Flags().BoolVar(&loginOIDC, "oidc", false, "Log in through the server's OIDC provider")
+	loginCmd.MarkFlagsMutuallyExclusive("token", "oidc")
+	logoutCmd.Flags().BoolVar(&logoutRevoke, "revoke", false, "Revoke the token on the server")
+
+	rootCmd.AddCommand(loginCmd)
+	rootCmd.AddCommand(logoutCmd)
+}
+
+func runLogin(cmd *cobra.Command, args []string) {
+	server := strings.TrimSuffix(args[0], "/")
+	if !strings.HasPrefix(server, "http://") && !strings.HasPrefix(server, "https://") {
+		server = "https://" + server
+	}
+	if strings.HasPrefix(server, "http://") {
+		fmt.Fprintln(os.Stderr, "Warning: the server URL is not https, so the token is sent in cleartext.")
+	}
+
+	token := loginToken
+	if loginOIDC {
+		var err error
+		token, err = oidcLogin(context.Background(), server)
+		if err != nil {
+			exitError("OIDC login failed", err)
+		}
+	}
+	if token == "" {
+		fmt.Print("API token: ")
+		line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil && line == "" {
+			exitError("failed to read token", err)
+		}
+		token = strings.TrimSpace(line)
+	}
+	if token == "" {
+		exitError("a token is required", nil)
+	}
+
+	// Check the server accepts the token before saving it
+	who, err := api.NewClient(server, token).WhoAmI(context.Background())
+	if err != nil {
+		if api.IsStatus(err, http.StatusUnauthorized) {
+			exitError("the server rejected the token", nil)
+		}
+		exitError("login failed", err)
+	}
+
+	if err := saveCredentials(server, token, who.Username); err != nil {
+		exitError("failed to save config", err)
+	}
+
+	fmt.Printf("Logged in to %s as %s (scopes: %s).\n", server, who.Username, strings.Join(who.Scopes, ", "))
+	if who.TokenID == "" {
+		fmt.Println("\nThis is the server's bootstrap token. Issue personal tokens with:")
+		fmt.Println("  justup token create <name> --user <username>")
+	}
+}
+
+func runLogout(cmd *cobra.Command, args []string) {
+	if logoutRevoke {
+		client := requireAPIClient()
+		ctx := context.Background()
+
+		who, err := client.WhoAmI(ctx)
+		if err != nil {
+			exitError("failed to identify token", err)
+		}
+		if who.TokenID == "" {
+			exitError("the bootstrap token cannot be revoked; rotate the justup-api-token secret instead", nil)
+		}
+		if err := client.RevokeToken(ctx, who.TokenID); err != nil {
+			exitError("failed to revoke token", err)
+		}
+		fmt.Println("Token revoked.")
+	}
+
+	if err := saveCredentials("", "", ""); err != nil {
+		exitError("failed to save config", err)
+	}
+	fmt.Println("Logged out.")
+}
+
+// oidcLogin runs the OAuth2 device flow against the server's OIDC provider
+// and exchanges the resulting ID token for an API token
+func oidcLogin(ctx context.Context, server string) (string, error) {
+	client := api.NewClient(server, "")
+
+	config, err := client.OIDCConfig(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	provider, err := oidc.Discover(ctx, config.Issuer)
+	if err != nil {
+		return "", err
+	}
+	oauthConfig, err := provider.DeviceConfig(config.ClientID)
+	if err != nil {
+		return "", err
+	}
+
+	auth, err := oauthConfig.DeviceAuth(ctx)
+	if err != nil {
+		return "", fmt.Errorf("failed to start device authorization: %w", err)
+	}
+
+	if auth.VerificationURIComplete != "" {
+		fmt.Printf("Open %s to log in,\n", auth.VerificationURIComplete)
+		fmt.Printf("or go to %s and enter the code %s\n", auth.VerificationURI, auth.UserCode)
+	} else {
+		fmt.Printf("Go to %s and enter the code %s\n", auth.VerificationURI, auth.UserCode)
+	}
+	fmt.Println("Waiting for confirmation...")
+
+	oauthToken, err := oauthConfig.DeviceAccessToken(ctx, auth)
+	if err != nil {
+		return "", fmt.Errorf("device authorization failed: %w", err)
+	}
+	idToken, err := oidc.IDToken(oauthToken)
+	if err != nil {
+		return "", err
+	}
+
+	tokenName := "oidc-login"
+	if hostname, err := os.Hostname(); err == nil {
+		tokenName = "oidc-" + hostname
+	}
+
+	resp, err := client.OIDCLogin(ctx, idToken, tokenName)
+	if err != nil {
+		return "", err
+	}
+	return resp.Token, nil
+}
+
+// saveCredentials stores the server credentials in the config file. The
+// file is read directly so that JUSTUP_SERVER and JUSTUP_TOKEN are not
+// persisted.
+func saveCredentials(server, token, user string) error {
+	config := &Config{}
+	if err := readConfigFile(config); err != nil {
+		return err
+	}
+
+	config.Server = server
+	config.Token = token
+	config.User = user
+	return saveConfig(config)
+}
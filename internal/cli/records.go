@@ -0,0 +1,66 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/rahulvramesh/justup/pkg/database"
+	"github.com/rahulvramesh/justup/pkg/kubernetes"
+)
+
+// recordWorkspace runs fn against the local database with the current user.
+// Workspace records are bookkeeping on top of the cluster state, so failures
+// are reported as warnings rather than failing a command whose Kubernetes
+// operation already succeeded.
+func recordWorkspace(fn func(db *database.DB, user *database.User) error) {
+	db, err := database.Open(getDBPath())
+	if err != nil {
+		warnRecord(err)
+		return
+	}
+	defer db.Close()
+
+	user, err := db.GetOrCreateDefaultUser()
+	if err != nil {
+		warnRecord(err)
+		return
+	}
+
+	if err := fn(db, user); err != nil {
+		warnRecord(err)
+	}
+}
+
+// saveWorkspaceRecord stores the current spec of a workspace resource
+func saveWorkspaceRecord(ctx context.Context, client *kubernetes.Client, name string) {
+	ws, err := client.GetWorkspaceResource(ctx, name)
+	if err != nil {
+		warnRecord(err)
+		return
+	}
+
+	recordWorkspace(func(db *database.DB, user *database.User) error {
+		now := time.Now()
+		return db.SaveWorkspace(&database.Workspace{
+			ID:            uuid.New().String(),
+			Name:          name,
+			UserID:        user.ID,
+			GitURL:        ws.Spec.GitURL,
+			Branch:        ws.Spec.Branch,
+			Image:         ws.Spec.Image,
+			CPU:           ws.Spec.Resources.CPU,
+			Memory:        ws.Spec.Resources.Memory,
+			Storage:       ws.Spec.Resources.Storage,
+			EnableDinD:    ws.Spec.EnableDinD,
+			CreatedAt:     now,
+			LastStartedAt: &now,
+		})
+	})
+}
+
+func warnRecord(err error) {
+	fmt.Fprintf(os.Stderr, "Warning: failed to update local workspace record: %v\n", err)
+}
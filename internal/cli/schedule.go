@@ -0,0 +1,144 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+	// Embed the timezone database so schedules validate on any machine
+	_ "time/tzdata"
+
+	"github.com/rahulvramesh/justup/pkg/kubernetes"
+	"github.com/rahulvramesh/justup/pkg/scheduler"
+	"github.com/spf13/cobra"
+)
+
+var (
+	scheduleStart    string
+	scheduleStop     string
+	scheduleTimezone string
+)
+
+var scheduleCmd = &cobra.Command{
+	Use:   "schedule",
+	Short: "Manage workspace start/stop schedules",
+	Long: `Start and stop workspaces automatically at fixed times.
+
+Times are five-field cron expressions (minute hour day-of-month month
+day-of-week) evaluated in the schedule's timezone. Schedules are applied by
+the justup controller.`,
+}
+
+var scheduleSetCmd = &cobra.Command{
+	Use:   "set <workspace>",
+	Short: "Set a workspace's schedule",
+	Long: `Set when a workspace is started and stopped.
+
+Examples:
+  # Start at 08:30 and stop at 19:00, Monday to Friday
+  justup schedule set myworkspace --start "30 8 * * mon-fri" --stop "0 19 * * mon-fri" --timezone Europe/Berlin
+
+  # Only stop in the evening; start on demand
+  justup schedule set myworkspace --stop "0 19 * * *"`,
+	Args: cobra.ExactArgs(1),
+	Run:  runScheduleSet,
+}
+
+var scheduleClearCmd = &cobra.Command{
+	Use:   "clear <workspace>",
+	Short: "Remove a workspace's schedule",
+	Args:  cobra.ExactArgs(1),
+	Run:   runScheduleClear,
+}
+
+var scheduleListCmd = &cobra.Command{
+	Use:     "list",
+	Aliases: []string{"ls"},
+	Short:   "List workspace schedules",
+	Args:    cobra.NoArgs,
+	Run:     runScheduleList,
+}
+
+func init() {
+	scheduleSetCmd.Flags().StringVar(&scheduleStart, "start", "", "Cron expression for starting the workspace")
+	scheduleSetCmd.Flags().StringVar(&scheduleStop, "stop", "", "Cron expression for stopping the workspace")
+	scheduleSetCmd.Flags().StringVar(&scheduleTimezone, "timezone", "UTC", "IANA timezone the expressions are evaluated in")
+
+	scheduleCmd.AddCommand(scheduleSetCmd)
+	scheduleCmd.AddCommand(scheduleClearCmd)
+	scheduleCmd.AddCommand(scheduleListCmd)
+	rootCmd.AddCommand(scheduleCmd)
+}
+
+func runScheduleSet(cmd *cobra.Command, args []string) {
+	name := args[0]
+
+	schedule := &kubernetes.WorkspaceSchedule{
+		Start:    scheduleStart,
+		Stop:     scheduleStop,
+		Timezone: scheduleTimezone,
+	}
+	if err := scheduler.Validate(schedule); err != nil {
+		exitError("invalid schedule", err)
+	}
+
+	if err := newWorkspaceBackend().SetWorkspaceSchedule(context.Background(), name, schedule); err != nil {
+		exitError("failed to set schedule", err)
+	}
+
+	fmt.Printf("Schedule set for workspace '%s'.\n", name)
+	if action, at, err := scheduler.NextAction(schedule, time.Now()); err == nil && action != "" {
+		fmt.Printf("Next: %s at %s\n", action, at.Format("Mon Jan 2 15:04 MST"))
+	}
+}
+
+func runScheduleClear(cmd *cobra.Command, args []string) {
+	name := args[0]
+
+	if err := newWorkspaceBackend().SetWorkspaceSchedule(context.Background(), name, nil); err != nil {
+		exitError("failed to clear schedule", err)
+	}
+
+	fmt.Printf("Schedule cleared for workspace '%s'.\n", name)
+}
+
+func runScheduleList(cmd *cobra.Command, args []string) {
+	workspaces, err := newWorkspaceBackend().ListWorkspaces(context.Background(), true)
+	if err != nil {
+		exitError("failed to list workspaces", err)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "WORKSPACE\tSTART\tSTOP\tTIMEZONE\tNEXT")
+
+	count := 0
+	for _, ws := range workspaces {
+		schedule := ws.Schedule
+		if schedule == nil {
+			continue
+		}
+		count++
+
+		next := "-"
+		if action, at, err := scheduler.NextAction(schedule, time.Now()); err != nil {
+			next = "invalid"
+		} else if action != "" {
+			next = fmt.Sprintf("%s %s", action, at.Format("Mon 15:04"))
+		}
+
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n",
+			ws.Name,
+			orDash(schedule.Start),
+			orDash(schedule.Stop),
+			orDash(schedule.Timezone),
+			next,
+		)
+	}
+
+	if count == 0 {
+		fmt.Println("No workspace schedules. Set one with 'justup schedule set <workspace>'.")
+		return
+	}
+	w.Flush()
+}
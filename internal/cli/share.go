@@ -0,0 +1,115 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/rahulvramesh/justup/pkg/api"
+	"github.com/rahulvramesh/justup/pkg/database"
+	"github.com/spf13/cobra"
+)
+
+var shareRole string
+
+var shareCmd = &cobra.Command{
+	Use:   "share <workspace> [username]",
+	Short: "Share a workspace with another user",
+	Long: `Grant another user access to a workspace through the SSH proxy.
+Sharing is managed through the justup server (see 'justup login'), since the
+proxy checks access against the server's database.
+
+Roles:
+  owner         Full access, including managing who the workspace is shared with
+  collaborator  Full SSH access (shell, exec, port forwarding)
+  read-only     Port forwarding only, e.g. to view a running dev server
+
+Without a username, lists who the workspace is shared with.
+
+Examples:
+  justup share myworkspace
+  justup share myworkspace alice
+  justup share myworkspace bob --role read-only`,
+	Args: cobra.RangeArgs(1, 2),
+	Run:  runShare,
+}
+
+var unshareCmd = &cobra.Command{
+	Use:   "unshare <workspace> <username>",
+	Short: "Revoke a user's access to a workspace",
+	Long: `Revoke access to a workspace previously granted with 'justup share'.
+
+Examples:
+  justup unshare myworkspace alice`,
+	Args: cobra.ExactArgs(2),
+	Run:  runUnshare,
+}
+
+func init() {
+	shareCmd.Flags().StringVarP(&shareRole, "role", "r", database.RoleCollaborator, "Role to grant (owner, collaborator, read-only)")
+
+	rootCmd.AddCommand(shareCmd)
+	rootCmd.AddCommand(unshareCmd)
+}
+
+func runShare(cmd *cobra.Command, args []string) {
+	workspaceName := args[0]
+	client := requireShareClient()
+	ctx := context.Background()
+
+	if len(args) == 1 {
+		listShares(ctx, client, workspaceName)
+		return
+	}
+
+	if !database.IsValidRole(shareRole) {
+		exitError(fmt.Sprintf("invalid role '%s' (must be owner, collaborator or read-only)", shareRole), nil)
+	}
+
+	if err := client.ShareWorkspace(ctx, workspaceName, args[1], shareRole); err != nil {
+		exitError("failed to share workspace", err)
+	}
+
+	fmt.Printf("Workspace '%s' shared with '%s' as %s.\n", workspaceName, args[1], shareRole)
+}
+
+func runUnshare(cmd *cobra.Command, args []string) {
+	workspaceName := args[0]
+
+	if err := requireShareClient().UnshareWorkspace(context.Background(), workspaceName, args[1]); err != nil {
+		exitError("failed to unshare workspace", err)
+	}
+
+	fmt.Printf("Access to workspace '%s' revoked for '%s'.\n", workspaceName, args[1])
+}
+
+func listShares(ctx context.Context, client *api.Client, workspaceName string) {
+	shares, err := client.ListShares(ctx, workspaceName)
+	if err != nil {
+		exitError("failed to list shares", err)
+	}
+
+	if len(shares) == 0 {
+		fmt.Printf("Workspace '%s' is not shared.\n", workspaceName)
+		return
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "USER\tROLE\tGRANTED")
+	for _, share := range shares {
+		fmt.Fprintf(w, "%s\t%s\t%s\n", share.Username, share.Role, formatTimeAgo(share.GrantedAt))
+	}
+	w.Flush()
+}
+
+// requireShareClient returns a client for the configured API server. Grants
+// live in the server's database, which the SSH proxy checks, so there is
+// nothing to share without one.
+func requireShareClient() *api.Client {
+	client := apiClient()
+	if client == nil {
+		exitError("sharing needs a justup server, whose database the SSH proxy checks; run 'justup login <server-url>'", nil)
+	}
+	return client
+}
@@ -3,41 +3,79 @@ package cli
 import (
 	"context"
 	"fmt"
+	"io"
+	"net"
 	"os"
-	"os/exec"
 	"os/signal"
 	"syscall"
+	"time"
 
 	"github.com/rahulvramesh/justup/pkg/kubernetes"
 	"github.com/spf13/cobra"
 )
 
-var sshPort int
+var (
+	sshPort           int
+	sshStdio          bool
+	sshIdentity       string
+	sshForwardAgent   bool
+	sshLocalForwards  []string
+	sshRemoteForwards []string
+)
 
 var sshCmd = &cobra.Command{
-	Use:   "ssh <workspace>",
+	Use:   "ssh <workspace> [-- command...]",
 	Short: "SSH into a workspace",
 	Long: `Connect to a workspace via SSH.
 
 This command establishes an SSH connection to your workspace. It uses
-kubectl port-forward under the hood to create a secure tunnel.
+kubectl port-forward under the hood to create a secure tunnel, and a
+built-in SSH client, so no ssh binary is needed.
+
+Without a command an interactive shell is started. A command given after
+'--' runs without a terminal, and its exit status becomes justup's.
+Authentication uses the keys in ssh-agent and ~/.ssh/id_ed25519,
+id_ecdsa or id_rsa, or the key given with -i.
+
+With --stdio, nothing is run locally: stdin and stdout are connected to the
+workspace's SSH server over the port-forward stream, for use as an SSH
+ProxyCommand.
 
 Examples:
   justup ssh myworkspace
-  justup ssh myworkspace -p 2222`,
-	Args: cobra.ExactArgs(1),
+  justup ssh myworkspace -p 2222
+  justup ssh myworkspace -- make test
+  justup ssh myworkspace -A -L 8080:localhost:8080
+  ssh -o 'ProxyCommand=justup ssh --stdio %n' \
+      -o HostKeyAlias=justup-myworkspace \
+      -o UserKnownHostsFile=~/.justup/known_hosts dev@myworkspace`,
+	Args: cobra.MinimumNArgs(1),
 	Run:  runSSH,
 }
 
 func init() {
 	sshCmd.Flags().IntVarP(&sshPort, "port", "p", 0, "Local port for SSH (random if not specified)")
+	sshCmd.Flags().BoolVar(&sshStdio, "stdio", false, "Connect stdin and stdout to the workspace's SSH server (for ProxyCommand)")
+	sshCmd.Flags().StringVarP(&sshIdentity, "identity", "i", "", "Private key to authenticate with")
+	sshCmd.Flags().BoolVarP(&sshForwardAgent, "forward-agent", "A", false, "Forward the ssh-agent at SSH_AUTH_SOCK")
+	sshCmd.Flags().StringArrayVarP(&sshLocalForwards, "local", "L", nil, "Forward a local port to the workspace: [bind_address:]port:host:hostport (repeatable)")
+	sshCmd.Flags().StringArrayVarP(&sshRemoteForwards, "remote", "R", nil, "Forward a workspace port to the local side: [bind_address:]port:host:hostport (repeatable)")
+	sshCmd.MarkFlagsMutuallyExclusive("port", "stdio")
 }
 
 func runSSH(cmd *cobra.Command, args []string) {
-	name := args[0]
+	name, command := args[0], args[1:]
+	if sshStdio && len(command) > 0 {
+		exitError("a command cannot be run with --stdio", nil)
+	}
 
+	// Port-forwarding needs the Kubernetes API itself, which the justup
+	// server does not expose
 	client, err := kubernetes.NewClient()
 	if err != nil {
+		if apiClient() != nil {
+			exitError("justup ssh needs a kubeconfig to port-forward; without one, connect through the SSH proxy (ssh <workspace>@<proxy-host>)", err)
+		}
 		exitError("failed to create Kubernetes client", err)
 	}
 
@@ -49,10 +87,28 @@ func runSSH(cmd *cobra.Command, args []string) {
 		exitError("failed to get workspace", err)
 	}
 
-	if ws.Status != "Running" {
+	if ws.Status != kubernetes.PhaseRunning {
 		exitError(fmt.Sprintf("workspace is not running (status: %s)", ws.Status), nil)
 	}
 
+	// Pin the workspace host key so the connection is verified rather than
+	// trusted on first use
+	if ws.HostKey == "" {
+		exitError("workspace has no host key yet; restart it with 'justup stop' and 'justup start'", nil)
+	}
+	hostKey, err := kubernetes.ParseHostKey(ws.HostKey)
+	if err != nil {
+		exitError("invalid workspace host key", err)
+	}
+	if err := pinHostKey(name, ws.HostKey); err != nil {
+		exitError("failed to pin workspace host key", err)
+	}
+
+	if sshStdio {
+		runSSHStdio(ctx, client, name)
+		return
+	}
+
 	// Get a free port if not specified
 	localPort := sshPort
 	if localPort == 0 {
@@ -62,7 +118,10 @@ func runSSH(cmd *cobra.Command, args []string) {
 		}
 	}
 
-	fmt.Printf("Connecting to workspace '%s'...\n", name)
+	// Output is left to the command, if one is run
+	if len(command) == 0 {
+		fmt.Printf("Connecting to workspace '%s'...\n", name)
+	}
 
 	// Start port-forward in background
 	portForwardCtx, cancel := context.WithCancel(ctx)
@@ -97,36 +156,82 @@ func runSSH(cmd *cobra.Command, args []string) {
 		return
 	}
 
-	// Run SSH command
-	sshArgs := []string{
-		"-o", "StrictHostKeyChecking=no",
-		"-o", "UserKnownHostsFile=/dev/null",
-		"-o", "LogLevel=ERROR",
-		"-p", fmt.Sprintf("%d", localPort),
-		"dev@localhost",
+	// The session bypasses the SSH proxy, so report activity to keep the
+	// workspace from being stopped as idle
+	go keepWorkspaceActive(portForwardCtx, client, name)
+
+	status, err := runSSHClient(portForwardCtx, sshClientOptions{
+		addr:           net.JoinHostPort("127.0.0.1", fmt.Sprintf("%d", localPort)),
+		hostKey:        hostKey,
+		command:        command,
+		identity:       sshIdentity,
+		forwardAgent:   sshForwardAgent,
+		localForwards:  sshLocalForwards,
+		remoteForwards: sshRemoteForwards,
+	})
+	if err != nil {
+		// Don't show error if cancelled
+		if portForwardCtx.Err() == nil {
+			exitError("ssh failed", err)
+		}
+		return
 	}
+	if status != 0 {
+		cancel()
+		os.Exit(status)
+	}
+}
+
+// runSSHStdio relays stdin and stdout to the workspace's SSH server until
+// the server closes the connection
+func runSSHStdio(ctx context.Context, client *kubernetes.Client, name string) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
 
-	sshBin, err := exec.LookPath("ssh")
+	conn, err := client.DialPort(ctx, name, 22)
 	if err != nil {
-		exitError("ssh not found in PATH", err)
+		exitError("port-forward failed", err)
 	}
+	defer conn.Close()
 
-	sshExec := exec.CommandContext(ctx, sshBin, sshArgs...)
-	sshExec.Stdin = os.Stdin
-	sshExec.Stdout = os.Stdout
-	sshExec.Stderr = os.Stderr
+	go keepWorkspaceActive(ctx, client, name)
 
-	if err := sshExec.Run(); err != nil {
-		// Don't show error if cancelled
-		if ctx.Err() == nil {
-			exitError("ssh failed", err)
+	// Pass on the end of input, so the server sees the client go away
+	go func() {
+		io.Copy(conn, os.Stdin)
+		if cw, ok := conn.(interface{ CloseWrite() error }); ok {
+			cw.CloseWrite()
 		}
+	}()
+
+	if _, err := io.Copy(os.Stdout, conn); err != nil {
+		exitError("connection to workspace failed", err)
 	}
 }
 
-// getFreePort finds an available port
+// keepWorkspaceActive records activity on the workspace until ctx is done
+func keepWorkspaceActive(ctx context.Context, client *kubernetes.Client, name string) {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+
+	for {
+		// Best effort: a missed heartbeat only shortens the idle window
+		client.RecordWorkspaceActivity(ctx, name, time.Now())
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// getFreePort asks the kernel for an unused local port
 func getFreePort() (int, error) {
-	// Use a simple approach: try to find an available port in a range
-	// In production, you'd use net.Listen(":0") to get a truly free port
-	return 2222, nil
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return 0, err
+	}
+	defer listener.Close()
+	return listener.Addr().(*net.TCPAddr).Port, nil
 }
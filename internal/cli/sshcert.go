@@ -0,0 +1,120 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/rahulvramesh/justup/pkg/api"
+	"github.com/spf13/cobra"
+	"golang.org/x/crypto/ssh"
+)
+
+var (
+	sshKeySignValidFor      time.Duration
+	sshKeySignWorkspaces    []string
+	sshKeySignSourceAddress string
+)
+
+// defaultPublicKeys are tried in order when no key is given to sign
+var defaultPublicKeys = []string{"id_ed25519.pub", "id_ecdsa.pub", "id_rsa.pub"}
+
+var sshKeySignCmd = &cobra.Command{
+	Use:   "sign [path-to-public-key]",
+	Short: "Get a short-lived SSH certificate from the server",
+	Long: `Have the justup server's certificate authority sign a public key.
+
+The certificate authenticates you to the SSH proxy without registering the
+key, and expires on its own. It is written next to the key as *-cert.pub,
+where ssh picks it up automatically when using the key. Requires
+'justup login'.
+
+Without a path, ~/.ssh/id_ed25519.pub, id_ecdsa.pub or id_rsa.pub is used.
+
+Examples:
+  justup ssh-key sign
+  justup ssh-key sign ~/.ssh/id_ed25519.pub --valid-for 1h
+  justup ssh-key sign --workspace my-project --source-address 10.0.0.0/8`,
+	Args: cobra.MaximumNArgs(1),
+	Run:  runSSHKeySign,
+}
+
+func init() {
+	sshKeySignCmd.Flags().DurationVar(&sshKeySignValidFor, "valid-for", 0, "How long the certificate is valid (defaults to the server's limit)")
+	sshKeySignCmd.Flags().StringSliceVarP(&sshKeySignWorkspaces, "workspace", "w", nil, "Restrict the certificate to these workspaces")
+	sshKeySignCmd.Flags().StringVar(&sshKeySignSourceAddress, "source-address", "", "Restrict the certificate to these client addresses (comma-separated CIDRs)")
+
+	sshKeyCmd.AddCommand(sshKeySignCmd)
+}
+
+func runSSHKeySign(cmd *cobra.Command, args []string) {
+	client := requireAPIClient()
+
+	keyPath, err := publicKeyPath(args)
+	if err != nil {
+		exitError("no public key to sign", err)
+	}
+
+	keyBytes, err := os.ReadFile(keyPath)
+	if err != nil {
+		exitError("failed to read public key file", err)
+	}
+	if _, _, _, _, err := ssh.ParseAuthorizedKey(keyBytes); err != nil {
+		exitError("failed to parse public key", err)
+	}
+
+	req := api.SignCertificateRequest{
+		PublicKey:     strings.TrimSpace(string(keyBytes)),
+		Workspaces:    sshKeySignWorkspaces,
+		SourceAddress: sshKeySignSourceAddress,
+	}
+	if sshKeySignValidFor > 0 {
+		req.ValidFor = sshKeySignValidFor.String()
+	}
+
+	cert, err := client.SignCertificate(context.Background(), req)
+	if err != nil {
+		if api.IsStatus(err, http.StatusNotFound) {
+			exitError("the server has no certificate authority configured", nil)
+		}
+		exitError("failed to sign key", err)
+	}
+
+	certPath := strings.TrimSuffix(keyPath, ".pub") + "-cert.pub"
+	if err := os.WriteFile(certPath, []byte(cert.Certificate+"\n"), 0644); err != nil {
+		exitError("failed to write certificate", err)
+	}
+
+	fmt.Printf("Certificate written to %s\n", certPath)
+	fmt.Printf("  Principals:  %s\n", strings.Join(cert.Principals, ", "))
+	fmt.Printf("  Valid until: %s\n", cert.ValidBefore.Local().Format(time.RFC1123))
+	if len(cert.Workspaces) > 0 {
+		fmt.Printf("  Workspaces:  %s\n", strings.Join(cert.Workspaces, ", "))
+	}
+	fmt.Printf("\nConnect as usual with the key:\n")
+	fmt.Printf("  ssh -i %s <workspace>@<proxy-host>\n", strings.TrimSuffix(keyPath, ".pub"))
+}
+
+// publicKeyPath returns the key given on the command line, or the first
+// default key that exists
+func publicKeyPath(args []string) (string, error) {
+	if len(args) > 0 {
+		return expandHome(args[0]), nil
+	}
+
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	for _, name := range defaultPublicKeys {
+		keyPath := filepath.Join(home, ".ssh", name)
+		if _, err := os.Stat(keyPath); err == nil {
+			return keyPath, nil
+		}
+	}
+	return "", fmt.Errorf("none of %s found in ~/.ssh", strings.Join(defaultPublicKeys, ", "))
+}
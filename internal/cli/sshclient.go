@@ -0,0 +1,372 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"golang.org/x/term"
+)
+
+// sshClientOptions describes a connection `justup ssh` makes to a
+// workspace's SSH server
+type sshClientOptions struct {
+	addr    string
+	hostKey ssh.PublicKey
+	// command runs instead of an interactive shell if set
+	command []string
+	// identity is a private key file to use instead of the defaults
+	identity     string
+	forwardAgent bool
+	// localForwards and remoteForwards are in ssh(1) -L and -R form
+	localForwards  []string
+	remoteForwards []string
+}
+
+// runSSHClient connects to a workspace as the dev user and runs a shell or
+// command, returning its exit status. The connection is closed when ctx is
+// done.
+func runSSHClient(ctx context.Context, opts sshClientOptions) (int, error) {
+	agentClient := sshAgent()
+	client, err := ssh.Dial("tcp", opts.addr, &ssh.ClientConfig{
+		User:              "dev",
+		Auth:              []ssh.AuthMethod{ssh.PublicKeysCallback(sshSigners(agentClient, opts.identity))},
+		HostKeyCallback:   ssh.FixedHostKey(opts.hostKey),
+		HostKeyAlgorithms: []string{opts.hostKey.Type()},
+		Timeout:           30 * time.Second,
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to connect: %w", err)
+	}
+	defer client.Close()
+
+	go func() {
+		<-ctx.Done()
+		client.Close()
+	}()
+
+	for _, spec := range opts.localForwards {
+		listener, err := forwardLocal(client, spec)
+		if err != nil {
+			return 0, err
+		}
+		defer listener.Close()
+	}
+	for _, spec := range opts.remoteForwards {
+		listener, err := forwardRemote(client, spec)
+		if err != nil {
+			return 0, err
+		}
+		defer listener.Close()
+	}
+
+	session, err := client.NewSession()
+	if err != nil {
+		return 0, fmt.Errorf("failed to open session: %w", err)
+	}
+	defer session.Close()
+
+	if opts.forwardAgent {
+		sock := os.Getenv("SSH_AUTH_SOCK")
+		if sock == "" {
+			return 0, errors.New("agent forwarding needs SSH_AUTH_SOCK to be set")
+		}
+		if err := agent.ForwardToRemote(client, sock); err != nil {
+			return 0, fmt.Errorf("failed to forward agent: %w", err)
+		}
+		if err := agent.RequestAgentForwarding(session); err != nil {
+			return 0, fmt.Errorf("failed to forward agent: %w", err)
+		}
+	}
+
+	session.Stdin = os.Stdin
+	session.Stdout = os.Stdout
+	session.Stderr = os.Stderr
+
+	if len(opts.command) > 0 {
+		return sshExitStatus(session.Run(strings.Join(opts.command, " ")))
+	}
+
+	// An interactive shell gets a terminal if there is one here
+	fd := int(os.Stdin.Fd())
+	if term.IsTerminal(fd) {
+		restore, err := startTerminal(session, fd)
+		if err != nil {
+			return 0, err
+		}
+		defer restore()
+	}
+	if err := session.Shell(); err != nil {
+		return 0, fmt.Errorf("failed to start shell: %w", err)
+	}
+	return sshExitStatus(session.Wait())
+}
+
+// startTerminal requests a pty sized like the local terminal, puts the
+// local terminal in raw mode and passes on its size changes. It returns a
+// function restoring the terminal.
+func startTerminal(session *ssh.Session, fd int) (func(), error) {
+	width, height, err := term.GetSize(fd)
+	if err != nil {
+		width, height = 80, 24
+	}
+	termType := os.Getenv("TERM")
+	if termType == "" {
+		termType = "xterm-256color"
+	}
+
+	modes := ssh.TerminalModes{
+		ssh.ECHO:          1,
+		ssh.TTY_OP_ISPEED: 14400,
+		ssh.TTY_OP_OSPEED: 14400,
+	}
+	if err := session.RequestPty(termType, height, width, modes); err != nil {
+		return nil, fmt.Errorf("failed to request terminal: %w", err)
+	}
+
+	state, err := term.MakeRaw(fd)
+	if err != nil {
+		return nil, fmt.Errorf("failed to set terminal to raw mode: %w", err)
+	}
+
+	stop := watchWindowSize(fd, func(width, height int) {
+		session.WindowChange(height, width)
+	})
+	return func() {
+		stop()
+		term.Restore(fd, state)
+	}, nil
+}
+
+// sshExitStatus returns the exit status of a finished shell or command.
+// Like ssh(1), it reports 255 for commands killed by a signal.
+func sshExitStatus(err error) (int, error) {
+	var exitErr *ssh.ExitError
+	var missingErr *ssh.ExitMissingError
+	switch {
+	case err == nil:
+		return 0, nil
+	case errors.As(err, &exitErr):
+		if exitErr.Signal() != "" {
+			fmt.Fprintf(os.Stderr, "Remote command killed by signal %s\n", exitErr.Signal())
+			return 255, nil
+		}
+		return exitErr.ExitStatus(), nil
+	case errors.As(err, &missingErr):
+		return 0, errors.New("connection closed without an exit status")
+	default:
+		return 0, err
+	}
+}
+
+// sshAgent connects to the ssh-agent at SSH_AUTH_SOCK, if one is running
+func sshAgent() agent.ExtendedAgent {
+	sock := os.Getenv("SSH_AUTH_SOCK")
+	if sock == "" {
+		return nil
+	}
+	conn, err := net.Dial("unix", sock)
+	if err != nil {
+		return nil
+	}
+	return agent.NewClient(conn)
+}
+
+// defaultIdentities are the private keys tried when none is given, the
+// halves of defaultPublicKeys
+func defaultIdentities() []string {
+	home, _ := os.UserHomeDir()
+	var paths []string
+	for _, name := range defaultPublicKeys {
+		paths = append(paths, filepath.Join(home, ".ssh", strings.TrimSuffix(name, ".pub")))
+	}
+	return paths
+}
+
+// sshSigners returns the callback offering the agent's keys, then those in
+// the identity file or the default ones. A passphrase is asked for keys the
+// agent does not already hold.
+func sshSigners(agentClient agent.ExtendedAgent, identity string) func() ([]ssh.Signer, error) {
+	return func() ([]ssh.Signer, error) {
+		var signers []ssh.Signer
+		held := make(map[string]bool)
+		if agentClient != nil {
+			if agentSigners, err := agentClient.Signers(); err == nil {
+				for _, signer := range agentSigners {
+					held[string(signer.PublicKey().Marshal())] = true
+				}
+				signers = append(signers, agentSigners...)
+			}
+		}
+
+		paths := defaultIdentities()
+		if identity != "" {
+			paths = []string{expandHome(identity)}
+		}
+		for _, path := range paths {
+			pemBytes, err := os.ReadFile(path)
+			if err != nil {
+				if identity != "" {
+					return nil, fmt.Errorf("failed to read identity: %w", err)
+				}
+				continue
+			}
+
+			signer, err := ssh.ParsePrivateKey(pemBytes)
+			var missing *ssh.PassphraseMissingError
+			if errors.As(err, &missing) {
+				if held[string(missing.PublicKey.Marshal())] || !term.IsTerminal(int(os.Stdin.Fd())) {
+					continue
+				}
+				signer, err = parseKeyWithPassphrase(path, pemBytes)
+			}
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Warning: skipping %s: %v\n", path, err)
+				continue
+			}
+			signers = append(signers, signer)
+		}
+		return signers, nil
+	}
+}
+
+// parseKeyWithPassphrase asks for the passphrase of an encrypted key
+func parseKeyWithPassphrase(path string, pemBytes []byte) (ssh.Signer, error) {
+	fmt.Fprintf(os.Stderr, "Enter passphrase for %s: ", path)
+	passphrase, err := term.ReadPassword(int(os.Stdin.Fd()))
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return nil, err
+	}
+	return ssh.ParsePrivateKeyWithPassphrase(pemBytes, passphrase)
+}
+
+// expandHome expands a leading ~/ to the home directory
+func expandHome(path string) string {
+	if strings.HasPrefix(path, "~/") {
+		home, _ := os.UserHomeDir()
+		return filepath.Join(home, path[2:])
+	}
+	return path
+}
+
+// parseForward parses a forward in ssh(1) form,
+// [bind_address:]port:host:hostport, into the address to listen on and the
+// one to connect to. Without a bind address, only loopback is listened on.
+func parseForward(spec string) (listen, target string, err error) {
+	var parts []string
+	depth, start := 0, 0
+	for i, c := range spec {
+		switch c {
+		case '[':
+			depth++
+		case ']':
+			depth--
+		case ':':
+			if depth == 0 {
+				parts = append(parts, spec[start:i])
+				start = i + 1
+			}
+		}
+	}
+	parts = append(parts, spec[start:])
+	for i := range parts {
+		parts[i] = strings.Trim(parts[i], "[]")
+	}
+
+	switch len(parts) {
+	case 3:
+		parts = append([]string{"localhost"}, parts...)
+	case 4:
+	default:
+		return "", "", fmt.Errorf("invalid forward %q; use [bind_address:]port:host:hostport", spec)
+	}
+	for _, port := range []string{parts[1], parts[3]} {
+		if n, err := strconv.Atoi(port); err != nil || n < 0 || n > 65535 {
+			return "", "", fmt.Errorf("invalid port %q in forward %q", port, spec)
+		}
+	}
+	return net.JoinHostPort(parts[0], parts[1]), net.JoinHostPort(parts[2], parts[3]), nil
+}
+
+// forwardLocal listens locally and connects each connection to the target
+// through the workspace
+func forwardLocal(client *ssh.Client, spec string) (io.Closer, error) {
+	listen, target, err := parseForward(spec)
+	if err != nil {
+		return nil, err
+	}
+	listener, err := net.Listen("tcp", listen)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on %s: %w", listen, err)
+	}
+	go serveForward(listener, target, client.Dial)
+	return listener, nil
+}
+
+// forwardRemote has the workspace listen and connects each connection to
+// the target from here
+func forwardRemote(client *ssh.Client, spec string) (io.Closer, error) {
+	listen, target, err := parseForward(spec)
+	if err != nil {
+		return nil, err
+	}
+	listener, err := client.Listen("tcp", listen)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on %s in the workspace: %w", listen, err)
+	}
+	go serveForward(listener, target, net.Dial)
+	return listener, nil
+}
+
+// serveForward connects the connections listener accepts to target until
+// it is closed
+func serveForward(listener net.Listener, target string, dial func(network, addr string) (net.Conn, error)) {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			defer conn.Close()
+			remote, err := dial("tcp", target)
+			if err != nil {
+				// The terminal may be in raw mode
+				fmt.Fprintf(os.Stderr, "Warning: failed to forward to %s: %v\r\n", target, err)
+				return
+			}
+			defer remote.Close()
+			relay(conn, remote)
+		}()
+	}
+}
+
+// relay copies data both ways between two connections, passing on the end
+// of each direction, until both have ended
+func relay(a, b net.Conn) {
+	done := make(chan struct{})
+	go func() {
+		io.Copy(a, b)
+		closeWrite(a)
+		close(done)
+	}()
+	io.Copy(b, a)
+	closeWrite(b)
+	<-done
+}
+
+// closeWrite half-closes a connection if it supports it
+func closeWrite(conn net.Conn) {
+	if cw, ok := conn.(interface{ CloseWrite() error }); ok {
+		cw.CloseWrite()
+	}
+}
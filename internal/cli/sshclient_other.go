@@ -0,0 +1,41 @@
+//go:build !unix
+
+package cli
+
+import (
+	"time"
+
+	"golang.org/x/term"
+)
+
+// windowSizeInterval is how often the terminal size is checked where there
+// is no SIGWINCH
+const windowSizeInterval = 250 * time.Millisecond
+
+// watchWindowSize calls resized with the terminal's size whenever it
+// changes, polling for changes, until stop is called
+func watchWindowSize(fd int, resized func(width, height int)) (stop func()) {
+	ticker := time.NewTicker(windowSizeInterval)
+	done := make(chan struct{})
+
+	go func() {
+		lastWidth, lastHeight, _ := term.GetSize(fd)
+		for {
+			select {
+			case <-ticker.C:
+				width, height, err := term.GetSize(fd)
+				if err == nil && (width != lastWidth || height != lastHeight) {
+					lastWidth, lastHeight = width, height
+					resized(width, height)
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	return func() {
+		ticker.Stop()
+		close(done)
+	}
+}
@@ -0,0 +1,287 @@
+package cli
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/rahulvramesh/justup/pkg/kubernetes"
+	"github.com/spf13/cobra"
+)
+
+// sshConfigInclude is the line that pulls the generated configuration into
+// ~/.ssh/config
+const sshConfigInclude = "Include ~/.justup/ssh_config"
+
+var (
+	sshConfigProxy   string
+	sshConfigInstall bool
+)
+
+var sshConfigCmd = &cobra.Command{
+	Use:   "ssh-config",
+	Short: "Generate SSH configuration for workspaces",
+	Long: `Generate SSH configuration for connecting to workspaces with other tools.
+
+Prints a 'Host justup-<workspace>' entry for every workspace, so that ssh,
+scp, VS Code Remote-SSH and JetBrains Gateway can connect by name. Entries
+connect through 'justup ssh --stdio', which needs cluster access, or through
+the SSH proxy given with --proxy.
+
+With --install, the entries are written to ~/.justup/ssh_config, included
+from ~/.ssh/config, and kept up to date as workspaces are created and
+deleted. Workspace host keys, or the proxy's host key with --proxy, are
+pinned in ~/.justup/known_hosts. The proxy's key is fetched from the proxy
+the first time and kept; remove its 'justup-proxy.<host>' entry there if
+the proxy's key is changed on purpose.
+
+Examples:
+  justup ssh-config
+  justup ssh-config --install
+  justup ssh-config --install --proxy proxy.justup.example.com:2222
+  ssh justup-myworkspace`,
+	Args: cobra.NoArgs,
+	Run:  runSSHConfig,
+}
+
+var sshConfigKnownHostsCmd = &cobra.Command{
+	Use:   "known-hosts",
+	Short: "Print pinned host keys for all workspaces",
+	Long: `Print a known_hosts entry for every workspace's host key.
+
+Entries are keyed by the host alias 'justup-<workspace>', so pair them with
+'HostKeyAlias justup-<workspace>' in your SSH configuration.
+
+Examples:
+  justup ssh-config known-hosts >> ~/.ssh/known_hosts`,
+	Args: cobra.NoArgs,
+	Run:  runSSHConfigKnownHosts,
+}
+
+func init() {
+	sshConfigCmd.Flags().StringVar(&sshConfigProxy, "proxy", "", "Connect through the SSH proxy at host[:port] instead of 'justup ssh --stdio'")
+	sshConfigCmd.Flags().BoolVar(&sshConfigInstall, "install", false, "Write the entries to ~/.justup/ssh_config and include it from ~/.ssh/config")
+	sshConfigCmd.AddCommand(sshConfigKnownHostsCmd)
+	rootCmd.AddCommand(sshConfigCmd)
+}
+
+func runSSHConfig(cmd *cobra.Command, args []string) {
+	workspaces, err := newWorkspaceBackend().ListWorkspaces(context.Background(), true)
+	if err != nil {
+		exitError("failed to list workspaces", err)
+	}
+
+	if !sshConfigInstall {
+		content, err := renderSSHConfig(workspaces, sshConfigProxy)
+		if err != nil {
+			exitError("failed to generate SSH configuration", err)
+		}
+		fmt.Print(content)
+		return
+	}
+
+	// Remember how hosts connect for the updates that follow workspace
+	// changes
+	config := &Config{}
+	if err := readConfigFile(config); err != nil {
+		exitError("failed to read config", err)
+	}
+	config.SSHProxy = sshConfigProxy
+	if err := saveConfig(config); err != nil {
+		exitError("failed to save config", err)
+	}
+
+	if err := writeSSHConfig(workspaces, sshConfigProxy); err != nil {
+		exitError("failed to write SSH configuration", err)
+	}
+	added, err := includeSSHConfig()
+	if err != nil {
+		exitError("failed to update ~/.ssh/config", err)
+	}
+
+	fmt.Printf("Wrote %d workspace hosts to %s\n", len(workspaces), getSSHConfigPath())
+	if added {
+		fmt.Printf("Added '%s' to %s\n", sshConfigInclude, userSSHConfigPath())
+	}
+	fmt.Println("Connect with: ssh justup-<workspace>")
+}
+
+func runSSHConfigKnownHosts(cmd *cobra.Command, args []string) {
+	workspaces, err := newWorkspaceBackend().ListWorkspaces(context.Background(), true)
+	if err != nil {
+		exitError("failed to list workspaces", err)
+	}
+
+	for _, ws := range workspaces {
+		if ws.HostKey == "" {
+			continue
+		}
+		line, err := knownHostsLine(ws.Name, ws.HostKey)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: skipping workspace '%s': %v\n", ws.Name, err)
+			continue
+		}
+		fmt.Println(line)
+	}
+}
+
+// getSSHConfigPath returns the SSH configuration file justup maintains
+func getSSHConfigPath() string {
+	home, _ := os.UserHomeDir()
+	return filepath.Join(home, ".justup", "ssh_config")
+}
+
+// userSSHConfigPath returns the user's OpenSSH configuration file
+func userSSHConfigPath() string {
+	home, _ := os.UserHomeDir()
+	return filepath.Join(home, ".ssh", "config")
+}
+
+// renderSSHConfig renders a Host entry for each workspace. Without a proxy,
+// hosts connect through 'justup ssh --stdio', which pins the workspace host
+// key before ssh checks it. Through the proxy, the proxy's own host key is
+// presented instead, checked against the entry writeSSHConfig pins.
+func renderSSHConfig(workspaces []kubernetes.Workspace, proxy string) (string, error) {
+	var b strings.Builder
+	b.WriteString("# Generated by 'justup ssh-config'; changes are overwritten\n")
+
+	if proxy != "" {
+		host, port := proxy, ""
+		if h, p, err := net.SplitHostPort(proxy); err == nil {
+			host, port = h, p
+		}
+		for _, ws := range workspaces {
+			fmt.Fprintf(&b, "\nHost %s\n", hostKeyAlias(ws.Name))
+			fmt.Fprintf(&b, "    HostName %s\n", host)
+			if port != "" {
+				fmt.Fprintf(&b, "    Port %s\n", port)
+			}
+			fmt.Fprintf(&b, "    User %s\n", ws.Name)
+			fmt.Fprintf(&b, "    HostKeyAlias %s\n", proxyHostKeyAlias(proxy))
+			fmt.Fprintf(&b, "    UserKnownHostsFile ~/.justup/known_hosts\n")
+		}
+		return b.String(), nil
+	}
+
+	justup, err := os.Executable()
+	if err != nil {
+		return "", fmt.Errorf("failed to find the justup executable: %w", err)
+	}
+	if strings.ContainsAny(justup, " \t") {
+		justup = `"` + justup + `"`
+	}
+
+	for _, ws := range workspaces {
+		fmt.Fprintf(&b, "\nHost %s\n", hostKeyAlias(ws.Name))
+		fmt.Fprintf(&b, "    User dev\n")
+		fmt.Fprintf(&b, "    ProxyCommand %s ssh --stdio %s\n", justup, ws.Name)
+		fmt.Fprintf(&b, "    HostKeyAlias %s\n", hostKeyAlias(ws.Name))
+		fmt.Fprintf(&b, "    UserKnownHostsFile ~/.justup/known_hosts\n")
+		fmt.Fprintf(&b, "    StrictHostKeyChecking yes\n")
+	}
+	return b.String(), nil
+}
+
+// writeSSHConfig writes the generated configuration and pins the host keys
+// its hosts are checked against
+func writeSSHConfig(workspaces []kubernetes.Workspace, proxy string) error {
+	content, err := renderSSHConfig(workspaces, proxy)
+	if err != nil {
+		return err
+	}
+
+	path := getSSHConfigPath()
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return fmt.Errorf("failed to create %s: %w", filepath.Dir(path), err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+
+	if proxy != "" {
+		if err := pinProxyHostKey(proxy); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: failed to pin host key of proxy %s: %v\n", proxy, err)
+		}
+		return nil
+	}
+	for _, ws := range workspaces {
+		if ws.HostKey == "" {
+			continue
+		}
+		if err := pinHostKey(ws.Name, ws.HostKey); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: failed to pin host key of workspace '%s': %v\n", ws.Name, err)
+		}
+	}
+	return nil
+}
+
+// includeSSHConfig adds the Include line to the top of ~/.ssh/config unless
+// it is already there, reporting whether it was added. Include must come
+// before any Host entry to apply to every host.
+func includeSSHConfig() (bool, error) {
+	path := userSSHConfigPath()
+
+	mode := os.FileMode(0600)
+	existing, err := os.ReadFile(path)
+	switch {
+	case err == nil:
+		scanner := bufio.NewScanner(strings.NewReader(string(existing)))
+		for scanner.Scan() {
+			if strings.TrimSpace(scanner.Text()) == sshConfigInclude {
+				return false, nil
+			}
+		}
+		if info, err := os.Stat(path); err == nil {
+			mode = info.Mode().Perm()
+		}
+	case os.IsNotExist(err):
+		if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+			return false, fmt.Errorf("failed to create %s: %w", filepath.Dir(path), err)
+		}
+	default:
+		return false, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+
+	content := "# Added by 'justup ssh-config --install'\n" + sshConfigInclude + "\n"
+	if len(existing) > 0 {
+		content += "\n" + string(existing)
+	}
+	if err := os.WriteFile(path, []byte(content), mode); err != nil {
+		return false, fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	return true, nil
+}
+
+// refreshSSHConfig regenerates an installed SSH configuration after
+// workspaces change, leaving out a workspace just deleted; failures only
+// warn
+func refreshSSHConfig(ctx context.Context, backend workspaceBackend, deleted string) {
+	if _, err := os.Stat(getSSHConfigPath()); err != nil {
+		return
+	}
+
+	config := &Config{}
+	if err := readConfigFile(config); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: failed to update SSH configuration: %v\n", err)
+		return
+	}
+	workspaces, err := backend.ListWorkspaces(ctx, true)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: failed to update SSH configuration: %v\n", err)
+		return
+	}
+
+	kept := workspaces[:0]
+	for _, ws := range workspaces {
+		if ws.Name != deleted {
+			kept = append(kept, ws)
+		}
+	}
+	if err := writeSSHConfig(kept, config.SSHProxy); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: failed to update SSH configuration: %v\n", err)
+	}
+}
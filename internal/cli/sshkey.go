@@ -2,7 +2,9 @@ package cli
 
 import (
 	"bufio"
+	"context"
 	"fmt"
+	"net/http"
 	"os"
 	"path/filepath"
 	"strings"
@@ -10,6 +12,7 @@ import (
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/rahulvramesh/justup/pkg/api"
 	"github.com/rahulvramesh/justup/pkg/database"
 	"github.com/spf13/cobra"
 	"golang.org/x/crypto/ssh"
@@ -109,6 +112,25 @@ func runSSHKeyAdd(cmd *cobra.Command, args []string) {
 		}
 	}
 
+	publicKey := strings.TrimSpace(string(keyBytes))
+
+	// With a server configured, the key is registered there and cached
+	// locally
+	if client := apiClient(); client != nil {
+		ctx := context.Background()
+		if _, err := client.AddSSHKey(ctx, name, publicKey); err != nil {
+			if api.IsStatus(err, http.StatusConflict) {
+				fmt.Println("Key already registered on the server.")
+				fmt.Printf("Fingerprint: %s\n", fingerprint)
+				return
+			}
+			exitError("failed to add SSH key", err)
+		}
+		refreshSSHKeyCache(ctx, client)
+		printSSHKeyAdded(name, fingerprint)
+		return
+	}
+
 	// Open database
 	db, err := database.Open(getDBPath())
 	if err != nil {
@@ -135,7 +157,7 @@ func runSSHKeyAdd(cmd *cobra.Command, args []string) {
 		ID:          uuid.New().String(),
 		UserID:      user.ID,
 		Name:        name,
-		PublicKey:   strings.TrimSpace(string(keyBytes)),
+		PublicKey:   publicKey,
 		Fingerprint: fingerprint,
 		CreatedAt:   time.Now(),
 	}
@@ -144,6 +166,10 @@ func runSSHKeyAdd(cmd *cobra.Command, args []string) {
 		exitError("failed to add SSH key", err)
 	}
 
+	printSSHKeyAdded(name, fingerprint)
+}
+
+func printSSHKeyAdded(name, fingerprint string) {
 	fmt.Printf("SSH key added successfully!\n")
 	fmt.Printf("  Name:        %s\n", name)
 	fmt.Printf("  Fingerprint: %s\n", fingerprint)
@@ -152,18 +178,7 @@ func runSSHKeyAdd(cmd *cobra.Command, args []string) {
 }
 
 func runSSHKeyList(cmd *cobra.Command, args []string) {
-	db, err := database.Open(getDBPath())
-	if err != nil {
-		exitError("failed to open database", err)
-	}
-	defer db.Close()
-
-	user, err := db.GetOrCreateDefaultUser()
-	if err != nil {
-		exitError("failed to get user", err)
-	}
-
-	keys, err := db.ListSSHKeys(user.ID)
+	keys, err := listSSHKeys()
 	if err != nil {
 		exitError("failed to list SSH keys", err)
 	}
@@ -207,19 +222,8 @@ func runSSHKeyRemove(cmd *cobra.Command, args []string) {
 		return
 	}
 
-	db, err := database.Open(getDBPath())
-	if err != nil {
-		exitError("failed to open database", err)
-	}
-	defer db.Close()
-
 	// Try to find key by partial fingerprint match
-	user, err := db.GetOrCreateDefaultUser()
-	if err != nil {
-		exitError("failed to get user", err)
-	}
-
-	keys, err := db.ListSSHKeys(user.ID)
+	keys, err := listSSHKeys()
 	if err != nil {
 		exitError("failed to list SSH keys", err)
 	}
@@ -236,13 +240,132 @@ func runSSHKeyRemove(cmd *cobra.Command, args []string) {
 		exitError("SSH key not found", nil)
 	}
 
-	if err := db.DeleteSSHKey(matchedKey.ID); err != nil {
-		exitError("failed to remove SSH key", err)
+	if client := apiClient(); client != nil {
+		ctx := context.Background()
+		if err := client.RemoveSSHKey(ctx, matchedKey.Fingerprint); err != nil {
+			exitError("failed to remove SSH key", err)
+		}
+		refreshSSHKeyCache(ctx, client)
+	} else {
+		db, err := database.Open(getDBPath())
+		if err != nil {
+			exitError("failed to open database", err)
+		}
+		defer db.Close()
+
+		if err := db.DeleteSSHKey(matchedKey.ID); err != nil {
+			exitError("failed to remove SSH key", err)
+		}
 	}
 
 	fmt.Printf("SSH key '%s' removed.\n", matchedKey.Name)
 }
 
+// listSSHKeys lists the current user's SSH keys from the configured server,
+// refreshing the local cache, or from the local database
+func listSSHKeys() ([]database.SSHKey, error) {
+	if client := apiClient(); client != nil {
+		remote, err := client.ListSSHKeys(context.Background())
+		if err != nil {
+			return nil, err
+		}
+		cacheSSHKeys(remote)
+
+		keys := make([]database.SSHKey, 0, len(remote))
+		for _, key := range remote {
+			keys = append(keys, database.SSHKey{
+				ID:          key.ID,
+				Name:        key.Name,
+				PublicKey:   key.PublicKey,
+				Fingerprint: key.Fingerprint,
+				CreatedAt:   key.CreatedAt,
+				LastUsedAt:  key.LastUsedAt,
+			})
+		}
+		return keys, nil
+	}
+
+	db, err := database.Open(getDBPath())
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	user, err := db.GetOrCreateDefaultUser()
+	if err != nil {
+		return nil, err
+	}
+	return db.ListSSHKeys(user.ID)
+}
+
+// refreshSSHKeyCache re-fetches the server's keys into the local cache
+func refreshSSHKeyCache(ctx context.Context, client *api.Client) {
+	keys, err := client.ListSSHKeys(ctx)
+	if err != nil {
+		warnKeyCache(err)
+		return
+	}
+	cacheSSHKeys(keys)
+}
+
+// cacheSSHKeys makes the local database's keys match the server's. The cache
+// supplies the authorized_keys of workspaces created with this CLI.
+func cacheSSHKeys(remote []api.SSHKey) {
+	db, err := database.Open(getDBPath())
+	if err != nil {
+		warnKeyCache(err)
+		return
+	}
+	defer db.Close()
+
+	user, err := db.GetOrCreateDefaultUser()
+	if err != nil {
+		warnKeyCache(err)
+		return
+	}
+
+	local, err := db.ListSSHKeys(user.ID)
+	if err != nil {
+		warnKeyCache(err)
+		return
+	}
+
+	wanted := make(map[string]bool, len(remote))
+	for _, key := range remote {
+		wanted[key.Fingerprint] = true
+	}
+	cached := make(map[string]bool, len(local))
+	for _, key := range local {
+		cached[key.Fingerprint] = true
+		if !wanted[key.Fingerprint] {
+			if err := db.DeleteSSHKey(key.ID); err != nil {
+				warnKeyCache(err)
+			}
+		}
+	}
+
+	for _, key := range remote {
+		if cached[key.Fingerprint] {
+			continue
+		}
+		err := db.AddSSHKey(&database.SSHKey{
+			ID:          key.ID,
+			UserID:      user.ID,
+			Name:        key.Name,
+			PublicKey:   key.PublicKey,
+			Fingerprint: key.Fingerprint,
+			CreatedAt:   key.CreatedAt,
+		})
+		if err != nil {
+			warnKeyCache(err)
+		}
+	}
+}
+
+func warnKeyCache(err error) {
+	fmt.Fprintf(os.Stderr, "Warning: failed to update local SSH key cache: %v\n", err)
+}
+
 func formatTimeAgo(t time.Time) string {
 	d := time.Since(t)
 
@@ -3,48 +3,67 @@ package cli
 import (
 	"context"
 	"fmt"
+	"strconv"
 	"time"
 
+	"github.com/rahulvramesh/justup/pkg/database"
 	"github.com/rahulvramesh/justup/pkg/kubernetes"
 	"github.com/spf13/cobra"
 )
 
-var startWait bool
+var (
+	startWait      bool
+	startOverrides []string
+)
 
 var startCmd = &cobra.Command{
 	Use:   "start <workspace>",
 	Short: "Start a stopped workspace",
 	Long: `Start a previously stopped workspace.
 
-This will recreate the pod with the same configuration and attach the
-existing persistent volume.
+This will recreate the pod with the configuration the workspace was created
+with (image, resources, Docker-in-Docker, environment) and attach the
+existing persistent volume. Use --override to change the image, resources
+or Docker-in-Docker setting; the change is persisted for later starts.
 
 Examples:
   justup start myworkspace
-  justup start myworkspace --wait`,
+  justup start myworkspace --wait
+  justup start myworkspace --override cpu=4 --override memory=8Gi`,
 	Args: cobra.ExactArgs(1),
 	Run:  runStart,
 }
 
 func init() {
 	startCmd.Flags().BoolVarP(&startWait, "wait", "w", true, "Wait for workspace to be ready")
+	startCmd.Flags().StringArrayVar(&startOverrides, "override", nil, "Override a setting on start (image=, cpu=, memory=, dind=; repeatable)")
 }
 
 func runStart(cmd *cobra.Command, args []string) {
 	name := args[0]
 
-	client, err := kubernetes.NewClient()
+	overrides, err := parseStartOverrides(startOverrides)
 	if err != nil {
-		exitError("failed to create Kubernetes client", err)
+		exitError("invalid --override", err)
 	}
 
+	client := newWorkspaceBackend()
+
 	ctx := context.Background()
 
 	fmt.Printf("Starting workspace '%s'...\n", name)
-	if err := client.StartWorkspace(ctx, name); err != nil {
+	if err := client.StartWorkspace(ctx, name, overrides); err != nil {
 		exitError("failed to start workspace", err)
 	}
 
+	// Record the (possibly overridden) spec and the start time
+	if k8sClient, direct := client.(*kubernetes.Client); direct {
+		saveWorkspaceRecord(ctx, k8sClient, name)
+		recordWorkspace(func(db *database.DB, user *database.User) error {
+			return db.MarkWorkspaceStarted(name)
+		})
+	}
+
 	if startWait {
 		fmt.Print("Waiting for workspace to be ready")
 		for i := 0; i < 60; i++ {
@@ -52,7 +71,7 @@ func runStart(cmd *cobra.Command, args []string) {
 			if err != nil {
 				exitError("failed to get workspace status", err)
 			}
-			if ws.Status == "Running" {
+			if ws.Status == kubernetes.PhaseRunning {
 				fmt.Println(" done!")
 				fmt.Printf("\nTo connect:\n  justup ssh %s\n", name)
 				return
@@ -65,3 +84,34 @@ func runStart(cmd *cobra.Command, args []string) {
 		fmt.Println("Workspace starting.")
 	}
 }
+
+// parseStartOverrides parses --override key=value pairs into start options
+func parseStartOverrides(pairs []string) (kubernetes.StartOptions, error) {
+	var opts kubernetes.StartOptions
+
+	values, err := parseKeyValues(pairs)
+	if err != nil {
+		return opts, err
+	}
+
+	for key, value := range values {
+		switch key {
+		case "image":
+			opts.Image = value
+		case "cpu":
+			opts.CPU = value
+		case "memory":
+			opts.Memory = value
+		case "dind":
+			enabled, err := strconv.ParseBool(value)
+			if err != nil {
+				return opts, fmt.Errorf("dind must be true or false, got %q", value)
+			}
+			opts.EnableDinD = &enabled
+		default:
+			return opts, fmt.Errorf("unknown setting %q (supported: image, cpu, memory, dind)", key)
+		}
+	}
+
+	return opts, nil
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 
+	"github.com/rahulvramesh/justup/pkg/database"
 	"github.com/rahulvramesh/justup/pkg/kubernetes"
 	"github.com/spf13/cobra"
 )
@@ -24,10 +25,7 @@ Examples:
 func runStop(cmd *cobra.Command, args []string) {
 	name := args[0]
 
-	client, err := kubernetes.NewClient()
-	if err != nil {
-		exitError("failed to create Kubernetes client", err)
-	}
+	client := newWorkspaceBackend()
 
 	ctx := context.Background()
 
@@ -36,6 +34,12 @@ func runStop(cmd *cobra.Command, args []string) {
 		exitError("failed to stop workspace", err)
 	}
 
+	if _, direct := client.(*kubernetes.Client); direct {
+		recordWorkspace(func(db *database.DB, user *database.User) error {
+			return db.MarkWorkspaceStopped(name)
+		})
+	}
+
 	fmt.Println("Workspace stopped. Data is preserved.")
 	fmt.Printf("\nTo resume:\n  justup start %s\n", name)
 }
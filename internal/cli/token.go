@@ -0,0 +1,141 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/rahulvramesh/justup/pkg/api"
+	"github.com/spf13/cobra"
+)
+
+var (
+	tokenScopes  []string
+	tokenExpires string
+	tokenUser    string
+)
+
+var tokenCmd = &cobra.Command{
+	Use:   "token",
+	Short: "Manage API tokens",
+	Long: `Manage tokens for the justup API server.
+
+Each token belongs to a user and grants scopes:
+  read   List and get workspaces, keys and tokens
+  write  Create, start, stop and delete workspaces; manage keys and tokens
+  admin  Act on every user's workspaces and issue tokens for other users
+
+Requires 'justup login'.`,
+}
+
+var tokenCreateCmd = &cobra.Command{
+	Use:   "create <name>",
+	Short: "Create an API token",
+	Long: `Create an API token. The token is printed once; only its hash is stored.
+
+Admins can issue a token for another user with --user, which creates the
+user if needed. A token cannot be granted scopes its creator lacks.
+
+Examples:
+  justup token create laptop
+  justup token create ci --scope read --expires 24h
+  justup token create alice-laptop --user alice`,
+	Args: cobra.ExactArgs(1),
+	Run:  runTokenCreate,
+}
+
+var tokenListCmd = &cobra.Command{
+	Use:     "list",
+	Aliases: []string{"ls"},
+	Short:   "List your API tokens",
+	Args:    cobra.NoArgs,
+	Run:     runTokenList,
+}
+
+var tokenRevokeCmd = &cobra.Command{
+	Use:     "revoke <id>",
+	Aliases: []string{"rm"},
+	Short:   "Revoke an API token",
+	Long: `Revoke an API token by its ID.
+
+Use 'justup token list' to see token IDs.
+
+Examples:
+  justup token revoke 3f1c2a...`,
+	Args: cobra.ExactArgs(1),
+	Run:  runTokenRevoke,
+}
+
+func init() {
+	tokenCreateCmd.Flags().StringSliceVar(&tokenScopes, "scope", []string{api.ScopeRead, api.ScopeWrite}, "Scopes to grant (read, write, admin)")
+	tokenCreateCmd.Flags().StringVar(&tokenExpires, "expires", "2160h", "Lifetime of the token, e.g. 720h (0 never expires)")
+	tokenCreateCmd.Flags().StringVar(&tokenUser, "user", "", "Issue the token for another user (requires admin)")
+
+	tokenCmd.AddCommand(tokenCreateCmd)
+	tokenCmd.AddCommand(tokenListCmd)
+	tokenCmd.AddCommand(tokenRevokeCmd)
+
+	rootCmd.AddCommand(tokenCmd)
+}
+
+func runTokenCreate(cmd *cobra.Command, args []string) {
+	client := requireAPIClient()
+
+	resp, err := client.CreateToken(context.Background(), api.CreateTokenRequest{
+		Name:      args[0],
+		Scopes:    tokenScopes,
+		ExpiresIn: tokenExpires,
+		Username:  tokenUser,
+	})
+	if err != nil {
+		exitError("failed to create token", err)
+	}
+
+	fmt.Printf("Token '%s' created for %s.\n", resp.Name, resp.Username)
+	fmt.Printf("  ID:      %s\n", resp.ID)
+	fmt.Printf("  Scopes:  %s\n", strings.Join(resp.Scopes, ", "))
+	fmt.Printf("  Expires: %s\n", formatExpiry(resp.APIToken))
+	fmt.Printf("\n%s\n", resp.Token)
+	fmt.Printf("\nThe token is shown only once. Log in with:\n")
+	fmt.Printf("  justup login <server-url> --token <token>\n")
+}
+
+func runTokenList(cmd *cobra.Command, args []string) {
+	tokens, err := requireAPIClient().ListTokens(context.Background())
+	if err != nil {
+		exitError("failed to list tokens", err)
+	}
+
+	if len(tokens) == 0 {
+		fmt.Println("No API tokens.")
+		return
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tNAME\tSCOPES\tEXPIRES\tCREATED\tLAST USED")
+	for _, token := range tokens {
+		lastUsed := "never"
+		if token.LastUsedAt != nil {
+			lastUsed = formatTimeAgo(*token.LastUsedAt)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n",
+			token.ID, token.Name, strings.Join(token.Scopes, ","), formatExpiry(token), formatTimeAgo(token.CreatedAt), lastUsed)
+	}
+	w.Flush()
+}
+
+func runTokenRevoke(cmd *cobra.Command, args []string) {
+	if err := requireAPIClient().RevokeToken(context.Background(), args[0]); err != nil {
+		exitError("failed to revoke token", err)
+	}
+	fmt.Println("Token revoked.")
+}
+
+func formatExpiry(token api.APIToken) string {
+	if token.ExpiresAt == nil {
+		return "never"
+	}
+	return token.ExpiresAt.Local().Format("2006-01-02 15:04")
+}
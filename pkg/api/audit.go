@@ -0,0 +1,151 @@
+package api
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rahulvramesh/justup/pkg/database"
+)
+
+// maxAuditEvents caps the events returned by one request
+const maxAuditEvents = 1000
+
+// EnableRecordings serves the session recordings the SSH proxy writes to dir
+func (s *Server) EnableRecordings(dir string) {
+	s.recordingDir = dir
+}
+
+// listAuditEvents lists audit events. Users see their own sessions; admins
+// see everyone's.
+func (s *Server) listAuditEvents(w http.ResponseWriter, r *http.Request) {
+	p := caller(r)
+	query := r.URL.Query()
+
+	filter := database.AuditFilter{
+		SessionID: query.Get("session"),
+		Workspace: query.Get("workspace"),
+		Event:     query.Get("event"),
+		Limit:     100,
+	}
+
+	if v := query.Get("since"); v != "" {
+		since, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid since %q", v))
+			return
+		}
+		filter.Since = since
+	}
+	if v := query.Get("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit <= 0 || limit > maxAuditEvents {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("limit must be between 1 and %d", maxAuditEvents))
+			return
+		}
+		filter.Limit = limit
+	}
+
+	if username := query.Get("user"); username != "" && username != p.user.Username {
+		if !p.isAdmin() {
+			writeError(w, http.StatusForbidden, "listing other users' events requires the 'admin' scope")
+			return
+		}
+		user, err := s.db.GetUserByUsername(username)
+		if errors.Is(err, sql.ErrNoRows) {
+			writeError(w, http.StatusNotFound, "user '"+username+"' not found")
+			return
+		}
+		if err != nil {
+			writeInternalError(w, "get user", err)
+			return
+		}
+		filter.UserID = user.ID
+	} else if username != "" || !p.isAdmin() {
+		filter.UserID = p.user.ID
+	}
+
+	events, err := s.db.ListAuditEvents(filter)
+	if err != nil {
+		writeInternalError(w, "list audit events", err)
+		return
+	}
+
+	resp := make([]AuditEvent, 0, len(events))
+	for _, event := range events {
+		resp = append(resp, AuditEvent{
+			ID:         event.ID,
+			Time:       event.Time,
+			SessionID:  event.SessionID,
+			Event:      event.Event,
+			Username:   event.Username,
+			Workspace:  event.Workspace,
+			RemoteAddr: event.RemoteAddr,
+			Detail:     event.Detail,
+		})
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// getRecording serves the asciicast recording of a session channel, the
+// first recorded channel unless the channel parameter is given
+func (s *Server) getRecording(w http.ResponseWriter, r *http.Request) {
+	if s.recordingDir == "" {
+		writeError(w, http.StatusNotFound, "session recordings are not enabled on this server")
+		return
+	}
+
+	p := caller(r)
+	sessionID := r.PathValue("id")
+
+	events, err := s.db.ListAuditEvents(database.AuditFilter{SessionID: sessionID})
+	if err != nil {
+		writeInternalError(w, "list audit events", err)
+		return
+	}
+	if len(events) == 0 || (!p.isAdmin() && events[0].UserID != p.user.ID) {
+		writeError(w, http.StatusNotFound, "session '"+sessionID+"' not found")
+		return
+	}
+
+	channel := r.URL.Query().Get("channel")
+	var name string
+	for _, event := range events {
+		if event.Event != database.AuditRecording {
+			continue
+		}
+		// Recordings are named <session>-<channel>.cast
+		file := filepath.Base(event.Detail)
+		if channel == "" || file == fmt.Sprintf("%s-%s.cast", sessionID, channel) {
+			name = file
+			break
+		}
+	}
+	if name == "" {
+		writeError(w, http.StatusNotFound, "no recording for session '"+sessionID+"'")
+		return
+	}
+
+	f, err := os.Open(filepath.Join(s.recordingDir, name))
+	if errors.Is(err, os.ErrNotExist) {
+		writeError(w, http.StatusNotFound, "recording "+name+" is no longer available")
+		return
+	}
+	if err != nil {
+		writeInternalError(w, "open recording", err)
+		return
+	}
+	defer f.Close()
+
+	w.Header().Set("Content-Type", "application/x-asciicast")
+	w.Header().Set("Content-Disposition", `attachment; filename="`+strings.ReplaceAll(name, `"`, "")+`"`)
+	w.WriteHeader(http.StatusOK)
+	io.Copy(w, f)
+}
@@ -0,0 +1,189 @@
+package api
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/rahulvramesh/justup/pkg/database"
+)
+
+// Scopes an API token can grant
+const (
+	// ScopeRead allows listing and getting workspaces, keys and tokens
+	ScopeRead = "read"
+	// ScopeWrite allows creating, changing and deleting them
+	ScopeWrite = "write"
+	// ScopeAdmin allows acting on every user's workspaces and issuing
+	// tokens for other users
+	ScopeAdmin = "admin"
+)
+
+// tokenPrefix marks justup API tokens so they are recognizable in config
+// files and secret scanners
+const tokenPrefix = "jut_"
+
+// IsValidScope reports whether scope is a known scope
+func IsValidScope(scope string) bool {
+	return scope == ScopeRead || scope == ScopeWrite || scope == ScopeAdmin
+}
+
+// LoadToken reads the bootstrap token from path
+func LoadToken(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to read API token: %w", err)
+	}
+	token := strings.TrimSpace(string(data))
+	if token == "" {
+		return "", fmt.Errorf("API token file %s is empty", path)
+	}
+	return token, nil
+}
+
+// GenerateToken returns a new random API token
+func GenerateToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return tokenPrefix + hex.EncodeToString(b), nil
+}
+
+// HashToken returns the hash under which a token is stored
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// principal is the authenticated caller of a request
+type principal struct {
+	user    *database.User
+	scopes  []string
+	tokenID string // Empty for the bootstrap token
+}
+
+func (p *principal) hasScope(scope string) bool {
+	for _, s := range p.scopes {
+		if s == scope || s == ScopeAdmin {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *principal) isAdmin() bool {
+	return p.hasScope(ScopeAdmin)
+}
+
+type principalKey struct{}
+
+// caller returns the authenticated caller of a request
+func caller(r *http.Request) *principal {
+	return r.Context().Value(principalKey{}).(*principal)
+}
+
+// authenticate resolves a bearer token to its user and scopes. The bootstrap
+// token acts as the default user with every scope; other tokens are looked
+// up by hash.
+func (s *Server) authenticate(token string) (*principal, error) {
+	if s.token != "" && subtle.ConstantTimeCompare([]byte(token), []byte(s.token)) == 1 {
+		user, err := s.db.GetOrCreateDefaultUser()
+		if err != nil {
+			return nil, err
+		}
+		return &principal{user: user, scopes: []string{ScopeRead, ScopeWrite, ScopeAdmin}}, nil
+	}
+
+	apiToken, err := s.db.GetAPITokenByHash(HashToken(token))
+	if err != nil {
+		return nil, err
+	}
+	if apiToken.Expired(time.Now()) {
+		return nil, errors.New("token expired")
+	}
+
+	user, err := s.db.GetUser(apiToken.UserID)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.db.UpdateAPITokenLastUsed(apiToken.ID); err != nil {
+		log.Printf("API: failed to update token last use: %v", err)
+	}
+
+	return &principal{user: user, scopes: apiToken.Scopes, tokenID: apiToken.ID}, nil
+}
+
+// ServeHTTP authenticates the request, checks the token's scope allows the
+// method and dispatches it. Login endpoints are dispatched without a token.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if h, pattern := s.public.Handler(r); pattern != "" {
+		h.ServeHTTP(w, r)
+		return
+	}
+
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || token == "" {
+		writeError(w, http.StatusUnauthorized, "invalid or missing API token")
+		return
+	}
+
+	p, err := s.authenticate(token)
+	if err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			log.Printf("API: authentication failed: %v", err)
+		}
+		writeError(w, http.StatusUnauthorized, "invalid or expired API token")
+		return
+	}
+
+	scope := ScopeWrite
+	if r.Method == http.MethodGet || r.Method == http.MethodHead {
+		scope = ScopeRead
+	}
+	if !p.hasScope(scope) {
+		writeError(w, http.StatusForbidden, "token lacks the '"+scope+"' scope")
+		return
+	}
+
+	s.mux.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), principalKey{}, p)))
+}
+
+// requireRole checks that the caller has one of roles on a workspace,
+// writing a 404 otherwise so that other users' workspaces are not revealed.
+// Admins may act on every workspace.
+func (s *Server) requireRole(w http.ResponseWriter, r *http.Request, name string, roles ...string) bool {
+	p := caller(r)
+	if p.isAdmin() {
+		return true
+	}
+
+	role, err := s.db.GetWorkspaceRole(name, p.user.ID)
+	if err == nil {
+		for _, allowed := range roles {
+			if role == allowed {
+				return true
+			}
+		}
+		writeError(w, http.StatusForbidden, "your role on workspace '"+name+"' does not allow this")
+		return false
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		writeInternalError(w, "get workspace role", err)
+		return false
+	}
+
+	writeError(w, http.StatusNotFound, "workspace '"+name+"' not found")
+	return false
+}
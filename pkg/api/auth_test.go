@@ -0,0 +1,96 @@
+package api
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"net/http"
+	"testing"
+
+	"github.com/rahulvramesh/justup/pkg/kubernetes"
+	"golang.org/x/crypto/ssh"
+)
+
+// newPublicKey returns a fresh public key in authorized_keys format
+func newPublicKey(t *testing.T) string {
+	t.Helper()
+
+	pub, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	sshPub, err := ssh.NewPublicKey(pub)
+	if err != nil {
+		t.Fatalf("failed to convert key: %v", err)
+	}
+	return string(ssh.MarshalAuthorizedKey(sshPub))
+}
+
+func TestReadScope(t *testing.T) {
+	_, httpServer, admin := newServer(t)
+	ctx := context.Background()
+	reader := userClient(t, httpServer, admin, "alice", ScopeRead)
+
+	reads := map[string]func() error{
+		"whoami":      func() error { _, err := reader.WhoAmI(ctx); return err },
+		"list keys":   func() error { _, err := reader.ListSSHKeys(ctx); return err },
+		"list tokens": func() error { _, err := reader.ListTokens(ctx); return err },
+	}
+	for name, call := range reads {
+		t.Run(name, func(t *testing.T) {
+			if err := call(); err != nil {
+				t.Errorf("read-scoped token failed: %v", err)
+			}
+		})
+	}
+
+	// Writes are refused before the handler runs, so none reaches the
+	// missing cluster
+	writes := map[string]func() error{
+		"add key": func() error { _, err := reader.AddSSHKey(ctx, "laptop", newPublicKey(t)); return err },
+		"create token": func() error {
+			_, err := reader.CreateToken(ctx, CreateTokenRequest{Name: "ci", Scopes: []string{ScopeRead}})
+			return err
+		},
+		"revoke token": func() error { return reader.RevokeToken(ctx, "token-id") },
+		"create workspace": func() error {
+			_, err := reader.CreateWorkspace(ctx, kubernetes.WorkspaceOptions{Name: "myproject", GitURL: "https://github.com/user/repo.git"})
+			return err
+		},
+		"stop workspace": func() error { return reader.StopWorkspace(ctx, "myproject") },
+	}
+	for name, call := range writes {
+		t.Run(name, func(t *testing.T) {
+			if err := call(); !IsStatus(err, http.StatusForbidden) {
+				t.Errorf("got error %v, want 403", err)
+			}
+		})
+	}
+}
+
+func TestTokenScopes(t *testing.T) {
+	_, httpServer, admin := newServer(t)
+	ctx := context.Background()
+	writer := userClient(t, httpServer, admin, "alice", ScopeRead, ScopeWrite)
+
+	if _, err := writer.AddSSHKey(ctx, "laptop", newPublicKey(t)); err != nil {
+		t.Errorf("write-scoped token failed to add a key: %v", err)
+	}
+
+	// Tokens cannot grant more than their own scopes
+	_, err := writer.CreateToken(ctx, CreateTokenRequest{Name: "escalate", Scopes: []string{ScopeAdmin}})
+	if !IsStatus(err, http.StatusForbidden) {
+		t.Errorf("got error %v, want 403 for an admin token", err)
+	}
+
+	resp, err := writer.CreateToken(ctx, CreateTokenRequest{Name: "ci", Scopes: []string{ScopeRead}})
+	if err != nil {
+		t.Fatalf("failed to create token: %v", err)
+	}
+	if err := writer.RevokeToken(ctx, resp.ID); err != nil {
+		t.Fatalf("failed to revoke token: %v", err)
+	}
+	if _, err := NewClient(httpServer.URL, resp.Token).WhoAmI(ctx); !IsStatus(err, http.StatusUnauthorized) {
+		t.Errorf("got error %v, want 401 for a revoked token", err)
+	}
+}
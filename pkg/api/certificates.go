@@ -0,0 +1,101 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/rahulvramesh/justup/pkg/sshca"
+	"golang.org/x/crypto/ssh"
+)
+
+// EnableCertificates lets users get their public keys certified by ca, for
+// at most maxValidity
+func (s *Server) EnableCertificates(ca ssh.Signer, maxValidity time.Duration) {
+	s.sshCA = ca
+	s.maxCertValidity = maxValidity
+}
+
+func (s *Server) signCertificate(w http.ResponseWriter, r *http.Request) {
+	if s.sshCA == nil {
+		writeError(w, http.StatusNotFound, "certificate signing is not enabled on this server")
+		return
+	}
+
+	p := caller(r)
+
+	var req SignCertificateRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+
+	pubKey, _, _, _, err := ssh.ParseAuthorizedKey([]byte(req.PublicKey))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid public key: %v", err))
+		return
+	}
+	if _, ok := pubKey.(*ssh.Certificate); ok {
+		writeError(w, http.StatusBadRequest, "invalid public key: got a certificate")
+		return
+	}
+
+	validFor := s.maxCertValidity
+	if req.ValidFor != "" {
+		d, err := time.ParseDuration(req.ValidFor)
+		if err != nil || d <= 0 {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid validFor %q", req.ValidFor))
+			return
+		}
+		if d > s.maxCertValidity {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("validFor may not exceed %s", s.maxCertValidity))
+			return
+		}
+		validFor = d
+	}
+
+	for _, cidr := range strings.Split(req.SourceAddress, ",") {
+		if cidr = strings.TrimSpace(cidr); cidr == "" {
+			continue
+		}
+		if _, _, err := net.ParseCIDR(cidr); err != nil && net.ParseIP(cidr) == nil {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid source address %q", cidr))
+			return
+		}
+	}
+	for _, name := range req.Workspaces {
+		if name == "" || strings.Contains(name, ",") {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid workspace name %q", name))
+			return
+		}
+	}
+
+	cert, err := sshca.SignUserCertificate(s.sshCA, pubKey, sshca.CertificateOptions{
+		Principal:     p.user.Username,
+		KeyID:         fmt.Sprintf("%s (%s)", p.user.Username, ssh.FingerprintSHA256(pubKey)),
+		ValidFor:      validFor,
+		Workspaces:    req.Workspaces,
+		SourceAddress: req.SourceAddress,
+	})
+	if err != nil {
+		writeInternalError(w, "sign certificate", err)
+		return
+	}
+
+	log.Printf("API: issued %s to %s, valid until %s", sshca.Describe(cert), p.user.Username,
+		time.Unix(int64(cert.ValidBefore), 0).UTC().Format(time.RFC3339))
+
+	writeJSON(w, http.StatusCreated, SSHCertificate{
+		Certificate: strings.TrimSpace(string(ssh.MarshalAuthorizedKey(cert))),
+		KeyID:       cert.KeyId,
+		Serial:      cert.Serial,
+		Principals:  cert.ValidPrincipals,
+		Workspaces:  req.Workspaces,
+		ValidAfter:  time.Unix(int64(cert.ValidAfter), 0),
+		ValidBefore: time.Unix(int64(cert.ValidBefore), 0),
+	})
+}
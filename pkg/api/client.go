@@ -0,0 +1,346 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rahulvramesh/justup/pkg/kubernetes"
+)
+
+// Client calls the justup HTTP API
+type Client struct {
+	baseURL    string
+	token      string
+	httpClient *http.Client
+}
+
+// NewClient creates an API client for the server at baseURL
+func NewClient(baseURL, token string) *Client {
+	return &Client{
+		baseURL:    strings.TrimSuffix(baseURL, "/"),
+		token:      token,
+		httpClient: &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// StatusError is returned for non-2xx responses
+type StatusError struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *StatusError) Error() string {
+	return e.Message
+}
+
+// IsStatus reports whether err is a StatusError with the given status code
+func IsStatus(err error, code int) bool {
+	statusErr, ok := err.(*StatusError)
+	return ok && statusErr.StatusCode == code
+}
+
+// CreateWorkspace creates a workspace
+func (c *Client) CreateWorkspace(ctx context.Context, opts kubernetes.WorkspaceOptions) (*kubernetes.Workspace, error) {
+	req := CreateWorkspaceRequest{
+		Name:        opts.Name,
+		GitURL:      opts.GitURL,
+		Branch:      opts.Branch,
+		Image:       opts.Image,
+		CPU:         opts.CPU,
+		Memory:      opts.Memory,
+		Storage:     opts.Storage,
+		EnableDinD:  opts.EnableDinD,
+		Env:         opts.Env,
+		IdleTimeout: opts.IdleTimeout,
+	}
+
+	var ws Workspace
+	if err := c.do(ctx, http.MethodPost, "/api/v1/workspaces", req, &ws); err != nil {
+		return nil, err
+	}
+	return fromWorkspace(&ws), nil
+}
+
+// GetWorkspace gets a workspace by name
+func (c *Client) GetWorkspace(ctx context.Context, name string) (*kubernetes.Workspace, error) {
+	var ws Workspace
+	if err := c.do(ctx, http.MethodGet, workspacePath(name), nil, &ws); err != nil {
+		return nil, err
+	}
+	return fromWorkspace(&ws), nil
+}
+
+// ListWorkspaces lists workspaces. Unless includeAll is set, only starting,
+// running and stopping workspaces are returned.
+func (c *Client) ListWorkspaces(ctx context.Context, includeAll bool) ([]kubernetes.Workspace, error) {
+	path := "/api/v1/workspaces"
+	if includeAll {
+		path += "?all=true"
+	}
+
+	var resp []Workspace
+	if err := c.do(ctx, http.MethodGet, path, nil, &resp); err != nil {
+		return nil, err
+	}
+
+	workspaces := make([]kubernetes.Workspace, 0, len(resp))
+	for _, ws := range resp {
+		workspaces = append(workspaces, *fromWorkspace(&ws))
+	}
+	return workspaces, nil
+}
+
+// StartWorkspace starts a stopped workspace, applying any overrides
+func (c *Client) StartWorkspace(ctx context.Context, name string, overrides kubernetes.StartOptions) error {
+	req := StartWorkspaceRequest{
+		Image:      overrides.Image,
+		CPU:        overrides.CPU,
+		Memory:     overrides.Memory,
+		EnableDinD: overrides.EnableDinD,
+	}
+	return c.do(ctx, http.MethodPost, workspacePath(name)+"/start", req, nil)
+}
+
+// StopWorkspace stops a running workspace
+func (c *Client) StopWorkspace(ctx context.Context, name string) error {
+	return c.do(ctx, http.MethodPost, workspacePath(name)+"/stop", nil, nil)
+}
+
+// DeleteWorkspace deletes a workspace
+func (c *Client) DeleteWorkspace(ctx context.Context, opts kubernetes.DeleteOptions) error {
+	path := workspacePath(opts.Name)
+	if opts.KeepPVC {
+		path += "?keepPVC=true"
+	}
+	return c.do(ctx, http.MethodDelete, path, nil, nil)
+}
+
+// SetWorkspaceSchedule sets or, with nil, clears a workspace's start/stop
+// schedule
+func (c *Client) SetWorkspaceSchedule(ctx context.Context, name string, schedule *kubernetes.WorkspaceSchedule) error {
+	if schedule == nil {
+		return c.do(ctx, http.MethodDelete, workspacePath(name)+"/schedule", nil, nil)
+	}
+	return c.do(ctx, http.MethodPut, workspacePath(name)+"/schedule", schedule, nil)
+}
+
+// SetWorkspaceForwarding sets or, with nil, clears a workspace's port
+// forwarding allowlists
+func (c *Client) SetWorkspaceForwarding(ctx context.Context, name string, forwarding *kubernetes.WorkspaceForwarding) error {
+	if forwarding == nil {
+		return c.do(ctx, http.MethodDelete, workspacePath(name)+"/forwarding", nil, nil)
+	}
+	return c.do(ctx, http.MethodPut, workspacePath(name)+"/forwarding", forwarding, nil)
+}
+
+// ListShares lists who a workspace is shared with
+func (c *Client) ListShares(ctx context.Context, name string) ([]WorkspaceShare, error) {
+	var shares []WorkspaceShare
+	err := c.do(ctx, http.MethodGet, workspacePath(name)+"/shares", nil, &shares)
+	return shares, err
+}
+
+// ShareWorkspace grants a user a role on a workspace
+func (c *Client) ShareWorkspace(ctx context.Context, name, username, role string) error {
+	return c.do(ctx, http.MethodPut, sharePath(name, username), ShareWorkspaceRequest{Role: role}, nil)
+}
+
+// UnshareWorkspace revokes a user's access to a workspace
+func (c *Client) UnshareWorkspace(ctx context.Context, name, username string) error {
+	return c.do(ctx, http.MethodDelete, sharePath(name, username), nil, nil)
+}
+
+func workspacePath(name string) string {
+	return "/api/v1/workspaces/" + url.PathEscape(name)
+}
+
+func sharePath(name, username string) string {
+	return workspacePath(name) + "/shares/" + url.PathEscape(username)
+}
+
+// ListSSHKeys lists the registered SSH keys
+func (c *Client) ListSSHKeys(ctx context.Context) ([]SSHKey, error) {
+	var keys []SSHKey
+	err := c.do(ctx, http.MethodGet, "/api/v1/keys", nil, &keys)
+	return keys, err
+}
+
+// AddSSHKey registers an SSH public key
+func (c *Client) AddSSHKey(ctx context.Context, name, publicKey string) (*SSHKey, error) {
+	var key SSHKey
+	err := c.do(ctx, http.MethodPost, "/api/v1/keys", AddSSHKeyRequest{Name: name, PublicKey: publicKey}, &key)
+	if err != nil {
+		return nil, err
+	}
+	return &key, nil
+}
+
+// RemoveSSHKey removes the SSH key with the given fingerprint
+func (c *Client) RemoveSSHKey(ctx context.Context, fingerprint string) error {
+	return c.do(ctx, http.MethodDelete, "/api/v1/keys/"+url.PathEscape(fingerprint), nil, nil)
+}
+
+// SignCertificate has the server's CA certify a public key for the client's
+// user
+func (c *Client) SignCertificate(ctx context.Context, req SignCertificateRequest) (*SSHCertificate, error) {
+	var cert SSHCertificate
+	if err := c.do(ctx, http.MethodPost, "/api/v1/certificates", req, &cert); err != nil {
+		return nil, err
+	}
+	return &cert, nil
+}
+
+// WhoAmI describes the user and scopes of the client's token
+func (c *Client) WhoAmI(ctx context.Context) (*WhoAmI, error) {
+	var who WhoAmI
+	if err := c.do(ctx, http.MethodGet, "/api/v1/whoami", nil, &who); err != nil {
+		return nil, err
+	}
+	return &who, nil
+}
+
+// ListTokens lists the API tokens of the client's user
+func (c *Client) ListTokens(ctx context.Context) ([]APIToken, error) {
+	var tokens []APIToken
+	err := c.do(ctx, http.MethodGet, "/api/v1/tokens", nil, &tokens)
+	return tokens, err
+}
+
+// CreateToken issues an API token
+func (c *Client) CreateToken(ctx context.Context, req CreateTokenRequest) (*CreateTokenResponse, error) {
+	var resp CreateTokenResponse
+	if err := c.do(ctx, http.MethodPost, "/api/v1/tokens", req, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// RevokeToken revokes the API token with the given ID
+func (c *Client) RevokeToken(ctx context.Context, id string) error {
+	return c.do(ctx, http.MethodDelete, "/api/v1/tokens/"+url.PathEscape(id), nil, nil)
+}
+
+// OIDCConfig gets the OIDC provider the server accepts logins from. It
+// needs no token.
+func (c *Client) OIDCConfig(ctx context.Context) (*OIDCConfig, error) {
+	var config OIDCConfig
+	if err := c.do(ctx, http.MethodGet, "/api/v1/auth/oidc", nil, &config); err != nil {
+		return nil, err
+	}
+	return &config, nil
+}
+
+// OIDCLogin exchanges an ID token for an API token. It needs no token.
+func (c *Client) OIDCLogin(ctx context.Context, idToken, tokenName string) (*CreateTokenResponse, error) {
+	var resp CreateTokenResponse
+	req := OIDCLoginRequest{IDToken: idToken, TokenName: tokenName}
+	if err := c.do(ctx, http.MethodPost, "/api/v1/auth/oidc/token", req, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// ListAuditEvents lists the SSH proxy's audit events, oldest first
+func (c *Client) ListAuditEvents(ctx context.Context, q AuditQuery) ([]AuditEvent, error) {
+	params := url.Values{}
+	for key, value := range map[string]string{
+		"session":   q.SessionID,
+		"user":      q.Username,
+		"workspace": q.Workspace,
+		"event":     q.Event,
+	} {
+		if value != "" {
+			params.Set(key, value)
+		}
+	}
+	if !q.Since.IsZero() {
+		params.Set("since", q.Since.UTC().Format(time.RFC3339))
+	}
+	if q.Limit > 0 {
+		params.Set("limit", strconv.Itoa(q.Limit))
+	}
+
+	path := "/api/v1/audit/events"
+	if len(params) > 0 {
+		path += "?" + params.Encode()
+	}
+
+	var events []AuditEvent
+	err := c.do(ctx, http.MethodGet, path, nil, &events)
+	return events, err
+}
+
+// GetRecording downloads the asciicast recording of a session channel;
+// channel 0 gets the session's first recording
+func (c *Client) GetRecording(ctx context.Context, sessionID string, channel int) ([]byte, error) {
+	path := "/api/v1/audit/sessions/" + url.PathEscape(sessionID) + "/recording"
+	if channel > 0 {
+		path += "?channel=" + strconv.Itoa(channel)
+	}
+
+	var buf bytes.Buffer
+	if err := c.do(ctx, http.MethodGet, path, nil, &buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// do sends a JSON request and decodes the JSON response into out, if set.
+// An io.Writer out receives the raw response body instead.
+func (c *Client) do(ctx context.Context, method, path string, in, out interface{}) error {
+	var body io.Reader
+	if in != nil {
+		data, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+path, body)
+	if err != nil {
+		return err
+	}
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to reach justup server: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		var errResp ErrorResponse
+		if err := json.NewDecoder(resp.Body).Decode(&errResp); err != nil || errResp.Error == "" {
+			errResp.Error = resp.Status
+		}
+		return &StatusError{StatusCode: resp.StatusCode, Message: errResp.Error}
+	}
+
+	if out == nil {
+		return nil
+	}
+	if w, ok := out.(io.Writer); ok {
+		if _, err := io.Copy(w, resp.Body); err != nil {
+			return fmt.Errorf("failed to read response: %w", err)
+		}
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("failed to decode response: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,150 @@
+package api
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/rahulvramesh/justup/pkg/database"
+	"github.com/rahulvramesh/justup/pkg/oidc"
+)
+
+// defaultOIDCTokenName names tokens issued through OIDC login when the
+// client does not choose a name
+const defaultOIDCTokenName = "oidc-login"
+
+// errIdentityConflict is returned when an ID token's email belongs to a user
+// linked to a different OIDC subject
+var errIdentityConflict = errors.New("email address is linked to another identity")
+
+// EnableOIDC lets users log in with ID tokens issued by provider for
+// clientID. Each login issues an API token that expires after tokenTTL, or
+// never if it is zero.
+func (s *Server) EnableOIDC(provider *oidc.Provider, clientID string, tokenTTL time.Duration) {
+	s.oidcProvider = provider
+	s.oidcClientID = clientID
+	s.oidcTokenTTL = tokenTTL
+}
+
+// getOIDCConfig tells clients where to run the device flow. It is served
+// without authentication.
+func (s *Server) getOIDCConfig(w http.ResponseWriter, r *http.Request) {
+	if s.oidcProvider == nil {
+		writeError(w, http.StatusNotFound, "OIDC login is not enabled on this server")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, OIDCConfig{
+		Issuer:   s.oidcProvider.Issuer,
+		ClientID: s.oidcClientID,
+	})
+}
+
+// oidcLogin exchanges a verified ID token for an API token, provisioning
+// the user on first login. It is served without authentication.
+func (s *Server) oidcLogin(w http.ResponseWriter, r *http.Request) {
+	if s.oidcProvider == nil {
+		writeError(w, http.StatusNotFound, "OIDC login is not enabled on this server")
+		return
+	}
+
+	var req OIDCLoginRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.IDToken == "" {
+		writeError(w, http.StatusBadRequest, "idToken is required")
+		return
+	}
+
+	claims, err := s.oidcProvider.Verify(r.Context(), s.oidcClientID, req.IDToken)
+	if err != nil {
+		log.Printf("API: rejected ID token: %v", err)
+		writeError(w, http.StatusUnauthorized, "invalid ID token")
+		return
+	}
+
+	user, err := s.oidcUser(claims)
+	if errors.Is(err, errIdentityConflict) {
+		writeError(w, http.StatusForbidden, err.Error())
+		return
+	}
+	if err != nil {
+		writeInternalError(w, "provision user", err)
+		return
+	}
+
+	var expiresAt *time.Time
+	if s.oidcTokenTTL > 0 {
+		t := time.Now().Add(s.oidcTokenTTL)
+		expiresAt = &t
+	}
+
+	name := req.TokenName
+	if name == "" {
+		name = defaultOIDCTokenName
+	}
+
+	resp, err := s.issueToken(user, name, []string{ScopeRead, ScopeWrite}, expiresAt)
+	if err != nil {
+		writeInternalError(w, "create token", err)
+		return
+	}
+	log.Printf("API: OIDC login for user %s (subject %s)", user.Username, claims.Subject)
+	writeJSON(w, http.StatusCreated, resp)
+}
+
+// oidcUser maps ID token claims to a user. Users are found by subject, then
+// by verified email address (also matching users an admin created with the
+// email as username), and are created otherwise.
+func (s *Server) oidcUser(claims *oidc.Claims) (*database.User, error) {
+	email := ""
+	if claims.EmailVerified {
+		email = claims.Email
+	}
+
+	user, err := s.db.GetUserByOIDCSubject(claims.Subject)
+	if err == nil {
+		if email != "" && email != user.Email {
+			if err := s.db.LinkOIDCUser(user.ID, email, claims.Subject); err != nil {
+				return nil, err
+			}
+		}
+		return user, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return nil, err
+	}
+
+	if email != "" {
+		user, err := s.db.GetUserByEmail(email)
+		if errors.Is(err, sql.ErrNoRows) {
+			user, err = s.db.GetUserByUsername(email)
+		}
+		if err == nil {
+			if user.OIDCSubject != "" {
+				return nil, errIdentityConflict
+			}
+			if err := s.db.LinkOIDCUser(user.ID, email, claims.Subject); err != nil {
+				return nil, err
+			}
+			return s.db.GetUser(user.ID)
+		}
+		if !errors.Is(err, sql.ErrNoRows) {
+			return nil, err
+		}
+	}
+
+	username := email
+	if username == "" {
+		username = fmt.Sprintf("oidc-%s", claims.Subject)
+	}
+
+	id := uuid.New().String()
+	if err := s.db.CreateOIDCUser(id, username, email, claims.Subject); err != nil {
+		return nil, err
+	}
+	return s.db.GetUser(id)
+}
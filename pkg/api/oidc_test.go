@@ -0,0 +1,192 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/rahulvramesh/justup/pkg/oidc"
+	"github.com/rahulvramesh/justup/pkg/oidc/oidctest"
+)
+
+const oidcClientID = "justup-cli"
+
+// newOIDCServer starts an API server accepting ID tokens from a test
+// provider
+func newOIDCServer(t *testing.T) (*Server, *httptest.Server, *oidctest.Provider) {
+	t.Helper()
+
+	s, httpServer, _ := newServer(t)
+	idp := oidctest.NewProvider(oidcClientID)
+	t.Cleanup(idp.Close)
+	provider, err := oidc.Discover(context.Background(), idp.Issuer())
+	if err != nil {
+		t.Fatalf("failed to discover provider: %v", err)
+	}
+
+	s.EnableOIDC(provider, oidcClientID, time.Hour)
+	return s, httpServer, idp
+}
+
+func TestOIDCLogin(t *testing.T) {
+	s, httpServer, idp := newOIDCServer(t)
+	ctx := context.Background()
+	client := NewClient(httpServer.URL, "")
+
+	config, err := client.OIDCConfig(ctx)
+	if err != nil {
+		t.Fatalf("failed to get OIDC config: %v", err)
+	}
+	if config.Issuer != idp.Issuer() || config.ClientID != oidcClientID {
+		t.Errorf("config is %+v, want issuer %q and client %q", config, idp.Issuer(), oidcClientID)
+	}
+
+	alice := oidctest.Identity{Subject: "alice-subject", Email: "alice@example.com", EmailVerified: true}
+	resp, err := client.OIDCLogin(ctx, idp.IDToken(alice, nil), "laptop")
+	if err != nil {
+		t.Fatalf("failed to log in: %v", err)
+	}
+	if resp.Name != "laptop" || resp.ExpiresAt == nil {
+		t.Errorf("token is %+v, want one named laptop that expires", resp.APIToken)
+	}
+
+	whoami, err := NewClient(httpServer.URL, resp.Token).WhoAmI(ctx)
+	if err != nil {
+		t.Fatalf("issued token does not work: %v", err)
+	}
+	if whoami.Username != alice.Email {
+		t.Errorf("logged in as %q, want %q", whoami.Username, alice.Email)
+	}
+
+	// Logging in again finds the same user
+	again, err := client.OIDCLogin(ctx, idp.IDToken(alice, nil), "")
+	if err != nil {
+		t.Fatalf("failed to log in again: %v", err)
+	}
+	if again.Name != defaultOIDCTokenName {
+		t.Errorf("token is named %q, want %q", again.Name, defaultOIDCTokenName)
+	}
+	user, err := s.db.GetUserByOIDCSubject(alice.Subject)
+	if err != nil || user.ID != whoami.UserID {
+		t.Errorf("subject maps to %+v (%v), want user %s", user, err, whoami.UserID)
+	}
+}
+
+func TestOIDCLoginRejected(t *testing.T) {
+	_, httpServer, idp := newOIDCServer(t)
+	ctx := context.Background()
+	client := NewClient(httpServer.URL, "")
+
+	alice := oidctest.Identity{Subject: "alice-subject", Email: "alice@example.com", EmailVerified: true}
+	tokens := map[string]string{
+		"other audience": idp.IDToken(alice, map[string]interface{}{"aud": "another-client"}),
+		"expired":        idp.IDToken(alice, map[string]interface{}{"exp": time.Now().Add(-time.Hour).Unix()}),
+		"malformed":      "not-a-token",
+	}
+	for name, idToken := range tokens {
+		t.Run(name, func(t *testing.T) {
+			if _, err := client.OIDCLogin(ctx, idToken, ""); !IsStatus(err, http.StatusUnauthorized) {
+				t.Errorf("got error %v, want 401", err)
+			}
+		})
+	}
+}
+
+func TestOIDCLoginDisabled(t *testing.T) {
+	_, httpServer, _ := newServer(t)
+
+	client := NewClient(httpServer.URL, "")
+	if _, err := client.OIDCConfig(context.Background()); !IsStatus(err, http.StatusNotFound) {
+		t.Errorf("got error %v, want 404", err)
+	}
+	if _, err := client.OIDCLogin(context.Background(), "token", ""); !IsStatus(err, http.StatusNotFound) {
+		t.Errorf("got error %v, want 404", err)
+	}
+}
+
+func TestOIDCUser(t *testing.T) {
+	s, _, _ := newOIDCServer(t)
+
+	// An admin created bob with an email address as username; carol is
+	// already linked to an identity
+	if err := s.db.CreateUser("bob-id", "bob@example.com"); err != nil {
+		t.Fatalf("failed to create user: %v", err)
+	}
+	if err := s.db.CreateOIDCUser("carol-id", "carol", "carol@example.com", "carol-subject"); err != nil {
+		t.Fatalf("failed to create user: %v", err)
+	}
+
+	tests := []struct {
+		name         string
+		claims       oidc.Claims
+		wantID       string
+		wantUsername string
+		wantEmail    string
+		wantErr      error
+	}{
+		{
+			name:         "new user",
+			claims:       oidc.Claims{Subject: "dave-subject", Email: "dave@example.com", EmailVerified: true},
+			wantUsername: "dave@example.com",
+			wantEmail:    "dave@example.com",
+		},
+		{
+			name:         "unverified email",
+			claims:       oidc.Claims{Subject: "erin-subject", Email: "erin@example.com"},
+			wantUsername: "oidc-erin-subject",
+		},
+		{
+			name:         "username is email",
+			claims:       oidc.Claims{Subject: "bob-subject", Email: "bob@example.com", EmailVerified: true},
+			wantID:       "bob-id",
+			wantUsername: "bob@example.com",
+			wantEmail:    "bob@example.com",
+		},
+		{
+			name:         "known subject with new email",
+			claims:       oidc.Claims{Subject: "carol-subject", Email: "carol@corp.example.com", EmailVerified: true},
+			wantID:       "carol-id",
+			wantUsername: "carol",
+			wantEmail:    "carol@corp.example.com",
+		},
+		{
+			name:    "email of another identity",
+			claims:  oidc.Claims{Subject: "mallory-subject", Email: "carol@corp.example.com", EmailVerified: true},
+			wantErr: errIdentityConflict,
+		},
+	}
+
+	// Cases run in order; the last relies on carol's new email
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			user, err := s.oidcUser(&tt.claims)
+			if tt.wantErr != nil {
+				if err != tt.wantErr {
+					t.Fatalf("got error %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("failed to map claims: %v", err)
+			}
+
+			// The link is stored, so the subject finds the user from now on
+			stored, err := s.db.GetUserByOIDCSubject(tt.claims.Subject)
+			if err != nil {
+				t.Fatalf("subject is not linked: %v", err)
+			}
+			if stored.ID != user.ID || stored.Email != tt.wantEmail {
+				t.Errorf("subject maps to %+v, want user %s with email %q", stored, user.ID, tt.wantEmail)
+			}
+
+			if tt.wantID != "" && user.ID != tt.wantID {
+				t.Errorf("user ID is %q, want %q", user.ID, tt.wantID)
+			}
+			if user.Username != tt.wantUsername {
+				t.Errorf("username is %q, want %q", user.Username, tt.wantUsername)
+			}
+		})
+	}
+}
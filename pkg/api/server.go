@@ -0,0 +1,235 @@
+package api
+
+import (
+	"context"
+	"crypto/tls"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/rahulvramesh/justup/pkg/database"
+	"github.com/rahulvramesh/justup/pkg/kubernetes"
+	"github.com/rahulvramesh/justup/pkg/oidc"
+	"golang.org/x/crypto/ssh"
+)
+
+// Server serves the justup HTTP API
+type Server struct {
+	db        *database.DB
+	k8sClient *kubernetes.Client
+	token     string
+	mux       *http.ServeMux
+	// public serves the login endpoints, which need no token
+	public *http.ServeMux
+
+	oidcProvider *oidc.Provider
+	oidcClientID string
+	oidcTokenTTL time.Duration
+
+	sshCA           ssh.Signer
+	maxCertValidity time.Duration
+
+	recordingDir string
+
+	// allowDinD lets non-admins run workspaces with the privileged DinD
+	// sidecar; imagePrefixes, if set, limits the images they may run
+	allowDinD     bool
+	imagePrefixes []string
+}
+
+// NewServer creates an API server backed by db and the cluster. Requests
+// authenticate with a user's API token, or with token, the bootstrap token
+// that acts as the default admin user.
+func NewServer(db *database.DB, k8sClient *kubernetes.Client, token string) *Server {
+	s := &Server{
+		db:        db,
+		k8sClient: k8sClient,
+		token:     token,
+		mux:       http.NewServeMux(),
+		public:    http.NewServeMux(),
+	}
+
+	s.public.HandleFunc("GET /api/v1/auth/oidc", s.getOIDCConfig)
+	s.public.HandleFunc("POST /api/v1/auth/oidc/token", s.oidcLogin)
+
+	s.mux.HandleFunc("GET /api/v1/workspaces", s.listWorkspaces)
+	s.mux.HandleFunc("POST /api/v1/workspaces", s.createWorkspace)
+	s.mux.HandleFunc("GET /api/v1/workspaces/{name}", s.getWorkspace)
+	s.mux.HandleFunc("DELETE /api/v1/workspaces/{name}", s.deleteWorkspace)
+	s.mux.HandleFunc("POST /api/v1/workspaces/{name}/start", s.startWorkspace)
+	s.mux.HandleFunc("POST /api/v1/workspaces/{name}/stop", s.stopWorkspace)
+	s.mux.HandleFunc("PUT /api/v1/workspaces/{name}/schedule", s.setSchedule)
+	s.mux.HandleFunc("DELETE /api/v1/workspaces/{name}/schedule", s.clearSchedule)
+	s.mux.HandleFunc("PUT /api/v1/workspaces/{name}/forwarding", s.setForwarding)
+	s.mux.HandleFunc("DELETE /api/v1/workspaces/{name}/forwarding", s.clearForwarding)
+	s.mux.HandleFunc("GET /api/v1/workspaces/{name}/shares", s.listShares)
+	s.mux.HandleFunc("PUT /api/v1/workspaces/{name}/shares/{username}", s.shareWorkspace)
+	s.mux.HandleFunc("DELETE /api/v1/workspaces/{name}/shares/{username}", s.unshareWorkspace)
+
+	s.mux.HandleFunc("GET /api/v1/keys", s.listSSHKeys)
+	s.mux.HandleFunc("POST /api/v1/keys", s.addSSHKey)
+	s.mux.HandleFunc("DELETE /api/v1/keys/{fingerprint}", s.removeSSHKey)
+	s.mux.HandleFunc("POST /api/v1/certificates", s.signCertificate)
+
+	s.mux.HandleFunc("GET /api/v1/whoami", s.whoami)
+	s.mux.HandleFunc("GET /api/v1/tokens", s.listTokens)
+	s.mux.HandleFunc("POST /api/v1/tokens", s.createToken)
+	s.mux.HandleFunc("DELETE /api/v1/tokens/{id}", s.revokeToken)
+
+	s.mux.HandleFunc("GET /api/v1/audit/events", s.listAuditEvents)
+	s.mux.HandleFunc("GET /api/v1/audit/sessions/{id}/recording", s.getRecording)
+
+	return s
+}
+
+// ListenAndServe serves the API on addr until ctx is cancelled. With
+// certFile and keyFile set it serves HTTPS; otherwise it serves plain HTTP,
+// which is only safe behind a proxy that terminates TLS, since requests
+// carry bearer tokens.
+func (s *Server) ListenAndServe(ctx context.Context, addr, certFile, keyFile string) error {
+	httpServer := &http.Server{
+		Addr:              addr,
+		Handler:           s,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	if certFile != "" || keyFile != "" {
+		reloader, err := newCertReloader(certFile, keyFile)
+		if err != nil {
+			return err
+		}
+		httpServer.TLSConfig = &tls.Config{
+			MinVersion:     tls.VersionTLS12,
+			GetCertificate: reloader.getCertificate,
+		}
+	}
+
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		httpServer.Shutdown(shutdownCtx)
+	}()
+
+	var err error
+	if httpServer.TLSConfig != nil {
+		err = httpServer.ListenAndServeTLS("", "")
+	} else {
+		err = httpServer.ListenAndServe()
+	}
+	if err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+func (s *Server) listSSHKeys(w http.ResponseWriter, r *http.Request) {
+	keys, err := s.db.ListSSHKeys(caller(r).user.ID)
+	if err != nil {
+		writeInternalError(w, "list SSH keys", err)
+		return
+	}
+
+	resp := make([]SSHKey, 0, len(keys))
+	for _, key := range keys {
+		resp = append(resp, toSSHKey(&key))
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+func (s *Server) addSSHKey(w http.ResponseWriter, r *http.Request) {
+	var req AddSSHKeyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+
+	pubKey, comment, _, _, err := ssh.ParseAuthorizedKey([]byte(req.PublicKey))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid public key: %v", err))
+		return
+	}
+	fingerprint := ssh.FingerprintSHA256(pubKey)
+
+	if existing, err := s.db.GetSSHKeyByFingerprint(fingerprint); err == nil {
+		writeError(w, http.StatusConflict, fmt.Sprintf("key already registered as '%s'", existing.Name))
+		return
+	}
+
+	name := req.Name
+	if name == "" {
+		name = comment
+	}
+	if name == "" {
+		name = "unnamed"
+	}
+
+	key := &database.SSHKey{
+		ID:          uuid.New().String(),
+		UserID:      caller(r).user.ID,
+		Name:        name,
+		PublicKey:   strings.TrimSpace(req.PublicKey),
+		Fingerprint: fingerprint,
+		CreatedAt:   time.Now(),
+	}
+	if err := s.db.AddSSHKey(key); err != nil {
+		writeInternalError(w, "add SSH key", err)
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, toSSHKey(key))
+}
+
+func (s *Server) removeSSHKey(w http.ResponseWriter, r *http.Request) {
+	fingerprint := r.PathValue("fingerprint")
+
+	key, err := s.db.GetSSHKeyByFingerprint(fingerprint)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		writeInternalError(w, "get SSH key", err)
+		return
+	}
+	if err != nil || key.UserID != caller(r).user.ID {
+		writeError(w, http.StatusNotFound, "SSH key not found")
+		return
+	}
+
+	if err := s.db.DeleteSSHKeyByFingerprint(fingerprint); err != nil {
+		writeInternalError(w, "remove SSH key", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func toSSHKey(key *database.SSHKey) SSHKey {
+	return SSHKey{
+		ID:          key.ID,
+		Name:        key.Name,
+		PublicKey:   key.PublicKey,
+		Fingerprint: key.Fingerprint,
+		CreatedAt:   key.CreatedAt,
+		LastUsedAt:  key.LastUsedAt,
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, msg string) {
+	writeJSON(w, status, ErrorResponse{Error: msg})
+}
+
+// writeInternalError logs err and returns a generic error to the client
+func writeInternalError(w http.ResponseWriter, action string, err error) {
+	log.Printf("API: failed to %s: %v", action, err)
+	writeError(w, http.StatusInternalServerError, "failed to "+action)
+}
@@ -0,0 +1,115 @@
+package api
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/rahulvramesh/justup/pkg/database"
+)
+
+func (s *Server) listShares(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	if !s.requireRole(w, r, name, database.RoleOwner) {
+		return
+	}
+
+	grants, err := s.db.ListWorkspaceAccess(name)
+	if err != nil {
+		writeInternalError(w, "list shares", err)
+		return
+	}
+
+	resp := make([]WorkspaceShare, 0, len(grants))
+	for _, grant := range grants {
+		resp = append(resp, toWorkspaceShare(&grant))
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+func (s *Server) shareWorkspace(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	if !s.requireRole(w, r, name, database.RoleOwner) {
+		return
+	}
+
+	var req ShareWorkspaceRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	if !database.IsValidRole(req.Role) {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid role '%s' (must be owner, collaborator or read-only)", req.Role))
+		return
+	}
+
+	user, ok := s.lookupUser(w, r.PathValue("username"))
+	if !ok {
+		return
+	}
+
+	// Grants reference the workspace's record, which admins may lack for
+	// workspaces created with a kubeconfig
+	if _, err := s.db.GetWorkspace(name); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			writeError(w, http.StatusNotFound, fmt.Sprintf("workspace '%s' has no record on the server and cannot be shared", name))
+			return
+		}
+		writeInternalError(w, "get workspace", err)
+		return
+	}
+
+	if err := s.db.GrantWorkspaceAccess(name, user.ID, req.Role); err != nil {
+		writeInternalError(w, "share workspace", err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, WorkspaceShare{Username: user.Username, Role: req.Role})
+}
+
+func (s *Server) unshareWorkspace(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	if !s.requireRole(w, r, name, database.RoleOwner) {
+		return
+	}
+
+	user, ok := s.lookupUser(w, r.PathValue("username"))
+	if !ok {
+		return
+	}
+
+	if err := s.db.RevokeWorkspaceAccess(name, user.ID); err != nil {
+		if errors.Is(err, database.ErrAccessNotFound) {
+			writeError(w, http.StatusNotFound, fmt.Sprintf("workspace '%s' is not shared with '%s'", name, user.Username))
+			return
+		}
+		writeInternalError(w, "unshare workspace", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// lookupUser gets a user by name, writing a 404 if there is none
+func (s *Server) lookupUser(w http.ResponseWriter, username string) (*database.User, bool) {
+	user, err := s.db.GetUserByUsername(username)
+	if errors.Is(err, sql.ErrNoRows) {
+		writeError(w, http.StatusNotFound, fmt.Sprintf("user '%s' not found", username))
+		return nil, false
+	}
+	if err != nil {
+		writeInternalError(w, "get user", err)
+		return nil, false
+	}
+	return user, true
+}
+
+func toWorkspaceShare(grant *database.WorkspaceAccess) WorkspaceShare {
+	return WorkspaceShare{
+		Username:  grant.Username,
+		Role:      grant.Role,
+		GrantedAt: grant.GrantedAt,
+	}
+}
@@ -0,0 +1,177 @@
+package api
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/rahulvramesh/justup/pkg/database"
+)
+
+func (s *Server) whoami(w http.ResponseWriter, r *http.Request) {
+	p := caller(r)
+	writeJSON(w, http.StatusOK, WhoAmI{
+		UserID:   p.user.ID,
+		Username: p.user.Username,
+		Scopes:   p.scopes,
+		TokenID:  p.tokenID,
+	})
+}
+
+func (s *Server) listTokens(w http.ResponseWriter, r *http.Request) {
+	user := caller(r).user
+
+	tokens, err := s.db.ListAPITokens(user.ID)
+	if err != nil {
+		writeInternalError(w, "list tokens", err)
+		return
+	}
+
+	resp := make([]APIToken, 0, len(tokens))
+	for _, token := range tokens {
+		resp = append(resp, toAPIToken(&token, user.Username))
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+func (s *Server) createToken(w http.ResponseWriter, r *http.Request) {
+	p := caller(r)
+
+	var req CreateTokenRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	if req.Name == "" {
+		writeError(w, http.StatusBadRequest, "name is required")
+		return
+	}
+
+	scopes := req.Scopes
+	if len(scopes) == 0 {
+		scopes = []string{ScopeRead, ScopeWrite}
+	}
+	for _, scope := range scopes {
+		if !IsValidScope(scope) {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("unknown scope '%s'", scope))
+			return
+		}
+		if !p.hasScope(scope) {
+			writeError(w, http.StatusForbidden, fmt.Sprintf("cannot grant the '%s' scope you do not have", scope))
+			return
+		}
+	}
+
+	var expiresAt *time.Time
+	if req.ExpiresIn != "" {
+		d, err := time.ParseDuration(req.ExpiresIn)
+		if err != nil || d < 0 {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid expiresIn %q", req.ExpiresIn))
+			return
+		}
+		if d > 0 {
+			t := time.Now().Add(d)
+			expiresAt = &t
+		}
+	}
+
+	user := p.user
+	if req.Username != "" && req.Username != user.Username {
+		if !p.isAdmin() {
+			writeError(w, http.StatusForbidden, "issuing tokens for other users requires the 'admin' scope")
+			return
+		}
+
+		var err error
+		user, err = s.getOrCreateUser(req.Username)
+		if err != nil {
+			writeInternalError(w, "get user", err)
+			return
+		}
+	}
+
+	resp, err := s.issueToken(user, req.Name, scopes, expiresAt)
+	if err != nil {
+		writeInternalError(w, "create token", err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, resp)
+}
+
+// issueToken creates an API token for user. The token itself is only
+// returned here; the database keeps its hash.
+func (s *Server) issueToken(user *database.User, name string, scopes []string, expiresAt *time.Time) (*CreateTokenResponse, error) {
+	token, err := GenerateToken()
+	if err != nil {
+		return nil, err
+	}
+
+	apiToken := &database.APIToken{
+		ID:        uuid.New().String(),
+		UserID:    user.ID,
+		Name:      name,
+		TokenHash: HashToken(token),
+		Scopes:    scopes,
+		ExpiresAt: expiresAt,
+		CreatedAt: time.Now(),
+	}
+	if err := s.db.CreateAPIToken(apiToken); err != nil {
+		return nil, err
+	}
+
+	return &CreateTokenResponse{
+		APIToken: toAPIToken(apiToken, user.Username),
+		Token:    token,
+	}, nil
+}
+
+func (s *Server) revokeToken(w http.ResponseWriter, r *http.Request) {
+	p := caller(r)
+
+	token, err := s.db.GetAPIToken(r.PathValue("id"))
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		writeInternalError(w, "get token", err)
+		return
+	}
+	if err != nil || (token.UserID != p.user.ID && !p.isAdmin()) {
+		writeError(w, http.StatusNotFound, "token not found")
+		return
+	}
+
+	if err := s.db.DeleteAPIToken(token.ID); err != nil {
+		writeInternalError(w, "revoke token", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// getOrCreateUser provisions users on first use, since tokens are their
+// only credential
+func (s *Server) getOrCreateUser(username string) (*database.User, error) {
+	user, err := s.db.GetUserByUsername(username)
+	if err == nil || !errors.Is(err, sql.ErrNoRows) {
+		return user, err
+	}
+
+	if err := s.db.CreateUser(uuid.New().String(), username); err != nil {
+		return nil, err
+	}
+	return s.db.GetUserByUsername(username)
+}
+
+func toAPIToken(token *database.APIToken, username string) APIToken {
+	return APIToken{
+		ID:         token.ID,
+		Name:       token.Name,
+		Username:   username,
+		Scopes:     token.Scopes,
+		ExpiresAt:  token.ExpiresAt,
+		CreatedAt:  token.CreatedAt,
+		LastUsedAt: token.LastUsedAt,
+	}
+}
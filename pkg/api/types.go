@@ -0,0 +1,181 @@
+package api
+
+import (
+	"time"
+
+	"github.com/rahulvramesh/justup/pkg/kubernetes"
+)
+
+// SSHKey is a registered SSH public key
+type SSHKey struct {
+	ID          string     `json:"id"`
+	Name        string     `json:"name"`
+	PublicKey   string     `json:"publicKey"`
+	Fingerprint string     `json:"fingerprint"`
+	CreatedAt   time.Time  `json:"createdAt"`
+	LastUsedAt  *time.Time `json:"lastUsedAt,omitempty"`
+}
+
+// AddSSHKeyRequest registers an SSH public key
+type AddSSHKeyRequest struct {
+	Name      string `json:"name"`
+	PublicKey string `json:"publicKey"`
+}
+
+// ErrorResponse is the body of every non-2xx response
+type ErrorResponse struct {
+	Error string `json:"error"`
+}
+
+// Workspace is a workspace and its observed state
+type Workspace struct {
+	Name    string `json:"name"`
+	Status  string `json:"status"`
+	Age     string `json:"age"`
+	GitURL  string `json:"gitUrl,omitempty"`
+	Branch  string `json:"branch,omitempty"`
+	CPU     string `json:"cpu,omitempty"`
+	Memory  string `json:"memory,omitempty"`
+	Storage string `json:"storage,omitempty"`
+	HostKey string `json:"hostKey,omitempty"`
+
+	Schedule   *kubernetes.WorkspaceSchedule   `json:"schedule,omitempty"`
+	Forwarding *kubernetes.WorkspaceForwarding `json:"forwarding,omitempty"`
+}
+
+// CreateWorkspaceRequest creates a workspace. Empty fields use the server's
+// defaults.
+type CreateWorkspaceRequest struct {
+	Name        string            `json:"name"`
+	GitURL      string            `json:"gitUrl"`
+	Branch      string            `json:"branch,omitempty"`
+	Image       string            `json:"image,omitempty"`
+	CPU         string            `json:"cpu,omitempty"`
+	Memory      string            `json:"memory,omitempty"`
+	Storage     string            `json:"storage,omitempty"`
+	EnableDinD  bool              `json:"enableDinD,omitempty"`
+	Env         map[string]string `json:"env,omitempty"`
+	IdleTimeout string            `json:"idleTimeout,omitempty"`
+}
+
+// StartWorkspaceRequest overrides parts of a workspace's spec when starting
+// it. Empty fields keep the persisted value.
+type StartWorkspaceRequest struct {
+	Image      string `json:"image,omitempty"`
+	CPU        string `json:"cpu,omitempty"`
+	Memory     string `json:"memory,omitempty"`
+	EnableDinD *bool  `json:"enableDinD,omitempty"`
+}
+
+// WorkspaceShare is a user's access to a workspace shared with them
+type WorkspaceShare struct {
+	Username  string    `json:"username"`
+	Role      string    `json:"role"`
+	GrantedAt time.Time `json:"grantedAt"`
+}
+
+// ShareWorkspaceRequest grants a user a role on a workspace, replacing any
+// role granted before
+type ShareWorkspaceRequest struct {
+	Role string `json:"role"`
+}
+
+// WhoAmI describes the caller of a request
+type WhoAmI struct {
+	UserID   string   `json:"userId"`
+	Username string   `json:"username"`
+	Scopes   []string `json:"scopes"`
+	// TokenID is empty for the bootstrap token
+	TokenID string `json:"tokenId,omitempty"`
+}
+
+// APIToken describes an API token. The token itself is only returned when
+// it is created.
+type APIToken struct {
+	ID         string     `json:"id"`
+	Name       string     `json:"name"`
+	Username   string     `json:"username"`
+	Scopes     []string   `json:"scopes"`
+	ExpiresAt  *time.Time `json:"expiresAt,omitempty"`
+	CreatedAt  time.Time  `json:"createdAt"`
+	LastUsedAt *time.Time `json:"lastUsedAt,omitempty"`
+}
+
+// CreateTokenRequest issues an API token
+type CreateTokenRequest struct {
+	Name string `json:"name"`
+	// Scopes default to read and write. They may not exceed the caller's.
+	Scopes []string `json:"scopes,omitempty"`
+	// ExpiresIn is a duration such as "720h"; empty never expires
+	ExpiresIn string `json:"expiresIn,omitempty"`
+	// Username issues the token for another user, creating the user if
+	// needed. Requires the admin scope.
+	Username string `json:"username,omitempty"`
+}
+
+// CreateTokenResponse returns a newly issued token
+type CreateTokenResponse struct {
+	APIToken
+	Token string `json:"token"`
+}
+
+// OIDCConfig describes the OIDC provider clients log in with
+type OIDCConfig struct {
+	Issuer   string `json:"issuer"`
+	ClientID string `json:"clientId"`
+}
+
+// OIDCLoginRequest exchanges an ID token from the OIDC provider for an API
+// token
+type OIDCLoginRequest struct {
+	IDToken   string `json:"idToken"`
+	TokenName string `json:"tokenName,omitempty"`
+}
+
+// SignCertificateRequest asks the server's CA to certify a public key for
+// the caller
+type SignCertificateRequest struct {
+	PublicKey string `json:"publicKey"`
+	// ValidFor is a duration such as "8h"; it defaults to and is capped by
+	// the server's limit
+	ValidFor string `json:"validFor,omitempty"`
+	// Workspaces restricts the certificate to these workspaces
+	Workspaces []string `json:"workspaces,omitempty"`
+	// SourceAddress restricts client addresses (comma-separated CIDRs)
+	SourceAddress string `json:"sourceAddress,omitempty"`
+}
+
+// SSHCertificate is an issued OpenSSH user certificate
+type SSHCertificate struct {
+	// Certificate is in authorized_keys format, as written to *-cert.pub
+	Certificate string    `json:"certificate"`
+	KeyID       string    `json:"keyId"`
+	Serial      uint64    `json:"serial"`
+	Principals  []string  `json:"principals"`
+	Workspaces  []string  `json:"workspaces,omitempty"`
+	ValidAfter  time.Time `json:"validAfter"`
+	ValidBefore time.Time `json:"validBefore"`
+}
+
+// AuditEvent is an entry in the SSH proxy's audit trail
+type AuditEvent struct {
+	ID        int64     `json:"id"`
+	Time      time.Time `json:"time"`
+	SessionID string    `json:"sessionId"`
+	Event     string    `json:"event"`
+	// Username is empty for failed authentication with an unknown key
+	Username   string `json:"username,omitempty"`
+	Workspace  string `json:"workspace,omitempty"`
+	RemoteAddr string `json:"remoteAddr,omitempty"`
+	Detail     string `json:"detail,omitempty"`
+}
+
+// AuditQuery filters audit events; empty fields match everything
+type AuditQuery struct {
+	SessionID string
+	Username  string
+	Workspace string
+	Event     string
+	Since     time.Time
+	Limit     int
+}
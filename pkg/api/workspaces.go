@@ -0,0 +1,446 @@
+package api
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/rahulvramesh/justup/pkg/database"
+	"github.com/rahulvramesh/justup/pkg/kubernetes"
+	"github.com/rahulvramesh/justup/pkg/scheduler"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+)
+
+// AllowDinD lets users without the admin scope create and start workspaces
+// with the Docker-in-Docker sidecar, which runs privileged
+func (s *Server) AllowDinD() {
+	s.allowDinD = true
+}
+
+// RestrictImages limits the images users without the admin scope may run
+// to the default image and those starting with one of prefixes
+func (s *Server) RestrictImages(prefixes []string) {
+	s.imagePrefixes = nil
+	for _, prefix := range prefixes {
+		if prefix = strings.TrimSpace(prefix); prefix != "" {
+			s.imagePrefixes = append(s.imagePrefixes, prefix)
+		}
+	}
+}
+
+// allowSpec checks that the caller may run a workspace with image and DinD,
+// writing a 403 otherwise. An empty image keeps the current one.
+func (s *Server) allowSpec(w http.ResponseWriter, r *http.Request, image string, dind bool) bool {
+	if caller(r).isAdmin() {
+		return true
+	}
+	if dind && !s.allowDinD {
+		writeError(w, http.StatusForbidden, "Docker-in-Docker runs privileged and needs the 'admin' scope on this server")
+		return false
+	}
+	if image == "" || image == kubernetes.DefaultImage || len(s.imagePrefixes) == 0 {
+		return true
+	}
+	for _, prefix := range s.imagePrefixes {
+		if strings.HasPrefix(image, prefix) {
+			return true
+		}
+	}
+	writeError(w, http.StatusForbidden, fmt.Sprintf("image '%s' is not allowed on this server; use one starting with %s", image, strings.Join(s.imagePrefixes, ", ")))
+	return false
+}
+
+func (s *Server) listWorkspaces(w http.ResponseWriter, r *http.Request) {
+	all, _ := strconv.ParseBool(r.URL.Query().Get("all"))
+
+	p := caller(r)
+
+	workspaces, err := s.k8sClient.ListWorkspaces(r.Context(), all)
+	if err != nil {
+		writeInternalError(w, "list workspaces", err)
+		return
+	}
+
+	// Workspaces deleted with their volume kept are only known from the
+	// database
+	if all {
+		records, err := s.db.ListWorkspaces(p.user.ID)
+		if err != nil {
+			writeInternalError(w, "list workspace records", err)
+			return
+		}
+		workspaces = kubernetes.MergeWorkspaceRecords(workspaces, records)
+	}
+
+	// Users see the workspaces they own or were granted; admins see all
+	var roles map[string]string
+	if !p.isAdmin() {
+		roles, err = s.db.ListWorkspaceRoles(p.user.ID)
+		if err != nil {
+			writeInternalError(w, "list workspace roles", err)
+			return
+		}
+	}
+
+	resp := make([]Workspace, 0, len(workspaces))
+	for _, ws := range workspaces {
+		if _, ok := roles[ws.Name]; roles != nil && !ok {
+			continue
+		}
+		resp = append(resp, toWorkspace(&ws))
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+func (s *Server) getWorkspace(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	if !s.requireRole(w, r, name, database.RoleOwner, database.RoleCollaborator, database.RoleReadOnly) {
+		return
+	}
+
+	ws, ok := s.lookupWorkspace(w, r, name)
+	if !ok {
+		return
+	}
+	writeJSON(w, http.StatusOK, toWorkspace(ws))
+}
+
+func (s *Server) createWorkspace(w http.ResponseWriter, r *http.Request) {
+	var req CreateWorkspaceRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	if req.Name == "" || req.GitURL == "" {
+		writeError(w, http.StatusBadRequest, "name and gitUrl are required")
+		return
+	}
+	if !kubernetes.IsValidWorkspaceName(req.Name) {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid workspace name '%s' (must be lowercase alphanumeric with dashes)", req.Name))
+		return
+	}
+	if !s.allowSpec(w, r, req.Image, req.EnableDinD) {
+		return
+	}
+
+	// A new workspace would take over the volume or the record, with its
+	// owner and grants, of an earlier workspace with the same name
+	if _, err := s.db.GetWorkspace(req.Name); err == nil {
+		writeError(w, http.StatusConflict, fmt.Sprintf("workspace '%s' already exists", req.Name))
+		return
+	} else if !errors.Is(err, sql.ErrNoRows) {
+		writeInternalError(w, "get workspace record", err)
+		return
+	}
+	kept, err := s.k8sClient.WorkspaceVolumeExists(r.Context(), req.Name)
+	if err != nil {
+		writeInternalError(w, "check workspace volume", err)
+		return
+	}
+	if kept {
+		writeError(w, http.StatusConflict, fmt.Sprintf("a volume of workspace '%s' already exists; start the workspace to restore it, or delete it first", req.Name))
+		return
+	}
+
+	user := caller(r).user
+
+	// Keys registered with the server also grant direct (port-forward)
+	// access; the proxy's own key is added by the controller
+	keys, err := s.db.ListSSHKeys(user.ID)
+	if err != nil {
+		writeInternalError(w, "list SSH keys", err)
+		return
+	}
+	var keyLines []string
+	for _, key := range keys {
+		keyLines = append(keyLines, key.PublicKey)
+	}
+
+	opts := kubernetes.WorkspaceOptions{
+		Name:        req.Name,
+		GitURL:      req.GitURL,
+		Branch:      orDefault(req.Branch, kubernetes.DefaultBranch),
+		Image:       orDefault(req.Image, kubernetes.DefaultImage),
+		CPU:         orDefault(req.CPU, kubernetes.DefaultCPU),
+		Memory:      orDefault(req.Memory, kubernetes.DefaultMemory),
+		Storage:     orDefault(req.Storage, kubernetes.DefaultStorage),
+		EnableDinD:  req.EnableDinD,
+		Env:         req.Env,
+		IdleTimeout: req.IdleTimeout,
+		SSHPubKey:   strings.Join(keyLines, "\n"),
+		Owner:       user.Username,
+	}
+
+	ws, err := s.k8sClient.CreateWorkspace(r.Context(), opts)
+	if err != nil {
+		writeWorkspaceError(w, "create workspace", err)
+		return
+	}
+
+	now := time.Now()
+	err = s.db.SaveWorkspace(&database.Workspace{
+		ID:            uuid.New().String(),
+		Name:          opts.Name,
+		UserID:        user.ID,
+		GitURL:        opts.GitURL,
+		Branch:        opts.Branch,
+		Image:         opts.Image,
+		CPU:           opts.CPU,
+		Memory:        opts.Memory,
+		Storage:       opts.Storage,
+		EnableDinD:    opts.EnableDinD,
+		CreatedAt:     now,
+		LastStartedAt: &now,
+	})
+	if err != nil {
+		// Without a record the workspace would only be owned through its
+		// annotation, so undo the creation rather than leave it behind
+		if delErr := s.k8sClient.DeleteWorkspace(r.Context(), kubernetes.DeleteOptions{Name: opts.Name}); delErr != nil {
+			log.Printf("API: failed to remove unrecorded workspace '%s': %v", opts.Name, delErr)
+		}
+		writeInternalError(w, "record workspace", err)
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, toWorkspace(ws))
+}
+
+func (s *Server) startWorkspace(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	if !s.requireRole(w, r, name, database.RoleOwner, database.RoleCollaborator) {
+		return
+	}
+
+	var req StartWorkspaceRequest
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeError(w, http.StatusBadRequest, "invalid request body")
+			return
+		}
+	}
+
+	if !s.allowSpec(w, r, req.Image, req.EnableDinD != nil && *req.EnableDinD) {
+		return
+	}
+
+	overrides := kubernetes.StartOptions{
+		Image:      req.Image,
+		CPU:        req.CPU,
+		Memory:     req.Memory,
+		EnableDinD: req.EnableDinD,
+	}
+	if err := s.k8sClient.StartWorkspace(r.Context(), name, overrides); err != nil {
+		writeWorkspaceError(w, "start workspace", err)
+		return
+	}
+
+	if err := s.db.MarkWorkspaceStarted(name); err != nil {
+		writeInternalError(w, "record workspace start", err)
+		return
+	}
+
+	ws, ok := s.lookupWorkspace(w, r, name)
+	if !ok {
+		return
+	}
+	writeJSON(w, http.StatusOK, toWorkspace(ws))
+}
+
+func (s *Server) stopWorkspace(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	if !s.requireRole(w, r, name, database.RoleOwner, database.RoleCollaborator) {
+		return
+	}
+
+	if err := s.k8sClient.StopWorkspace(r.Context(), name); err != nil {
+		writeWorkspaceError(w, "stop workspace", err)
+		return
+	}
+
+	if err := s.db.MarkWorkspaceStopped(name); err != nil {
+		writeInternalError(w, "record workspace stop", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) deleteWorkspace(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	if !s.requireRole(w, r, name, database.RoleOwner) {
+		return
+	}
+	keepPVC, _ := strconv.ParseBool(r.URL.Query().Get("keepPVC"))
+
+	err := s.k8sClient.DeleteWorkspace(r.Context(), kubernetes.DeleteOptions{Name: name, KeepPVC: keepPVC})
+	if err != nil {
+		writeWorkspaceError(w, "delete workspace", err)
+		return
+	}
+
+	// Keep the record of a workspace whose volume was kept, since it can
+	// still be restored by starting it
+	if keepPVC {
+		err = s.db.MarkWorkspaceStopped(name)
+	} else {
+		err = s.db.DeleteWorkspace(name)
+	}
+	if err != nil {
+		writeInternalError(w, "record workspace deletion", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) setSchedule(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	if !s.requireRole(w, r, name, database.RoleOwner, database.RoleCollaborator) {
+		return
+	}
+
+	var schedule kubernetes.WorkspaceSchedule
+	if err := json.NewDecoder(r.Body).Decode(&schedule); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	if err := scheduler.Validate(&schedule); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid schedule: %v", err))
+		return
+	}
+
+	if err := s.k8sClient.SetWorkspaceSchedule(r.Context(), name, &schedule); err != nil {
+		writeWorkspaceError(w, "set schedule", err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) clearSchedule(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	if !s.requireRole(w, r, name, database.RoleOwner, database.RoleCollaborator) {
+		return
+	}
+
+	if err := s.k8sClient.SetWorkspaceSchedule(r.Context(), name, nil); err != nil {
+		writeWorkspaceError(w, "clear schedule", err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// setForwarding replaces a workspace's forwarding allowlists. Forwarding
+// reaches into the workspace's network, so only owners may change it.
+func (s *Server) setForwarding(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	if !s.requireRole(w, r, name, database.RoleOwner) {
+		return
+	}
+
+	var forwarding kubernetes.WorkspaceForwarding
+	if err := json.NewDecoder(r.Body).Decode(&forwarding); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	for _, ports := range [][]string{forwarding.Local, forwarding.Remote} {
+		if _, err := kubernetes.ParsePortRanges(ports); err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid ports: %v", err))
+			return
+		}
+	}
+
+	if err := s.k8sClient.SetWorkspaceForwarding(r.Context(), name, &forwarding); err != nil {
+		writeWorkspaceError(w, "set forwarding", err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) clearForwarding(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	if !s.requireRole(w, r, name, database.RoleOwner) {
+		return
+	}
+
+	if err := s.k8sClient.SetWorkspaceForwarding(r.Context(), name, nil); err != nil {
+		writeWorkspaceError(w, "clear forwarding", err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// lookupWorkspace gets a workspace, writing a 404 if it does not exist
+func (s *Server) lookupWorkspace(w http.ResponseWriter, r *http.Request, name string) (*kubernetes.Workspace, bool) {
+	ws, err := s.k8sClient.GetWorkspace(r.Context(), name)
+	if err != nil {
+		writeWorkspaceError(w, "get workspace", err)
+		return nil, false
+	}
+	return ws, true
+}
+
+// writeWorkspaceError maps a workspace operation's error to a status code.
+// The messages describe the workspace's state, so they are passed on to the
+// client.
+func writeWorkspaceError(w http.ResponseWriter, action string, err error) {
+	switch {
+	case errors.Is(err, kubernetes.ErrWorkspaceNotFound) || k8serrors.IsNotFound(err):
+		writeError(w, http.StatusNotFound, err.Error())
+	case errors.Is(err, kubernetes.ErrWorkspaceExists) || errors.Is(err, kubernetes.ErrWorkspaceRunning) ||
+		errors.Is(err, kubernetes.ErrWorkspaceStopped) || k8serrors.IsAlreadyExists(err):
+		writeError(w, http.StatusConflict, err.Error())
+	case errors.Is(err, kubernetes.ErrInvalidWorkspace):
+		writeError(w, http.StatusBadRequest, err.Error())
+	default:
+		log.Printf("API: failed to %s: %v", action, err)
+		writeError(w, http.StatusInternalServerError, fmt.Sprintf("failed to %s: %v", action, err))
+	}
+}
+
+func toWorkspace(ws *kubernetes.Workspace) Workspace {
+	return Workspace{
+		Name:    ws.Name,
+		Status:  ws.Status,
+		Age:     ws.Age,
+		GitURL:  ws.GitURL,
+		Branch:  ws.Branch,
+		CPU:     ws.CPU,
+		Memory:  ws.Memory,
+		Storage: ws.Storage,
+		HostKey: ws.HostKey,
+
+		Schedule:   ws.Schedule,
+		Forwarding: ws.Forwarding,
+	}
+}
+
+func fromWorkspace(ws *Workspace) *kubernetes.Workspace {
+	return &kubernetes.Workspace{
+		Name:    ws.Name,
+		Status:  ws.Status,
+		Age:     ws.Age,
+		GitURL:  ws.GitURL,
+		Branch:  ws.Branch,
+		CPU:     ws.CPU,
+		Memory:  ws.Memory,
+		Storage: ws.Storage,
+		HostKey: ws.HostKey,
+
+		Schedule:   ws.Schedule,
+		Forwarding: ws.Forwarding,
+	}
+}
+
+func orDefault(value, fallback string) string {
+	if value == "" {
+		return fallback
+	}
+	return value
+}
@@ -0,0 +1,212 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/rahulvramesh/justup/pkg/database"
+	"github.com/rahulvramesh/justup/pkg/kubernetes"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+const bootstrapToken = "bootstrap-token"
+
+// newServer starts an API server without a cluster, returning it with a
+// client holding the bootstrap token
+func newServer(t *testing.T) (*Server, *httptest.Server, *Client) {
+	t.Helper()
+
+	db, err := database.Open(filepath.Join(t.TempDir(), "justup.db"))
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	s := NewServer(db, nil, bootstrapToken)
+	httpServer := httptest.NewServer(s)
+	t.Cleanup(httpServer.Close)
+	return s, httpServer, NewClient(httpServer.URL, bootstrapToken)
+}
+
+// userClient issues a token with scopes for a user, creating the user, and
+// returns a client holding it
+func userClient(t *testing.T, httpServer *httptest.Server, admin *Client, username string, scopes ...string) *Client {
+	t.Helper()
+
+	resp, err := admin.CreateToken(context.Background(), CreateTokenRequest{Name: "test", Username: username, Scopes: scopes})
+	if err != nil {
+		t.Fatalf("failed to create token: %v", err)
+	}
+	return NewClient(httpServer.URL, resp.Token)
+}
+
+func TestCreateWorkspaceSpecPolicy(t *testing.T) {
+	s, httpServer, admin := newServer(t)
+	s.RestrictImages([]string{"registry.example.com/team/"})
+	alice := userClient(t, httpServer, admin, "alice", ScopeRead, ScopeWrite)
+
+	// Both are refused before the cluster is touched
+	tests := map[string]kubernetes.WorkspaceOptions{
+		"dind":        {Name: "myproject", GitURL: "https://github.com/user/repo.git", EnableDinD: true},
+		"other image": {Name: "myproject", GitURL: "https://github.com/user/repo.git", Image: "docker.io/evil/image"},
+	}
+	for name, opts := range tests {
+		t.Run(name, func(t *testing.T) {
+			if _, err := alice.CreateWorkspace(context.Background(), opts); !IsStatus(err, http.StatusForbidden) {
+				t.Errorf("got error %v, want 403", err)
+			}
+		})
+	}
+}
+
+// saveWorkspace records a workspace owned by username
+func saveWorkspace(t *testing.T, s *Server, name, username string) {
+	t.Helper()
+
+	user, err := s.db.GetUserByUsername(username)
+	if err != nil {
+		t.Fatalf("failed to get user %s: %v", username, err)
+	}
+	if err := s.db.SaveWorkspace(&database.Workspace{ID: name + "-id", Name: name, UserID: user.ID, CreatedAt: time.Now()}); err != nil {
+		t.Fatalf("failed to save workspace: %v", err)
+	}
+}
+
+func TestWorkspaceSettingsPolicy(t *testing.T) {
+	s, httpServer, admin := newServer(t)
+	ctx := context.Background()
+	alice := userClient(t, httpServer, admin, "alice", ScopeRead, ScopeWrite)
+	bob := userClient(t, httpServer, admin, "bob", ScopeRead, ScopeWrite)
+	carol := userClient(t, httpServer, admin, "carol", ScopeRead, ScopeWrite)
+	saveWorkspace(t, s, "myproject", "alice")
+	if err := alice.ShareWorkspace(ctx, "myproject", "bob", database.RoleCollaborator); err != nil {
+		t.Fatalf("failed to share workspace: %v", err)
+	}
+
+	// Every request is refused before the cluster is touched
+	tests := []struct {
+		name string
+		call func() error
+		want int
+	}{
+		{
+			name: "invalid schedule",
+			call: func() error {
+				return alice.SetWorkspaceSchedule(ctx, "myproject", &kubernetes.WorkspaceSchedule{Start: "60 8 * * *"})
+			},
+			want: http.StatusBadRequest,
+		},
+		{
+			name: "unknown timezone",
+			call: func() error {
+				return alice.SetWorkspaceSchedule(ctx, "myproject", &kubernetes.WorkspaceSchedule{Stop: "0 19 * * *", Timezone: "Mars/Olympus"})
+			},
+			want: http.StatusBadRequest,
+		},
+		{
+			name: "invalid ports",
+			call: func() error {
+				return alice.SetWorkspaceForwarding(ctx, "myproject", &kubernetes.WorkspaceForwarding{Local: []string{"9000-8000"}})
+			},
+			want: http.StatusBadRequest,
+		},
+		{
+			name: "collaborator sets forwarding",
+			call: func() error {
+				return bob.SetWorkspaceForwarding(ctx, "myproject", &kubernetes.WorkspaceForwarding{Local: []string{}})
+			},
+			want: http.StatusForbidden,
+		},
+		{
+			name: "collaborator clears forwarding",
+			call: func() error { return bob.SetWorkspaceForwarding(ctx, "myproject", nil) },
+			want: http.StatusForbidden,
+		},
+		{
+			name: "schedule without access",
+			call: func() error { return carol.SetWorkspaceSchedule(ctx, "myproject", nil) },
+			want: http.StatusNotFound,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.call(); !IsStatus(err, tt.want) {
+				t.Errorf("got error %v, want %d", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestAllowSpec(t *testing.T) {
+	s, _, _ := newServer(t)
+	s.RestrictImages([]string{"registry.example.com/team/", " "})
+
+	user := &principal{user: &database.User{ID: "alice-id"}, scopes: []string{ScopeRead, ScopeWrite}}
+	admin := &principal{user: &database.User{ID: "admin-id"}, scopes: []string{ScopeRead, ScopeWrite, ScopeAdmin}}
+
+	tests := []struct {
+		name      string
+		caller    *principal
+		allowDinD bool
+		image     string
+		dind      bool
+		want      bool
+	}{
+		{name: "default image", caller: user, image: kubernetes.DefaultImage, want: true},
+		{name: "kept image", caller: user, want: true},
+		{name: "allowed image", caller: user, image: "registry.example.com/team/dev:latest", want: true},
+		{name: "other image", caller: user, image: "docker.io/library/ubuntu", want: false},
+		{name: "dind", caller: user, dind: true, want: false},
+		{name: "dind allowed", caller: user, allowDinD: true, dind: true, want: true},
+		{name: "admin", caller: admin, image: "docker.io/library/ubuntu", dind: true, want: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s.allowDinD = tt.allowDinD
+			r := httptest.NewRequest(http.MethodPost, "/api/v1/workspaces", nil)
+			r = r.WithContext(context.WithValue(r.Context(), principalKey{}, tt.caller))
+			w := httptest.NewRecorder()
+
+			if got := s.allowSpec(w, r, tt.image, tt.dind); got != tt.want {
+				t.Errorf("allowSpec is %v, want %v", got, tt.want)
+			}
+			if !tt.want && w.Code != http.StatusForbidden {
+				t.Errorf("status is %d, want 403", w.Code)
+			}
+		})
+	}
+}
+
+func TestWriteWorkspaceError(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want int
+	}{
+		{name: "not found", err: fmt.Errorf("workspace 'a' is gone: %w", kubernetes.ErrWorkspaceNotFound), want: http.StatusNotFound},
+		{name: "exists", err: fmt.Errorf("failed to restore workspace: %w", kubernetes.ErrWorkspaceExists), want: http.StatusConflict},
+		{name: "running", err: kubernetes.ErrWorkspaceRunning, want: http.StatusConflict},
+		{name: "stopped", err: kubernetes.ErrWorkspaceStopped, want: http.StatusConflict},
+		{name: "invalid", err: kubernetes.ErrInvalidWorkspace, want: http.StatusBadRequest},
+		{name: "cluster not found", err: k8serrors.NewNotFound(schema.GroupResource{Resource: "pods"}, "a"), want: http.StatusNotFound},
+		{name: "other", err: errors.New("workspace 'a' is already running, not found or invalid"), want: http.StatusInternalServerError},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			writeWorkspaceError(w, "test", tt.err)
+			if w.Code != tt.want {
+				t.Errorf("status is %d, want %d", w.Code, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,154 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/rahulvramesh/justup/pkg/kubernetes"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/workqueue"
+)
+
+// Config holds the controller configuration
+type Config struct {
+	// Workers is the number of workspaces reconciled concurrently
+	Workers int
+	// ResyncPeriod is how often every workspace is reconciled regardless of events
+	ResyncPeriod time.Duration
+}
+
+// Controller reconciles Workspace resources into pods, PVCs and secrets
+type Controller struct {
+	config    *Config
+	k8sClient *kubernetes.Client
+	queue     workqueue.TypedRateLimitingInterface[string]
+
+	workspaceInformer cache.SharedIndexInformer
+	podInformer       cache.SharedIndexInformer
+}
+
+// New creates a new workspace controller
+func New(config *Config, k8sClient *kubernetes.Client) (*Controller, error) {
+	c := &Controller{
+		config:    config,
+		k8sClient: k8sClient,
+		queue: workqueue.NewTypedRateLimitingQueueWithConfig(
+			workqueue.DefaultTypedControllerRateLimiter[string](),
+			workqueue.TypedRateLimitingQueueConfig[string]{Name: "workspaces"},
+		),
+	}
+
+	c.workspaceInformer, c.podInformer = k8sClient.NewWorkspaceInformers(config.ResyncPeriod)
+
+	// Workspace events are keyed by the resource name
+	_, err := c.workspaceInformer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    c.enqueueWorkspace,
+		UpdateFunc: func(_, obj interface{}) { c.enqueueWorkspace(obj) },
+		DeleteFunc: c.enqueueWorkspace,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to watch workspaces: %w", err)
+	}
+
+	// Pod events are mapped back to their workspace via the workspace label
+	_, err = c.podInformer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    c.enqueuePod,
+		UpdateFunc: func(_, obj interface{}) { c.enqueuePod(obj) },
+		DeleteFunc: c.enqueuePod,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to watch pods: %w", err)
+	}
+
+	return c, nil
+}
+
+// Run starts the informers and workers and blocks until ctx is cancelled
+func (c *Controller) Run(ctx context.Context) error {
+	defer c.queue.ShutDown()
+
+	go c.workspaceInformer.Run(ctx.Done())
+	go c.podInformer.Run(ctx.Done())
+
+	if !cache.WaitForCacheSync(ctx.Done(), c.workspaceInformer.HasSynced, c.podInformer.HasSynced) {
+		return fmt.Errorf("failed to sync informer caches")
+	}
+
+	workers := c.config.Workers
+	if workers < 1 {
+		workers = 1
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for c.processNextItem(ctx) {
+			}
+		}()
+	}
+
+	<-ctx.Done()
+	c.queue.ShutDown()
+	wg.Wait()
+	return nil
+}
+
+// processNextItem reconciles one queued workspace, returning false once the
+// queue has been shut down
+func (c *Controller) processNextItem(ctx context.Context) bool {
+	name, shutdown := c.queue.Get()
+	if shutdown {
+		return false
+	}
+	defer c.queue.Done(name)
+
+	if err := c.k8sClient.ReconcileWorkspace(ctx, name); err != nil {
+		log.Printf("Failed to reconcile workspace '%s': %v", name, err)
+		c.queue.AddRateLimited(name)
+		return true
+	}
+
+	c.queue.Forget(name)
+	return true
+}
+
+// enqueueWorkspace queues a Workspace resource for reconciliation
+func (c *Controller) enqueueWorkspace(obj interface{}) {
+	if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		obj = tombstone.Obj
+	}
+
+	key, err := cache.MetaNamespaceKeyFunc(obj)
+	if err != nil {
+		log.Printf("Failed to get key for workspace: %v", err)
+		return
+	}
+
+	_, name, err := cache.SplitMetaNamespaceKey(key)
+	if err != nil {
+		return
+	}
+	c.queue.Add(name)
+}
+
+// enqueuePod queues the workspace owning a pod for reconciliation
+func (c *Controller) enqueuePod(obj interface{}) {
+	if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		obj = tombstone.Obj
+	}
+
+	pod, ok := obj.(*corev1.Pod)
+	if !ok {
+		return
+	}
+
+	if name := pod.Labels[kubernetes.WorkspaceLabel]; name != "" {
+		c.queue.Add(name)
+	}
+}
@@ -1,10 +1,13 @@
 package database
 
 import (
+	"context"
 	"database/sql"
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
 	"time"
 
 	_ "github.com/mattn/go-sqlite3"
@@ -28,18 +31,20 @@ type SSHKey struct {
 
 // Workspace represents stored workspace metadata
 type Workspace struct {
-	ID        string
-	Name      string
-	UserID    string
-	GitURL    string
-	Branch    string
-	Image     string
-	CPU       string
-	Memory    string
-	Storage   string
-	EnableDinD bool
-	CreatedAt time.Time
-	UpdatedAt time.Time
+	ID            string
+	Name          string
+	UserID        string
+	GitURL        string
+	Branch        string
+	Image         string
+	CPU           string
+	Memory        string
+	Storage       string
+	EnableDinD    bool
+	CreatedAt     time.Time
+	UpdatedAt     time.Time
+	LastStartedAt *time.Time
+	LastStoppedAt *time.Time
 }
 
 // Open opens or creates the SQLite database
@@ -50,17 +55,13 @@ func Open(path string) (*DB, error) {
 		return nil, fmt.Errorf("failed to create database directory: %w", err)
 	}
 
-	db, err := sql.Open("sqlite3", path)
+	// Foreign keys are enabled in the DSN so every pooled connection has
+	// them; a PRAGMA would only apply to the connection it ran on
+	db, err := sql.Open("sqlite3", path+"?_foreign_keys=on")
 	if err != nil {
 		return nil, fmt.Errorf("failed to open database: %w", err)
 	}
 
-	// Enable foreign keys
-	if _, err := db.Exec("PRAGMA foreign_keys = ON"); err != nil {
-		db.Close()
-		return nil, fmt.Errorf("failed to enable foreign keys: %w", err)
-	}
-
 	// Create tables
 	if err := createTables(db); err != nil {
 		db.Close()
@@ -75,6 +76,11 @@ func (d *DB) Close() error {
 	return d.db.Close()
 }
 
+// Ping checks that the database is reachable
+func (d *DB) Ping(ctx context.Context) error {
+	return d.db.PingContext(ctx)
+}
+
 // createTables creates the database schema
 func createTables(db *sql.DB) error {
 	schema := `
@@ -111,16 +117,113 @@ func createTables(db *sql.DB) error {
 		FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
 	);
 
+	CREATE TABLE IF NOT EXISTS workspace_access (
+		workspace_name TEXT NOT NULL,
+		user_id TEXT NOT NULL,
+		role TEXT NOT NULL,
+		granted_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		PRIMARY KEY (workspace_name, user_id),
+		FOREIGN KEY (workspace_name) REFERENCES workspaces(name) ON DELETE CASCADE,
+		FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+	);
+
+	CREATE TABLE IF NOT EXISTS api_tokens (
+		id TEXT PRIMARY KEY,
+		user_id TEXT NOT NULL,
+		name TEXT NOT NULL,
+		token_hash TEXT UNIQUE NOT NULL,
+		scopes TEXT NOT NULL,
+		expires_at DATETIME,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		last_used_at DATETIME,
+		FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+	);
+
+	CREATE TABLE IF NOT EXISTS audit_events (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		time DATETIME NOT NULL,
+		session_id TEXT NOT NULL,
+		event TEXT NOT NULL,
+		user_id TEXT,
+		workspace TEXT,
+		remote_addr TEXT,
+		detail TEXT
+	);
+
 	CREATE INDEX IF NOT EXISTS idx_ssh_keys_fingerprint ON ssh_keys(fingerprint);
 	CREATE INDEX IF NOT EXISTS idx_ssh_keys_user_id ON ssh_keys(user_id);
 	CREATE INDEX IF NOT EXISTS idx_workspaces_user_id ON workspaces(user_id);
 	CREATE INDEX IF NOT EXISTS idx_workspaces_name ON workspaces(name);
+	CREATE INDEX IF NOT EXISTS idx_workspace_access_user_id ON workspace_access(user_id);
+	CREATE INDEX IF NOT EXISTS idx_api_tokens_user_id ON api_tokens(user_id);
+	CREATE INDEX IF NOT EXISTS idx_audit_events_session_id ON audit_events(session_id);
+	CREATE INDEX IF NOT EXISTS idx_audit_events_time ON audit_events(time);
 	`
 
-	_, err := db.Exec(schema)
+	if _, err := db.Exec(schema); err != nil {
+		return err
+	}
+
+	return migrate(db)
+}
+
+// migrate adds columns introduced after a table was first created
+func migrate(db *sql.DB) error {
+	columns := []struct {
+		table, name, definition string
+	}{
+		{"workspaces", "last_started_at", "DATETIME"},
+		{"workspaces", "last_stopped_at", "DATETIME"},
+		{"users", "email", "TEXT"},
+		{"users", "oidc_subject", "TEXT"},
+	}
+
+	for _, col := range columns {
+		exists, err := columnExists(db, col.table, col.name)
+		if err != nil {
+			return err
+		}
+		if exists {
+			continue
+		}
+		if _, err := db.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", col.table, col.name, col.definition)); err != nil {
+			return fmt.Errorf("failed to add column %s.%s: %w", col.table, col.name, err)
+		}
+	}
+
+	// Indexes on added columns can only be created once they exist
+	_, err := db.Exec("CREATE UNIQUE INDEX IF NOT EXISTS idx_users_oidc_subject ON users(oidc_subject)")
 	return err
 }
 
+// columnExists reports whether a table has the given column
+func columnExists(db *sql.DB, table, column string) (bool, error) {
+	rows, err := db.Query(fmt.Sprintf("PRAGMA table_info(%s)", table))
+	if err != nil {
+		return false, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			cid       int
+			name      string
+			colType   string
+			notNull   bool
+			dfltValue sql.NullString
+			pk        int
+		)
+		if err := rows.Scan(&cid, &name, &colType, &notNull, &dfltValue, &pk); err != nil {
+			return false, err
+		}
+		if name == column {
+			return true, nil
+		}
+	}
+
+	return false, rows.Err()
+}
+
 // --- User operations ---
 
 // CreateUser creates a new user
@@ -132,42 +235,228 @@ func (d *DB) CreateUser(id, username string) error {
 	return err
 }
 
+// userColumns are the columns scanned by scanUser
+const userColumns = "id, username, COALESCE(email, ''), COALESCE(oidc_subject, ''), created_at"
+
 // GetUser retrieves a user by ID
 func (d *DB) GetUser(id string) (*User, error) {
+	return scanUser(d.db.QueryRow("SELECT "+userColumns+" FROM users WHERE id = ?", id))
+}
+
+// GetUserByUsername retrieves a user by username
+func (d *DB) GetUserByUsername(username string) (*User, error) {
+	return scanUser(d.db.QueryRow("SELECT "+userColumns+" FROM users WHERE username = ?", username))
+}
+
+// GetUserByEmail retrieves a user by email address
+func (d *DB) GetUserByEmail(email string) (*User, error) {
+	return scanUser(d.db.QueryRow("SELECT "+userColumns+" FROM users WHERE email = ?", email))
+}
+
+// GetUserByOIDCSubject retrieves the user linked to an OIDC subject
+func (d *DB) GetUserByOIDCSubject(subject string) (*User, error) {
+	return scanUser(d.db.QueryRow("SELECT "+userColumns+" FROM users WHERE oidc_subject = ?", subject))
+}
+
+// CreateOIDCUser creates a user linked to an OIDC subject
+func (d *DB) CreateOIDCUser(id, username, email, subject string) error {
+	_, err := d.db.Exec(
+		"INSERT INTO users (id, username, email, oidc_subject) VALUES (?, ?, ?, ?)",
+		id, username, nullString(email), subject,
+	)
+	return err
+}
+
+// LinkOIDCUser links an existing user to an OIDC subject and records the
+// email address it reported
+func (d *DB) LinkOIDCUser(id, email, subject string) error {
+	_, err := d.db.Exec(
+		"UPDATE users SET email = ?, oidc_subject = ? WHERE id = ?",
+		nullString(email), subject, id,
+	)
+	return err
+}
+
+func scanUser(row *sql.Row) (*User, error) {
 	var user User
-	err := d.db.QueryRow(
-		"SELECT id, username, created_at FROM users WHERE id = ?",
-		id,
-	).Scan(&user.ID, &user.Username, &user.CreatedAt)
+	err := row.Scan(&user.ID, &user.Username, &user.Email, &user.OIDCSubject, &user.CreatedAt)
 	if err != nil {
 		return nil, err
 	}
 	return &user, nil
 }
 
+// nullString stores empty strings as NULL, so that lookups never match
+// users without a value
+func nullString(s string) interface{} {
+	if s == "" {
+		return nil
+	}
+	return s
+}
+
+// The default user of single-user mode, whom the API server's bootstrap
+// token acts as
+const (
+	DefaultUserID   = "default"
+	DefaultUsername = "default"
+)
+
 // GetOrCreateDefaultUser gets or creates the default user for single-user mode
 func (d *DB) GetOrCreateDefaultUser() (*User, error) {
-	const defaultUserID = "default"
-	const defaultUsername = "default"
-
-	user, err := d.GetUser(defaultUserID)
+	user, err := d.GetUser(DefaultUserID)
 	if err == nil {
 		return user, nil
 	}
 
 	// Create default user
-	if err := d.CreateUser(defaultUserID, defaultUsername); err != nil {
+	if err := d.CreateUser(DefaultUserID, DefaultUsername); err != nil {
 		return nil, err
 	}
 
-	return d.GetUser(defaultUserID)
+	return d.GetUser(DefaultUserID)
 }
 
 // User represents a user in the system
 type User struct {
-	ID        string
-	Username  string
-	CreatedAt time.Time
+	ID          string
+	Username    string
+	Email       string
+	OIDCSubject string // Empty unless the user logs in through OIDC
+	CreatedAt   time.Time
+}
+
+// --- API token operations ---
+
+// APIToken is a credential for the API server. Only a hash of the token is
+// stored.
+type APIToken struct {
+	ID         string
+	UserID     string
+	Name       string
+	TokenHash  string
+	Scopes     []string
+	ExpiresAt  *time.Time
+	CreatedAt  time.Time
+	LastUsedAt *time.Time
+}
+
+// Expired reports whether the token's expiry has passed
+func (t *APIToken) Expired(now time.Time) bool {
+	return t.ExpiresAt != nil && !now.Before(*t.ExpiresAt)
+}
+
+// HasScope reports whether the token grants scope
+func (t *APIToken) HasScope(scope string) bool {
+	for _, s := range t.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// CreateAPIToken stores a new API token
+func (d *DB) CreateAPIToken(token *APIToken) error {
+	_, err := d.db.Exec(
+		`INSERT INTO api_tokens (id, user_id, name, token_hash, scopes, expires_at, created_at)
+		 VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		token.ID, token.UserID, token.Name, token.TokenHash, strings.Join(token.Scopes, ","), token.ExpiresAt, token.CreatedAt,
+	)
+	return err
+}
+
+// GetAPITokenByHash retrieves an API token by the hash of its value
+func (d *DB) GetAPITokenByHash(hash string) (*APIToken, error) {
+	return scanAPIToken(d.db.QueryRow(
+		`SELECT id, user_id, name, token_hash, scopes, expires_at, created_at, last_used_at
+		 FROM api_tokens WHERE token_hash = ?`,
+		hash,
+	))
+}
+
+// GetAPIToken retrieves an API token by ID
+func (d *DB) GetAPIToken(id string) (*APIToken, error) {
+	return scanAPIToken(d.db.QueryRow(
+		`SELECT id, user_id, name, token_hash, scopes, expires_at, created_at, last_used_at
+		 FROM api_tokens WHERE id = ?`,
+		id,
+	))
+}
+
+// ListAPITokens lists all API tokens of a user
+func (d *DB) ListAPITokens(userID string) ([]APIToken, error) {
+	rows, err := d.db.Query(
+		`SELECT id, user_id, name, token_hash, scopes, expires_at, created_at, last_used_at
+		 FROM api_tokens WHERE user_id = ? ORDER BY created_at DESC`,
+		userID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tokens []APIToken
+	for rows.Next() {
+		token, err := scanAPIToken(rows)
+		if err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, *token)
+	}
+
+	return tokens, rows.Err()
+}
+
+// DeleteAPIToken revokes an API token
+func (d *DB) DeleteAPIToken(id string) error {
+	result, err := d.db.Exec("DELETE FROM api_tokens WHERE id = ?", id)
+	if err != nil {
+		return err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return fmt.Errorf("token not found")
+	}
+	return nil
+}
+
+// UpdateAPITokenLastUsed updates the last_used_at timestamp
+func (d *DB) UpdateAPITokenLastUsed(id string) error {
+	_, err := d.db.Exec(
+		"UPDATE api_tokens SET last_used_at = CURRENT_TIMESTAMP WHERE id = ?",
+		id,
+	)
+	return err
+}
+
+// scanAPIToken scans a row selected with the api_tokens columns in table
+// order
+func scanAPIToken(row interface{ Scan(...interface{}) error }) (*APIToken, error) {
+	var token APIToken
+	var scopes string
+	var expiresAt, lastUsed sql.NullTime
+
+	err := row.Scan(&token.ID, &token.UserID, &token.Name, &token.TokenHash, &scopes, &expiresAt, &token.CreatedAt, &lastUsed)
+	if err != nil {
+		return nil, err
+	}
+
+	if scopes != "" {
+		token.Scopes = strings.Split(scopes, ",")
+	}
+	if expiresAt.Valid {
+		token.ExpiresAt = &expiresAt.Time
+	}
+	if lastUsed.Valid {
+		token.LastUsedAt = &lastUsed.Time
+	}
+
+	return &token, nil
 }
 
 // --- SSH Key operations ---
@@ -280,36 +569,65 @@ func (d *DB) UpdateSSHKeyLastUsed(id string) error {
 
 // --- Workspace operations ---
 
-// SaveWorkspace saves workspace metadata
+const workspaceColumns = `id, name, user_id, git_url, branch, image, cpu, memory, storage, enable_dind,
+	created_at, updated_at, last_started_at, last_stopped_at`
+
+// scanWorkspace scans a row selected with workspaceColumns
+func scanWorkspace(row interface{ Scan(...interface{}) error }) (*Workspace, error) {
+	var ws Workspace
+	var lastStarted, lastStopped sql.NullTime
+
+	err := row.Scan(&ws.ID, &ws.Name, &ws.UserID, &ws.GitURL, &ws.Branch, &ws.Image, &ws.CPU, &ws.Memory, &ws.Storage, &ws.EnableDinD,
+		&ws.CreatedAt, &ws.UpdatedAt, &lastStarted, &lastStopped)
+	if err != nil {
+		return nil, err
+	}
+
+	if lastStarted.Valid {
+		ws.LastStartedAt = &lastStarted.Time
+	}
+	if lastStopped.Valid {
+		ws.LastStoppedAt = &lastStopped.Time
+	}
+
+	return &ws, nil
+}
+
+// SaveWorkspace saves workspace metadata, updating the spec of an existing
+// record with the same name while keeping its ID, owner and creation time
 func (d *DB) SaveWorkspace(ws *Workspace) error {
 	_, err := d.db.Exec(
-		`INSERT OR REPLACE INTO workspaces
-		 (id, name, user_id, git_url, branch, image, cpu, memory, storage, enable_dind, created_at, updated_at)
-		 VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, CURRENT_TIMESTAMP)`,
+		`INSERT INTO workspaces
+		 (id, name, user_id, git_url, branch, image, cpu, memory, storage, enable_dind, created_at, updated_at, last_started_at, last_stopped_at)
+		 VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, CURRENT_TIMESTAMP, ?, ?)
+		 ON CONFLICT(name) DO UPDATE SET
+		   git_url = excluded.git_url,
+		   branch = excluded.branch,
+		   image = excluded.image,
+		   cpu = excluded.cpu,
+		   memory = excluded.memory,
+		   storage = excluded.storage,
+		   enable_dind = excluded.enable_dind,
+		   updated_at = CURRENT_TIMESTAMP`,
 		ws.ID, ws.Name, ws.UserID, ws.GitURL, ws.Branch, ws.Image, ws.CPU, ws.Memory, ws.Storage, ws.EnableDinD, ws.CreatedAt,
+		ws.LastStartedAt, ws.LastStoppedAt,
 	)
 	return err
 }
 
 // GetWorkspace retrieves workspace metadata by name
 func (d *DB) GetWorkspace(name string) (*Workspace, error) {
-	var ws Workspace
-	err := d.db.QueryRow(
-		`SELECT id, name, user_id, git_url, branch, image, cpu, memory, storage, enable_dind, created_at, updated_at
-		 FROM workspaces WHERE name = ?`,
+	row := d.db.QueryRow(
+		`SELECT `+workspaceColumns+` FROM workspaces WHERE name = ?`,
 		name,
-	).Scan(&ws.ID, &ws.Name, &ws.UserID, &ws.GitURL, &ws.Branch, &ws.Image, &ws.CPU, &ws.Memory, &ws.Storage, &ws.EnableDinD, &ws.CreatedAt, &ws.UpdatedAt)
-	if err != nil {
-		return nil, err
-	}
-	return &ws, nil
+	)
+	return scanWorkspace(row)
 }
 
 // ListWorkspaces lists all workspaces for a user
 func (d *DB) ListWorkspaces(userID string) ([]Workspace, error) {
 	rows, err := d.db.Query(
-		`SELECT id, name, user_id, git_url, branch, image, cpu, memory, storage, enable_dind, created_at, updated_at
-		 FROM workspaces WHERE user_id = ? ORDER BY created_at DESC`,
+		`SELECT `+workspaceColumns+` FROM workspaces WHERE user_id = ? ORDER BY created_at DESC`,
 		userID,
 	)
 	if err != nil {
@@ -319,18 +637,291 @@ func (d *DB) ListWorkspaces(userID string) ([]Workspace, error) {
 
 	var workspaces []Workspace
 	for rows.Next() {
-		var ws Workspace
-		if err := rows.Scan(&ws.ID, &ws.Name, &ws.UserID, &ws.GitURL, &ws.Branch, &ws.Image, &ws.CPU, &ws.Memory, &ws.Storage, &ws.EnableDinD, &ws.CreatedAt, &ws.UpdatedAt); err != nil {
+		ws, err := scanWorkspace(rows)
+		if err != nil {
 			return nil, err
 		}
-		workspaces = append(workspaces, ws)
+		workspaces = append(workspaces, *ws)
 	}
 
 	return workspaces, rows.Err()
 }
 
+// MarkWorkspaceStarted records that a workspace was started
+func (d *DB) MarkWorkspaceStarted(name string) error {
+	_, err := d.db.Exec(
+		"UPDATE workspaces SET last_started_at = CURRENT_TIMESTAMP, updated_at = CURRENT_TIMESTAMP WHERE name = ?",
+		name,
+	)
+	return err
+}
+
+// MarkWorkspaceStopped records that a workspace was stopped
+func (d *DB) MarkWorkspaceStopped(name string) error {
+	_, err := d.db.Exec(
+		"UPDATE workspaces SET last_stopped_at = CURRENT_TIMESTAMP, updated_at = CURRENT_TIMESTAMP WHERE name = ?",
+		name,
+	)
+	return err
+}
+
 // DeleteWorkspace deletes workspace metadata
 func (d *DB) DeleteWorkspace(name string) error {
 	_, err := d.db.Exec("DELETE FROM workspaces WHERE name = ?", name)
 	return err
 }
+
+// --- Workspace access operations ---
+
+// Workspace access roles
+const (
+	// RoleOwner has full access; it is implied by workspaces.user_id
+	RoleOwner = "owner"
+	// RoleCollaborator has full SSH access but cannot manage sharing
+	RoleCollaborator = "collaborator"
+	// RoleReadOnly may only forward ports, e.g. to view a running service
+	RoleReadOnly = "read-only"
+)
+
+// WorkspaceAccess represents access to a workspace granted to a user
+type WorkspaceAccess struct {
+	WorkspaceName string
+	UserID        string
+	Username      string
+	Role          string
+	GrantedAt     time.Time
+}
+
+// ErrAccessNotFound is returned when revoking access that was not granted
+var ErrAccessNotFound = errors.New("access grant not found")
+
+// IsValidRole reports whether role can be granted
+func IsValidRole(role string) bool {
+	return role == RoleOwner || role == RoleCollaborator || role == RoleReadOnly
+}
+
+// GrantWorkspaceAccess grants (or changes) a user's role on a workspace
+func (d *DB) GrantWorkspaceAccess(workspaceName, userID, role string) error {
+	if !IsValidRole(role) {
+		return fmt.Errorf("invalid role %q", role)
+	}
+
+	_, err := d.db.Exec(
+		`INSERT INTO workspace_access (workspace_name, user_id, role, granted_at)
+		 VALUES (?, ?, ?, CURRENT_TIMESTAMP)
+		 ON CONFLICT(workspace_name, user_id) DO UPDATE SET role = excluded.role, granted_at = CURRENT_TIMESTAMP`,
+		workspaceName, userID, role,
+	)
+	return err
+}
+
+// RevokeWorkspaceAccess removes a user's access grant on a workspace
+func (d *DB) RevokeWorkspaceAccess(workspaceName, userID string) error {
+	result, err := d.db.Exec(
+		"DELETE FROM workspace_access WHERE workspace_name = ? AND user_id = ?",
+		workspaceName, userID,
+	)
+	if err != nil {
+		return err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return ErrAccessNotFound
+	}
+	return nil
+}
+
+// ListWorkspaceAccess lists the access grants on a workspace
+func (d *DB) ListWorkspaceAccess(workspaceName string) ([]WorkspaceAccess, error) {
+	rows, err := d.db.Query(
+		`SELECT a.workspace_name, a.user_id, u.username, a.role, a.granted_at
+		 FROM workspace_access a JOIN users u ON u.id = a.user_id
+		 WHERE a.workspace_name = ? ORDER BY a.granted_at`,
+		workspaceName,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var grants []WorkspaceAccess
+	for rows.Next() {
+		var grant WorkspaceAccess
+		if err := rows.Scan(&grant.WorkspaceName, &grant.UserID, &grant.Username, &grant.Role, &grant.GrantedAt); err != nil {
+			return nil, err
+		}
+		grants = append(grants, grant)
+	}
+
+	return grants, rows.Err()
+}
+
+// ListWorkspaceRoles returns the role a user has on every workspace they own
+// or have been granted, keyed by workspace name
+func (d *DB) ListWorkspaceRoles(userID string) (map[string]string, error) {
+	rows, err := d.db.Query(
+		`SELECT name, ? FROM workspaces WHERE user_id = ?
+		 UNION ALL
+		 SELECT workspace_name, role FROM workspace_access WHERE user_id = ?`,
+		RoleOwner, userID, userID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	roles := make(map[string]string)
+	for rows.Next() {
+		var name, role string
+		if err := rows.Scan(&name, &role); err != nil {
+			return nil, err
+		}
+		// Ownership wins over a grant on the same workspace
+		if _, ok := roles[name]; !ok || role == RoleOwner {
+			roles[name] = role
+		}
+	}
+
+	return roles, rows.Err()
+}
+
+// GetWorkspaceRole returns the role a user has on a workspace: RoleOwner
+// for the workspace's owner, otherwise the granted role. It returns
+// sql.ErrNoRows if the user has no access.
+func (d *DB) GetWorkspaceRole(workspaceName, userID string) (string, error) {
+	var role string
+	err := d.db.QueryRow(
+		`SELECT ? FROM workspaces WHERE name = ? AND user_id = ?
+		 UNION ALL
+		 SELECT role FROM workspace_access WHERE workspace_name = ? AND user_id = ?
+		 LIMIT 1`,
+		RoleOwner, workspaceName, userID, workspaceName, userID,
+	).Scan(&role)
+	if err != nil {
+		return "", err
+	}
+	return role, nil
+}
+
+// --- Audit operations ---
+
+// Audit event types recorded by the SSH proxy
+const (
+	AuditConnect         = "connect"
+	AuditAuth            = "auth"
+	AuditAuthFailed      = "auth_failed"
+	AuditChannelOpen     = "channel_open"
+	AuditChannelRejected = "channel_rejected"
+	AuditChannelClose    = "channel_close"
+	AuditForward         = "forward"
+	AuditForwardRejected = "forward_rejected"
+	AuditPTY             = "pty"
+	AuditShell           = "shell"
+	AuditExec            = "exec"
+	AuditSubsystem       = "subsystem"
+	AuditRecording       = "recording"
+	AuditError           = "error"
+	AuditDisconnect      = "disconnect"
+)
+
+// AuditEvent is one entry of the SSH proxy's audit trail. Events of the
+// same connection share a session ID.
+type AuditEvent struct {
+	ID         int64
+	Time       time.Time
+	SessionID  string
+	Event      string
+	UserID     string
+	Username   string // Filled in when listing
+	Workspace  string
+	RemoteAddr string
+	Detail     string
+}
+
+// AuditFilter selects audit events; zero fields match everything
+type AuditFilter struct {
+	SessionID string
+	UserID    string
+	Workspace string
+	Event     string
+	Since     time.Time
+	// Limit caps the number of events, keeping the most recent
+	Limit int
+}
+
+// AddAuditEvent records an audit event
+func (d *DB) AddAuditEvent(event *AuditEvent) error {
+	_, err := d.db.Exec(
+		`INSERT INTO audit_events (time, session_id, event, user_id, workspace, remote_addr, detail)
+		 VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		event.Time.UTC(), event.SessionID, event.Event, nullString(event.UserID), nullString(event.Workspace), event.RemoteAddr, event.Detail,
+	)
+	return err
+}
+
+// ListAuditEvents lists matching audit events, oldest first
+func (d *DB) ListAuditEvents(filter AuditFilter) ([]AuditEvent, error) {
+	var (
+		conditions []string
+		args       []interface{}
+	)
+	add := func(condition string, arg interface{}) {
+		conditions = append(conditions, condition)
+		args = append(args, arg)
+	}
+	if filter.SessionID != "" {
+		add("e.session_id = ?", filter.SessionID)
+	}
+	if filter.UserID != "" {
+		add("e.user_id = ?", filter.UserID)
+	}
+	if filter.Workspace != "" {
+		add("e.workspace = ?", filter.Workspace)
+	}
+	if filter.Event != "" {
+		add("e.event = ?", filter.Event)
+	}
+	if !filter.Since.IsZero() {
+		// Times are stored in UTC, so they compare as text
+		add("e.time >= ?", filter.Since.UTC())
+	}
+
+	query := `SELECT e.id, e.time, e.session_id, e.event, COALESCE(e.user_id, ''), COALESCE(u.username, ''),
+	          COALESCE(e.workspace, ''), COALESCE(e.remote_addr, ''), COALESCE(e.detail, '')
+	          FROM audit_events e LEFT JOIN users u ON u.id = e.user_id`
+	if len(conditions) > 0 {
+		query += " WHERE " + strings.Join(conditions, " AND ")
+	}
+	query += " ORDER BY e.id DESC"
+	if filter.Limit > 0 {
+		query += fmt.Sprintf(" LIMIT %d", filter.Limit)
+	}
+
+	rows, err := d.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var events []AuditEvent
+	for rows.Next() {
+		var e AuditEvent
+		if err := rows.Scan(&e.ID, &e.Time, &e.SessionID, &e.Event, &e.UserID, &e.Username, &e.Workspace, &e.RemoteAddr, &e.Detail); err != nil {
+			return nil, err
+		}
+		events = append(events, e)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	// Queried newest first so the limit keeps the most recent events
+	for i, j := 0, len(events)-1; i < j; i, j = i+1, j-1 {
+		events[i], events[j] = events[j], events[i]
+	}
+	return events, nil
+}
@@ -0,0 +1,58 @@
+package database
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestDeleteWorkspaceRevokesAccess(t *testing.T) {
+	db, err := Open(filepath.Join(t.TempDir(), "justup.db"))
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	defer db.Close()
+
+	// Holding the first connection makes the rest run on new ones, which
+	// must enforce foreign keys too
+	conn, err := db.db.Conn(context.Background())
+	if err != nil {
+		t.Fatalf("failed to get connection: %v", err)
+	}
+	defer conn.Close()
+
+	for _, id := range []string{"alice-id", "bob-id"} {
+		if err := db.CreateUser(id, id); err != nil {
+			t.Fatalf("failed to create user: %v", err)
+		}
+	}
+	save := func(id string) {
+		t.Helper()
+		err := db.SaveWorkspace(&Workspace{ID: id, Name: "myproject", UserID: "alice-id", CreatedAt: time.Now()})
+		if err != nil {
+			t.Fatalf("failed to save workspace: %v", err)
+		}
+	}
+
+	save("first-id")
+	if err := db.GrantWorkspaceAccess("myproject", "bob-id", RoleReadOnly); err != nil {
+		t.Fatalf("failed to grant access: %v", err)
+	}
+	if err := db.DeleteWorkspace("myproject"); err != nil {
+		t.Fatalf("failed to delete workspace: %v", err)
+	}
+
+	// A new workspace with the same name starts unshared
+	save("second-id")
+	grants, err := db.ListWorkspaceAccess("myproject")
+	if err != nil {
+		t.Fatalf("failed to list access: %v", err)
+	}
+	if len(grants) != 0 {
+		t.Errorf("new workspace inherited grants %+v", grants)
+	}
+	if _, err := db.GetWorkspaceRole("myproject", "bob-id"); err == nil {
+		t.Error("bob still has a role on the new workspace")
+	}
+}
@@ -3,12 +3,17 @@ package kubernetes
 import (
 	"context"
 	"fmt"
+	"io"
 	"net/http"
 	"os"
 	"path/filepath"
+	"strconv"
+	"sync"
 
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/httpstream"
+	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/clientcmd"
@@ -25,11 +30,22 @@ const (
 	GitURLAnnotation = "justup.io/git-url"
 	// Annotation for storing branch
 	BranchAnnotation = "justup.io/branch"
+	// Annotation on the PVC storing the full workspace spec as JSON, so a
+	// workspace can be restored from its volume alone
+	SpecAnnotation = "justup.io/spec"
+	// Annotation on a Workspace and its PVC naming the user who owns it, so
+	// the SSH proxy can authorize workspaces not created through the API
+	OwnerAnnotation = "justup.io/owner"
+	// Annotation on a Workspace opting it out of idle shutdown when "true"
+	NoIdleShutdownAnnotation = "justup.io/no-idle-shutdown"
+	// Annotation on a Workspace recording its last SSH activity (RFC 3339)
+	LastActivityAnnotation = "justup.io/last-activity"
 )
 
 // Client wraps the Kubernetes client
 type Client struct {
 	clientset  *kubernetes.Clientset
+	dynamic    dynamic.Interface
 	restConfig *rest.Config
 }
 
@@ -45,8 +61,14 @@ func NewClient() (*Client, error) {
 		return nil, fmt.Errorf("failed to create clientset: %w", err)
 	}
 
+	dynamicClient, err := dynamic.NewForConfig(config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create dynamic client: %w", err)
+	}
+
 	return &Client{
 		clientset:  clientset,
+		dynamic:    dynamicClient,
 		restConfig: config,
 	}, nil
 }
@@ -145,3 +167,116 @@ func (c *Client) PortForward(ctx context.Context, name string, localPort, remote
 
 	return pf.ForwardPorts()
 }
+
+// DialPort connects to a port on a workspace pod's loopback interface
+// through the pods/portforward subresource. The connection has its own
+// forwarding session, which closing it ends; its CloseWrite method signals
+// the end of input.
+func (c *Client) DialPort(ctx context.Context, name string, port int) (io.ReadWriteCloser, error) {
+	url := c.clientset.CoreV1().RESTClient().Post().
+		Resource("pods").
+		Namespace(WorkspaceNamespace).
+		Name("ws-" + name).
+		SubResource("portforward").
+		URL()
+
+	transport, upgrader, err := spdy.RoundTripperFor(c.restConfig)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create round tripper: %w", err)
+	}
+
+	dialer := spdy.NewDialer(upgrader, &http.Client{Transport: transport}, "POST", url)
+	conn, _, err := dialer.Dial(portforward.PortForwardProtocolV1Name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to pod: %w", err)
+	}
+
+	// The kubelet reports failures to connect on the error stream and
+	// carries the data on the data stream
+	headers := http.Header{}
+	headers.Set(corev1.StreamType, corev1.StreamTypeError)
+	headers.Set(corev1.PortHeader, strconv.Itoa(port))
+	headers.Set(corev1.PortForwardRequestIDHeader, "0")
+	errorStream, err := conn.CreateStream(headers)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to create error stream: %w", err)
+	}
+	errorStream.Close()
+
+	headers.Set(corev1.StreamType, corev1.StreamTypeData)
+	dataStream, err := conn.CreateStream(headers)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to create data stream: %w", err)
+	}
+
+	pc := &portConn{stream: dataStream, conn: conn}
+	go func() {
+		message, _ := io.ReadAll(errorStream)
+		if len(message) > 0 {
+			pc.fail(fmt.Errorf("failed to forward port %d: %s", port, message))
+		}
+	}()
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.Close()
+		case <-conn.CloseChan():
+		}
+	}()
+	return pc, nil
+}
+
+// portConn is a connection DialPort opened
+type portConn struct {
+	stream httpstream.Stream
+	conn   httpstream.Connection
+
+	mu  sync.Mutex
+	err error
+}
+
+// fail ends the connection with an error the kubelet reported
+func (c *portConn) fail(err error) {
+	c.mu.Lock()
+	c.err = err
+	c.mu.Unlock()
+	c.conn.Close()
+}
+
+// failure returns the reported error in place of err, if there is one
+func (c *portConn) failure(err error) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.err != nil {
+		return c.err
+	}
+	return err
+}
+
+func (c *portConn) Read(p []byte) (int, error) {
+	n, err := c.stream.Read(p)
+	if err != nil {
+		err = c.failure(err)
+	}
+	return n, err
+}
+
+func (c *portConn) Write(p []byte) (int, error) {
+	n, err := c.stream.Write(p)
+	if err != nil {
+		err = c.failure(err)
+	}
+	return n, err
+}
+
+// CloseWrite tells the pod no more data will be sent
+func (c *portConn) CloseWrite() error {
+	return c.stream.Close()
+}
+
+// Close ends the forwarding session
+func (c *portConn) Close() error {
+	return c.conn.Close()
+}
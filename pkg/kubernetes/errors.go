@@ -0,0 +1,39 @@
+package kubernetes
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Errors workspace operations match with errors.Is, so callers such as the
+// API server can tell them apart without parsing messages
+var (
+	// ErrWorkspaceNotFound is returned for workspaces with neither a
+	// resource nor a volume to restore
+	ErrWorkspaceNotFound = errors.New("workspace not found")
+	// ErrWorkspaceExists is returned when creating a workspace that exists
+	ErrWorkspaceExists = errors.New("workspace already exists")
+	// ErrWorkspaceRunning is returned when starting or reconfiguring a
+	// running workspace
+	ErrWorkspaceRunning = errors.New("workspace is running")
+	// ErrWorkspaceStopped is returned when stopping a stopped workspace
+	ErrWorkspaceStopped = errors.New("workspace is stopped")
+	// ErrInvalidWorkspace is returned for invalid or reserved names and
+	// options
+	ErrInvalidWorkspace = errors.New("invalid workspace options")
+)
+
+// workspaceError is an error with its own message that matches one of the
+// errors above
+type workspaceError struct {
+	kind error
+	err  error
+}
+
+func (e *workspaceError) Error() string   { return e.err.Error() }
+func (e *workspaceError) Unwrap() []error { return []error{e.kind, e.err} }
+
+// workspaceErrorf formats an error matching kind
+func workspaceErrorf(kind error, format string, args ...interface{}) error {
+	return &workspaceError{kind: kind, err: fmt.Errorf(format, args...)}
+}
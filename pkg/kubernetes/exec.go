@@ -0,0 +1,107 @@
+package kubernetes
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/httpstream"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/remotecommand"
+	utilexec "k8s.io/client-go/util/exec"
+)
+
+// WorkspaceContainer is the name of the container users work in
+const WorkspaceContainer = "workspace"
+
+// TerminalSize is the size of a terminal in characters
+type TerminalSize struct {
+	Width  uint16
+	Height uint16
+}
+
+// ExecOptions defines a command to run in a workspace container
+type ExecOptions struct {
+	Command []string
+	// Stdin, Stdout and Stderr are attached to the command if set. With a
+	// TTY, output is merged onto Stdout.
+	Stdin  io.Reader
+	Stdout io.Writer
+	Stderr io.Writer
+	TTY    bool
+	// Resize, if set, delivers terminal size changes; it should be closed
+	// when the command ends
+	Resize <-chan TerminalSize
+}
+
+// Exec runs a command in a workspace's container through the pods/exec
+// subresource, returning when it exits. A command that exits with a non-zero
+// status returns an error ExitCode recognizes.
+func (c *Client) Exec(ctx context.Context, name string, opts ExecOptions) error {
+	podName := "ws-" + name
+
+	req := c.clientset.CoreV1().RESTClient().Post().
+		Resource("pods").
+		Namespace(WorkspaceNamespace).
+		Name(podName).
+		SubResource("exec").
+		VersionedParams(&corev1.PodExecOptions{
+			Container: WorkspaceContainer,
+			Command:   opts.Command,
+			Stdin:     opts.Stdin != nil,
+			Stdout:    opts.Stdout != nil,
+			Stderr:    opts.Stderr != nil && !opts.TTY,
+			TTY:       opts.TTY,
+		}, scheme.ParameterCodec)
+
+	// Prefer WebSockets, falling back to SPDY for API servers older than
+	// Kubernetes 1.30
+	websocket, err := remotecommand.NewWebSocketExecutor(c.restConfig, "GET", req.URL().String())
+	if err != nil {
+		return fmt.Errorf("failed to create websocket executor: %w", err)
+	}
+	spdy, err := remotecommand.NewSPDYExecutor(c.restConfig, "POST", req.URL())
+	if err != nil {
+		return fmt.Errorf("failed to create spdy executor: %w", err)
+	}
+	executor, err := remotecommand.NewFallbackExecutor(websocket, spdy, httpstream.IsUpgradeFailure)
+	if err != nil {
+		return fmt.Errorf("failed to create executor: %w", err)
+	}
+
+	streamOpts := remotecommand.StreamOptions{
+		Stdin:  opts.Stdin,
+		Stdout: opts.Stdout,
+		Tty:    opts.TTY,
+	}
+	if !opts.TTY {
+		streamOpts.Stderr = opts.Stderr
+	}
+	if opts.Resize != nil {
+		streamOpts.TerminalSizeQueue = resizeQueue(opts.Resize)
+	}
+
+	return executor.StreamWithContext(ctx, streamOpts)
+}
+
+// ExitCode returns the exit status of a command Exec ran, if err reports one
+func ExitCode(err error) (int, bool) {
+	var exitErr utilexec.ExitError
+	if errors.As(err, &exitErr) && exitErr.Exited() {
+		return exitErr.ExitStatus(), true
+	}
+	return 0, false
+}
+
+// resizeQueue adapts a channel of sizes to remotecommand's queue
+type resizeQueue <-chan TerminalSize
+
+func (q resizeQueue) Next() *remotecommand.TerminalSize {
+	size, ok := <-q
+	if !ok {
+		return nil
+	}
+	return &remotecommand.TerminalSize{Width: size.Width, Height: size.Height}
+}
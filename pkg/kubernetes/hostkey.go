@@ -0,0 +1,53 @@
+package kubernetes
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	// HostKeySecretKey is the workspace Secret key holding the sshd host key
+	HostKeySecretKey = "ssh_host_ed25519_key"
+	// HostPublicKeySecretKey is the workspace Secret key holding the public
+	// half of the host key, in authorized_keys format
+	HostPublicKeySecretKey = "ssh_host_ed25519_key.pub"
+)
+
+// generateHostKey generates an ED25519 sshd host key for a workspace. The
+// private key is returned in OpenSSH PEM format and the public key in
+// authorized_keys format.
+func generateHostKey(workspaceName string) (privateKey, publicKey string, err error) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to generate host key: %w", err)
+	}
+
+	comment := "justup-" + workspaceName
+	block, err := ssh.MarshalPrivateKey(priv, comment)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to marshal host key: %w", err)
+	}
+
+	sshPub, err := ssh.NewPublicKey(pub)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to encode host public key: %w", err)
+	}
+
+	publicKey = strings.TrimSpace(string(ssh.MarshalAuthorizedKey(sshPub))) + " " + comment + "\n"
+	return string(pem.EncodeToMemory(block)), publicKey, nil
+}
+
+// ParseHostKey parses a workspace host public key as published in the
+// Workspace status
+func ParseHostKey(hostKey string) (ssh.PublicKey, error) {
+	key, _, _, _, err := ssh.ParseAuthorizedKey([]byte(hostKey))
+	if err != nil {
+		return nil, fmt.Errorf("invalid workspace host key: %w", err)
+	}
+	return key, nil
+}
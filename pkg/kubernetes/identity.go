@@ -0,0 +1,87 @@
+package kubernetes
+
+import (
+	"context"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// SystemNamespace holds the justup system components
+	SystemNamespace = "justup-system"
+	// ProxyIdentityConfigMap publishes the public keys the SSH proxy uses to
+	// authenticate to workspaces
+	ProxyIdentityConfigMap = "justup-proxy-identity"
+	// ProxyAuthorizedKeysKey is the ConfigMap key holding the proxy's keys in
+	// authorized_keys format
+	ProxyAuthorizedKeysKey = "authorized_keys"
+)
+
+// PublishProxyKeys creates or updates the ConfigMap holding the SSH proxy's
+// client public keys, which the controller appends to every workspace's
+// authorized_keys
+func (c *Client) PublishProxyKeys(ctx context.Context, authorizedKeys string) error {
+	configMaps := c.clientset.CoreV1().ConfigMaps(SystemNamespace)
+
+	existing, err := configMaps.Get(ctx, ProxyIdentityConfigMap, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		_, err = configMaps.Create(ctx, &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      ProxyIdentityConfigMap,
+				Namespace: SystemNamespace,
+				Labels: map[string]string{
+					"app.kubernetes.io/name":      "justup",
+					"app.kubernetes.io/component": "sshproxy",
+				},
+			},
+			Data: map[string]string{
+				ProxyAuthorizedKeysKey: authorizedKeys,
+			},
+		}, metav1.CreateOptions{})
+		return err
+	}
+	if err != nil {
+		return err
+	}
+
+	if existing.Data[ProxyAuthorizedKeysKey] == authorizedKeys {
+		return nil
+	}
+
+	if existing.Data == nil {
+		existing.Data = map[string]string{}
+	}
+	existing.Data[ProxyAuthorizedKeysKey] = authorizedKeys
+	_, err = configMaps.Update(ctx, existing, metav1.UpdateOptions{})
+	return err
+}
+
+// GetProxyKeys returns the SSH proxy's published client public keys, or an
+// empty string if the proxy has not published any
+func (c *Client) GetProxyKeys(ctx context.Context) (string, error) {
+	cm, err := c.clientset.CoreV1().ConfigMaps(SystemNamespace).Get(ctx, ProxyIdentityConfigMap, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	return cm.Data[ProxyAuthorizedKeysKey], nil
+}
+
+// joinAuthorizedKeys joins authorized_keys fragments, skipping empty ones
+func joinAuthorizedKeys(fragments ...string) string {
+	var lines []string
+	for _, fragment := range fragments {
+		if fragment = strings.TrimSpace(fragment); fragment != "" {
+			lines = append(lines, fragment)
+		}
+	}
+	if len(lines) == 0 {
+		return ""
+	}
+	return strings.Join(lines, "\n") + "\n"
+}
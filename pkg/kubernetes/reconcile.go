@@ -0,0 +1,236 @@
+package kubernetes
+
+import (
+	"context"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// ReconcileWorkspace drives the pod, PVC and secret of a workspace towards
+// the state described by its Workspace resource and records the observed
+// state in the resource's status
+func (c *Client) ReconcileWorkspace(ctx context.Context, name string) error {
+	ws, err := c.GetWorkspaceResource(ctx, name)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			// Deleted; owned objects are garbage collected
+			return nil
+		}
+		return err
+	}
+
+	if ws.DeletionTimestamp != nil {
+		return nil
+	}
+
+	podName := "ws-" + name
+	pvcName := podName + "-pvc"
+	secretName := podName + "-ssh"
+	opts := specToOptions(name, ws.Spec)
+	opts.Owner = ws.Annotations[OwnerAnnotation]
+	owner := workspaceOwnerReference(ws)
+
+	// The PVC is intentionally not owned by the workspace so that
+	// `justup delete --keep-pvc` can preserve it
+	pvc, err := c.clientset.CoreV1().PersistentVolumeClaims(WorkspaceNamespace).Get(ctx, pvcName, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		pvc, err = c.clientset.CoreV1().PersistentVolumeClaims(WorkspaceNamespace).Create(ctx, buildPVC(pvcName, opts), metav1.CreateOptions{})
+	}
+	if err != nil {
+		return fmt.Errorf("failed to ensure PVC: %w", err)
+	}
+
+	// Keep the persisted spec and owner in sync so start-time overrides and
+	// ownership survive a later restore from the PVC
+	spec := persistedSpec(opts)
+	if pvc.Annotations[SpecAnnotation] != spec || pvc.Annotations[OwnerAnnotation] != opts.Owner {
+		if pvc.Annotations == nil {
+			pvc.Annotations = map[string]string{}
+		}
+		pvc.Annotations[SpecAnnotation] = spec
+		if opts.Owner != "" {
+			pvc.Annotations[OwnerAnnotation] = opts.Owner
+		} else {
+			delete(pvc.Annotations, OwnerAnnotation)
+		}
+		pvc, err = c.clientset.CoreV1().PersistentVolumeClaims(WorkspaceNamespace).Update(ctx, pvc, metav1.UpdateOptions{})
+		if err != nil {
+			return fmt.Errorf("failed to update PVC spec annotation: %w", err)
+		}
+	}
+
+	hostKey, err := c.ensureSecret(ctx, secretName, opts, owner)
+	if err != nil {
+		return fmt.Errorf("failed to ensure secret: %w", err)
+	}
+
+	pod, err := c.clientset.CoreV1().Pods(WorkspaceNamespace).Get(ctx, podName, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		pod = nil
+	} else if err != nil {
+		return fmt.Errorf("failed to get pod: %w", err)
+	}
+
+	switch {
+	case ws.Spec.Running && pod == nil:
+		newPod := buildPod(podName, pvcName, secretName, opts)
+		newPod.OwnerReferences = []metav1.OwnerReference{owner}
+		pod, err = c.clientset.CoreV1().Pods(WorkspaceNamespace).Create(ctx, newPod, metav1.CreateOptions{})
+		if err != nil {
+			return fmt.Errorf("failed to create pod: %w", err)
+		}
+	case !ws.Spec.Running && pod != nil && pod.DeletionTimestamp == nil:
+		err = c.clientset.CoreV1().Pods(WorkspaceNamespace).Delete(ctx, podName, metav1.DeleteOptions{})
+		if err != nil && !errors.IsNotFound(err) {
+			return fmt.Errorf("failed to delete pod: %w", err)
+		}
+	}
+
+	return c.updateStatus(ctx, ws, pvc, pod, hostKey)
+}
+
+// ensureSecret creates the workspace SSH secret or refreshes its
+// authorized_keys when the spec or the proxy's published keys changed. The
+// secret also carries the workspace's sshd host key, generated once when the
+// secret is created. It returns the host public key.
+func (c *Client) ensureSecret(ctx context.Context, name string, opts WorkspaceOptions, owner metav1.OwnerReference) (string, error) {
+	proxyKeys, err := c.GetProxyKeys(ctx)
+	if err != nil {
+		return "", fmt.Errorf("failed to get proxy keys: %w", err)
+	}
+	opts.SSHPubKey = joinAuthorizedKeys(opts.SSHPubKey, proxyKeys)
+
+	desired := buildSSHSecret(name, opts)
+	desired.OwnerReferences = []metav1.OwnerReference{owner}
+
+	secrets := c.clientset.CoreV1().Secrets(WorkspaceNamespace)
+	existing, err := secrets.Get(ctx, name, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		privateKey, publicKey, err := generateHostKey(opts.Name)
+		if err != nil {
+			return "", err
+		}
+		desired.StringData[HostKeySecretKey] = privateKey
+		desired.StringData[HostPublicKeySecretKey] = publicKey
+
+		_, err = secrets.Create(ctx, desired, metav1.CreateOptions{})
+		return publicKey, err
+	}
+	if err != nil {
+		return "", err
+	}
+
+	update := map[string]string{}
+	if string(existing.Data["authorized_keys"]) != desired.StringData["authorized_keys"] {
+		update["authorized_keys"] = desired.StringData["authorized_keys"]
+	}
+
+	// Secrets created before host keys were managed get one added; the
+	// workspace serves it from its next start
+	publicKey := string(existing.Data[HostPublicKeySecretKey])
+	if len(existing.Data[HostKeySecretKey]) == 0 {
+		var privateKey string
+		privateKey, publicKey, err = generateHostKey(opts.Name)
+		if err != nil {
+			return "", err
+		}
+		update[HostKeySecretKey] = privateKey
+		update[HostPublicKeySecretKey] = publicKey
+	}
+
+	if len(update) == 0 {
+		return publicKey, nil
+	}
+
+	existing.StringData = update
+	_, err = secrets.Update(ctx, existing, metav1.UpdateOptions{})
+	return publicKey, err
+}
+
+// updateStatus derives the workspace status from its PVC and pod, and
+// publishes the host key clients pin when connecting
+func (c *Client) updateStatus(ctx context.Context, ws *WorkspaceResource, pvc *corev1.PersistentVolumeClaim, pod *corev1.Pod, hostKey string) error {
+	previous := ws.Status
+	previous.Conditions = append([]metav1.Condition(nil), ws.Status.Conditions...)
+
+	status := &ws.Status
+	status.ObservedGeneration = ws.Generation
+	status.PodName = ""
+	status.PodIP = ""
+	status.HostKey = hostKey
+
+	storageReady := metav1.Condition{
+		Type:               ConditionStorageReady,
+		Status:             metav1.ConditionFalse,
+		Reason:             string(pvc.Status.Phase),
+		ObservedGeneration: ws.Generation,
+	}
+	if pvc.Status.Phase == corev1.ClaimBound {
+		storageReady.Status = metav1.ConditionTrue
+	}
+	if storageReady.Reason == "" {
+		storageReady.Reason = "Unknown"
+	}
+	meta.SetStatusCondition(&status.Conditions, storageReady)
+
+	podReady := metav1.Condition{
+		Type:               ConditionPodReady,
+		Status:             metav1.ConditionFalse,
+		Reason:             "NoPod",
+		ObservedGeneration: ws.Generation,
+	}
+
+	switch {
+	case pod == nil && ws.Spec.Running:
+		status.Phase = PhaseStarting
+	case pod == nil:
+		status.Phase = PhaseStopped
+	case !ws.Spec.Running || pod.DeletionTimestamp != nil:
+		status.Phase = PhaseStopping
+		podReady.Reason = "Terminating"
+	default:
+		status.PodName = pod.Name
+		status.PodIP = pod.Status.PodIP
+		podReady.Reason = string(pod.Status.Phase)
+
+		switch pod.Status.Phase {
+		case corev1.PodRunning:
+			status.Phase = PhaseRunning
+		case corev1.PodFailed, corev1.PodSucceeded:
+			status.Phase = PhaseFailed
+		default:
+			status.Phase = PhaseStarting
+		}
+
+		for _, cond := range pod.Status.Conditions {
+			if cond.Type == corev1.PodReady && cond.Status == corev1.ConditionTrue {
+				podReady.Status = metav1.ConditionTrue
+				podReady.Reason = "Ready"
+			}
+		}
+	}
+	meta.SetStatusCondition(&status.Conditions, podReady)
+
+	if equality.Semantic.DeepEqual(previous, *status) {
+		return nil
+	}
+	return c.UpdateWorkspaceStatus(ctx, ws)
+}
+
+// workspaceOwnerReference makes objects owned by (and garbage collected
+// with) the given workspace
+func workspaceOwnerReference(ws *WorkspaceResource) metav1.OwnerReference {
+	return metav1.OwnerReference{
+		APIVersion:         WorkspaceGroup + "/" + WorkspaceVersion,
+		Kind:               WorkspaceKind,
+		Name:               ws.Name,
+		UID:                ws.UID,
+		Controller:         boolPtr(true),
+		BlockOwnerDeletion: boolPtr(true),
+	}
+}
@@ -0,0 +1,314 @@
+package kubernetes
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/dynamic/dynamicinformer"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/tools/cache"
+)
+
+const (
+	// WorkspaceGroup is the API group of the Workspace custom resource
+	WorkspaceGroup = "justup.io"
+	// WorkspaceVersion is the served version of the Workspace custom resource
+	WorkspaceVersion = "v1alpha1"
+	// WorkspaceKind is the kind of the Workspace custom resource
+	WorkspaceKind = "Workspace"
+)
+
+// Workspace phases reported in WorkspaceStatus.Phase
+const (
+	PhaseStarting = "Starting"
+	PhaseRunning  = "Running"
+	PhaseStopping = "Stopping"
+	PhaseStopped  = "Stopped"
+	PhaseFailed   = "Failed"
+)
+
+// Condition types reported in WorkspaceStatus.Conditions
+const (
+	ConditionStorageReady = "StorageReady"
+	ConditionPodReady     = "PodReady"
+)
+
+// WorkspaceGVR identifies the Workspace custom resource
+var WorkspaceGVR = schema.GroupVersionResource{
+	Group:    WorkspaceGroup,
+	Version:  WorkspaceVersion,
+	Resource: "workspaces",
+}
+
+// WorkspaceResource is the Workspace custom resource. The spec holds the
+// desired state of a workspace; the controller reconciles the pod, PVC and
+// secret from it and reports back through the status.
+type WorkspaceResource struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   WorkspaceSpec   `json:"spec"`
+	Status WorkspaceStatus `json:"status,omitempty"`
+}
+
+// WorkspaceSpec is the desired state of a workspace
+type WorkspaceSpec struct {
+	GitURL         string               `json:"gitURL"`
+	Branch         string               `json:"branch,omitempty"`
+	Image          string               `json:"image"`
+	Resources      WorkspaceResources   `json:"resources,omitempty"`
+	EnableDinD     bool                 `json:"dind,omitempty"`
+	Env            map[string]string    `json:"env,omitempty"`
+	IdleTimeout    string               `json:"idleTimeout,omitempty"` // empty uses the proxy default, "0" disables
+	Schedule       *WorkspaceSchedule   `json:"schedule,omitempty"`
+	Forwarding     *WorkspaceForwarding `json:"forwarding,omitempty"`
+	Running        bool                 `json:"running"`
+	AuthorizedKeys string               `json:"authorizedKeys,omitempty"`
+}
+
+// WorkspaceSchedule starts and stops a workspace at fixed times. Start and
+// Stop are five-field cron expressions evaluated in Timezone (an IANA name,
+// UTC if empty).
+type WorkspaceSchedule struct {
+	Start    string `json:"start,omitempty"`
+	Stop     string `json:"stop,omitempty"`
+	Timezone string `json:"timezone,omitempty"`
+}
+
+// WorkspaceForwarding restricts the ports clients may forward through the
+// SSH proxy. Entries are ports or ranges such as "8000-8099"; a nil list
+// allows every port and an empty one none, so empty lists are kept in JSON.
+type WorkspaceForwarding struct {
+	// Local lists the workspace ports clients may reach with ssh -L
+	Local []string `json:"local"`
+	// Remote lists the ports clients may listen on in the workspace with
+	// ssh -R
+	Remote []string `json:"remote"`
+}
+
+// WorkspaceResources holds the compute and storage sizing of a workspace
+type WorkspaceResources struct {
+	CPU     string `json:"cpu,omitempty"`
+	Memory  string `json:"memory,omitempty"`
+	Storage string `json:"storage,omitempty"`
+}
+
+// WorkspaceStatus is the observed state of a workspace
+type WorkspaceStatus struct {
+	Phase              string             `json:"phase,omitempty"`
+	PodName            string             `json:"podName,omitempty"`
+	PodIP              string             `json:"podIP,omitempty"`
+	HostKey            string             `json:"hostKey,omitempty"`
+	ObservedGeneration int64              `json:"observedGeneration,omitempty"`
+	Conditions         []metav1.Condition `json:"conditions,omitempty"`
+}
+
+// optionsToSpec converts CLI-facing workspace options to a resource spec
+func optionsToSpec(opts WorkspaceOptions) WorkspaceSpec {
+	return WorkspaceSpec{
+		GitURL: opts.GitURL,
+		Branch: opts.Branch,
+		Image:  opts.Image,
+		Resources: WorkspaceResources{
+			CPU:     opts.CPU,
+			Memory:  opts.Memory,
+			Storage: opts.Storage,
+		},
+		EnableDinD:     opts.EnableDinD,
+		Env:            opts.Env,
+		IdleTimeout:    opts.IdleTimeout,
+		Schedule:       opts.Schedule,
+		Running:        true,
+		AuthorizedKeys: opts.SSHPubKey,
+	}
+}
+
+// specToOptions converts a resource spec back to the options used to build
+// the workspace's Kubernetes objects
+func specToOptions(name string, spec WorkspaceSpec) WorkspaceOptions {
+	return WorkspaceOptions{
+		Name:        name,
+		GitURL:      spec.GitURL,
+		Branch:      spec.Branch,
+		Image:       spec.Image,
+		CPU:         spec.Resources.CPU,
+		Memory:      spec.Resources.Memory,
+		Storage:     spec.Resources.Storage,
+		EnableDinD:  spec.EnableDinD,
+		Env:         spec.Env,
+		IdleTimeout: spec.IdleTimeout,
+		Schedule:    spec.Schedule,
+		SSHPubKey:   spec.AuthorizedKeys,
+	}
+}
+
+// toUnstructured converts a WorkspaceResource for use with the dynamic client
+func (w *WorkspaceResource) toUnstructured() (*unstructured.Unstructured, error) {
+	w.APIVersion = WorkspaceGroup + "/" + WorkspaceVersion
+	w.Kind = WorkspaceKind
+
+	obj, err := runtime.DefaultUnstructuredConverter.ToUnstructured(w)
+	if err != nil {
+		return nil, err
+	}
+	return &unstructured.Unstructured{Object: obj}, nil
+}
+
+// workspaceFromUnstructured converts a dynamic client object to a WorkspaceResource
+func workspaceFromUnstructured(u *unstructured.Unstructured) (*WorkspaceResource, error) {
+	var ws WorkspaceResource
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(u.Object, &ws); err != nil {
+		return nil, fmt.Errorf("failed to decode workspace %q: %w", u.GetName(), err)
+	}
+	return &ws, nil
+}
+
+// wrapCRDError turns a missing-CRD error into an actionable message
+func wrapCRDError(err error) error {
+	if meta.IsNoMatchError(err) || (errors.IsNotFound(err) && isResourceMissing(err)) {
+		return fmt.Errorf("the Workspace CRD is not installed (apply deploy/crd.yaml): %w", err)
+	}
+	return err
+}
+
+// isResourceMissing reports whether a NotFound error refers to the resource
+// type itself rather than a single object
+func isResourceMissing(err error) bool {
+	status, ok := err.(errors.APIStatus)
+	if !ok || status.Status().Details == nil {
+		return true
+	}
+	return status.Status().Details.Name == ""
+}
+
+// CreateWorkspaceResource creates a Workspace custom resource
+func (c *Client) CreateWorkspaceResource(ctx context.Context, ws *WorkspaceResource) (*WorkspaceResource, error) {
+	obj, err := ws.toUnstructured()
+	if err != nil {
+		return nil, err
+	}
+
+	created, err := c.dynamic.Resource(WorkspaceGVR).Namespace(WorkspaceNamespace).Create(ctx, obj, metav1.CreateOptions{})
+	if err != nil {
+		return nil, wrapCRDError(err)
+	}
+	return workspaceFromUnstructured(created)
+}
+
+// GetWorkspaceResource retrieves a Workspace custom resource by name
+func (c *Client) GetWorkspaceResource(ctx context.Context, name string) (*WorkspaceResource, error) {
+	obj, err := c.dynamic.Resource(WorkspaceGVR).Namespace(WorkspaceNamespace).Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return nil, wrapCRDError(err)
+	}
+	return workspaceFromUnstructured(obj)
+}
+
+// ListWorkspaceResources lists all Workspace custom resources
+func (c *Client) ListWorkspaceResources(ctx context.Context) ([]WorkspaceResource, error) {
+	list, err := c.dynamic.Resource(WorkspaceGVR).Namespace(WorkspaceNamespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, wrapCRDError(err)
+	}
+
+	workspaces := make([]WorkspaceResource, 0, len(list.Items))
+	for i := range list.Items {
+		ws, err := workspaceFromUnstructured(&list.Items[i])
+		if err != nil {
+			return nil, err
+		}
+		workspaces = append(workspaces, *ws)
+	}
+	return workspaces, nil
+}
+
+// PatchWorkspaceSpec applies a JSON merge patch to a workspace's spec
+func (c *Client) PatchWorkspaceSpec(ctx context.Context, name string, spec map[string]interface{}) error {
+	patch, err := json.Marshal(map[string]interface{}{"spec": spec})
+	if err != nil {
+		return err
+	}
+
+	_, err = c.dynamic.Resource(WorkspaceGVR).Namespace(WorkspaceNamespace).Patch(ctx, name, types.MergePatchType, patch, metav1.PatchOptions{})
+	return wrapCRDError(err)
+}
+
+// SetWorkspaceSchedule sets or, with a nil schedule, clears a workspace's
+// start/stop schedule
+func (c *Client) SetWorkspaceSchedule(ctx context.Context, name string, schedule *WorkspaceSchedule) error {
+	return c.PatchWorkspaceSpec(ctx, name, map[string]interface{}{"schedule": schedule})
+}
+
+// SetWorkspaceForwarding sets or, with nil, clears a workspace's port
+// forwarding allowlists
+func (c *Client) SetWorkspaceForwarding(ctx context.Context, name string, forwarding *WorkspaceForwarding) error {
+	return c.PatchWorkspaceSpec(ctx, name, map[string]interface{}{"forwarding": forwarding})
+}
+
+// AnnotateWorkspace merges annotations into a workspace's metadata
+func (c *Client) AnnotateWorkspace(ctx context.Context, name string, annotations map[string]string) error {
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{"annotations": annotations},
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = c.dynamic.Resource(WorkspaceGVR).Namespace(WorkspaceNamespace).Patch(ctx, name, types.MergePatchType, patch, metav1.PatchOptions{})
+	return wrapCRDError(err)
+}
+
+// RecordWorkspaceActivity records the time of a workspace's last SSH
+// activity, which the idle reaper compares against its idle timeout
+func (c *Client) RecordWorkspaceActivity(ctx context.Context, name string, at time.Time) error {
+	return c.AnnotateWorkspace(ctx, name, map[string]string{
+		LastActivityAnnotation: at.UTC().Format(time.RFC3339),
+	})
+}
+
+// UpdateWorkspaceStatus writes the status subresource of a workspace
+func (c *Client) UpdateWorkspaceStatus(ctx context.Context, ws *WorkspaceResource) error {
+	obj, err := ws.toUnstructured()
+	if err != nil {
+		return err
+	}
+
+	_, err = c.dynamic.Resource(WorkspaceGVR).Namespace(WorkspaceNamespace).UpdateStatus(ctx, obj, metav1.UpdateOptions{})
+	return err
+}
+
+// DeleteWorkspaceResource deletes a Workspace custom resource. Objects owned
+// by the workspace are garbage collected by Kubernetes.
+func (c *Client) DeleteWorkspaceResource(ctx context.Context, name string) error {
+	policy := metav1.DeletePropagationBackground
+	err := c.dynamic.Resource(WorkspaceGVR).Namespace(WorkspaceNamespace).Delete(ctx, name, metav1.DeleteOptions{
+		PropagationPolicy: &policy,
+	})
+	return wrapCRDError(err)
+}
+
+// NewWorkspaceInformers returns informers for Workspace resources and
+// workspace pods, used by the controller to trigger reconciliation
+func (c *Client) NewWorkspaceInformers(resync time.Duration) (workspaces, pods cache.SharedIndexInformer) {
+	dynamicFactory := dynamicinformer.NewFilteredDynamicSharedInformerFactory(c.dynamic, resync, WorkspaceNamespace, nil)
+	workspaces = dynamicFactory.ForResource(WorkspaceGVR).Informer()
+
+	podFactory := informers.NewSharedInformerFactoryWithOptions(c.clientset, resync,
+		informers.WithNamespace(WorkspaceNamespace),
+		informers.WithTweakListOptions(func(opts *metav1.ListOptions) {
+			opts.LabelSelector = WorkspaceLabel
+		}),
+	)
+	pods = podFactory.Core().V1().Pods().Informer()
+
+	return workspaces, pods
+}
@@ -2,35 +2,188 @@ package kubernetes
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
+	"sort"
+	"strconv"
+	"strings"
 	"time"
 
+	"github.com/rahulvramesh/justup/pkg/database"
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
+// Defaults applied when a workspace option is not set
+const (
+	DefaultImage   = "ghcr.io/rahulvramesh/justup/devcontainer:latest"
+	DefaultBranch  = "main"
+	DefaultCPU     = "1"
+	DefaultMemory  = "2Gi"
+	DefaultStorage = "10Gi"
+)
+
 // WorkspaceOptions defines options for creating a workspace
 type WorkspaceOptions struct {
-	Name       string
-	GitURL     string
-	Branch     string
+	Name        string
+	GitURL      string
+	Branch      string
+	Image       string
+	CPU         string
+	Memory      string
+	Storage     string
+	EnableDinD  bool
+	Env         map[string]string  // Optional: extra environment variables
+	IdleTimeout string             // Optional: idle shutdown timeout, e.g. "2h"
+	Schedule    *WorkspaceSchedule // Optional: start/stop schedule
+	SSHPubKey   string             // Optional: SSH public key to inject
+	Owner       string             // Optional: username of the owner
+}
+
+// StartOptions overrides parts of the persisted spec when starting a
+// workspace. Empty fields keep the persisted value.
+type StartOptions struct {
 	Image      string
 	CPU        string
 	Memory     string
-	Storage    string
-	EnableDinD bool
-	SSHPubKey  string // Optional: SSH public key to inject
+	EnableDinD *bool
+}
+
+// IsEmpty reports whether no overrides are set
+func (o StartOptions) IsEmpty() bool {
+	return o.Image == "" && o.CPU == "" && o.Memory == "" && o.EnableDinD == nil
+}
+
+// validate checks that resource quantities parse, since the pod and PVC
+// builders would otherwise panic in the controller
+func (o WorkspaceOptions) validate() error {
+	if !IsValidWorkspaceName(o.Name) {
+		return workspaceErrorf(ErrInvalidWorkspace, "invalid workspace name '%s': use lowercase letters, digits and dashes", o.Name)
+	}
+	if o.Name == ReservedWorkspaceName {
+		return workspaceErrorf(ErrInvalidWorkspace, "workspace name '%s' is reserved", o.Name)
+	}
+
+	// git would read a leading dash as an option
+	if o.GitURL == "" || strings.HasPrefix(o.GitURL, "-") {
+		return workspaceErrorf(ErrInvalidWorkspace, "invalid git URL %q", o.GitURL)
+	}
+	if strings.HasPrefix(o.Branch, "-") {
+		return workspaceErrorf(ErrInvalidWorkspace, "invalid branch %q", o.Branch)
+	}
+
+	quantities := map[string]string{"cpu": o.CPU, "memory": o.Memory, "storage": o.Storage}
+	for name, value := range quantities {
+		if _, err := resource.ParseQuantity(value); err != nil {
+			return workspaceErrorf(ErrInvalidWorkspace, "invalid %s %q: %w", name, value, err)
+		}
+	}
+	if o.IdleTimeout != "" {
+		if _, err := ParseIdleTimeout(o.IdleTimeout); err != nil {
+			return &workspaceError{kind: ErrInvalidWorkspace, err: err}
+		}
+	}
+	return nil
+}
+
+// ParseIdleTimeout parses a workspace idle timeout such as "2h"; zero
+// disables idle shutdown
+func ParseIdleTimeout(value string) (time.Duration, error) {
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid idle timeout %q: %w", value, err)
+	}
+	if d < 0 {
+		return 0, fmt.Errorf("invalid idle timeout %q: must not be negative", value)
+	}
+	return d, nil
+}
+
+// PortRange is an inclusive range of TCP ports
+type PortRange struct {
+	First, Last int
+}
+
+// Contains reports whether port is in the range
+func (r PortRange) Contains(port int) bool {
+	return port >= r.First && port <= r.Last
+}
+
+// ParsePortRanges parses forwarding allowlist entries such as "3000" or
+// "8000-8099"
+func ParsePortRanges(entries []string) ([]PortRange, error) {
+	ranges := make([]PortRange, 0, len(entries))
+	for _, entry := range entries {
+		first, last, isRange := strings.Cut(strings.TrimSpace(entry), "-")
+		if !isRange {
+			last = first
+		}
+		r := PortRange{}
+		var err error
+		if r.First, err = strconv.Atoi(first); err == nil {
+			r.Last, err = strconv.Atoi(last)
+		}
+		if err != nil || r.First < 1 || r.Last > 65535 || r.First > r.Last {
+			return nil, fmt.Errorf("invalid port or range %q", entry)
+		}
+		ranges = append(ranges, r)
+	}
+	return ranges, nil
 }
 
+// ReservedWorkspaceName is the SSH proxy's management user, so no workspace
+// may be named after it
+const ReservedWorkspaceName = "justup"
+
+// IsValidWorkspaceName checks that a name is lowercase alphanumeric with
+// dashes, as the names of the Kubernetes objects derived from it require
+func IsValidWorkspaceName(name string) bool {
+	if len(name) == 0 || len(name) > 63 {
+		return false
+	}
+	for i, c := range name {
+		if c >= 'a' && c <= 'z' {
+			continue
+		}
+		if c >= '0' && c <= '9' {
+			continue
+		}
+		if c == '-' && i > 0 && i < len(name)-1 {
+			continue
+		}
+		return false
+	}
+	return true
+}
+
+// StatusOrphaned is reported by ListWorkspaces for a workspace pod that is
+// not managed by a Workspace resource
+const StatusOrphaned = "Orphaned"
+
+// StatusDeleted is reported for a workspace recorded in a database whose
+// resource, pod and volume are all gone, so it can no longer be started
+const StatusDeleted = "Deleted"
+
 // Workspace represents a workspace status
 type Workspace struct {
-	Name   string
-	Status string
-	Age    string
-	GitURL string
-	PodIP  string
+	Name    string
+	Status  string
+	Age     string
+	GitURL  string
+	Branch  string
+	CPU     string
+	Memory  string
+	Storage string
+	PodIP   string
+	HostKey string
+	// Owner is the username recorded as the workspace's owner, if any
+	Owner string
+	// Schedule starts and stops the workspace at fixed times
+	Schedule *WorkspaceSchedule
+	// Forwarding restricts port forwarding through the SSH proxy
+	Forwarding *WorkspaceForwarding
 }
 
 // DeleteOptions defines options for deleting a workspace
@@ -39,85 +192,156 @@ type DeleteOptions struct {
 	KeepPVC bool
 }
 
-// CreateWorkspace creates a new workspace in Kubernetes
+// CreateWorkspace creates a new workspace by creating its Workspace resource.
+// The controller provisions the PVC, secret and pod from the resource.
 func (c *Client) CreateWorkspace(ctx context.Context, opts WorkspaceOptions) (*Workspace, error) {
 	// Ensure namespace exists
 	if err := c.EnsureNamespace(ctx); err != nil {
 		return nil, fmt.Errorf("failed to ensure namespace: %w", err)
 	}
 
-	podName := "ws-" + opts.Name
-	pvcName := podName + "-pvc"
-	secretName := podName + "-ssh"
+	if err := opts.validate(); err != nil {
+		return nil, err
+	}
 
 	// Check if workspace already exists
-	_, err := c.clientset.CoreV1().Pods(WorkspaceNamespace).Get(ctx, podName, metav1.GetOptions{})
+	_, err := c.GetWorkspaceResource(ctx, opts.Name)
 	if err == nil {
-		return nil, fmt.Errorf("workspace '%s' already exists", opts.Name)
+		return nil, workspaceErrorf(ErrWorkspaceExists, "workspace '%s' already exists", opts.Name)
 	}
-
-	// Create PVC for workspace storage
-	pvc := buildPVC(pvcName, opts)
-	_, err = c.clientset.CoreV1().PersistentVolumeClaims(WorkspaceNamespace).Create(ctx, pvc, metav1.CreateOptions{})
-	if err != nil && !errors.IsAlreadyExists(err) {
-		return nil, fmt.Errorf("failed to create PVC: %w", err)
+	if !errors.IsNotFound(err) {
+		return nil, err
 	}
 
-	// Create SSH keys secret (placeholder for now)
-	secret := buildSSHSecret(secretName, opts)
-	_, err = c.clientset.CoreV1().Secrets(WorkspaceNamespace).Create(ctx, secret, metav1.CreateOptions{})
-	if err != nil && !errors.IsAlreadyExists(err) {
-		return nil, fmt.Errorf("failed to create secret: %w", err)
+	ws := &WorkspaceResource{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      opts.Name,
+			Namespace: WorkspaceNamespace,
+			Labels: map[string]string{
+				WorkspaceLabel: opts.Name,
+			},
+		},
+		Spec: optionsToSpec(opts),
+	}
+	if opts.Owner != "" {
+		ws.Annotations = map[string]string{OwnerAnnotation: opts.Owner}
 	}
 
-	// Create the pod
-	pod := buildPod(podName, pvcName, secretName, opts)
-	createdPod, err := c.clientset.CoreV1().Pods(WorkspaceNamespace).Create(ctx, pod, metav1.CreateOptions{})
+	created, err := c.CreateWorkspaceResource(ctx, ws)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create pod: %w", err)
+		return nil, fmt.Errorf("failed to create workspace resource: %w", err)
 	}
 
-	return &Workspace{
-		Name:   opts.Name,
-		Status: string(createdPod.Status.Phase),
-		GitURL: opts.GitURL,
-	}, nil
+	return resourceToWorkspace(created, opts.Storage), nil
 }
 
 // GetWorkspace retrieves a workspace by name
 func (c *Client) GetWorkspace(ctx context.Context, name string) (*Workspace, error) {
-	podName := "ws-" + name
-
-	pod, err := c.clientset.CoreV1().Pods(WorkspaceNamespace).Get(ctx, podName, metav1.GetOptions{})
+	ws, err := c.GetWorkspaceResource(ctx, name)
 	if err != nil {
 		if errors.IsNotFound(err) {
-			return nil, fmt.Errorf("workspace '%s' not found", name)
+			return nil, workspaceErrorf(ErrWorkspaceNotFound, "workspace '%s' not found", name)
 		}
 		return nil, err
 	}
 
-	return podToWorkspace(pod), nil
+	return resourceToWorkspace(ws, ""), nil
 }
 
-// ListWorkspaces lists all workspaces
+// ListWorkspaces lists all workspaces. Listing is driven by the workspace
+// PVCs, which outlive pods, joined with Workspace resources and pods:
+//   - a Workspace resource reports its own phase
+//   - a PVC without a resource or pod is Stopped (restorable via start)
+//   - a pod without a resource is Orphaned
+//
+// Unless includeAll is set, only starting, running and stopping workspaces
+// are returned.
 func (c *Client) ListWorkspaces(ctx context.Context, includeAll bool) ([]Workspace, error) {
-	labelSelector := fmt.Sprintf("%s", WorkspaceLabel)
+	resources, err := c.ListWorkspaceResources(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	listOpts := metav1.ListOptions{LabelSelector: WorkspaceLabel}
 
-	pods, err := c.clientset.CoreV1().Pods(WorkspaceNamespace).List(ctx, metav1.ListOptions{
-		LabelSelector: labelSelector,
-	})
+	pvcs, err := c.clientset.CoreV1().PersistentVolumeClaims(WorkspaceNamespace).List(ctx, listOpts)
 	if err != nil {
-		if errors.IsNotFound(err) {
-			return []Workspace{}, nil
+		return nil, fmt.Errorf("failed to list PVCs: %w", err)
+	}
+
+	pods, err := c.clientset.CoreV1().Pods(WorkspaceNamespace).List(ctx, listOpts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list pods: %w", err)
+	}
+
+	byName := make(map[string]*Workspace)
+	var names []string
+	entry := func(name string) *Workspace {
+		ws, ok := byName[name]
+		if !ok {
+			ws = &Workspace{Name: name}
+			byName[name] = ws
+			names = append(names, name)
+		}
+		return ws
+	}
+
+	for i := range pvcs.Items {
+		pvc := &pvcs.Items[i]
+		name := pvc.Labels[WorkspaceLabel]
+		if name == "" {
+			continue
+		}
+
+		ws := entry(name)
+		ws.Status = PhaseStopped
+		ws.Age = FormatAge(pvc.CreationTimestamp.Time)
+		ws.Storage = pvcStorage(pvc)
+
+		if opts, err := optionsFromPVC(name, pvc); err == nil {
+			ws.GitURL = opts.GitURL
+			ws.Branch = opts.Branch
+			ws.CPU = opts.CPU
+			ws.Memory = opts.Memory
 		}
-		return nil, err
 	}
 
-	workspaces := make([]Workspace, 0, len(pods.Items))
-	for _, pod := range pods.Items {
-		ws := podToWorkspace(&pod)
-		// Filter out completed/failed pods unless includeAll
-		if !includeAll && (ws.Status == "Succeeded" || ws.Status == "Failed") {
+	for i := range pods.Items {
+		pod := &pods.Items[i]
+		name := pod.Labels[WorkspaceLabel]
+		if name == "" {
+			continue
+		}
+
+		ws := entry(name)
+		ws.Status = StatusOrphaned
+		ws.PodIP = pod.Status.PodIP
+		if ws.Age == "" {
+			ws.Age = FormatAge(pod.CreationTimestamp.Time)
+		}
+		if ws.GitURL == "" {
+			ws.GitURL = pod.Annotations[GitURLAnnotation]
+			ws.Branch = pod.Annotations[BranchAnnotation]
+		}
+		for _, container := range pod.Spec.Containers {
+			if container.Name == "workspace" {
+				ws.CPU = container.Resources.Limits.Cpu().String()
+				ws.Memory = container.Resources.Limits.Memory().String()
+			}
+		}
+	}
+
+	for i := range resources {
+		res := &resources[i]
+		ws := entry(res.Name)
+		*ws = *resourceToWorkspace(res, ws.Storage)
+	}
+
+	sort.Strings(names)
+	workspaces := make([]Workspace, 0, len(names))
+	for _, name := range names {
+		ws := byName[name]
+		if !includeAll && !isActiveStatus(ws.Status) {
 			continue
 		}
 		workspaces = append(workspaces, *ws)
@@ -126,14 +350,52 @@ func (c *Client) ListWorkspaces(ctx context.Context, includeAll bool) ([]Workspa
 	return workspaces, nil
 }
 
+// isActiveStatus reports whether a workspace status is shown without --all
+func isActiveStatus(status string) bool {
+	return status == PhaseStarting || status == PhaseRunning || status == PhaseStopping
+}
+
+// pvcStorage returns the provisioned size of a PVC, falling back to the
+// requested size while it is unbound
+func pvcStorage(pvc *corev1.PersistentVolumeClaim) string {
+	if qty, ok := pvc.Status.Capacity[corev1.ResourceStorage]; ok {
+		return qty.String()
+	}
+	if qty, ok := pvc.Spec.Resources.Requests[corev1.ResourceStorage]; ok {
+		return qty.String()
+	}
+	return ""
+}
+
+// WorkspaceVolumeExists reports whether a workspace's PVC exists, e.g. one
+// kept by `justup delete --keep-pvc`
+func (c *Client) WorkspaceVolumeExists(ctx context.Context, name string) (bool, error) {
+	_, err := c.clientset.CoreV1().PersistentVolumeClaims(WorkspaceNamespace).Get(ctx, "ws-"+name+"-pvc", metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to get PVC: %w", err)
+	}
+	return true, nil
+}
+
 // DeleteWorkspace deletes a workspace
 func (c *Client) DeleteWorkspace(ctx context.Context, opts DeleteOptions) error {
 	podName := "ws-" + opts.Name
 	pvcName := podName + "-pvc"
 	secretName := podName + "-ssh"
 
+	// Delete the workspace resource; the pod and secret it owns are
+	// garbage collected, but are also removed here so that deletion does
+	// not depend on the controller being up
+	err := c.DeleteWorkspaceResource(ctx, opts.Name)
+	if err != nil && !errors.IsNotFound(err) {
+		return fmt.Errorf("failed to delete workspace resource: %w", err)
+	}
+
 	// Delete pod
-	err := c.clientset.CoreV1().Pods(WorkspaceNamespace).Delete(ctx, podName, metav1.DeleteOptions{})
+	err = c.clientset.CoreV1().Pods(WorkspaceNamespace).Delete(ctx, podName, metav1.DeleteOptions{})
 	if err != nil && !errors.IsNotFound(err) {
 		return fmt.Errorf("failed to delete pod: %w", err)
 	}
@@ -155,87 +417,204 @@ func (c *Client) DeleteWorkspace(ctx context.Context, opts DeleteOptions) error
 	return nil
 }
 
-// StopWorkspace stops a workspace by deleting the pod (keeping PVC)
+// StopWorkspace stops a workspace by marking it as not running. The
+// controller deletes the pod and keeps the PVC.
 func (c *Client) StopWorkspace(ctx context.Context, name string) error {
-	podName := "ws-" + name
-
-	err := c.clientset.CoreV1().Pods(WorkspaceNamespace).Delete(ctx, podName, metav1.DeleteOptions{})
+	ws, err := c.GetWorkspaceResource(ctx, name)
 	if err != nil {
 		if errors.IsNotFound(err) {
-			return fmt.Errorf("workspace '%s' not found or already stopped", name)
+			return workspaceErrorf(ErrWorkspaceNotFound, "workspace '%s' not found", name)
 		}
+		return err
+	}
+
+	if !ws.Spec.Running {
+		return workspaceErrorf(ErrWorkspaceStopped, "workspace '%s' is already stopped", name)
+	}
+
+	if err := c.PatchWorkspaceSpec(ctx, name, map[string]interface{}{"running": false}); err != nil {
 		return fmt.Errorf("failed to stop workspace: %w", err)
 	}
 
 	return nil
 }
 
-// StartWorkspace starts a stopped workspace
-func (c *Client) StartWorkspace(ctx context.Context, name string) error {
-	podName := "ws-" + name
-	pvcName := podName + "-pvc"
-	secretName := podName + "-ssh"
+// StartWorkspace starts a stopped workspace by marking it as running. The
+// controller recreates the pod from the persisted workspace spec, with any
+// overrides applied. If the Workspace resource is gone but its PVC was kept,
+// the resource is restored from the spec stored on the PVC.
+func (c *Client) StartWorkspace(ctx context.Context, name string, overrides StartOptions) error {
+	ws, err := c.GetWorkspaceResource(ctx, name)
+	if errors.IsNotFound(err) {
+		return c.restoreWorkspace(ctx, name, overrides)
+	}
+	if err != nil {
+		return err
+	}
 
-	// Check if pod already exists
-	_, err := c.clientset.CoreV1().Pods(WorkspaceNamespace).Get(ctx, podName, metav1.GetOptions{})
-	if err == nil {
-		return fmt.Errorf("workspace '%s' is already running", name)
+	if ws.Spec.Running {
+		if !overrides.IsEmpty() {
+			return workspaceErrorf(ErrWorkspaceRunning, "workspace '%s' is running; stop it before changing its configuration", name)
+		}
+		return workspaceErrorf(ErrWorkspaceRunning, "workspace '%s' is already running", name)
+	}
+
+	opts := specToOptions(name, ws.Spec)
+	applyStartOptions(&opts, overrides)
+	if err := opts.validate(); err != nil {
+		return err
+	}
+
+	patch := map[string]interface{}{
+		"running": true,
+		"image":   opts.Image,
+		"dind":    opts.EnableDinD,
+		"resources": map[string]interface{}{
+			"cpu":    opts.CPU,
+			"memory": opts.Memory,
+		},
+	}
+	if err := c.PatchWorkspaceSpec(ctx, name, patch); err != nil {
+		return fmt.Errorf("failed to start workspace: %w", err)
 	}
 
-	// Check if PVC exists (to get workspace config)
+	return nil
+}
+
+// restoreWorkspace recreates a Workspace resource from the spec persisted on
+// its PVC, e.g. after `justup delete --keep-pvc`
+func (c *Client) restoreWorkspace(ctx context.Context, name string, overrides StartOptions) error {
+	pvcName := "ws-" + name + "-pvc"
+
 	pvc, err := c.clientset.CoreV1().PersistentVolumeClaims(WorkspaceNamespace).Get(ctx, pvcName, metav1.GetOptions{})
 	if err != nil {
 		if errors.IsNotFound(err) {
-			return fmt.Errorf("workspace '%s' not found (no PVC)", name)
+			return workspaceErrorf(ErrWorkspaceNotFound, "workspace '%s' not found", name)
 		}
 		return err
 	}
 
-	// Reconstruct options from PVC labels/annotations
-	opts := WorkspaceOptions{
+	opts, err := optionsFromPVC(name, pvc)
+	if err != nil {
+		return err
+	}
+	applyStartOptions(&opts, overrides)
+
+	if _, err := c.CreateWorkspace(ctx, opts); err != nil {
+		return fmt.Errorf("failed to restore workspace: %w", err)
+	}
+	return nil
+}
+
+// optionsFromPVC reads the workspace spec persisted on a PVC. Volumes created
+// before the spec annotation existed only carry the git URL and branch, so
+// the remaining fields fall back to the defaults.
+func optionsFromPVC(name string, pvc *corev1.PersistentVolumeClaim) (WorkspaceOptions, error) {
+	if raw, ok := pvc.Annotations[SpecAnnotation]; ok {
+		var spec WorkspaceSpec
+		if err := json.Unmarshal([]byte(raw), &spec); err != nil {
+			return WorkspaceOptions{}, fmt.Errorf("failed to decode spec of workspace '%s': %w", name, err)
+		}
+		opts := specToOptions(name, spec)
+		opts.Owner = pvc.Annotations[OwnerAnnotation]
+		return opts, nil
+	}
+
+	storage := DefaultStorage
+	if qty, ok := pvc.Spec.Resources.Requests[corev1.ResourceStorage]; ok {
+		storage = qty.String()
+	}
+
+	return WorkspaceOptions{
 		Name:       name,
 		GitURL:     pvc.Annotations[GitURLAnnotation],
 		Branch:     pvc.Annotations[BranchAnnotation],
-		Image:      "justup/devcontainer:latest",
-		CPU:        "1",
-		Memory:     "2Gi",
+		Image:      DefaultImage,
+		CPU:        DefaultCPU,
+		Memory:     DefaultMemory,
+		Storage:    storage,
 		EnableDinD: pvc.Labels["justup.io/dind"] == "true",
-	}
+		Owner:      pvc.Annotations[OwnerAnnotation],
+	}, nil
+}
 
-	// Recreate the pod
-	pod := buildPod(podName, pvcName, secretName, opts)
-	_, err = c.clientset.CoreV1().Pods(WorkspaceNamespace).Create(ctx, pod, metav1.CreateOptions{})
-	if err != nil {
-		return fmt.Errorf("failed to create pod: %w", err)
+// applyStartOptions applies start-time overrides to workspace options
+func applyStartOptions(opts *WorkspaceOptions, overrides StartOptions) {
+	if overrides.Image != "" {
+		opts.Image = overrides.Image
+	}
+	if overrides.CPU != "" {
+		opts.CPU = overrides.CPU
+	}
+	if overrides.Memory != "" {
+		opts.Memory = overrides.Memory
+	}
+	if overrides.EnableDinD != nil {
+		opts.EnableDinD = *overrides.EnableDinD
 	}
-
-	return nil
 }
 
-// podToWorkspace converts a pod to a Workspace struct
-func podToWorkspace(pod *corev1.Pod) *Workspace {
-	name := pod.Labels[WorkspaceLabel]
-	if name == "" {
-		// Fallback: extract from pod name
-		if len(pod.Name) > 3 {
-			name = pod.Name[3:] // Remove "ws-" prefix
-		}
+// resourceToWorkspace converts a Workspace resource to a Workspace struct.
+// storage is the provisioned volume size, if known; otherwise the requested
+// size from the spec is reported.
+func resourceToWorkspace(ws *WorkspaceResource, storage string) *Workspace {
+	status := ws.Status.Phase
+	if status == "" {
+		// Not yet observed by the controller
+		status = PhaseStarting
 	}
 
-	age := formatAge(pod.CreationTimestamp.Time)
-	gitURL := pod.Annotations[GitURLAnnotation]
+	if storage == "" {
+		storage = ws.Spec.Resources.Storage
+	}
 
 	return &Workspace{
-		Name:   name,
-		Status: string(pod.Status.Phase),
-		Age:    age,
-		GitURL: gitURL,
-		PodIP:  pod.Status.PodIP,
+		Name:    ws.Name,
+		Status:  status,
+		Age:     FormatAge(ws.CreationTimestamp.Time),
+		GitURL:  ws.Spec.GitURL,
+		Branch:  ws.Spec.Branch,
+		CPU:     ws.Spec.Resources.CPU,
+		Memory:  ws.Spec.Resources.Memory,
+		Storage: storage,
+		PodIP:   ws.Status.PodIP,
+		HostKey: ws.Status.HostKey,
+		Owner:   ws.Annotations[OwnerAnnotation],
+
+		Schedule:   ws.Spec.Schedule,
+		Forwarding: ws.Spec.Forwarding,
 	}
 }
 
-// formatAge formats a time as a human-readable age
-func formatAge(t time.Time) string {
+// MergeWorkspaceRecords adds the recorded workspaces that are missing from
+// workspaces, sorting the result by name. Kept volumes are listed by the
+// cluster, so workspaces only known from their records are gone for good
+// and reported as deleted.
+func MergeWorkspaceRecords(workspaces []Workspace, records []database.Workspace) []Workspace {
+	seen := make(map[string]bool, len(workspaces))
+	for _, ws := range workspaces {
+		seen[ws.Name] = true
+	}
+	for _, record := range records {
+		if seen[record.Name] {
+			continue
+		}
+		workspaces = append(workspaces, Workspace{
+			Name:   record.Name,
+			Status: StatusDeleted,
+			Age:    FormatAge(record.CreatedAt),
+			GitURL: record.GitURL,
+			Branch: record.Branch,
+			CPU:    record.CPU,
+			Memory: record.Memory,
+		})
+	}
+	sort.Slice(workspaces, func(i, j int) bool { return workspaces[i].Name < workspaces[j].Name })
+	return workspaces
+}
+
+// FormatAge formats a time as a human-readable age
+func FormatAge(t time.Time) string {
 	d := time.Since(t)
 
 	if d < time.Minute {
@@ -261,15 +640,21 @@ func buildPVC(name string, opts WorkspaceOptions) *corev1.PersistentVolumeClaim
 		labels["justup.io/dind"] = "true"
 	}
 
+	annotations := map[string]string{
+		GitURLAnnotation: opts.GitURL,
+		BranchAnnotation: opts.Branch,
+		SpecAnnotation:   persistedSpec(opts),
+	}
+	if opts.Owner != "" {
+		annotations[OwnerAnnotation] = opts.Owner
+	}
+
 	return &corev1.PersistentVolumeClaim{
 		ObjectMeta: metav1.ObjectMeta{
-			Name:      name,
-			Namespace: WorkspaceNamespace,
-			Labels:    labels,
-			Annotations: map[string]string{
-				GitURLAnnotation: opts.GitURL,
-				BranchAnnotation: opts.Branch,
-			},
+			Name:        name,
+			Namespace:   WorkspaceNamespace,
+			Labels:      labels,
+			Annotations: annotations,
 		},
 		Spec: corev1.PersistentVolumeClaimSpec{
 			AccessModes: []corev1.PersistentVolumeAccessMode{
@@ -284,6 +669,20 @@ func buildPVC(name string, opts WorkspaceOptions) *corev1.PersistentVolumeClaim
 	}
 }
 
+// persistedSpec serializes the workspace spec stored on its PVC. The running
+// flag and SSH keys are left out: they are re-derived on restore.
+func persistedSpec(opts WorkspaceOptions) string {
+	spec := optionsToSpec(opts)
+	spec.Running = false
+	spec.AuthorizedKeys = ""
+
+	data, err := json.Marshal(spec)
+	if err != nil {
+		return ""
+	}
+	return string(data)
+}
+
 // buildSSHSecret creates a Secret for SSH keys
 func buildSSHSecret(name string, opts WorkspaceOptions) *corev1.Secret {
 	authorizedKeys := opts.SSHPubKey
@@ -352,6 +751,19 @@ func buildPod(podName, pvcName, secretName string, opts WorkspaceOptions) *corev
 		},
 	}
 
+	// Add user-provided environment variables in a stable order
+	envNames := make([]string, 0, len(opts.Env))
+	for name := range opts.Env {
+		envNames = append(envNames, name)
+	}
+	sort.Strings(envNames)
+	for _, name := range envNames {
+		workspaceContainer.Env = append(workspaceContainer.Env, corev1.EnvVar{
+			Name:  name,
+			Value: opts.Env[name],
+		})
+	}
+
 	// Add Docker connection if DinD is enabled
 	// Use TCP connection to the DinD sidecar (more reliable than socket sharing)
 	if opts.EnableDinD {
@@ -391,18 +803,19 @@ func buildPod(podName, pvcName, secretName string, opts WorkspaceOptions) *corev
 	// Init container to clone the repository
 	initContainers := []corev1.Container{
 		{
-			Name:  "git-clone",
-			Image: "alpine/git:latest",
+			Name:    "git-clone",
+			Image:   "alpine/git:latest",
 			Command: []string{"/bin/sh", "-c"},
-			Args: []string{
-				fmt.Sprintf(`
+			// The URL and branch come from the user, so they reach git
+			// through the environment rather than the script
+			Args: []string{`
 					if [ ! -d /workspace/.git ]; then
 						echo "Cloning repository..."
-						git clone --branch %s %s /workspace
+						git clone --branch "$GIT_BRANCH" -- "$GIT_URL" /workspace
 					else
 						echo "Repository already exists, skipping clone"
 					fi
-				`, opts.Branch, opts.GitURL),
+				`,
 			},
 			VolumeMounts: []corev1.VolumeMount{
 				{
@@ -411,6 +824,8 @@ func buildPod(podName, pvcName, secretName string, opts WorkspaceOptions) *corev
 				},
 			},
 			Env: []corev1.EnvVar{
+				{Name: "GIT_URL", Value: opts.GitURL},
+				{Name: "GIT_BRANCH", Value: opts.Branch},
 				{Name: "GIT_SSH_COMMAND", Value: "ssh -o StrictHostKeyChecking=no"},
 			},
 		},
@@ -0,0 +1,114 @@
+package kubernetes
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rahulvramesh/justup/pkg/database"
+)
+
+func validOptions() WorkspaceOptions {
+	return WorkspaceOptions{
+		Name:    "myproject",
+		GitURL:  "https://github.com/user/repo.git",
+		Branch:  DefaultBranch,
+		CPU:     DefaultCPU,
+		Memory:  DefaultMemory,
+		Storage: DefaultStorage,
+	}
+}
+
+func TestWorkspaceOptionsValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		modify  func(*WorkspaceOptions)
+		wantErr string
+	}{
+		{name: "valid", modify: func(*WorkspaceOptions) {}},
+		{name: "uppercase name", modify: func(o *WorkspaceOptions) { o.Name = "MyProject" }, wantErr: "invalid workspace name"},
+		{name: "shell in name", modify: func(o *WorkspaceOptions) { o.Name = "a;reboot" }, wantErr: "invalid workspace name"},
+		{name: "reserved name", modify: func(o *WorkspaceOptions) { o.Name = ReservedWorkspaceName }, wantErr: "reserved"},
+		{name: "no git URL", modify: func(o *WorkspaceOptions) { o.GitURL = "" }, wantErr: "invalid git URL"},
+		{name: "option as git URL", modify: func(o *WorkspaceOptions) { o.GitURL = "--upload-pack=touch /tmp/x" }, wantErr: "invalid git URL"},
+		{name: "option as branch", modify: func(o *WorkspaceOptions) { o.Branch = "--help" }, wantErr: "invalid branch"},
+		{name: "bad quantity", modify: func(o *WorkspaceOptions) { o.Memory = "lots" }, wantErr: "invalid memory"},
+		{name: "bad idle timeout", modify: func(o *WorkspaceOptions) { o.IdleTimeout = "-1h" }, wantErr: "idle timeout"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := validOptions()
+			tt.modify(&opts)
+			err := opts.validate()
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("rejected valid options: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("got error %v, want one mentioning %q", err, tt.wantErr)
+			}
+			if !errors.Is(err, ErrInvalidWorkspace) {
+				t.Errorf("error %v does not match ErrInvalidWorkspace", err)
+			}
+		})
+	}
+}
+
+func TestBuildPodCloneScript(t *testing.T) {
+	opts := validOptions()
+	opts.GitURL = "https://example.com/repo.git; touch /workspace/pwned"
+	opts.Branch = "main$(id)"
+
+	pod := buildPod("pod", "pvc", "secret", opts)
+	clone := pod.Spec.InitContainers[0]
+
+	// The script must not change with the values, which only reach git
+	// through the environment
+	for _, arg := range clone.Args {
+		if strings.Contains(arg, opts.GitURL) || strings.Contains(arg, opts.Branch) {
+			t.Errorf("clone script contains user input: %s", arg)
+		}
+	}
+
+	env := map[string]string{}
+	for _, e := range clone.Env {
+		env[e.Name] = e.Value
+	}
+	if env["GIT_URL"] != opts.GitURL || env["GIT_BRANCH"] != opts.Branch {
+		t.Errorf("clone environment is %v, want GIT_URL %q and GIT_BRANCH %q", env, opts.GitURL, opts.Branch)
+	}
+}
+
+func TestMergeWorkspaceRecords(t *testing.T) {
+	workspaces := []Workspace{
+		{Name: "web", Status: PhaseRunning},
+		{Name: "api", Status: PhaseStopped},
+	}
+	records := []database.Workspace{
+		{Name: "api", GitURL: "https://github.com/user/api.git", CreatedAt: time.Now()},
+		{Name: "docs", GitURL: "https://github.com/user/docs.git", Branch: "main", CreatedAt: time.Now()},
+	}
+
+	merged := MergeWorkspaceRecords(workspaces, records)
+
+	want := []struct{ name, status string }{
+		{"api", PhaseStopped},
+		{"docs", StatusDeleted},
+		{"web", PhaseRunning},
+	}
+	if len(merged) != len(want) {
+		t.Fatalf("got %d workspaces, want %d: %+v", len(merged), len(want), merged)
+	}
+	for i, w := range want {
+		if merged[i].Name != w.name || merged[i].Status != w.status {
+			t.Errorf("workspace %d is %s (%s), want %s (%s)", i, merged[i].Name, merged[i].Status, w.name, w.status)
+		}
+	}
+	if docs := merged[1]; docs.GitURL != records[1].GitURL || docs.Branch != "main" {
+		t.Errorf("deleted workspace is %+v, want its recorded spec", docs)
+	}
+}
@@ -0,0 +1,77 @@
+package oidc
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	gooidc "github.com/coreos/go-oidc/v3/oidc"
+	"golang.org/x/oauth2"
+)
+
+// Scopes requested during login; email and profile supply the claims users
+// are mapped by
+var Scopes = []string{"openid", "email", "profile"}
+
+// Provider is an OpenID Connect provider, described by its discovery
+// document
+type Provider struct {
+	Issuer                      string `json:"issuer"`
+	DeviceAuthorizationEndpoint string `json:"device_authorization_endpoint"`
+	TokenEndpoint               string `json:"token_endpoint"`
+	JWKSURI                     string `json:"jwks_uri"`
+
+	// provider verifies ID tokens, fetching the provider's signing keys as
+	// they rotate
+	provider *gooidc.Provider
+}
+
+// Discover fetches the provider's discovery document from
+// <issuer>/.well-known/openid-configuration. The issuer it reports must
+// match issuer exactly.
+func Discover(ctx context.Context, issuer string) (*Provider, error) {
+	// Signing keys are later fetched with the same client
+	ctx = gooidc.ClientContext(ctx, &http.Client{Timeout: 30 * time.Second})
+	provider, err := gooidc.NewProvider(ctx, issuer)
+	if err != nil {
+		return nil, fmt.Errorf("failed to discover OIDC provider: %w", err)
+	}
+
+	p := &Provider{provider: provider}
+	if err := provider.Claims(p); err != nil {
+		return nil, fmt.Errorf("failed to read OIDC provider metadata: %w", err)
+	}
+	if p.TokenEndpoint == "" || p.JWKSURI == "" {
+		return nil, fmt.Errorf("OIDC provider %s is missing the token endpoint or JWKS URI", issuer)
+	}
+
+	return p, nil
+}
+
+// DeviceConfig returns the OAuth2 configuration for the device
+// authorization flow (RFC 8628) with the given client
+func (p *Provider) DeviceConfig(clientID string) (*oauth2.Config, error) {
+	if p.DeviceAuthorizationEndpoint == "" {
+		return nil, fmt.Errorf("OIDC provider %s does not support the device authorization flow", p.Issuer)
+	}
+
+	return &oauth2.Config{
+		ClientID: clientID,
+		Scopes:   Scopes,
+		Endpoint: oauth2.Endpoint{
+			DeviceAuthURL: p.DeviceAuthorizationEndpoint,
+			TokenURL:      p.TokenEndpoint,
+			AuthStyle:     oauth2.AuthStyleInParams,
+		},
+	}, nil
+}
+
+// IDToken returns the ID token of an OAuth2 token response
+func IDToken(token *oauth2.Token) (string, error) {
+	idToken, ok := token.Extra("id_token").(string)
+	if !ok || idToken == "" {
+		return "", fmt.Errorf("token response has no ID token")
+	}
+	return idToken, nil
+}
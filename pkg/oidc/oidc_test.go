@@ -0,0 +1,167 @@
+package oidc_test
+
+import (
+	"context"
+	"encoding/base64"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rahulvramesh/justup/pkg/oidc"
+	"github.com/rahulvramesh/justup/pkg/oidc/oidctest"
+)
+
+const clientID = "justup-cli"
+
+var alice = oidctest.Identity{
+	Subject:           "alice-subject",
+	Email:             "alice@example.com",
+	EmailVerified:     true,
+	PreferredUsername: "alice",
+	Name:              "Alice",
+}
+
+// newProvider starts a test provider and discovers it
+func newProvider(t *testing.T) (*oidctest.Provider, *oidc.Provider) {
+	t.Helper()
+
+	server := oidctest.NewProvider(clientID)
+	t.Cleanup(server.Close)
+
+	provider, err := oidc.Discover(context.Background(), server.Issuer())
+	if err != nil {
+		t.Fatalf("failed to discover provider: %v", err)
+	}
+	return server, provider
+}
+
+func TestDiscover(t *testing.T) {
+	server, provider := newProvider(t)
+
+	if provider.Issuer != server.Issuer() {
+		t.Errorf("issuer is %q, want %q", provider.Issuer, server.Issuer())
+	}
+	if provider.TokenEndpoint != server.URL+"/token" {
+		t.Errorf("token endpoint is %q, want %q", provider.TokenEndpoint, server.URL+"/token")
+	}
+
+	// The issuer reported must be the one asked for
+	if _, err := oidc.Discover(context.Background(), server.Issuer()+"/"); err == nil {
+		t.Error("discovered a provider reporting a different issuer")
+	}
+}
+
+func TestDeviceFlow(t *testing.T) {
+	server, provider := newProvider(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	config, err := provider.DeviceConfig(clientID)
+	if err != nil {
+		t.Fatalf("failed to configure device flow: %v", err)
+	}
+
+	auth, err := config.DeviceAuth(ctx)
+	if err != nil {
+		t.Fatalf("failed to start device authorization: %v", err)
+	}
+	if err := server.Approve(auth.UserCode, alice); err != nil {
+		t.Fatalf("failed to approve device code: %v", err)
+	}
+
+	token, err := config.DeviceAccessToken(ctx, auth)
+	if err != nil {
+		t.Fatalf("device authorization failed: %v", err)
+	}
+	idToken, err := oidc.IDToken(token)
+	if err != nil {
+		t.Fatalf("no ID token: %v", err)
+	}
+
+	claims, err := provider.Verify(ctx, clientID, idToken)
+	if err != nil {
+		t.Fatalf("failed to verify ID token: %v", err)
+	}
+	if claims.Subject != alice.Subject || claims.Email != alice.Email || !claims.EmailVerified {
+		t.Errorf("claims are %+v, want those of %+v", claims, alice)
+	}
+	if claims.PreferredUsername != alice.PreferredUsername || claims.Name != alice.Name {
+		t.Errorf("claims are %+v, want those of %+v", claims, alice)
+	}
+}
+
+func TestDeviceFlowDenied(t *testing.T) {
+	server, provider := newProvider(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	config, err := provider.DeviceConfig(clientID)
+	if err != nil {
+		t.Fatalf("failed to configure device flow: %v", err)
+	}
+	auth, err := config.DeviceAuth(ctx)
+	if err != nil {
+		t.Fatalf("failed to start device authorization: %v", err)
+	}
+	if err := server.Deny(auth.UserCode); err != nil {
+		t.Fatalf("failed to deny device code: %v", err)
+	}
+
+	if _, err := config.DeviceAccessToken(ctx, auth); err == nil || !strings.Contains(err.Error(), "access_denied") {
+		t.Errorf("got error %v, want access_denied", err)
+	}
+}
+
+func TestVerify(t *testing.T) {
+	server, provider := newProvider(t)
+
+	// A provider with its own key, issuing tokens in the first one's name
+	other := oidctest.NewProvider(clientID)
+	defer other.Close()
+
+	tests := []struct {
+		name    string
+		idToken string
+		wantErr string
+	}{
+		{name: "valid", idToken: server.IDToken(alice, nil)},
+		{name: "azp", idToken: server.IDToken(alice, map[string]interface{}{"aud": []string{clientID, "other"}, "azp": clientID})},
+		{name: "other issuer", idToken: server.IDToken(alice, map[string]interface{}{"iss": "https://issuer.example.com"}), wantErr: "issuer"},
+		{name: "other audience", idToken: server.IDToken(alice, map[string]interface{}{"aud": "another-client"}), wantErr: "audience"},
+		{name: "expired", idToken: server.IDToken(alice, map[string]interface{}{"exp": time.Now().Add(-time.Hour).Unix()}), wantErr: "expired"},
+		{name: "other key", idToken: other.IDToken(alice, map[string]interface{}{"iss": server.Issuer()}), wantErr: "signature"},
+		{name: "tampered", idToken: tamper(server.IDToken(alice, nil)), wantErr: "signature"},
+		{name: "malformed", idToken: "not-a-token", wantErr: "malformed"},
+		{name: "other azp", idToken: server.IDToken(alice, map[string]interface{}{"azp": "another-client"}), wantErr: "authorized for client"},
+		{name: "several audiences", idToken: server.IDToken(alice, map[string]interface{}{"aud": []string{clientID, "other"}}), wantErr: "no authorized party"},
+		{name: "nonce", idToken: server.IDToken(alice, map[string]interface{}{"nonce": "abc"}), wantErr: "nonce"},
+		{name: "no subject", idToken: server.IDToken(oidctest.Identity{Email: alice.Email}, nil), wantErr: "subject"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims, err := provider.Verify(context.Background(), clientID, tt.idToken)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("failed to verify: %v", err)
+				}
+				if claims.Subject != alice.Subject {
+					t.Errorf("subject is %q, want %q", claims.Subject, alice.Subject)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("got error %v, want one mentioning %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// tamper changes the subject of a token while keeping its signature
+func tamper(idToken string) string {
+	parts := strings.Split(idToken, ".")
+	payload, _ := base64.RawURLEncoding.DecodeString(parts[1])
+	payload = []byte(strings.Replace(string(payload), alice.Subject, "mallory-subject", 1))
+	parts[1] = base64.RawURLEncoding.EncodeToString(payload)
+	return strings.Join(parts, ".")
+}
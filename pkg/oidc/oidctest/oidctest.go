@@ -0,0 +1,306 @@
+// Package oidctest provides a stand-in OpenID Connect provider for testing
+// OIDC login against, in the manner of net/http/httptest
+package oidctest
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	keyID            = "oidctest"
+	deviceGrantType  = "urn:ietf:params:oauth:grant-type:device_code"
+	deviceCodeExpiry = 10 * time.Minute
+)
+
+// Identity is the user a device code is approved as
+type Identity struct {
+	Subject           string
+	Email             string
+	EmailVerified     bool
+	PreferredUsername string
+	Name              string
+}
+
+// Provider is a local OIDC provider serving discovery, JWKS, device
+// authorization and token endpoints. Device codes stay pending until they
+// are approved or denied, or are approved immediately after AutoApprove.
+type Provider struct {
+	*httptest.Server
+
+	// ClientID is the only client the provider issues tokens to
+	ClientID string
+	// TokenLifetime is the lifetime of issued ID tokens
+	TokenLifetime time.Duration
+
+	key *rsa.PrivateKey
+
+	mu          sync.Mutex
+	devices     map[string]*device // By device code
+	autoApprove *Identity
+}
+
+type device struct {
+	userCode string
+	expires  time.Time
+	identity *Identity
+	denied   bool
+}
+
+// NewProvider starts a provider for clientID. Callers should Close it when
+// done.
+func NewProvider(clientID string) *Provider {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		panic(fmt.Sprintf("oidctest: failed to generate key: %v", err))
+	}
+
+	p := &Provider{
+		ClientID:      clientID,
+		TokenLifetime: time.Hour,
+		key:           key,
+		devices:       make(map[string]*device),
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /.well-known/openid-configuration", p.serveDiscovery)
+	mux.HandleFunc("GET /jwks", p.serveJWKS)
+	mux.HandleFunc("POST /device", p.serveDeviceAuthorization)
+	mux.HandleFunc("POST /token", p.serveToken)
+	p.Server = httptest.NewServer(mux)
+
+	return p
+}
+
+// Issuer returns the provider's issuer URL
+func (p *Provider) Issuer() string {
+	return p.URL
+}
+
+// AutoApprove approves every pending and future device code as id
+func (p *Provider) AutoApprove(id Identity) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.autoApprove = &id
+	for _, d := range p.devices {
+		if d.identity == nil && !d.denied {
+			d.identity = &id
+		}
+	}
+}
+
+// Approve approves the device code with the given user code as id, as the
+// user would in a browser
+func (p *Provider) Approve(userCode string, id Identity) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	d, err := p.lookupUserCode(userCode)
+	if err != nil {
+		return err
+	}
+	d.identity = &id
+	return nil
+}
+
+// Deny denies the device code with the given user code
+func (p *Provider) Deny(userCode string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	d, err := p.lookupUserCode(userCode)
+	if err != nil {
+		return err
+	}
+	d.denied = true
+	return nil
+}
+
+func (p *Provider) lookupUserCode(userCode string) (*device, error) {
+	for _, d := range p.devices {
+		if d.userCode == userCode {
+			return d, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown user code %q", userCode)
+}
+
+// IDToken returns an ID token for id, signed by the provider, with the
+// given extra claims overriding the defaults
+func (p *Provider) IDToken(id Identity, extra map[string]interface{}) string {
+	now := time.Now()
+	claims := map[string]interface{}{
+		"iss":            p.Issuer(),
+		"aud":            p.ClientID,
+		"sub":            id.Subject,
+		"iat":            now.Unix(),
+		"exp":            now.Add(p.TokenLifetime).Unix(),
+		"email":          id.Email,
+		"email_verified": id.EmailVerified,
+	}
+	if id.PreferredUsername != "" {
+		claims["preferred_username"] = id.PreferredUsername
+	}
+	if id.Name != "" {
+		claims["name"] = id.Name
+	}
+	for k, v := range extra {
+		claims[k] = v
+	}
+
+	return p.sign(claims)
+}
+
+// sign encodes claims as an RS256 JWT
+func (p *Provider) sign(claims map[string]interface{}) string {
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT", "kid": keyID})
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		panic(fmt.Sprintf("oidctest: failed to encode claims: %v", err))
+	}
+
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(signed))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, p.key, crypto.SHA256, digest[:])
+	if err != nil {
+		panic(fmt.Sprintf("oidctest: failed to sign token: %v", err))
+	}
+
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func (p *Provider) serveDiscovery(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"issuer":                                p.Issuer(),
+		"device_authorization_endpoint":         p.URL + "/device",
+		"token_endpoint":                        p.URL + "/token",
+		"jwks_uri":                              p.URL + "/jwks",
+		"response_types_supported":              []string{"code"},
+		"subject_types_supported":               []string{"public"},
+		"id_token_signing_alg_values_supported": []string{"RS256"},
+		"grant_types_supported":                 []string{deviceGrantType},
+	})
+}
+
+func (p *Provider) serveJWKS(w http.ResponseWriter, r *http.Request) {
+	pub := p.key.PublicKey
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"keys": []map[string]string{{
+			"kty": "RSA",
+			"kid": keyID,
+			"use": "sig",
+			"alg": "RS256",
+			"n":   base64.RawURLEncoding.EncodeToString(pub.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes()),
+		}},
+	})
+}
+
+func (p *Provider) serveDeviceAuthorization(w http.ResponseWriter, r *http.Request) {
+	if r.PostFormValue("client_id") != p.ClientID {
+		writeOAuthError(w, http.StatusUnauthorized, "invalid_client")
+		return
+	}
+
+	deviceCode := randomString(16)
+	userCode := strings.ToUpper(randomString(4))
+
+	p.mu.Lock()
+	d := &device{userCode: userCode, expires: time.Now().Add(deviceCodeExpiry)}
+	if p.autoApprove != nil {
+		id := *p.autoApprove
+		d.identity = &id
+	}
+	p.devices[deviceCode] = d
+	p.mu.Unlock()
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"device_code":               deviceCode,
+		"user_code":                 userCode,
+		"verification_uri":          p.URL + "/activate",
+		"verification_uri_complete": p.URL + "/activate?user_code=" + userCode,
+		"expires_in":                int(deviceCodeExpiry.Seconds()),
+		"interval":                  1,
+	})
+}
+
+func (p *Provider) serveToken(w http.ResponseWriter, r *http.Request) {
+	if r.PostFormValue("client_id") != p.ClientID {
+		writeOAuthError(w, http.StatusUnauthorized, "invalid_client")
+		return
+	}
+	if r.PostFormValue("grant_type") != deviceGrantType {
+		writeOAuthError(w, http.StatusBadRequest, "unsupported_grant_type")
+		return
+	}
+
+	deviceCode := r.PostFormValue("device_code")
+
+	p.mu.Lock()
+	d, ok := p.devices[deviceCode]
+	var id *Identity
+	switch {
+	case !ok:
+		p.mu.Unlock()
+		writeOAuthError(w, http.StatusBadRequest, "invalid_grant")
+		return
+	case time.Now().After(d.expires):
+		delete(p.devices, deviceCode)
+		p.mu.Unlock()
+		writeOAuthError(w, http.StatusBadRequest, "expired_token")
+		return
+	case d.denied:
+		delete(p.devices, deviceCode)
+		p.mu.Unlock()
+		writeOAuthError(w, http.StatusBadRequest, "access_denied")
+		return
+	case d.identity == nil:
+		p.mu.Unlock()
+		writeOAuthError(w, http.StatusBadRequest, "authorization_pending")
+		return
+	default:
+		// Device codes are single use
+		id = d.identity
+		delete(p.devices, deviceCode)
+		p.mu.Unlock()
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"access_token": randomString(16),
+		"token_type":   "Bearer",
+		"expires_in":   int(p.TokenLifetime.Seconds()),
+		"id_token":     p.IDToken(*id, nil),
+	})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeOAuthError(w http.ResponseWriter, status int, code string) {
+	writeJSON(w, status, map[string]string{"error": code})
+}
+
+func randomString(n int) string {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		panic(fmt.Sprintf("oidctest: failed to read random bytes: %v", err))
+	}
+	return hex.EncodeToString(b)
+}
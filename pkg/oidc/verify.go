@@ -0,0 +1,57 @@
+package oidc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	gooidc "github.com/coreos/go-oidc/v3/oidc"
+)
+
+// Claims are the ID token claims justup uses
+type Claims struct {
+	Issuer            string `json:"iss"`
+	Subject           string `json:"sub"`
+	Email             string `json:"email"`
+	EmailVerified     bool   `json:"email_verified"`
+	PreferredUsername string `json:"preferred_username"`
+	Name              string `json:"name"`
+}
+
+// Verify checks an ID token and returns its claims. go-oidc checks its
+// signature against the provider's keys, with only the algorithms the
+// provider advertises, and its issuer, audience and expiry; crypto/rsa
+// refuses RSA keys under 1024 bits (rsa1024min in go.mod). A token for
+// several audiences must also name clientID as its authorized party. The
+// device flow sends no nonce, so a token carrying one was issued to some
+// other login and is refused.
+func (p *Provider) Verify(ctx context.Context, clientID, rawIDToken string) (*Claims, error) {
+	token, err := p.provider.Verifier(&gooidc.Config{ClientID: clientID}).Verify(ctx, rawIDToken)
+	if err != nil {
+		return nil, err
+	}
+
+	var claims Claims
+	var extra struct {
+		AuthorizedParty string `json:"azp"`
+	}
+	if err := token.Claims(&claims); err != nil {
+		return nil, fmt.Errorf("malformed ID token claims: %w", err)
+	}
+	if err := token.Claims(&extra); err != nil {
+		return nil, fmt.Errorf("malformed ID token claims: %w", err)
+	}
+
+	switch {
+	case extra.AuthorizedParty != "" && extra.AuthorizedParty != clientID:
+		return nil, fmt.Errorf("ID token authorized for client %q, expected %q", extra.AuthorizedParty, clientID)
+	case extra.AuthorizedParty == "" && len(token.Audience) > 1:
+		return nil, errors.New("ID token has several audiences but no authorized party")
+	case token.Nonce != "":
+		return nil, errors.New("ID token has a nonce, so it was not issued to the device flow")
+	case token.Subject == "":
+		return nil, errors.New("ID token has no subject")
+	}
+
+	return &claims, nil
+}
@@ -0,0 +1,206 @@
+package scheduler
+
+import (
+	"testing"
+	"time"
+	// Embed the timezone database so the DST cases run on any machine
+	_ "time/tzdata"
+)
+
+// bits returns the bit set of values
+func bits(values ...int) uint64 {
+	var set uint64
+	for _, v := range values {
+		set |= 1 << uint(v)
+	}
+	return set
+}
+
+// mustParseTime parses a "2006-01-02 15:04" time in loc
+func mustParseTime(t *testing.T, value string, loc *time.Location) time.Time {
+	t.Helper()
+
+	parsed, err := time.ParseInLocation("2006-01-02 15:04", value, loc)
+	if err != nil {
+		t.Fatalf("failed to parse time %q: %v", value, err)
+	}
+	return parsed
+}
+
+func TestParse(t *testing.T) {
+	minute := func(s *Schedule) uint64 { return s.minute }
+	hour := func(s *Schedule) uint64 { return s.hour }
+	dom := func(s *Schedule) uint64 { return s.dom }
+	month := func(s *Schedule) uint64 { return s.month }
+	dow := func(s *Schedule) uint64 { return s.dow }
+
+	tests := []struct {
+		expr  string
+		field func(*Schedule) uint64
+		want  uint64
+	}{
+		{"30 8 * * *", minute, bits(30)},
+		{"*/15 * * * *", minute, bits(0, 15, 30, 45)},
+		{"5/20 * * * *", minute, bits(5, 25, 45)},
+		{"0 8-18/2 * * *", hour, bits(8, 10, 12, 14, 16, 18)},
+		{"0 9-11 * * *", hour, bits(9, 10, 11)},
+		{"0 0 1,15,31 * *", dom, bits(1, 15, 31)},
+		{"0 0 * jan,JUL *", month, bits(1, 7)},
+		{"0 0 * jun-aug *", month, bits(6, 7, 8)},
+		{"0 0 * * mon-fri", dow, bits(1, 2, 3, 4, 5)},
+		{"0 0 * * 0", dow, bits(0)},
+		{"0 0 * * 7", dow, bits(0, 7)},
+		{"0 0 * * sat-7", dow, bits(0, 6, 7)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			s, err := Parse(tt.expr)
+			if err != nil {
+				t.Fatalf("failed to parse: %v", err)
+			}
+			if got := tt.field(s); got != tt.want {
+				t.Errorf("got bits %b, want %b", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseInvalid(t *testing.T) {
+	for _, expr := range []string{
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * * 13 *",
+		"* * * * 8",
+		"* * 5-1 * *",
+		"*/0 * * * *",
+		"*/x * * * *",
+		"* * * foo *",
+		"* * * *",
+		"* * * * * *",
+	} {
+		t.Run(expr, func(t *testing.T) {
+			if _, err := Parse(expr); err == nil {
+				t.Errorf("parsed %q, want an error", expr)
+			}
+		})
+	}
+}
+
+func TestNext(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Fatalf("failed to load timezone: %v", err)
+	}
+
+	tests := []struct {
+		name string
+		expr string
+		loc  *time.Location
+		from string
+		// want lists the following matches in order
+		want []string
+	}{
+		{
+			name: "steps within an hour",
+			expr: "*/20 * * * *",
+			loc:  time.UTC,
+			from: "2026-03-02 10:05",
+			want: []string{"2026-03-02 10:20", "2026-03-02 10:40", "2026-03-02 11:00"},
+		},
+		{
+			name: "end of year",
+			expr: "0 0 1 * *",
+			loc:  time.UTC,
+			from: "2026-12-31 23:59",
+			want: []string{"2027-01-01 00:00", "2027-02-01 00:00"},
+		},
+		{
+			name: "skips short months",
+			expr: "0 12 31 * *",
+			loc:  time.UTC,
+			from: "2026-01-31 12:00",
+			want: []string{"2026-03-31 12:00", "2026-05-31 12:00"},
+		},
+		{
+			name: "leap day",
+			expr: "0 0 29 feb *",
+			loc:  time.UTC,
+			from: "2026-01-01 00:00",
+			want: []string{"2028-02-29 00:00"},
+		},
+		{
+			// 2026-03-01 is a Sunday, so the 5th is a Thursday
+			name: "day of month or day of week",
+			expr: "0 0 5 * fri",
+			loc:  time.UTC,
+			from: "2026-03-01 00:00",
+			want: []string{"2026-03-05 00:00", "2026-03-06 00:00", "2026-03-13 00:00"},
+		},
+		{
+			name: "day of week only",
+			expr: "0 0 * * fri",
+			loc:  time.UTC,
+			from: "2026-03-01 00:00",
+			want: []string{"2026-03-06 00:00", "2026-03-13 00:00"},
+		},
+		{
+			name: "day of month only",
+			expr: "0 0 5 * *",
+			loc:  time.UTC,
+			from: "2026-03-01 00:00",
+			want: []string{"2026-03-05 00:00", "2026-04-05 00:00"},
+		},
+		{
+			// Clocks go forward on 2026-03-29 and back on 2026-10-25
+			name: "keeps local time across DST changes",
+			expr: "0 9 * * *",
+			loc:  berlin,
+			from: "2026-03-28 09:00",
+			want: []string{"2026-03-29 09:00", "2026-03-30 09:00"},
+		},
+		{
+			name: "keeps local time when clocks go back",
+			expr: "0 9 * * *",
+			loc:  berlin,
+			from: "2026-10-24 09:00",
+			want: []string{"2026-10-25 09:00", "2026-10-26 09:00"},
+		},
+		{
+			name: "skips a time that does not exist",
+			expr: "30 2 * * *",
+			loc:  berlin,
+			from: "2026-03-28 03:00",
+			want: []string{"2026-03-30 02:30"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := Parse(tt.expr)
+			if err != nil {
+				t.Fatalf("failed to parse: %v", err)
+			}
+
+			at := mustParseTime(t, tt.from, tt.loc)
+			for _, want := range tt.want {
+				at = s.Next(at)
+				if wantTime := mustParseTime(t, want, tt.loc); !at.Equal(wantTime) {
+					t.Fatalf("got %v, want %v", at, wantTime)
+				}
+				if at.Location() != tt.loc {
+					t.Errorf("got location %v, want %v", at.Location(), tt.loc)
+				}
+			}
+		})
+	}
+}
+
+func TestNextNever(t *testing.T) {
+	s, err := Parse("0 0 30 feb *")
+	if err != nil {
+		t.Fatalf("failed to parse: %v", err)
+	}
+	if next := s.Next(time.Now()); !next.IsZero() {
+		t.Errorf("got %v, want the zero time", next)
+	}
+}
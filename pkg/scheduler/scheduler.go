@@ -0,0 +1,159 @@
+package scheduler
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/rahulvramesh/justup/pkg/kubernetes"
+)
+
+// checkInterval is how often schedules are evaluated
+const checkInterval = 30 * time.Second
+
+// Action is a scheduled workspace transition
+type Action string
+
+const (
+	ActionStart Action = "start"
+	ActionStop  Action = "stop"
+)
+
+// Validate checks a workspace schedule's expressions and timezone
+func Validate(schedule *kubernetes.WorkspaceSchedule) error {
+	if schedule.Start == "" && schedule.Stop == "" {
+		return fmt.Errorf("schedule needs a start or stop time")
+	}
+	if _, err := location(schedule); err != nil {
+		return err
+	}
+	for _, expr := range []string{schedule.Start, schedule.Stop} {
+		if expr == "" {
+			continue
+		}
+		if _, err := Parse(expr); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// NextAction returns the next scheduled transition after t, or an empty
+// action if there is none
+func NextAction(schedule *kubernetes.WorkspaceSchedule, t time.Time) (Action, time.Time, error) {
+	loc, err := location(schedule)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	var action Action
+	var next time.Time
+	for _, candidate := range []struct {
+		action Action
+		expr   string
+	}{{ActionStart, schedule.Start}, {ActionStop, schedule.Stop}} {
+		if candidate.expr == "" {
+			continue
+		}
+		sched, err := Parse(candidate.expr)
+		if err != nil {
+			return "", time.Time{}, err
+		}
+		at := sched.Next(t.In(loc))
+		if !at.IsZero() && (next.IsZero() || at.Before(next)) {
+			action, next = candidate.action, at
+		}
+	}
+	return action, next, nil
+}
+
+// dueAction returns the latest transition scheduled in (from, to], or an
+// empty action if none is due
+func dueAction(schedule *kubernetes.WorkspaceSchedule, from, to time.Time) (Action, error) {
+	var due Action
+	for t := from; ; {
+		action, next, err := NextAction(schedule, t)
+		if err != nil {
+			return "", err
+		}
+		if action == "" || next.After(to) {
+			return due, nil
+		}
+		due, t = action, next
+	}
+}
+
+// location returns the schedule's timezone, UTC by default
+func location(schedule *kubernetes.WorkspaceSchedule) (*time.Location, error) {
+	if schedule.Timezone == "" {
+		return time.UTC, nil
+	}
+	loc, err := time.LoadLocation(schedule.Timezone)
+	if err != nil {
+		return nil, fmt.Errorf("invalid timezone %q: %w", schedule.Timezone, err)
+	}
+	return loc, nil
+}
+
+// Scheduler applies workspace schedules
+type Scheduler struct {
+	k8sClient *kubernetes.Client
+}
+
+// New creates a new workspace scheduler
+func New(k8sClient *kubernetes.Client) *Scheduler {
+	return &Scheduler{k8sClient: k8sClient}
+}
+
+// Run evaluates schedules until ctx is cancelled. Transitions scheduled while
+// the scheduler was not running are not replayed.
+func (s *Scheduler) Run(ctx context.Context) {
+	ticker := time.NewTicker(checkInterval)
+	defer ticker.Stop()
+
+	last := time.Now()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			s.apply(ctx, last, now)
+			last = now
+		}
+	}
+}
+
+// apply performs the transitions scheduled in (from, to]
+func (s *Scheduler) apply(ctx context.Context, from, to time.Time) {
+	resources, err := s.k8sClient.ListWorkspaceResources(ctx)
+	if err != nil {
+		log.Printf("Scheduler failed to list workspaces: %v", err)
+		return
+	}
+
+	for i := range resources {
+		ws := &resources[i]
+		if ws.Spec.Schedule == nil || ws.DeletionTimestamp != nil {
+			continue
+		}
+
+		action, err := dueAction(ws.Spec.Schedule, from, to)
+		if err != nil {
+			log.Printf("Workspace '%s' has an invalid schedule: %v", ws.Name, err)
+			continue
+		}
+
+		switch {
+		case action == ActionStart && !ws.Spec.Running:
+			log.Printf("Starting workspace '%s' on schedule", ws.Name)
+			err = s.k8sClient.StartWorkspace(ctx, ws.Name, kubernetes.StartOptions{})
+		case action == ActionStop && ws.Spec.Running:
+			log.Printf("Stopping workspace '%s' on schedule", ws.Name)
+			err = s.k8sClient.StopWorkspace(ctx, ws.Name)
+		}
+		if err != nil {
+			log.Printf("Scheduled %s of workspace '%s' failed: %v", action, ws.Name, err)
+		}
+	}
+}
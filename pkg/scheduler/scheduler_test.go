@@ -0,0 +1,49 @@
+package scheduler
+
+import (
+	"testing"
+	"time"
+
+	"github.com/rahulvramesh/justup/pkg/kubernetes"
+)
+
+func TestDueAction(t *testing.T) {
+	schedule := &kubernetes.WorkspaceSchedule{
+		Start:    "0 8 * * mon-fri",
+		Stop:     "0 18 * * mon-fri",
+		Timezone: "Europe/Berlin",
+	}
+	berlin, err := time.LoadLocation(schedule.Timezone)
+	if err != nil {
+		t.Fatalf("failed to load timezone: %v", err)
+	}
+
+	// 2026-03-02 is a Monday
+	tests := []struct {
+		name     string
+		from, to string
+		want     Action
+	}{
+		{name: "start", from: "2026-03-02 07:59", to: "2026-03-02 08:00", want: ActionStart},
+		{name: "nothing due", from: "2026-03-02 08:00", to: "2026-03-02 17:59"},
+		{name: "stop", from: "2026-03-02 17:59", to: "2026-03-02 18:01", want: ActionStop},
+		{name: "latest of both", from: "2026-03-02 07:00", to: "2026-03-02 19:00", want: ActionStop},
+		{name: "overnight", from: "2026-03-02 17:00", to: "2026-03-03 09:00", want: ActionStart},
+		{name: "weekend", from: "2026-03-06 19:00", to: "2026-03-09 07:00"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// The scheduler works in UTC; the schedule's timezone applies
+			from := mustParseTime(t, tt.from, berlin).UTC()
+			to := mustParseTime(t, tt.to, berlin).UTC()
+
+			got, err := dueAction(schedule, from, to)
+			if err != nil {
+				t.Fatalf("failed to evaluate schedule: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("got action %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,189 @@
+// Package sshca issues and describes the OpenSSH user certificates the SSH
+// proxy accepts as an alternative to registered keys
+package sshca
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// Critical options the proxy understands. Unknown critical options make the
+// proxy reject a certificate, as OpenSSH does.
+const (
+	// OptionForceCommand replaces the command of every session
+	OptionForceCommand = "force-command"
+	// OptionSourceAddress limits the client addresses, as a comma-separated
+	// list of CIDRs
+	OptionSourceAddress = "source-address"
+	// OptionWorkspaces limits the workspaces a certificate can connect to,
+	// as a comma-separated list of names. It is justup-specific, so other
+	// SSH servers refuse certificates that carry it.
+	OptionWorkspaces = "workspaces@justup"
+)
+
+// backdate is subtracted from a certificate's start time so clients and
+// servers with slightly skewed clocks accept it immediately
+const backdate = 5 * time.Minute
+
+// defaultExtensions are the permissions ssh-keygen grants by default
+var defaultExtensions = map[string]string{
+	"permit-X11-forwarding":   "",
+	"permit-agent-forwarding": "",
+	"permit-port-forwarding":  "",
+	"permit-pty":              "",
+	"permit-user-rc":          "",
+}
+
+// CertificateOptions describes a user certificate to issue
+type CertificateOptions struct {
+	// Principal is the justup username the certificate authenticates as
+	Principal string
+	// KeyID identifies the certificate in logs
+	KeyID    string
+	ValidFor time.Duration
+	// Workspaces restricts the certificate to these workspaces; empty
+	// allows every workspace the user has access to
+	Workspaces []string
+	// SourceAddress restricts client addresses (comma-separated CIDRs)
+	SourceAddress string
+}
+
+// SignUserCertificate signs a user certificate for pub with the CA
+func SignUserCertificate(ca ssh.Signer, pub ssh.PublicKey, opts CertificateOptions) (*ssh.Certificate, error) {
+	if _, ok := pub.(*ssh.Certificate); ok {
+		return nil, fmt.Errorf("cannot sign a certificate; provide a public key")
+	}
+
+	var serial [8]byte
+	if _, err := rand.Read(serial[:]); err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	cert := &ssh.Certificate{
+		Key:             pub,
+		Serial:          binary.BigEndian.Uint64(serial[:]),
+		CertType:        ssh.UserCert,
+		KeyId:           opts.KeyID,
+		ValidPrincipals: []string{opts.Principal},
+		ValidAfter:      uint64(now.Add(-backdate).Unix()),
+		ValidBefore:     uint64(now.Add(opts.ValidFor).Unix()),
+		Permissions: ssh.Permissions{
+			CriticalOptions: map[string]string{},
+			Extensions:      make(map[string]string, len(defaultExtensions)),
+		},
+	}
+	for k, v := range defaultExtensions {
+		cert.Permissions.Extensions[k] = v
+	}
+	if len(opts.Workspaces) > 0 {
+		cert.Permissions.CriticalOptions[OptionWorkspaces] = strings.Join(opts.Workspaces, ",")
+	}
+	if opts.SourceAddress != "" {
+		cert.Permissions.CriticalOptions[OptionSourceAddress] = opts.SourceAddress
+	}
+
+	if err := cert.SignCert(rand.Reader, ca); err != nil {
+		return nil, fmt.Errorf("failed to sign certificate: %w", err)
+	}
+	return cert, nil
+}
+
+// LoadSigner loads the CA's private key
+func LoadSigner(path string) (ssh.Signer, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	signer, err := ssh.ParsePrivateKey(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse CA key %s: %w", path, err)
+	}
+	return signer, nil
+}
+
+// LoadAuthorities loads trusted CA public keys from an authorized_keys style
+// file, so a new CA can be added before the old one is retired
+func LoadAuthorities(path string) ([]ssh.PublicKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var keys []ssh.PublicKey
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		key, _, _, _, err := ssh.ParseAuthorizedKey([]byte(line))
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse CA public key in %s: %w", path, err)
+		}
+		keys = append(keys, key)
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("no CA public keys in %s", path)
+	}
+	return keys, nil
+}
+
+// Workspaces returns the workspaces a certificate is restricted to, or nil
+// if it is not restricted
+func Workspaces(cert *ssh.Certificate) []string {
+	value, ok := cert.CriticalOptions[OptionWorkspaces]
+	if !ok {
+		return nil
+	}
+
+	names := []string{}
+	for _, name := range strings.Split(value, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// CheckSourceAddress checks a client address against the value of a
+// source-address option: a comma-separated list of CIDRs or addresses
+func CheckSourceAddress(addr net.Addr, sourceAddrs string) error {
+	tcpAddr, ok := addr.(*net.TCPAddr)
+	if !ok {
+		return fmt.Errorf("source-address requires a TCP client address, got %v", addr)
+	}
+
+	for _, source := range strings.Split(sourceAddrs, ",") {
+		source = strings.TrimSpace(source)
+		if strings.Contains(source, "/") {
+			_, ipNet, err := net.ParseCIDR(source)
+			if err != nil {
+				return fmt.Errorf("invalid source-address %q: %w", source, err)
+			}
+			if ipNet.Contains(tcpAddr.IP) {
+				return nil
+			}
+			continue
+		}
+		ip := net.ParseIP(source)
+		if ip == nil {
+			return fmt.Errorf("invalid source-address %q", source)
+		}
+		if ip.Equal(tcpAddr.IP) {
+			return nil
+		}
+	}
+	return fmt.Errorf("address %v is not allowed by source-address %q", tcpAddr.IP, sourceAddrs)
+}
+
+// Describe summarizes a certificate for logs
+func Describe(cert *ssh.Certificate) string {
+	return fmt.Sprintf("certificate %q serial %d", cert.KeyId, cert.Serial)
+}
@@ -0,0 +1,153 @@
+package sshproxy
+
+import (
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/rahulvramesh/justup/pkg/database"
+	"golang.org/x/crypto/ssh"
+)
+
+// auditLog records the audit events of one SSH connection. Writes are
+// synchronous so the trail survives a proxy crash; failures are logged but
+// never break the connection.
+type auditLog struct {
+	db         *database.DB
+	sessionID  string
+	remoteAddr string
+	userID     string
+	workspace  string
+
+	mu       sync.Mutex
+	channels int
+}
+
+// auditSessionID identifies a connection in the audit trail. It is derived
+// from the SSH session identifier, which is known from key exchange on, so
+// authentication attempts and the session share it.
+func auditSessionID(conn ssh.ConnMetadata) string {
+	id := hex.EncodeToString(conn.SessionID())
+	if len(id) > 16 {
+		id = id[:16]
+	}
+	return id
+}
+
+// newAuditLog starts the audit log of an authenticated connection
+func (s *Server) newAuditLog(conn *ssh.ServerConn) *auditLog {
+	return &auditLog{
+		db:         s.db,
+		sessionID:  auditSessionID(conn),
+		remoteAddr: conn.RemoteAddr().String(),
+		userID:     conn.Permissions.Extensions["user-id"],
+		workspace:  conn.User(),
+	}
+}
+
+// record adds an event to the audit trail
+func (a *auditLog) record(event, format string, args ...interface{}) {
+	err := a.db.AddAuditEvent(&database.AuditEvent{
+		Time:       time.Now(),
+		SessionID:  a.sessionID,
+		Event:      event,
+		UserID:     a.userID,
+		Workspace:  a.workspace,
+		RemoteAddr: a.remoteAddr,
+		Detail:     fmt.Sprintf(format, args...),
+	})
+	if err != nil {
+		log.Printf("Failed to record audit event %s for session %s: %v", event, a.sessionID, err)
+	}
+}
+
+// nextChannel numbers the channels of the connection
+func (a *auditLog) nextChannel() int {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.channels++
+	return a.channels
+}
+
+// auditAuth records an authentication attempt, which happens before the
+// connection's audit log exists. A failure's user is empty if the key is
+// unknown.
+func (s *Server) auditAuth(conn ssh.ConnMetadata, userID, detail string, authErr error) {
+	a := &auditLog{
+		db:         s.db,
+		sessionID:  auditSessionID(conn),
+		remoteAddr: conn.RemoteAddr().String(),
+		userID:     userID,
+		workspace:  conn.User(),
+	}
+	if authErr != nil {
+		a.record(database.AuditAuthFailed, "%s: %v", detail, authErr)
+		return
+	}
+	a.record(database.AuditAuth, "%s", detail)
+}
+
+// auditChannel describes a new channel for the audit trail
+func auditChannel(newChan ssh.NewChannel) string {
+	switch newChan.ChannelType() {
+	case "direct-tcpip", "forwarded-tcpip":
+		// Both payloads start with the connected address and port
+		var payload struct {
+			Addr     string
+			Port     uint32
+			OrigAddr string
+			OrigPort uint32
+		}
+		if err := ssh.Unmarshal(newChan.ExtraData(), &payload); err == nil {
+			return fmt.Sprintf("%s to %s", newChan.ChannelType(),
+				net.JoinHostPort(payload.Addr, strconv.Itoa(int(payload.Port))))
+		}
+	}
+	return newChan.ChannelType()
+}
+
+// auditRequest records the session requests that start or shape what runs
+// in a workspace
+func (a *auditLog) auditRequest(channel int, reqType string, payload []byte) {
+	switch reqType {
+	case "pty-req":
+		var pty ptyRequest
+		if err := ssh.Unmarshal(payload, &pty); err == nil {
+			a.record(database.AuditPTY, "channel %d: %s %dx%d", channel, pty.Term, pty.Columns, pty.Rows)
+		}
+	case "shell":
+		a.record(database.AuditShell, "channel %d", channel)
+	case "exec":
+		var exec struct{ Command string }
+		if err := ssh.Unmarshal(payload, &exec); err == nil {
+			a.record(database.AuditExec, "channel %d: %s", channel, exec.Command)
+		}
+	case "subsystem":
+		var subsystem struct{ Name string }
+		if err := ssh.Unmarshal(payload, &subsystem); err == nil {
+			a.record(database.AuditSubsystem, "channel %d: %s", channel, subsystem.Name)
+		}
+	}
+}
+
+// ptyRequest is the payload of a pty-req channel request
+type ptyRequest struct {
+	Term    string
+	Columns uint32
+	Rows    uint32
+	Width   uint32
+	Height  uint32
+	Modes   string
+}
+
+// windowChange is the payload of a window-change channel request
+type windowChange struct {
+	Columns uint32
+	Rows    uint32
+	Width   uint32
+	Height  uint32
+}
@@ -0,0 +1,163 @@
+package sshproxy
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+
+	"github.com/rahulvramesh/justup/pkg/database"
+	"github.com/rahulvramesh/justup/pkg/sshca"
+	"golang.org/x/crypto/ssh"
+)
+
+// clientIdentity is the user a client authenticated as, with a registered
+// key or a certificate
+type clientIdentity struct {
+	userID      string
+	keyID       string // Empty for certificates
+	name        string // Key name or certificate description, for logs
+	fingerprint string
+	// workspaces restricts a certificate to these workspaces; nil allows all
+	workspaces []string
+	// criticalOptions of a certificate, enforced by the proxy
+	criticalOptions map[string]string
+}
+
+// allowsWorkspace reports whether the identity may connect to a workspace
+func (id *clientIdentity) allowsWorkspace(name string) bool {
+	if id.workspaces == nil {
+		return true
+	}
+	for _, ws := range id.workspaces {
+		if ws == name {
+			return true
+		}
+	}
+	return false
+}
+
+// checkSourceAddress checks that a certificate restricted to source
+// addresses is used from one of them. The SSH library only checks
+// source-address when the public key callback completes authentication,
+// which it does not for workspaces that may need waking.
+func (id *clientIdentity) checkSourceAddress(addr net.Addr) error {
+	sourceAddrs, ok := id.criticalOptions[sshca.OptionSourceAddress]
+	if !ok {
+		return nil
+	}
+	return sshca.CheckSourceAddress(addr, sourceAddrs)
+}
+
+// loadCertChecker sets up checking of user certificates signed by the CAs
+// in path. A missing file leaves certificates disabled, so the CA can be
+// configured after deployment.
+func loadCertChecker(path string) (*ssh.CertChecker, error) {
+	if path == "" {
+		return nil, nil
+	}
+
+	authorities, err := sshca.LoadAuthorities(path)
+	if errors.Is(err, os.ErrNotExist) {
+		log.Printf("No user CA keys at %s; certificate authentication disabled", path)
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("Accepting user certificates from %d CA key(s)", len(authorities))
+
+	return &ssh.CertChecker{
+		IsUserAuthority: func(auth ssh.PublicKey) bool {
+			for _, ca := range authorities {
+				if bytes.Equal(auth.Marshal(), ca.Marshal()) {
+					return true
+				}
+			}
+			return false
+		},
+		SupportedCriticalOptions: []string{sshca.OptionForceCommand, sshca.OptionWorkspaces},
+	}, nil
+}
+
+// identify resolves the user a client key authenticates as
+func (s *Server) identify(key ssh.PublicKey) (*clientIdentity, error) {
+	if cert, ok := key.(*ssh.Certificate); ok {
+		return s.identifyCertificate(cert)
+	}
+
+	fingerprint := ssh.FingerprintSHA256(key)
+	sshKey, err := s.db.GetSSHKeyByFingerprint(fingerprint)
+	if err != nil {
+		log.Printf("Key not found: %s", fingerprint)
+		return nil, fmt.Errorf("unknown public key")
+	}
+
+	return &clientIdentity{
+		userID:      sshKey.UserID,
+		keyID:       sshKey.ID,
+		name:        sshKey.Name,
+		fingerprint: fingerprint,
+	}, nil
+}
+
+// identifyCertificate checks a user certificate against the trusted CAs and
+// maps its first principal naming a justup user to that user. The checker
+// verifies the signature, validity window and critical options.
+func (s *Server) identifyCertificate(cert *ssh.Certificate) (*clientIdentity, error) {
+	desc := sshca.Describe(cert)
+
+	if s.certChecker == nil {
+		log.Printf("Rejected %s: certificate authentication is disabled", desc)
+		return nil, fmt.Errorf("certificates not accepted")
+	}
+	if cert.CertType != ssh.UserCert {
+		return nil, fmt.Errorf("not a user certificate")
+	}
+	if !s.certChecker.IsUserAuthority(cert.SignatureKey) {
+		log.Printf("Rejected %s: signed by an unknown authority", desc)
+		return nil, fmt.Errorf("certificate signed by unrecognized authority")
+	}
+
+	for _, principal := range cert.ValidPrincipals {
+		user, err := s.db.GetUserByUsername(principal)
+		if err != nil {
+			continue
+		}
+		if err := s.certChecker.CheckCert(principal, cert); err != nil {
+			log.Printf("Rejected %s: %v", desc, err)
+			return nil, fmt.Errorf("invalid certificate")
+		}
+		return certificateIdentity(user, cert), nil
+	}
+
+	log.Printf("Rejected %s: no principal in %q is a justup user", desc, cert.ValidPrincipals)
+	return nil, fmt.Errorf("certificate principals do not match a user")
+}
+
+func certificateIdentity(user *database.User, cert *ssh.Certificate) *clientIdentity {
+	return &clientIdentity{
+		userID:          user.ID,
+		name:            sshca.Describe(cert),
+		fingerprint:     ssh.FingerprintSHA256(cert.Key),
+		workspaces:      sshca.Workspaces(cert),
+		criticalOptions: cert.CriticalOptions,
+	}
+}
+
+// forceCommandRewriter makes session channels run command instead of the
+// shell, command or subsystem the client requested
+func forceCommandRewriter(command string) requestRewriter {
+	return func(req *ssh.Request) (string, []byte, bool) {
+		switch req.Type {
+		case "shell", "exec":
+			return "exec", ssh.Marshal(struct{ Command string }{command}), true
+		case "subsystem":
+			return "", nil, false
+		default:
+			return req.Type, req.Payload, true
+		}
+	}
+}
@@ -0,0 +1,222 @@
+package sshproxy_test
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/pkg/sftp"
+	"github.com/rahulvramesh/justup/pkg/database"
+	"github.com/rahulvramesh/justup/pkg/sshca"
+	"github.com/rahulvramesh/justup/pkg/sshproxy"
+	"github.com/rahulvramesh/justup/pkg/sshproxy/sshproxytest"
+	"golang.org/x/crypto/ssh"
+)
+
+// newSigner returns a fresh ed25519 key
+func newSigner(t *testing.T) ssh.Signer {
+	t.Helper()
+
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	signer, err := ssh.NewSignerFromKey(key)
+	if err != nil {
+		t.Fatalf("failed to create key signer: %v", err)
+	}
+	return signer
+}
+
+// trustCA adds a new user CA to config, returning its key
+func trustCA(t *testing.T, config *sshproxy.Config) ssh.Signer {
+	t.Helper()
+
+	ca := newSigner(t)
+	config.UserCAKeysPath = filepath.Join(t.TempDir(), "user_ca.pub")
+	if err := os.WriteFile(config.UserCAKeysPath, ssh.MarshalAuthorizedKey(ca.PublicKey()), 0644); err != nil {
+		t.Fatalf("failed to write CA key: %v", err)
+	}
+	return ca
+}
+
+// certSigner returns a key with a certificate issued by ca
+func certSigner(t *testing.T, ca ssh.Signer, opts sshca.CertificateOptions) ssh.Signer {
+	t.Helper()
+
+	signer := newSigner(t)
+	if opts.ValidFor == 0 {
+		opts.ValidFor = time.Hour
+	}
+	cert, err := sshca.SignUserCertificate(ca, signer.PublicKey(), opts)
+	if err != nil {
+		t.Fatalf("failed to sign certificate: %v", err)
+	}
+	certSigner, err := ssh.NewCertSigner(cert, signer)
+	if err != nil {
+		t.Fatalf("failed to create certificate signer: %v", err)
+	}
+	return certSigner
+}
+
+func TestCertificateSourceAddress(t *testing.T) {
+	tests := []struct {
+		name          string
+		sourceAddress string
+		allowed       bool
+	}{
+		{name: "allowed network", sourceAddress: "192.0.2.0/24,127.0.0.0/8", allowed: true},
+		{name: "allowed address", sourceAddress: "127.0.0.1", allowed: true},
+		{name: "other network", sourceAddress: "192.0.2.0/24"},
+	}
+
+	// With waking enabled, authentication completes in a
+	// keyboard-interactive round rather than the public key callback
+	wakeTimeouts := map[string]time.Duration{"without wake": 0, "with wake": time.Minute}
+	for wakeName, wakeTimeout := range wakeTimeouts {
+		t.Run(wakeName, func(t *testing.T) {
+			for _, tt := range tests {
+				t.Run(tt.name, func(t *testing.T) {
+					config := sshproxy.Config{WakeTimeout: wakeTimeout}
+					ca := trustCA(t, &config)
+					proxy, _, _ := newProxy(t, config)
+					signer := certSigner(t, ca, sshca.CertificateOptions{
+						Principal:     "alice",
+						KeyID:         "alice-laptop",
+						SourceAddress: tt.sourceAddress,
+					})
+
+					client, err := proxy.Dial(workspaceName, signer)
+					if err == nil {
+						client.Close()
+					}
+					if tt.allowed && err != nil {
+						t.Fatalf("failed to connect: %v", err)
+					}
+					if !tt.allowed && err == nil {
+						t.Fatal("connected from outside the certificate's source addresses")
+					}
+				})
+			}
+		})
+	}
+}
+
+// forceCommandSigner returns a key with a certificate for principal issued
+// by ca, whose sessions run command
+func forceCommandSigner(t *testing.T, ca ssh.Signer, principal, command string) ssh.Signer {
+	t.Helper()
+
+	signer := newSigner(t)
+	now := time.Now()
+	cert := &ssh.Certificate{
+		Key:             signer.PublicKey(),
+		CertType:        ssh.UserCert,
+		KeyId:           principal + "-ci",
+		ValidPrincipals: []string{principal},
+		ValidAfter:      uint64(now.Add(-time.Minute).Unix()),
+		ValidBefore:     uint64(now.Add(time.Hour).Unix()),
+		Permissions: ssh.Permissions{
+			CriticalOptions: map[string]string{sshca.OptionForceCommand: command},
+		},
+	}
+	if err := cert.SignCert(rand.Reader, ca); err != nil {
+		t.Fatalf("failed to sign certificate: %v", err)
+	}
+	certSigner, err := ssh.NewCertSigner(cert, signer)
+	if err != nil {
+		t.Fatalf("failed to create certificate signer: %v", err)
+	}
+	return certSigner
+}
+
+func TestCertificateWorkspaces(t *testing.T) {
+	config := sshproxy.Config{}
+	ca := trustCA(t, &config)
+	proxy, _, _ := newProxy(t, config)
+
+	// alice also owns a second workspace
+	upstream := sshproxytest.NewUpstream(t.TempDir())
+	t.Cleanup(func() { upstream.Close() })
+	proxy.Cluster.AddWorkspace("other", upstream)
+	alice, err := proxy.DB.GetUserByUsername("alice")
+	if err != nil {
+		t.Fatalf("failed to get user: %v", err)
+	}
+	if err := proxy.DB.SaveWorkspace(&database.Workspace{ID: "other-id", Name: "other", UserID: alice.ID, CreatedAt: time.Now()}); err != nil {
+		t.Fatalf("failed to save workspace: %v", err)
+	}
+
+	tests := []struct {
+		name       string
+		workspaces []string
+		user       string
+		allowed    bool
+	}{
+		{name: "unrestricted", user: workspaceName, allowed: true},
+		{name: "listed workspace", workspaces: []string{"other", workspaceName}, user: workspaceName, allowed: true},
+		{name: "unlisted workspace", workspaces: []string{"other"}, user: workspaceName},
+		{name: "unrestricted management", user: "justup", allowed: true},
+		{name: "restricted management", workspaces: []string{workspaceName}, user: "justup"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			signer := certSigner(t, ca, sshca.CertificateOptions{
+				Principal:  "alice",
+				KeyID:      "alice-ci",
+				Workspaces: tt.workspaces,
+			})
+
+			client, err := proxy.Dial(tt.user, signer)
+			if err == nil {
+				client.Close()
+			}
+			if tt.allowed && err != nil {
+				t.Fatalf("failed to connect: %v", err)
+			}
+			if !tt.allowed && err == nil {
+				t.Fatal("connected outside the certificate's workspaces")
+			}
+		})
+	}
+}
+
+func TestCertificateForceCommand(t *testing.T) {
+	for _, backend := range backends {
+		t.Run(backend, func(t *testing.T) {
+			config := sshproxy.Config{Backend: backend}
+			ca := trustCA(t, &config)
+			proxy, _, _ := newProxy(t, config)
+			client := dial(t, proxy, forceCommandSigner(t, ca, "alice", "echo forced"))
+
+			for _, command := range []string{"echo requested", ""} {
+				session, err := client.NewSession()
+				if err != nil {
+					t.Fatalf("failed to open session: %v", err)
+				}
+				var out bytes.Buffer
+				session.Stdout = &out
+				if command == "" {
+					err = session.Shell()
+					if err == nil {
+						err = session.Wait()
+					}
+				} else {
+					err = session.Run(command)
+				}
+				session.Close()
+				if err != nil || out.String() != "forced\n" {
+					t.Errorf("%q printed %q (%v), want the forced command's output", command, out.String(), err)
+				}
+			}
+
+			if _, err := sftp.NewClient(client); err == nil {
+				t.Error("started a subsystem despite force-command")
+			}
+		})
+	}
+}
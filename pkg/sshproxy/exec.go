@@ -0,0 +1,430 @@
+package sshproxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"regexp"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rahulvramesh/justup/pkg/database"
+	"github.com/rahulvramesh/justup/pkg/kubernetes"
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	// BackendSSH proxies sessions to the SSH server in each workspace
+	BackendSSH = "ssh"
+	// BackendExec terminates SSH at the proxy and runs sessions through
+	// the pods/exec subresource, so workspace images need only a shell
+	BackendExec = "exec"
+)
+
+// loginShell starts bash as a login shell where the image has it, and sh
+// otherwise
+const loginShell = "if command -v bash >/dev/null 2>&1; then exec bash -l; fi; exec sh -l"
+
+// runAsScript runs the command in its arguments after the second as the
+// user named in the first, through su so that it gets the login
+// environment sshd would give, when the container runs as root and has
+// that user. The second argument is that command quoted for su, or empty
+// to start the user's login shell. Otherwise the command runs as it is, as
+// the container's user.
+const runAsScript = `u=$1 line=$2
+shift 2
+if [ "$(id -u)" = 0 ] && id "$u" >/dev/null 2>&1; then
+	if [ -z "$line" ]; then exec su - "$u"; fi
+	exec su - "$u" -c "$line"
+fi
+exec "$@"`
+
+// envName matches the environment variable names a session may set
+var envName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// ValidBackend reports whether backend names a backend
+func ValidBackend(backend string) bool {
+	return backend == BackendSSH || backend == BackendExec
+}
+
+// execConn serves a connection to a workspace in the proxy, running
+// sessions through the exec API and local forwards through the port
+// forwarding API
+type execConn struct {
+	ctx       context.Context
+	cluster   Cluster
+	workspace string
+	policy    *forwardPolicy
+	// rewrite, if set, changes session requests
+	rewrite requestRewriter
+	// touch is called whenever data flows
+	touch func()
+	// sent and received count the data sent to the workspace and back
+	sent, received prometheus.Counter
+	audit          *auditLog
+	// recordingDir enables asciicast recording of interactive sessions
+	recordingDir string
+	// user is the workspace user sessions run as; empty runs them as the
+	// container's user
+	user string
+}
+
+// serve serves channels until the client disconnects. Global requests are
+// refused; in particular remote forwards cannot work, since nothing in the
+// workspace can open a channel back to the client.
+func (c *execConn) serve(chans <-chan ssh.NewChannel, reqs <-chan *ssh.Request) {
+	go func() {
+		for req := range reqs {
+			if desc, _ := c.policy.globalRequest(req); desc != "" {
+				c.audit.record(database.AuditForwardRejected, "%s: not supported by the exec backend", desc)
+			}
+			if req.WantReply {
+				req.Reply(false, nil)
+			}
+		}
+	}()
+
+	var wg sync.WaitGroup
+	for newChan := range chans {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			c.channel(newChan)
+		}()
+	}
+	wg.Wait()
+}
+
+// channel serves a channel the client opens until it closes
+func (c *execConn) channel(newChan ssh.NewChannel) {
+	channel := c.audit.nextChannel()
+	desc := auditChannel(newChan)
+	reject := func(reason ssh.RejectionReason, err error) {
+		c.audit.record(database.AuditChannelRejected, "channel %d: %s: %v", channel, desc, err)
+		newChan.Reject(reason, err.Error())
+	}
+
+	if err := c.policy.clientChannel(newChan); err != nil {
+		reject(ssh.Prohibited, err)
+		return
+	}
+
+	switch newChan.ChannelType() {
+	case "session":
+		c.session(newChan, channel, desc)
+	case "direct-tcpip":
+		c.forward(newChan, channel, desc, reject)
+	default:
+		reject(ssh.UnknownChannelType, errors.New("not supported by the exec backend"))
+	}
+}
+
+// session serves a session channel, auditing and recording it like a
+// proxied session
+func (c *execConn) session(newChan ssh.NewChannel, channel int, desc string) {
+	ch, reqs, err := newChan.Accept()
+	if err != nil {
+		return
+	}
+	c.audit.record(database.AuditChannelOpen, "channel %d: %s", channel, desc)
+
+	// Account for the channel's traffic when it closes
+	var sent, received atomic.Int64
+	defer func() {
+		c.audit.record(database.AuditChannelClose, "channel %d: %d bytes sent, %d received", channel, sent.Load(), received.Load())
+	}()
+	defer ch.Close()
+
+	ctx, cancel := context.WithCancel(c.ctx)
+	defer cancel()
+
+	session := &execSession{
+		conn:    c,
+		ctx:     ctx,
+		channel: channel,
+		ch:      ch,
+		stdin:   io.TeeReader(ch, activityWriter{byteCounter{c.sent, &sent}, c.touch}),
+		stdout:  io.MultiWriter(activityWriter{ch, c.touch}, byteCounter{c.received, &received}),
+		stderr:  io.MultiWriter(activityWriter{ch.Stderr(), c.touch}, byteCounter{c.received, &received}),
+	}
+
+	var recorder *castRecorder
+	if c.recordingDir != "" {
+		recorder = newCastRecorder(c.recordingDir, c.audit, channel)
+		defer recorder.Close()
+		session.stdout = recorderWriter{session.stdout, recorder}
+	}
+	observe := func(reqType string, payload []byte) {
+		c.audit.auditRequest(channel, reqType, payload)
+		if recorder != nil {
+			recorder.request(reqType, payload)
+		}
+	}
+
+	// Requests end when either side closes the channel, which stops
+	// whatever still runs
+	proxyChannelRequests(reqs, session.request, c.rewrite, observe)
+	cancel()
+	session.wait()
+}
+
+// forward connects a local forward to a port on the workspace's loopback
+// interface, the only address the port forwarding API reaches
+func (c *execConn) forward(newChan ssh.NewChannel, channel int, desc string, reject func(ssh.RejectionReason, error)) {
+	var target struct {
+		Addr     string
+		Port     uint32
+		OrigAddr string
+		OrigPort uint32
+	}
+	if err := ssh.Unmarshal(newChan.ExtraData(), &target); err != nil {
+		reject(ssh.ConnectionFailed, errors.New("malformed forwarding request"))
+		return
+	}
+	if !isLoopback(target.Addr) {
+		reject(ssh.Prohibited, fmt.Errorf("forwarding to %s is not supported by the exec backend; forward to localhost", target.Addr))
+		return
+	}
+
+	conn, err := c.cluster.DialPort(c.ctx, c.workspace, int(target.Port))
+	if err != nil {
+		reject(ssh.ConnectionFailed, err)
+		return
+	}
+	defer conn.Close()
+
+	ch, reqs, err := newChan.Accept()
+	if err != nil {
+		return
+	}
+	defer ch.Close()
+	go ssh.DiscardRequests(reqs)
+	c.audit.record(database.AuditChannelOpen, "channel %d: %s", channel, desc)
+
+	var sent, received atomic.Int64
+	defer func() {
+		c.audit.record(database.AuditChannelClose, "channel %d: %d bytes sent, %d received", channel, sent.Load(), received.Load())
+	}()
+
+	// The client's end of input is passed on; the forward ends when the
+	// workspace side does
+	go func() {
+		io.Copy(activityWriter{conn, c.touch}, io.TeeReader(ch, byteCounter{c.sent, &sent}))
+		if cw, ok := conn.(interface{ CloseWrite() error }); ok {
+			cw.CloseWrite()
+		}
+	}()
+	io.Copy(activityWriter{ch, c.touch}, io.TeeReader(conn, byteCounter{c.received, &received}))
+}
+
+// execSession is a session channel whose shell, command or subsystem runs
+// in the workspace through the exec API
+type execSession struct {
+	conn    *execConn
+	ctx     context.Context
+	channel int
+	ch      ssh.Channel
+	stdin   io.Reader
+	stdout  io.Writer
+	stderr  io.Writer
+
+	// env and pty shape the command the session starts
+	env []string
+	pty *ptyRequest
+	// done is closed when what the session started ends; it is nil until
+	// the session starts
+	done chan struct{}
+
+	// resize passes window changes to a command running on a pty; it is
+	// nil when there is none
+	mu     sync.Mutex
+	resize chan kubernetes.TerminalSize
+}
+
+// request handles a session request, reporting whether it succeeded. Once
+// the session has started, only window changes apply.
+func (s *execSession) request(reqType string, wantReply bool, payload []byte) (bool, error) {
+	if reqType == "window-change" {
+		var size windowChange
+		if err := ssh.Unmarshal(payload, &size); err != nil {
+			return false, nil
+		}
+		s.resizeTo(size.Columns, size.Rows)
+		return true, nil
+	}
+	if s.done != nil {
+		return false, nil
+	}
+
+	switch reqType {
+	case "env":
+		var kv struct{ Name, Value string }
+		if err := ssh.Unmarshal(payload, &kv); err != nil || !envName.MatchString(kv.Name) {
+			return false, nil
+		}
+		s.env = append(s.env, kv.Name+"="+kv.Value)
+		return true, nil
+	case "pty-req":
+		var pty ptyRequest
+		if err := ssh.Unmarshal(payload, &pty); err != nil {
+			return false, nil
+		}
+		s.pty = &pty
+		return true, nil
+	case "shell":
+		s.start(s.command(loginAsUser(s.conn.user)...))
+		return true, nil
+	case "exec":
+		var exec struct{ Command string }
+		if err := ssh.Unmarshal(payload, &exec); err != nil {
+			return false, nil
+		}
+		s.start(asUser(s.conn.user, s.command("sh", "-c", exec.Command)...))
+		return true, nil
+	case "subsystem":
+		// Only sftp is served, by the proxy over the exec API
+		if subsystemName(payload) != "sftp" {
+			return false, nil
+		}
+		s.startSFTP()
+		return true, nil
+	}
+	return false, nil
+}
+
+// resizeTo changes the size of the session's terminal
+func (s *execSession) resizeTo(columns, rows uint32) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.pty != nil {
+		s.pty.Columns, s.pty.Rows = columns, rows
+	}
+	if s.resize != nil {
+		// Replace a size the command has not picked up yet
+		select {
+		case <-s.resize:
+		default:
+		}
+		s.resize <- kubernetes.TerminalSize{Width: uint16(columns), Height: uint16(rows)}
+	}
+}
+
+// command prefixes args with the environment the client asked for
+func (s *execSession) command(args ...string) []string {
+	env := s.env
+	if s.pty != nil && s.pty.Term != "" {
+		env = append([]string{"TERM=" + s.pty.Term}, env...)
+	}
+	if len(env) == 0 {
+		return args
+	}
+	return append(append([]string{"env"}, env...), args...)
+}
+
+// asUser returns the exec command running args as user; see runAsScript
+func asUser(user string, args ...string) []string {
+	if user == "" {
+		return args
+	}
+	return append([]string{"sh", "-c", runAsScript, "sh", user, shellJoin(args)}, args...)
+}
+
+// loginAsUser returns the exec command starting user's login shell; see
+// runAsScript. Under su, the shell's environment is cleared except for
+// TERM.
+func loginAsUser(user string) []string {
+	shell := []string{"sh", "-c", loginShell}
+	if user == "" {
+		return shell
+	}
+	return append([]string{"sh", "-c", runAsScript, "sh", user, ""}, shell...)
+}
+
+// shellJoin quotes args into a command line for a POSIX shell
+func shellJoin(args []string) string {
+	quoted := make([]string, len(args))
+	for i, arg := range args {
+		quoted[i] = "'" + strings.ReplaceAll(arg, "'", `'\''`) + "'"
+	}
+	return strings.Join(quoted, " ")
+}
+
+// start runs command in the workspace, on a terminal if the client asked
+// for one
+func (s *execSession) start(command []string) {
+	opts := kubernetes.ExecOptions{
+		Command: command,
+		Stdin:   s.stdin,
+		Stdout:  s.stdout,
+		Stderr:  s.stderr,
+	}
+	if s.pty != nil {
+		s.mu.Lock()
+		s.resize = make(chan kubernetes.TerminalSize, 1)
+		s.resize <- kubernetes.TerminalSize{Width: uint16(s.pty.Columns), Height: uint16(s.pty.Rows)}
+		s.mu.Unlock()
+		opts.TTY = true
+		opts.Resize = s.resize
+	}
+
+	s.run(func() uint32 {
+		err := s.conn.cluster.Exec(s.ctx, s.conn.workspace, opts)
+
+		s.mu.Lock()
+		if s.resize != nil {
+			close(s.resize)
+			s.resize = nil
+		}
+		s.mu.Unlock()
+
+		if err == nil {
+			return 0
+		}
+		if status, ok := kubernetes.ExitCode(err); ok {
+			return uint32(status)
+		}
+		if s.ctx.Err() == nil {
+			log.Printf("Failed to run command in workspace '%s': %v", s.conn.workspace, err)
+			s.conn.audit.record(database.AuditError, "channel %d: %v", s.channel, err)
+			fmt.Fprintf(s.stderr, "Failed to run command in workspace: %v\r\n", err)
+		}
+		return 255
+	})
+}
+
+// startSFTP serves the sftp subsystem
+func (s *execSession) startSFTP() {
+	s.run(func() uint32 {
+		if err := serveSFTP(s.ctx, s.conn.cluster, s.conn.workspace, s.conn.user, channelStream{s.stdin, s.stdout}); err != nil {
+			log.Printf("SFTP session on channel %d failed: %v", s.channel, err)
+			s.conn.audit.record(database.AuditError, "channel %d: sftp: %v", s.channel, err)
+			return 1
+		}
+		return 0
+	})
+}
+
+// run starts the session with fn in the background, then sends the exit
+// status it returns and closes the channel
+func (s *execSession) run(fn func() uint32) {
+	s.done = make(chan struct{})
+	go func() {
+		defer close(s.done)
+		status := fn()
+		s.ch.CloseWrite()
+		s.ch.SendRequest("exit-status", false, ssh.Marshal(struct{ Status uint32 }{status}))
+		s.ch.Close()
+	}()
+}
+
+// wait waits for what the session started, if anything, to end
+func (s *execSession) wait() {
+	if s.done != nil {
+		<-s.done
+	}
+}
@@ -0,0 +1,159 @@
+package sshproxy
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"strconv"
+
+	"github.com/rahulvramesh/justup/pkg/kubernetes"
+	"golang.org/x/crypto/ssh"
+)
+
+// errNotPermittedForRole refuses read-only users anything but local port
+// forwarding
+var errNotPermittedForRole = errors.New("not permitted for your role")
+
+// forwardPolicy decides which channels and port forwards a connection to a
+// workspace may use
+type forwardPolicy struct {
+	// disabled refuses all forwarding, cluster-wide
+	disabled bool
+	// anyHost allows local forwards to hosts other than the workspace's
+	// loopback interface
+	anyHost bool
+	// readOnly users may only open local forwards
+	readOnly bool
+	// local and remote allow these ports; nil allows every port
+	local  []kubernetes.PortRange
+	remote []kubernetes.PortRange
+}
+
+// forwardPolicy builds the policy for a connection to ws. An allowlist
+// that does not parse allows no ports.
+func (s *Server) forwardPolicy(ws *kubernetes.Workspace, readOnly bool) *forwardPolicy {
+	policy := &forwardPolicy{
+		disabled: s.config.DisableForwarding,
+		anyHost:  s.config.ForwardAnyHost,
+		readOnly: readOnly,
+	}
+	if ws.Forwarding == nil {
+		return policy
+	}
+
+	parse := func(entries []string, direction string) []kubernetes.PortRange {
+		if entries == nil {
+			return nil
+		}
+		ranges, err := kubernetes.ParsePortRanges(entries)
+		if err != nil {
+			log.Printf("Workspace '%s' has an invalid %s forwarding allowlist, allowing no ports: %v", ws.Name, direction, err)
+			return []kubernetes.PortRange{}
+		}
+		return ranges
+	}
+	policy.local = parse(ws.Forwarding.Local, "local")
+	policy.remote = parse(ws.Forwarding.Remote, "remote")
+	return policy
+}
+
+// portAllowed checks port against an allowlist; nil allows every port
+func portAllowed(ranges []kubernetes.PortRange, port int) bool {
+	if ranges == nil {
+		return true
+	}
+	for _, r := range ranges {
+		if r.Contains(port) {
+			return true
+		}
+	}
+	return false
+}
+
+// isLoopback reports whether host names the local machine
+func isLoopback(host string) bool {
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// clientChannel checks a channel the client opens
+func (p *forwardPolicy) clientChannel(newChan ssh.NewChannel) error {
+	switch newChan.ChannelType() {
+	case "direct-tcpip":
+		if p.disabled {
+			return errors.New("port forwarding is disabled")
+		}
+		var payload struct {
+			Addr     string
+			Port     uint32
+			OrigAddr string
+			OrigPort uint32
+		}
+		if err := ssh.Unmarshal(newChan.ExtraData(), &payload); err != nil {
+			return errors.New("malformed forwarding request")
+		}
+		if !p.anyHost && !isLoopback(payload.Addr) {
+			return fmt.Errorf("forwarding to %s is not permitted; forward to localhost", payload.Addr)
+		}
+		if !portAllowed(p.local, int(payload.Port)) {
+			return fmt.Errorf("forwarding to port %d is not permitted for this workspace", payload.Port)
+		}
+		return nil
+	case "direct-streamlocal@openssh.com":
+		// Unix sockets have no port, so a port allowlist refuses them
+		if p.disabled || p.local != nil {
+			return errors.New("socket forwarding is not permitted")
+		}
+		if p.readOnly {
+			return errNotPermittedForRole
+		}
+		return nil
+	default:
+		if p.readOnly {
+			return errNotPermittedForRole
+		}
+		return nil
+	}
+}
+
+// globalRequest checks a global request from the client. It returns a
+// description of forwarding requests for the audit trail, and an empty
+// one for requests that are not about forwarding.
+func (p *forwardPolicy) globalRequest(req *ssh.Request) (string, error) {
+	switch req.Type {
+	case "tcpip-forward":
+		var payload struct {
+			Addr string
+			Port uint32
+		}
+		if err := ssh.Unmarshal(req.Payload, &payload); err != nil {
+			return req.Type, errors.New("malformed forwarding request")
+		}
+		desc := fmt.Sprintf("%s on %s", req.Type, net.JoinHostPort(payload.Addr, strconv.Itoa(int(payload.Port))))
+		switch {
+		case p.disabled:
+			return desc, errors.New("port forwarding is disabled")
+		case p.readOnly:
+			return desc, errNotPermittedForRole
+		case payload.Port == 0 && p.remote != nil:
+			// The port would be chosen by the workspace
+			return desc, errors.New("dynamically allocated ports are not permitted for this workspace")
+		case payload.Port != 0 && !portAllowed(p.remote, int(payload.Port)):
+			return desc, fmt.Errorf("listening on port %d is not permitted for this workspace", payload.Port)
+		}
+		return desc, nil
+	case "streamlocal-forward@openssh.com":
+		if p.disabled || p.remote != nil {
+			return req.Type, errors.New("socket forwarding is not permitted")
+		}
+		if p.readOnly {
+			return req.Type, errNotPermittedForRole
+		}
+		return req.Type, nil
+	}
+	return "", nil
+}
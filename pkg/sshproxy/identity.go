@@ -0,0 +1,65 @@
+package sshproxy
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// clientKeyComment tags the proxy's keys in workspace authorized_keys
+const clientKeyComment = "justup-proxy"
+
+// loadClientIdentity loads the keypair the proxy uses to authenticate to
+// workspaces, plus the previous keypair kept after a rotation. With rotate
+// set, the current key becomes the previous one and a new key is generated.
+// The current key is always first in the returned slice.
+func loadClientIdentity(path string, rotate bool) ([]ssh.Signer, error) {
+	previousPath := path + ".previous"
+
+	if rotate {
+		if _, err := os.Stat(path); err == nil {
+			log.Printf("Rotating proxy client key at %s", path)
+			if err := os.Rename(path, previousPath); err != nil {
+				return nil, fmt.Errorf("failed to keep previous client key: %w", err)
+			}
+		}
+	}
+
+	current, err := loadOrGenerateKey(path)
+	if err != nil {
+		return nil, err
+	}
+	signers := []ssh.Signer{current}
+
+	// The previous key stays valid for workspaces that have not been
+	// restarted since the rotation
+	if keyBytes, err := os.ReadFile(previousPath); err == nil {
+		previous, err := ssh.ParsePrivateKey(keyBytes)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse previous client key: %w", err)
+		}
+		signers = append(signers, previous)
+	}
+
+	return signers, nil
+}
+
+// authorizedKeysFor renders signers' public keys in authorized_keys format
+func authorizedKeysFor(signers []ssh.Signer) string {
+	var lines []string
+	for _, signer := range signers {
+		line := strings.TrimSpace(string(ssh.MarshalAuthorizedKey(signer.PublicKey())))
+		lines = append(lines, line+" "+clientKeyComment)
+	}
+	return strings.Join(lines, "\n") + "\n"
+}
+
+// publishClientKeys publishes the proxy's client public keys so the
+// controller can add them to every workspace's authorized_keys
+func (s *Server) publishClientKeys(ctx context.Context) error {
+	return s.k8sClient.PublishProxyKeys(ctx, authorizedKeysFor(s.clientSigners))
+}
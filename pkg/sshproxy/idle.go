@@ -0,0 +1,163 @@
+package sshproxy
+
+import (
+	"context"
+	"io"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/rahulvramesh/justup/pkg/kubernetes"
+	"k8s.io/apimachinery/pkg/api/meta"
+)
+
+// idleCheckInterval is how often activity is published and idle workspaces
+// are stopped
+const idleCheckInterval = time.Minute
+
+// activityTracker records when each workspace last had SSH traffic through
+// the proxy. Activity is published on the Workspace resource so it survives
+// proxy restarts.
+type activityTracker struct {
+	mu   sync.Mutex
+	last map[string]time.Time
+	// pending holds activity not yet published
+	pending map[string]time.Time
+}
+
+func newActivityTracker() *activityTracker {
+	return &activityTracker{
+		last:    make(map[string]time.Time),
+		pending: make(map[string]time.Time),
+	}
+}
+
+// touch records activity for a workspace
+func (t *activityTracker) touch(workspaceName string) {
+	now := time.Now()
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.last[workspaceName] = now
+	t.pending[workspaceName] = now
+}
+
+// lastActivity returns the last activity the proxy saw for a workspace
+func (t *activityTracker) lastActivity(workspaceName string) time.Time {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.last[workspaceName]
+}
+
+// takePending returns and clears the activity not yet published
+func (t *activityTracker) takePending() map[string]time.Time {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	pending := t.pending
+	t.pending = make(map[string]time.Time)
+	return pending
+}
+
+// activityWriter records activity on every write
+type activityWriter struct {
+	io.Writer
+	touch func()
+}
+
+func (w activityWriter) Write(p []byte) (int, error) {
+	w.touch()
+	return w.Writer.Write(p)
+}
+
+// runIdleReaper periodically publishes activity and stops running workspaces
+// that have been idle for longer than their idle timeout
+func (s *Server) runIdleReaper(ctx context.Context) {
+	ticker := time.NewTicker(idleCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		s.publishActivity(ctx)
+		s.stopIdleWorkspaces(ctx)
+	}
+}
+
+// publishActivity records pending activity on the Workspace resources
+func (s *Server) publishActivity(ctx context.Context) {
+	for name, at := range s.activity.takePending() {
+		if err := s.k8sClient.RecordWorkspaceActivity(ctx, name, at); err != nil {
+			log.Printf("Failed to record activity for workspace '%s': %v", name, err)
+		}
+	}
+}
+
+// stopIdleWorkspaces stops running workspaces idle for longer than their
+// idle timeout
+func (s *Server) stopIdleWorkspaces(ctx context.Context) {
+	resources, err := s.k8sClient.ListWorkspaceResources(ctx)
+	if err != nil {
+		log.Printf("Idle check failed to list workspaces: %v", err)
+		return
+	}
+
+	for i := range resources {
+		ws := &resources[i]
+		if !ws.Spec.Running || ws.Status.Phase != kubernetes.PhaseRunning {
+			continue
+		}
+		if ws.Annotations[kubernetes.NoIdleShutdownAnnotation] == "true" {
+			continue
+		}
+
+		timeout := s.config.IdleTimeout
+		if ws.Spec.IdleTimeout != "" {
+			timeout, err = kubernetes.ParseIdleTimeout(ws.Spec.IdleTimeout)
+			if err != nil {
+				log.Printf("Workspace '%s': %v", ws.Name, err)
+				continue
+			}
+		}
+		if timeout <= 0 {
+			continue
+		}
+
+		idle := time.Since(s.lastActivity(ws))
+		if idle < timeout {
+			continue
+		}
+
+		log.Printf("Stopping workspace '%s' after %s without activity", ws.Name, idle.Round(time.Minute))
+		if err := s.k8sClient.StopWorkspace(ctx, ws.Name); err != nil {
+			log.Printf("Failed to stop idle workspace '%s': %v", ws.Name, err)
+			continue
+		}
+		if err := s.db.MarkWorkspaceStopped(ws.Name); err != nil {
+			log.Printf("Failed to record stop of workspace '%s': %v", ws.Name, err)
+		}
+	}
+}
+
+// lastActivity returns the latest of the workspace's published activity, the
+// activity seen by this proxy, and when the workspace became ready. The
+// proxy's start time is a floor so a restarted proxy never stops a workspace
+// before a full timeout has passed.
+func (s *Server) lastActivity(ws *kubernetes.WorkspaceResource) time.Time {
+	last := s.startedAt
+
+	if seen := s.activity.lastActivity(ws.Name); seen.After(last) {
+		last = seen
+	}
+	if published, err := time.Parse(time.RFC3339, ws.Annotations[kubernetes.LastActivityAnnotation]); err == nil && published.After(last) {
+		last = published
+	}
+	if ready := meta.FindStatusCondition(ws.Status.Conditions, kubernetes.ConditionPodReady); ready != nil && ready.LastTransitionTime.After(last) {
+		last = ready.LastTransitionTime.Time
+	}
+
+	return last
+}
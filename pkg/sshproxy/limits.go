@@ -0,0 +1,309 @@
+package sshproxy
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// Reasons a connection is refused before the SSH handshake, for the
+// rejected connections counter
+const (
+	rejectDenied           = "denied"
+	rejectBanned           = "banned"
+	rejectRateLimited      = "rate_limited"
+	rejectTooManyConnected = "too_many_connections"
+)
+
+// sourcePruneInterval is how often state of sources no longer connecting is
+// dropped
+const sourcePruneInterval = time.Minute
+
+// connLimiter decides which connections the proxy accepts: by allow and
+// deny lists, a cap on concurrent connections, a per-source rate limit and
+// bans of sources that repeatedly fail to authenticate. Sources are
+// identified by IP address.
+type connLimiter struct {
+	maxConnections   int
+	rate             rate.Limit
+	burst            int
+	authFailureLimit int
+	banDuration      time.Duration
+	maxBanDuration   time.Duration
+	allow            []*net.IPNet
+	deny             []*net.IPNet
+
+	mu      sync.Mutex
+	active  int
+	sources map[string]*sourceState
+}
+
+// sourceState tracks one source IP
+type sourceState struct {
+	limiter  *rate.Limiter
+	failures int // Consecutive failed authentications
+	bans     int // Bans so far; each doubles the next
+	until    time.Time
+	lastSeen time.Time
+}
+
+// newConnLimiter creates a limiter from the proxy configuration
+func newConnLimiter(config *Config) (*connLimiter, error) {
+	allow, err := parseCIDRs(config.AllowCIDRs)
+	if err != nil {
+		return nil, fmt.Errorf("invalid allowed networks: %w", err)
+	}
+	deny, err := parseCIDRs(config.DenyCIDRs)
+	if err != nil {
+		return nil, fmt.Errorf("invalid denied networks: %w", err)
+	}
+
+	l := &connLimiter{
+		maxConnections:   config.MaxConnections,
+		rate:             rate.Inf,
+		burst:            config.ConnectionBurst,
+		authFailureLimit: config.AuthFailureLimit,
+		banDuration:      config.BanDuration,
+		maxBanDuration:   config.MaxBanDuration,
+		allow:            allow,
+		deny:             deny,
+		sources:          make(map[string]*sourceState),
+	}
+	if config.ConnectionsPerMinute > 0 {
+		l.rate = rate.Limit(float64(config.ConnectionsPerMinute) / 60)
+		if l.burst < 1 {
+			l.burst = 1
+		}
+	}
+	if l.maxBanDuration < l.banDuration {
+		l.maxBanDuration = l.banDuration
+	}
+	return l, nil
+}
+
+// parseCIDRs parses networks in CIDR notation; a bare IP address is a
+// network of one
+func parseCIDRs(cidrs []string) ([]*net.IPNet, error) {
+	var networks []*net.IPNet
+	for _, cidr := range cidrs {
+		cidr = strings.TrimSpace(cidr)
+		if cidr == "" {
+			continue
+		}
+		if !strings.Contains(cidr, "/") {
+			ip := net.ParseIP(cidr)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid address %q", cidr)
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+			networks = append(networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, err
+		}
+		networks = append(networks, network)
+	}
+	return networks, nil
+}
+
+// sourceIP returns the IP address of a connection's remote end
+func sourceIP(addr net.Addr) string {
+	if tcpAddr, ok := addr.(*net.TCPAddr); ok {
+		return tcpAddr.IP.String()
+	}
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return addr.String()
+	}
+	return host
+}
+
+// admit decides whether to accept a connection from ip. If it is accepted,
+// release must be called when the connection closes; otherwise reason says
+// why it was refused.
+func (l *connLimiter) admit(ip string) (release func(), reason string) {
+	if !l.permitted(net.ParseIP(ip)) {
+		return nil, rejectDenied
+	}
+
+	now := time.Now()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	source := l.source(ip, now)
+	if now.Before(source.until) {
+		return nil, rejectBanned
+	}
+	if !source.limiter.AllowN(now, 1) {
+		return nil, rejectRateLimited
+	}
+	if l.maxConnections > 0 && l.active >= l.maxConnections {
+		return nil, rejectTooManyConnected
+	}
+
+	l.active++
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			l.mu.Lock()
+			l.active--
+			l.mu.Unlock()
+		})
+	}, ""
+}
+
+// permitted checks ip against the allow and deny lists
+func (l *connLimiter) permitted(ip net.IP) bool {
+	if ip == nil {
+		return len(l.allow) == 0
+	}
+	for _, network := range l.deny {
+		if network.Contains(ip) {
+			return false
+		}
+	}
+	if len(l.allow) == 0 {
+		return true
+	}
+	for _, network := range l.allow {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// source returns the state of ip, creating it; the caller holds the lock
+func (l *connLimiter) source(ip string, now time.Time) *sourceState {
+	source, ok := l.sources[ip]
+	if !ok {
+		source = &sourceState{limiter: rate.NewLimiter(l.rate, l.burst)}
+		l.sources[ip] = source
+	}
+	source.lastSeen = now
+	return source
+}
+
+// authFailed counts a connection from ip that failed to authenticate. It
+// returns how long ip is banned for, or zero if it is not banned.
+func (l *connLimiter) authFailed(ip string) time.Duration {
+	if l.authFailureLimit <= 0 {
+		return 0
+	}
+
+	now := time.Now()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	source := l.source(ip, now)
+	source.failures++
+	if source.failures < l.authFailureLimit {
+		return 0
+	}
+
+	// Ban for the base duration, doubling with each ban up to the maximum
+	ban := l.banDuration
+	for i := 0; i < source.bans && ban < l.maxBanDuration; i++ {
+		ban *= 2
+	}
+	if ban > l.maxBanDuration {
+		ban = l.maxBanDuration
+	}
+	source.bans++
+	source.failures = 0
+	source.until = now.Add(ban)
+	return ban
+}
+
+// authSucceeded clears the failures and bans of ip
+func (l *connLimiter) authSucceeded(ip string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if source, ok := l.sources[ip]; ok {
+		source.failures = 0
+		source.bans = 0
+	}
+}
+
+// prune drops sources that are not banned and have not connected for
+// longer than the longest ban, so their rate limit and ban history reset
+func (l *connLimiter) prune(now time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	expiry := l.maxBanDuration
+	if expiry < time.Hour {
+		expiry = time.Hour
+	}
+	for ip, source := range l.sources {
+		if now.After(source.until) && now.Sub(source.lastSeen) > expiry {
+			delete(l.sources, ip)
+		}
+	}
+}
+
+// run prunes sources until ctx is cancelled
+func (l *connLimiter) run(ctx context.Context) {
+	ticker := time.NewTicker(sourcePruneInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			l.prune(now)
+		}
+	}
+}
+
+// handshakeDeadlines tracks connections that are still in the SSH
+// handshake, so waking a workspace can extend the deadline
+type handshakeDeadlines struct {
+	mu    sync.Mutex
+	conns map[string]net.Conn
+}
+
+func newHandshakeDeadlines() *handshakeDeadlines {
+	return &handshakeDeadlines{conns: make(map[string]net.Conn)}
+}
+
+// start sets the handshake deadline of conn
+func (h *handshakeDeadlines) start(conn net.Conn, timeout time.Duration) {
+	conn.SetDeadline(time.Now().Add(timeout))
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.conns[conn.RemoteAddr().String()] = conn
+}
+
+// extend moves the deadline of the connection from addr
+func (h *handshakeDeadlines) extend(addr net.Addr, timeout time.Duration) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if conn, ok := h.conns[addr.String()]; ok {
+		conn.SetDeadline(time.Now().Add(timeout))
+	}
+}
+
+// finish clears the deadline of conn once the handshake is over
+func (h *handshakeDeadlines) finish(conn net.Conn) {
+	conn.SetDeadline(time.Time{})
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.conns, conn.RemoteAddr().String())
+}
@@ -0,0 +1,92 @@
+package sshproxy_test
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/rahulvramesh/justup/pkg/sshproxy"
+	"github.com/rahulvramesh/justup/pkg/sshproxy/sshproxytest"
+)
+
+// refused reports whether the proxy closes a connection without sending
+// its SSH version, as it does for sources it does not admit
+func refused(t *testing.T, proxy *sshproxytest.Proxy) bool {
+	t.Helper()
+
+	conn, err := net.Dial("tcp", proxy.Addr)
+	if err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+	defer conn.Close()
+
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	n, _ := conn.Read(make([]byte, 1))
+	return n == 0
+}
+
+func TestAuthFailureBan(t *testing.T) {
+	proxy, _, signer := newProxy(t, sshproxy.Config{AuthFailureLimit: 2, BanDuration: time.Minute})
+	unknown := newSigner(t)
+
+	for i := 0; i < 2; i++ {
+		if client, err := proxy.Dial(workspaceName, unknown); err == nil {
+			client.Close()
+			t.Fatal("connected with an unregistered key")
+		}
+	}
+
+	// The proxy counts a failure after the client has given up, so wait
+	// for the ban to take effect
+	deadline := time.Now().Add(5 * time.Second)
+	for !refused(t, proxy) {
+		if time.Now().After(deadline) {
+			t.Fatal("source was not banned after repeated authentication failures")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// The ban applies to the source, whatever key it presents next
+	if client, err := proxy.Dial(workspaceName, signer); err == nil {
+		client.Close()
+		t.Fatal("banned source connected with a registered key")
+	}
+}
+
+func TestAuthFailureBelowLimit(t *testing.T) {
+	proxy, _, signer := newProxy(t, sshproxy.Config{AuthFailureLimit: 3, BanDuration: time.Minute})
+
+	if client, err := proxy.Dial(workspaceName, newSigner(t)); err == nil {
+		client.Close()
+		t.Fatal("connected with an unregistered key")
+	}
+	client, err := proxy.Dial(workspaceName, signer)
+	if err != nil {
+		t.Fatalf("failed to connect after one authentication failure: %v", err)
+	}
+	client.Close()
+}
+
+func TestConnectionRateLimit(t *testing.T) {
+	proxy, _, signer := newProxy(t, sshproxy.Config{ConnectionsPerMinute: 1, ConnectionBurst: 2})
+
+	for i := 0; i < 2; i++ {
+		client, err := proxy.Dial(workspaceName, signer)
+		if err != nil {
+			t.Fatalf("connection %d within the burst failed: %v", i+1, err)
+		}
+		client.Close()
+	}
+
+	if client, err := proxy.Dial(workspaceName, signer); err == nil {
+		client.Close()
+		t.Fatal("connected beyond the rate limit")
+	}
+}
+
+func TestDeniedNetwork(t *testing.T) {
+	proxy, _, _ := newProxy(t, sshproxy.Config{DenyCIDRs: []string{"127.0.0.0/8"}})
+	if !refused(t, proxy) {
+		t.Error("connection from a denied network was accepted")
+	}
+}
@@ -0,0 +1,389 @@
+package sshproxy
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/rahulvramesh/justup/pkg/database"
+	"github.com/rahulvramesh/justup/pkg/kubernetes"
+	"github.com/rahulvramesh/justup/pkg/sshca"
+	"golang.org/x/crypto/ssh"
+)
+
+// managementUser is the SSH username whose sessions run management commands
+// in the proxy instead of connecting to a workspace
+const managementUser = kubernetes.ReservedWorkspaceName
+
+// maxKeyInput bounds the public key read from stdin by `keys add`
+const maxKeyInput = 16 * 1024
+
+const managementHelp = `Usage: ssh justup@<proxy> <command>
+
+Commands:
+  list                   List your workspaces
+  start <workspace>      Start a workspace
+  stop <workspace>       Stop a workspace
+  keys list              List your SSH keys
+  keys add [name]        Register the public key read from stdin
+  keys remove <fingerprint>
+                         Remove one of your SSH keys
+  whoami                 Show the user and key you authenticated with
+  help                   Show this help
+`
+
+// managementCommand runs a management command. It returns the exit status.
+type managementCommand func(ctx context.Context, session *managementSession, args []string) int
+
+// managementSession holds the state of one management exec request
+type managementSession struct {
+	user  *database.User
+	keyID string
+	// certificate describes the certificate the session authenticated
+	// with, if any
+	certificate string
+	// forceCommand replaces the requested command, from the
+	// certificate's force-command option
+	forceCommand string
+	// audit records the commands run, numbered by channel
+	audit   *auditLog
+	channel int
+
+	stdin  io.Reader
+	stdout io.Writer
+	stderr io.Writer
+}
+
+// fail writes an error to stderr and returns exit status 1
+func (m *managementSession) fail(format string, args ...interface{}) int {
+	fmt.Fprintf(m.stderr, "Error: "+format+"\n", args...)
+	return 1
+}
+
+// handleManagement serves the sessions of a management connection. Only
+// session channels are accepted; each exec request runs one command.
+func (s *Server) handleManagement(ctx context.Context, conn *ssh.ServerConn, chans <-chan ssh.NewChannel, reqs <-chan *ssh.Request, audit *auditLog) {
+	go ssh.DiscardRequests(reqs)
+
+	user, err := s.db.GetUser(conn.Permissions.Extensions["user-id"])
+	if err != nil {
+		log.Printf("Management connection for unknown user '%s': %v", conn.Permissions.Extensions["user-id"], err)
+		return
+	}
+
+	for newChan := range chans {
+		channel := audit.nextChannel()
+		if newChan.ChannelType() != "session" {
+			audit.record(database.AuditChannelRejected, "channel %d: %s: not a session", channel, auditChannel(newChan))
+			newChan.Reject(ssh.Prohibited, "only sessions are supported for the management user")
+			continue
+		}
+
+		ch, chReqs, err := newChan.Accept()
+		if err != nil {
+			continue
+		}
+		audit.record(database.AuditChannelOpen, "channel %d: session", channel)
+		go s.serveManagementSession(ctx, ch, chReqs, &managementSession{
+			user:         user,
+			keyID:        conn.Permissions.Extensions["key-id"],
+			certificate:  conn.Permissions.Extensions["certificate"],
+			forceCommand: conn.Permissions.CriticalOptions[sshca.OptionForceCommand],
+			audit:        audit,
+			channel:      channel,
+			stdin:        ch,
+			stdout:       ch,
+			stderr:       ch.Stderr(),
+		})
+	}
+}
+
+// serveManagementSession runs the command of an exec request, or prints help
+// for an interactive shell, then closes the channel with the exit status
+func (s *Server) serveManagementSession(ctx context.Context, ch ssh.Channel, reqs <-chan *ssh.Request, session *managementSession) {
+	defer ch.Close()
+
+	for req := range reqs {
+		switch req.Type {
+		case "exec", "shell":
+			var args []string
+			if req.Type == "exec" {
+				var payload struct{ Command string }
+				if err := ssh.Unmarshal(req.Payload, &payload); err != nil {
+					req.Reply(false, nil)
+					return
+				}
+				args = strings.Fields(payload.Command)
+			}
+			if session.forceCommand != "" {
+				args = strings.Fields(session.forceCommand)
+			}
+			req.Reply(true, nil)
+
+			session.audit.record(database.AuditExec, "channel %d: %s", session.channel, strings.Join(args, " "))
+			status := s.runManagementCommand(ctx, session, args)
+			ch.SendRequest("exit-status", false, ssh.Marshal(struct{ Status uint32 }{uint32(status)}))
+			return
+		case "pty-req", "env", "window-change":
+			// Accepted so interactive clients proceed to the shell request
+			if req.WantReply {
+				req.Reply(true, nil)
+			}
+		default:
+			if req.WantReply {
+				req.Reply(false, nil)
+			}
+		}
+	}
+}
+
+// runManagementCommand dispatches a management command line
+func (s *Server) runManagementCommand(ctx context.Context, session *managementSession, args []string) int {
+	if len(args) == 0 {
+		fmt.Fprint(session.stdout, managementHelp)
+		return 0
+	}
+
+	commands := map[string]managementCommand{
+		"list":   s.managementList,
+		"ls":     s.managementList,
+		"start":  s.managementStart,
+		"stop":   s.managementStop,
+		"keys":   s.managementKeys,
+		"whoami": s.managementWhoami,
+		"help": func(_ context.Context, session *managementSession, _ []string) int {
+			fmt.Fprint(session.stdout, managementHelp)
+			return 0
+		},
+	}
+
+	command, ok := commands[args[0]]
+	if !ok {
+		session.fail("unknown command '%s'", args[0])
+		fmt.Fprint(session.stderr, managementHelp)
+		return 2
+	}
+
+	log.Printf("Management command from user '%s': %s", session.user.Username, strings.Join(args, " "))
+	return command(ctx, session, args[1:])
+}
+
+func (s *Server) managementList(ctx context.Context, session *managementSession, _ []string) int {
+	roles, err := s.db.ListWorkspaceRoles(session.user.ID)
+	if err != nil {
+		return session.fail("failed to list workspaces: %v", err)
+	}
+
+	workspaces, err := s.k8sClient.ListWorkspaces(ctx, true)
+	if err != nil {
+		return session.fail("failed to list workspaces: %v", err)
+	}
+	byName := make(map[string]kubernetes.Workspace, len(workspaces))
+	for _, ws := range workspaces {
+		byName[ws.Name] = ws
+
+		// Workspaces created with a kubeconfig are owned by annotation
+		if _, ok := roles[ws.Name]; !ok && ws.Owner != "" && ws.Owner == session.user.Username {
+			if _, err := s.db.GetWorkspace(ws.Name); err != nil {
+				roles[ws.Name] = database.RoleOwner
+			}
+		}
+	}
+
+	names := make([]string, 0, len(roles))
+	for name := range roles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	if len(names) == 0 {
+		fmt.Fprintln(session.stdout, "No workspaces found.")
+		return 0
+	}
+
+	w := tabwriter.NewWriter(session.stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tROLE\tSTATUS\tAGE")
+	for _, name := range names {
+		status, age := kubernetes.StatusDeleted, "-"
+		if ws, ok := byName[name]; ok {
+			status, age = ws.Status, ws.Age
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", name, roles[name], status, age)
+	}
+	w.Flush()
+	return 0
+}
+
+// requireOperator checks that the session's user may start and stop the
+// workspace; read-only users may not
+func (s *Server) requireOperator(ctx context.Context, session *managementSession, workspaceName string) bool {
+	role, err := s.workspaceRole(ctx, workspaceName, session.user.ID)
+	if err != nil || role == database.RoleReadOnly {
+		session.fail("workspace '%s' not found or not permitted", workspaceName)
+		return false
+	}
+	return true
+}
+
+func (s *Server) managementStart(ctx context.Context, session *managementSession, args []string) int {
+	if len(args) != 1 {
+		return session.fail("usage: start <workspace>")
+	}
+	name := args[0]
+	if !s.requireOperator(ctx, session, name) {
+		return 1
+	}
+
+	if err := s.k8sClient.StartWorkspace(ctx, name, kubernetes.StartOptions{}); err != nil {
+		return session.fail("failed to start workspace: %v", err)
+	}
+	if err := s.db.MarkWorkspaceStarted(name); err != nil {
+		log.Printf("Failed to record start of workspace '%s': %v", name, err)
+	}
+
+	fmt.Fprintf(session.stdout, "Workspace '%s' is starting.\n", name)
+	return 0
+}
+
+func (s *Server) managementStop(ctx context.Context, session *managementSession, args []string) int {
+	if len(args) != 1 {
+		return session.fail("usage: stop <workspace>")
+	}
+	name := args[0]
+	if !s.requireOperator(ctx, session, name) {
+		return 1
+	}
+
+	if err := s.k8sClient.StopWorkspace(ctx, name); err != nil {
+		return session.fail("failed to stop workspace: %v", err)
+	}
+	if err := s.db.MarkWorkspaceStopped(name); err != nil {
+		log.Printf("Failed to record stop of workspace '%s': %v", name, err)
+	}
+
+	fmt.Fprintf(session.stdout, "Workspace '%s' stopped.\n", name)
+	return 0
+}
+
+func (s *Server) managementKeys(ctx context.Context, session *managementSession, args []string) int {
+	if len(args) == 0 {
+		return session.fail("usage: keys list|add [name]|remove <fingerprint>")
+	}
+
+	switch args[0] {
+	case "list", "ls":
+		return s.managementKeysList(session)
+	case "add":
+		return s.managementKeysAdd(session, args[1:])
+	case "remove", "rm":
+		return s.managementKeysRemove(session, args[1:])
+	default:
+		return session.fail("unknown keys command '%s'", args[0])
+	}
+}
+
+func (s *Server) managementKeysList(session *managementSession) int {
+	keys, err := s.db.ListSSHKeys(session.user.ID)
+	if err != nil {
+		return session.fail("failed to list SSH keys: %v", err)
+	}
+
+	w := tabwriter.NewWriter(session.stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tFINGERPRINT\tADDED")
+	for _, key := range keys {
+		fmt.Fprintf(w, "%s\t%s\t%s\n", key.Name, key.Fingerprint, key.CreatedAt.Format(time.DateOnly))
+	}
+	w.Flush()
+	return 0
+}
+
+func (s *Server) managementKeysAdd(session *managementSession, args []string) int {
+	input, err := io.ReadAll(io.LimitReader(session.stdin, maxKeyInput))
+	if err != nil {
+		return session.fail("failed to read public key: %v", err)
+	}
+
+	pubKey, comment, _, _, err := ssh.ParseAuthorizedKey(input)
+	if err != nil {
+		return session.fail("failed to parse public key from stdin: %v", err)
+	}
+	fingerprint := ssh.FingerprintSHA256(pubKey)
+
+	if existing, err := s.db.GetSSHKeyByFingerprint(fingerprint); err == nil {
+		return session.fail("key already registered as '%s'", existing.Name)
+	}
+
+	publicKey := strings.TrimSpace(string(ssh.MarshalAuthorizedKey(pubKey)))
+	if comment != "" {
+		publicKey += " " + comment
+	}
+
+	name := strings.Join(args, " ")
+	if name == "" {
+		name = comment
+	}
+	if name == "" {
+		name = "unnamed"
+	}
+
+	key := &database.SSHKey{
+		ID:          uuid.New().String(),
+		UserID:      session.user.ID,
+		Name:        name,
+		PublicKey:   publicKey,
+		Fingerprint: fingerprint,
+		CreatedAt:   time.Now(),
+	}
+
+	if err := s.db.AddSSHKey(key); err != nil {
+		return session.fail("failed to add SSH key: %v", err)
+	}
+
+	fmt.Fprintf(session.stdout, "SSH key '%s' added (%s).\n", name, fingerprint)
+	return 0
+}
+
+func (s *Server) managementKeysRemove(session *managementSession, args []string) int {
+	if len(args) != 1 {
+		return session.fail("usage: keys remove <fingerprint>")
+	}
+
+	key, err := s.db.GetSSHKeyByFingerprint(args[0])
+	if err != nil || key.UserID != session.user.ID {
+		return session.fail("SSH key not found")
+	}
+	if key.ID == session.keyID {
+		return session.fail("refusing to remove the key this session authenticated with")
+	}
+
+	if err := s.db.DeleteSSHKey(key.ID); err != nil {
+		return session.fail("failed to remove SSH key: %v", err)
+	}
+
+	fmt.Fprintf(session.stdout, "SSH key '%s' removed.\n", key.Name)
+	return 0
+}
+
+func (s *Server) managementWhoami(_ context.Context, session *managementSession, _ []string) int {
+	fmt.Fprintf(session.stdout, "User: %s (%s)\n", session.user.Username, session.user.ID)
+	if session.certificate != "" {
+		fmt.Fprintf(session.stdout, "Key:  %s\n", session.certificate)
+		return 0
+	}
+
+	keys, err := s.db.ListSSHKeys(session.user.ID)
+	if err == nil {
+		for _, key := range keys {
+			if key.ID == session.keyID {
+				fmt.Fprintf(session.stdout, "Key:  %s (%s)\n", key.Name, key.Fingerprint)
+			}
+		}
+	}
+	return 0
+}
@@ -0,0 +1,114 @@
+package sshproxy_test
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/rahulvramesh/justup/pkg/database"
+	"github.com/rahulvramesh/justup/pkg/kubernetes"
+	"github.com/rahulvramesh/justup/pkg/sshproxy"
+	"github.com/rahulvramesh/justup/pkg/sshproxy/sshproxytest"
+	"golang.org/x/crypto/ssh"
+)
+
+// manage runs a management command as the key's user, returning its output
+// and exit status
+func manage(t *testing.T, proxy *sshproxytest.Proxy, signer ssh.Signer, command string) (stdout, stderr string, status int) {
+	t.Helper()
+
+	client, err := proxy.Dial("justup", signer)
+	if err != nil {
+		t.Fatalf("failed to connect as the management user: %v", err)
+	}
+	defer client.Close()
+	session, err := client.NewSession()
+	if err != nil {
+		t.Fatalf("failed to open session: %v", err)
+	}
+	defer session.Close()
+
+	var out, errOut bytes.Buffer
+	session.Stdout = &out
+	session.Stderr = &errOut
+	err = session.Run(command)
+	var exitErr *ssh.ExitError
+	switch {
+	case errors.As(err, &exitErr):
+		status = exitErr.ExitStatus()
+	case err != nil:
+		t.Fatalf("failed to run %q: %v", command, err)
+	}
+	return out.String(), errOut.String(), status
+}
+
+func TestManagementAccess(t *testing.T) {
+	tests := []struct {
+		name string
+		// role is bob's role on alice's workspace, if any
+		role    string
+		allowed bool
+	}{
+		{name: "no access"},
+		{name: "read-only", role: database.RoleReadOnly},
+		{name: "collaborator", role: database.RoleCollaborator, allowed: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			proxy, _, _ := newProxy(t, sshproxy.Config{})
+			signer := proxy.AddUser("bob")
+			if tt.role != "" {
+				bob, err := proxy.DB.GetUserByUsername("bob")
+				if err != nil {
+					t.Fatalf("failed to get user: %v", err)
+				}
+				if err := proxy.DB.GrantWorkspaceAccess(workspaceName, bob.ID, tt.role); err != nil {
+					t.Fatalf("failed to share workspace: %v", err)
+				}
+			}
+
+			stdout, _, _ := manage(t, proxy, signer, "list")
+			if listed := strings.Contains(stdout, workspaceName); listed != (tt.role != "") {
+				t.Errorf("list printed %q; want the workspace listed only if shared", stdout)
+			}
+
+			_, stderr, status := manage(t, proxy, signer, "stop "+workspaceName)
+			if (status == 0) != tt.allowed {
+				t.Errorf("stop exited with %d (%s), want success %v", status, stderr, tt.allowed)
+			}
+
+			want := kubernetes.PhaseRunning
+			if tt.allowed {
+				want = kubernetes.PhaseStopped
+			}
+			if status := workspaceStatus(t, proxy); status != want {
+				t.Errorf("workspace is %s, want %s", status, want)
+			}
+		})
+	}
+}
+
+func TestManagementOwner(t *testing.T) {
+	proxy, _, signer := newProxy(t, sshproxy.Config{})
+
+	stdout, _, _ := manage(t, proxy, signer, "list")
+	owned := false
+	for _, line := range strings.Split(stdout, "\n") {
+		fields := strings.Fields(line)
+		owned = owned || len(fields) > 1 && fields[0] == workspaceName && fields[1] == database.RoleOwner
+	}
+	if !owned {
+		t.Errorf("list printed %q, want the workspace listed as owned", stdout)
+	}
+
+	if _, stderr, status := manage(t, proxy, signer, "stop "+workspaceName); status != 0 {
+		t.Fatalf("stop exited with %d: %s", status, stderr)
+	}
+	if _, stderr, status := manage(t, proxy, signer, "start "+workspaceName); status != 0 {
+		t.Fatalf("start exited with %d: %s", status, stderr)
+	}
+	if status := workspaceStatus(t, proxy); status != kubernetes.PhaseRunning {
+		t.Errorf("workspace is %s, want %s", status, kubernetes.PhaseRunning)
+	}
+}
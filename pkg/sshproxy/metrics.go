@@ -0,0 +1,194 @@
+package sshproxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Results of a connection, for the connections counter
+const (
+	connectionHandshakeFailed = "handshake_failed"
+	connectionManagement      = "management"
+	connectionProxied         = "proxied"
+	connectionUpstreamFailed  = "upstream_failed"
+)
+
+// Reasons authentication fails, for the auth failures counter
+const (
+	authUnknownKey            = "unknown_key"
+	authInvalidCertificate    = "invalid_certificate"
+	authRestrictedCertificate = "restricted_certificate"
+	authAccessDenied          = "access_denied"
+	authWakeFailed            = "wake_failed"
+)
+
+// metrics are the proxy's Prometheus metrics. They use their own registry
+// so only the proxy's and the Go runtime's metrics are exposed.
+type metrics struct {
+	registry *prometheus.Registry
+
+	connections      *prometheus.CounterVec
+	authFailures     *prometheus.CounterVec
+	handshakeSeconds prometheus.Histogram
+	dialSeconds      *prometheus.HistogramVec
+	bytes            *prometheus.CounterVec
+	activeSessions   *prometheus.GaugeVec
+	rejected         *prometheus.CounterVec
+	bans             prometheus.Counter
+}
+
+func newMetrics() *metrics {
+	// Handshakes include waking a stopped workspace, which takes minutes
+	latencyBuckets := prometheus.ExponentialBuckets(0.005, 2, 16)
+
+	m := &metrics{
+		registry: prometheus.NewRegistry(),
+		connections: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "justup",
+			Subsystem: "sshproxy",
+			Name:      "connections_total",
+			Help:      "SSH connections accepted, by result.",
+		}, []string{"result"}),
+		authFailures: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "justup",
+			Subsystem: "sshproxy",
+			Name:      "auth_failures_total",
+			Help:      "Rejected authentication attempts, by reason.",
+		}, []string{"reason"}),
+		handshakeSeconds: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace: "justup",
+			Subsystem: "sshproxy",
+			Name:      "handshake_duration_seconds",
+			Help:      "Time to complete the SSH handshake with clients, including authentication and waking workspaces.",
+			Buckets:   latencyBuckets,
+		}),
+		dialSeconds: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: "justup",
+			Subsystem: "sshproxy",
+			Name:      "upstream_dial_duration_seconds",
+			Help:      "Time to connect and complete the SSH handshake with workspaces, by result.",
+			Buckets:   latencyBuckets,
+		}, []string{"result"}),
+		bytes: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "justup",
+			Subsystem: "sshproxy",
+			Name:      "bytes_total",
+			Help:      "Channel data proxied, by workspace and direction (to_workspace or from_workspace).",
+		}, []string{"workspace", "direction"}),
+		activeSessions: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: "justup",
+			Subsystem: "sshproxy",
+			Name:      "active_sessions",
+			Help:      "Authenticated SSH connections currently open, by kind (workspace or management).",
+		}, []string{"kind"}),
+		rejected: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "justup",
+			Subsystem: "sshproxy",
+			Name:      "rejected_connections_total",
+			Help:      "Connections refused before the SSH handshake, by reason.",
+		}, []string{"reason"}),
+		bans: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: "justup",
+			Subsystem: "sshproxy",
+			Name:      "bans_total",
+			Help:      "Source addresses banned after repeated authentication failures.",
+		}),
+	}
+
+	m.registry.MustRegister(
+		m.connections,
+		m.authFailures,
+		m.handshakeSeconds,
+		m.dialSeconds,
+		m.bytes,
+		m.activeSessions,
+		m.rejected,
+		m.bans,
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+	return m
+}
+
+// authFailed counts a rejected authentication attempt
+func (m *metrics) authFailed(reason string) {
+	m.authFailures.WithLabelValues(reason).Inc()
+}
+
+// sessionStarted counts an open session until the returned function is
+// called
+func (m *metrics) sessionStarted(kind string) (done func()) {
+	gauge := m.activeSessions.WithLabelValues(kind)
+	gauge.Inc()
+	return gauge.Dec
+}
+
+// byteCounter counts the data written through it, in a metric and in the
+// channel's total
+type byteCounter struct {
+	counter prometheus.Counter
+	total   *atomic.Int64
+}
+
+func (c byteCounter) Write(p []byte) (int, error) {
+	c.counter.Add(float64(len(p)))
+	c.total.Add(int64(len(p)))
+	return len(p), nil
+}
+
+// serveMetrics serves /metrics, /healthz and /readyz on addr until ctx is
+// cancelled
+func (s *Server) serveMetrics(ctx context.Context, addr string) error {
+	mux := http.NewServeMux()
+	mux.Handle("GET /metrics", promhttp.HandlerFor(s.metrics.registry, promhttp.HandlerOpts{}))
+	mux.HandleFunc("GET /healthz", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, "ok")
+	})
+	mux.HandleFunc("GET /readyz", s.readyz)
+
+	httpServer := &http.Server{
+		Addr:              addr,
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		httpServer.Shutdown(shutdownCtx)
+	}()
+
+	log.Printf("Serving metrics and health checks on %s", addr)
+	if err := httpServer.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// readyz reports whether the proxy accepts connections and can authenticate
+// them
+func (s *Server) readyz(w http.ResponseWriter, r *http.Request) {
+	if !s.listening.Load() {
+		http.Error(w, "not listening", http.StatusServiceUnavailable)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 2*time.Second)
+	defer cancel()
+	if err := s.db.Ping(ctx); err != nil {
+		http.Error(w, fmt.Sprintf("database unavailable: %v", err), http.StatusServiceUnavailable)
+		return
+	}
+
+	fmt.Fprintln(w, "ok")
+}
@@ -0,0 +1,163 @@
+package sshproxy
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/rahulvramesh/justup/pkg/database"
+	"golang.org/x/crypto/ssh"
+)
+
+// castRecorder records the output of an interactive session channel in
+// asciicast v2 format (https://docs.asciinema.org/manual/asciicast/v2/).
+// Recording starts when a shell or command is started on a pty; sessions
+// without a pty, such as scp or port forwarding, are not recorded. Input is
+// never recorded, since it includes typed passwords.
+type castRecorder struct {
+	path  string
+	title string
+	audit *auditLog
+
+	mu      sync.Mutex
+	pty     *ptyRequest
+	file    *os.File
+	start   time.Time
+	pending []byte // Incomplete UTF-8 sequence from the last write
+}
+
+// newCastRecorder prepares a recording of a channel. The file is only
+// created once an interactive session starts.
+func newCastRecorder(dir string, audit *auditLog, channel int) *castRecorder {
+	return &castRecorder{
+		path:  filepath.Join(dir, recordingName(audit.sessionID, channel)),
+		title: audit.workspace,
+		audit: audit,
+	}
+}
+
+// recordingName is the file name of a channel's recording
+func recordingName(sessionID string, channel int) string {
+	return fmt.Sprintf("%s-%d.cast", sessionID, channel)
+}
+
+// request observes a channel request from the client
+func (r *castRecorder) request(reqType string, payload []byte) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	switch reqType {
+	case "pty-req":
+		var pty ptyRequest
+		if err := ssh.Unmarshal(payload, &pty); err == nil {
+			r.pty = &pty
+		}
+	case "shell", "exec":
+		if r.pty != nil && r.file == nil {
+			r.begin()
+		}
+	case "window-change":
+		var size windowChange
+		if r.file != nil && ssh.Unmarshal(payload, &size) == nil {
+			r.event("r", fmt.Sprintf("%dx%d", size.Columns, size.Rows))
+		}
+	}
+}
+
+// begin creates the recording and writes its header
+func (r *castRecorder) begin() {
+	if err := os.MkdirAll(filepath.Dir(r.path), 0700); err != nil {
+		log.Printf("Failed to create recording directory: %v", err)
+		return
+	}
+	file, err := os.OpenFile(r.path, os.O_CREATE|os.O_WRONLY|os.O_EXCL, 0600)
+	if err != nil {
+		log.Printf("Failed to create recording %s: %v", r.path, err)
+		return
+	}
+
+	r.file = file
+	r.start = time.Now()
+
+	header := map[string]interface{}{
+		"version":   2,
+		"width":     r.pty.Columns,
+		"height":    r.pty.Rows,
+		"timestamp": r.start.Unix(),
+		"title":     r.title,
+		"env":       map[string]string{"TERM": r.pty.Term},
+	}
+	data, _ := json.Marshal(header)
+	r.file.Write(append(data, '\n'))
+
+	r.audit.record(database.AuditRecording, "%s", filepath.Base(r.path))
+}
+
+// event appends an event line; the caller holds the lock. Lines are
+// written unbuffered so a recording is complete up to a proxy crash.
+func (r *castRecorder) event(code, data string) {
+	line, _ := json.Marshal([]interface{}{time.Since(r.start).Seconds(), code, data})
+	r.file.Write(append(line, '\n'))
+}
+
+// output records data the workspace sent to the client
+func (r *castRecorder) output(p []byte) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.file == nil {
+		return
+	}
+
+	// Hold back a multi-byte character split across writes, since JSON
+	// strings must be valid UTF-8
+	data := append(r.pending, p...)
+	cut := len(data)
+	for back := 1; back <= utf8.UTFMax && back <= len(data); back++ {
+		if utf8.RuneStart(data[len(data)-back]) {
+			if !utf8.FullRune(data[len(data)-back:]) {
+				cut = len(data) - back
+			}
+			break
+		}
+	}
+	r.pending = append([]byte(nil), data[cut:]...)
+
+	if cut > 0 {
+		r.event("o", string(data[:cut]))
+	}
+}
+
+// Close finishes the recording
+func (r *castRecorder) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.file == nil {
+		return nil
+	}
+	if len(r.pending) > 0 {
+		r.event("o", string(r.pending))
+		r.pending = nil
+	}
+	err := r.file.Close()
+	r.file = nil
+	return err
+}
+
+// recorderWriter passes writes through, recording them
+type recorderWriter struct {
+	io.Writer
+	recorder *castRecorder
+}
+
+func (w recorderWriter) Write(p []byte) (int, error) {
+	w.recorder.output(p)
+	return w.Writer.Write(p)
+}
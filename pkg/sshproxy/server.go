@@ -2,15 +2,21 @@ package sshproxy
 
 import (
 	"context"
+	"database/sql"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"net"
 	"os"
 	"sync"
+	"sync/atomic"
+	"time"
 
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/rahulvramesh/justup/pkg/database"
 	"github.com/rahulvramesh/justup/pkg/kubernetes"
+	"github.com/rahulvramesh/justup/pkg/sshca"
 	"golang.org/x/crypto/ssh"
 )
 
@@ -19,25 +25,136 @@ type Config struct {
 	ListenAddr   string
 	HostKeyPath  string
 	DatabasePath string
+	// ClientKeyPath is the private key the proxy uses to authenticate to
+	// workspaces; its public half is published for the controller
+	ClientKeyPath string
+	// RotateClientKey replaces the client key on startup, keeping the old
+	// one valid until workspaces pick up the new key
+	RotateClientKey bool
+	// WakeTimeout bounds how long a connection waits for a stopped workspace
+	// to start; zero disables starting workspaces on connect
+	WakeTimeout time.Duration
+	// IdleTimeout stops workspaces after this long without SSH activity,
+	// unless their spec sets its own timeout; zero disables it
+	IdleTimeout time.Duration
+	// UserCAKeysPath lists CA public keys whose user certificates are
+	// accepted in place of registered keys; empty disables certificates
+	UserCAKeysPath string
+	// RecordingDir stores asciicast recordings of interactive sessions;
+	// empty disables recording
+	RecordingDir string
+	// MetricsAddr serves Prometheus metrics and health checks over HTTP;
+	// empty disables them
+	MetricsAddr string
+	// MaxConnections caps concurrent connections; zero is unlimited
+	MaxConnections int
+	// ConnectionsPerMinute limits new connections per source IP, allowing
+	// bursts of ConnectionBurst; zero is unlimited
+	ConnectionsPerMinute int
+	ConnectionBurst      int
+	// HandshakeTimeout bounds the SSH handshake, extended by WakeTimeout
+	// while a workspace starts; zero is unlimited
+	HandshakeTimeout time.Duration
+	// AuthFailureLimit bans a source IP after this many consecutive
+	// connections that fail to authenticate; zero disables bans
+	AuthFailureLimit int
+	// BanDuration is the length of a first ban; each further ban doubles
+	// it, up to MaxBanDuration
+	BanDuration    time.Duration
+	MaxBanDuration time.Duration
+	// AllowCIDRs, if set, restricts clients to these networks; DenyCIDRs
+	// refuses clients from them
+	AllowCIDRs []string
+	DenyCIDRs  []string
+	// DisableForwarding refuses local and remote port forwarding for every
+	// workspace
+	DisableForwarding bool
+	// ForwardAnyHost lets local forwards reach hosts other than the
+	// workspace itself, such as cluster services
+	ForwardAnyHost bool
+	// SFTP selects who serves the sftp subsystem: SFTPUpstream, SFTPProxy
+	// or SFTPFallback; empty means SFTPUpstream
+	SFTP string
+	// Backend selects how sessions reach workspaces: BackendSSH proxies
+	// to each workspace's SSH server, BackendExec runs them through the
+	// Kubernetes exec API; empty means BackendSSH
+	Backend string
+	// ExecUser is the workspace user that sessions and SFTP run as through
+	// the exec API, where the container runs as root and has that user;
+	// empty runs them as the container's user
+	ExecUser string
+	// Cluster looks up and manages workspaces; nil uses the Kubernetes
+	// cluster from the environment
+	Cluster Cluster
+	// Dial connects to workspace SSH servers; nil dials them directly
+	Dial func(ctx context.Context, network, addr string) (net.Conn, error)
+}
+
+// Cluster is the workspace API the proxy uses, implemented by
+// *kubernetes.Client
+type Cluster interface {
+	GetWorkspace(ctx context.Context, name string) (*kubernetes.Workspace, error)
+	ListWorkspaces(ctx context.Context, includeAll bool) ([]kubernetes.Workspace, error)
+	ListWorkspaceResources(ctx context.Context) ([]kubernetes.WorkspaceResource, error)
+	StartWorkspace(ctx context.Context, name string, overrides kubernetes.StartOptions) error
+	StopWorkspace(ctx context.Context, name string) error
+	RecordWorkspaceActivity(ctx context.Context, name string, at time.Time) error
+	PublishProxyKeys(ctx context.Context, authorizedKeys string) error
+	Exec(ctx context.Context, name string, opts kubernetes.ExecOptions) error
+	DialPort(ctx context.Context, name string, port int) (io.ReadWriteCloser, error)
 }
 
 // Server is the SSH proxy server
 type Server struct {
-	config     *Config
-	sshConfig  *ssh.ServerConfig
-	db         *database.DB
-	k8sClient  *kubernetes.Client
-	hostSigner ssh.Signer
+	config        *Config
+	sshConfig     *ssh.ServerConfig
+	db            *database.DB
+	k8sClient     Cluster
+	hostSigner    ssh.Signer
+	clientSigners []ssh.Signer
+	certChecker   *ssh.CertChecker
+	activity      *activityTracker
+	metrics       *metrics
+	limiter       *connLimiter
+	handshakes    *handshakeDeadlines
+	startedAt     time.Time
+	// listening is set while the SSH listener accepts connections
+	listening atomic.Bool
 }
 
 // NewServer creates a new SSH proxy server
 func NewServer(config *Config) (*Server, error) {
 	// Load or generate host key
-	hostKey, err := loadOrGenerateHostKey(config.HostKeyPath)
+	hostKey, err := loadOrGenerateKey(config.HostKeyPath)
 	if err != nil {
 		return nil, fmt.Errorf("failed to load host key: %w", err)
 	}
 
+	// Load or generate the key used to authenticate to workspaces
+	clientSigners, err := loadClientIdentity(config.ClientKeyPath, config.RotateClientKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load client key: %w", err)
+	}
+
+	// Load the CAs whose user certificates are trusted
+	certChecker, err := loadCertChecker(config.UserCAKeysPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load user CA keys: %w", err)
+	}
+
+	limiter, err := newConnLimiter(config)
+	if err != nil {
+		return nil, err
+	}
+
+	if config.SFTP != "" && !ValidSFTPMode(config.SFTP) {
+		return nil, fmt.Errorf("invalid sftp mode %q; use %s, %s or %s", config.SFTP, SFTPUpstream, SFTPProxy, SFTPFallback)
+	}
+
+	if config.Backend != "" && !ValidBackend(config.Backend) {
+		return nil, fmt.Errorf("invalid backend %q; use %s or %s", config.Backend, BackendSSH, BackendExec)
+	}
+
 	// Open database
 	db, err := database.Open(config.DatabasePath)
 	if err != nil {
@@ -45,16 +162,27 @@ func NewServer(config *Config) (*Server, error) {
 	}
 
 	// Create Kubernetes client
-	k8sClient, err := kubernetes.NewClient()
-	if err != nil {
-		return nil, fmt.Errorf("failed to create kubernetes client: %w", err)
+	k8sClient := config.Cluster
+	if k8sClient == nil {
+		client, err := kubernetes.NewClient()
+		if err != nil {
+			return nil, fmt.Errorf("failed to create kubernetes client: %w", err)
+		}
+		k8sClient = client
 	}
 
 	server := &Server{
-		config:     config,
-		db:         db,
-		k8sClient:  k8sClient,
-		hostSigner: hostKey,
+		config:        config,
+		db:            db,
+		k8sClient:     k8sClient,
+		hostSigner:    hostKey,
+		clientSigners: clientSigners,
+		certChecker:   certChecker,
+		activity:      newActivityTracker(),
+		metrics:       newMetrics(),
+		limiter:       limiter,
+		handshakes:    newHandshakeDeadlines(),
+		startedAt:     time.Now(),
 	}
 
 	// Configure SSH server
@@ -67,14 +195,39 @@ func NewServer(config *Config) (*Server, error) {
 	return server, nil
 }
 
-// ListenAndServe starts the SSH proxy server
+// ListenAndServe starts the SSH proxy server on the configured address
 func (s *Server) ListenAndServe(ctx context.Context) error {
 	listener, err := net.Listen("tcp", s.config.ListenAddr)
 	if err != nil {
 		return fmt.Errorf("failed to listen: %w", err)
 	}
+	return s.Serve(ctx, listener)
+}
+
+// Serve accepts SSH connections on listener until ctx is cancelled
+func (s *Server) Serve(ctx context.Context, listener net.Listener) error {
+	// Publish the client keys; workspaces created before this succeeds need
+	// the key added by hand, so failure is not fatal
+	if err := s.publishClientKeys(ctx); err != nil {
+		log.Printf("Failed to publish proxy client keys: %v", err)
+	}
+
+	go s.runIdleReaper(ctx)
+	go s.limiter.run(ctx)
+
+	if s.config.MetricsAddr != "" {
+		go func() {
+			if err := s.serveMetrics(ctx, s.config.MetricsAddr); err != nil {
+				log.Printf("Failed to serve metrics: %v", err)
+			}
+		}()
+	}
+
 	defer listener.Close()
 
+	s.listening.Store(true)
+	defer s.listening.Store(false)
+
 	// Close listener on context cancellation
 	go func() {
 		<-ctx.Done()
@@ -93,7 +246,19 @@ func (s *Server) ListenAndServe(ctx context.Context) error {
 			}
 		}
 
-		go s.handleConnection(ctx, conn)
+		// Refuse sources that are denied, banned or connecting too often
+		// before spending a handshake on them
+		release, reason := s.limiter.admit(sourceIP(conn.RemoteAddr()))
+		if release == nil {
+			s.metrics.rejected.WithLabelValues(reason).Inc()
+			conn.Close()
+			continue
+		}
+
+		go func() {
+			defer release()
+			s.handleConnection(ctx, conn)
+		}()
 	}
 }
 
@@ -102,39 +267,136 @@ func (s *Server) handleConnection(ctx context.Context, netConn net.Conn) {
 	defer netConn.Close()
 
 	// Perform SSH handshake
+	ip := sourceIP(netConn.RemoteAddr())
+	handshakeStart := time.Now()
+	if s.config.HandshakeTimeout > 0 {
+		s.handshakes.start(netConn, s.config.HandshakeTimeout)
+	}
 	sshConn, chans, reqs, err := ssh.NewServerConn(netConn, s.sshConfig)
+	s.handshakes.finish(netConn)
 	if err != nil {
 		log.Printf("SSH handshake failed: %v", err)
+		s.metrics.connections.WithLabelValues(connectionHandshakeFailed).Inc()
+
+		// Count clients that tried to authenticate and failed, not
+		// scanners that disconnect during key exchange
+		var authErr *ssh.ServerAuthError
+		if errors.As(err, &authErr) && len(authErr.Errors) > 0 {
+			if ban := s.limiter.authFailed(ip); ban > 0 {
+				log.Printf("Banned %s for %s after repeated authentication failures", ip, ban)
+				s.metrics.bans.Inc()
+			}
+		}
 		return
 	}
 	defer sshConn.Close()
+	s.authenticated(sshConn)
+	s.limiter.authSucceeded(ip)
+	s.metrics.handshakeSeconds.Observe(time.Since(handshakeStart).Seconds())
+
+	audit := s.newAuditLog(sshConn)
+	audit.record(database.AuditConnect, "%s", sshConn.ClientVersion())
+	connectedAt := time.Now()
+	defer func() {
+		audit.record(database.AuditDisconnect, "after %s", time.Since(connectedAt).Round(time.Second))
+	}()
+
+	// The reserved management user runs commands in the proxy itself
+	if sshConn.User() == managementUser {
+		log.Printf("Management connection from %s", sshConn.RemoteAddr())
+		s.metrics.connections.WithLabelValues(connectionManagement).Inc()
+		defer s.metrics.sessionStarted("management")()
+		s.handleManagement(ctx, sshConn, chans, reqs, audit)
+		return
+	}
 
 	// Extract workspace name from username
 	workspaceName := sshConn.User()
 	log.Printf("Connection from %s for workspace '%s'", sshConn.RemoteAddr(), workspaceName)
+	defer s.metrics.sessionStarted("workspace")()
+
+	// fail logs why the connection cannot be proxied and audits it
+	fail := func(format string, args ...interface{}) {
+		msg := fmt.Sprintf(format, args...)
+		log.Print(msg)
+		audit.record(database.AuditError, "%s", msg)
+		s.metrics.connections.WithLabelValues(connectionUpstreamFailed).Inc()
+	}
 
 	// Get workspace pod IP
 	ws, err := s.k8sClient.GetWorkspace(ctx, workspaceName)
 	if err != nil {
-		log.Printf("Failed to get workspace '%s': %v", workspaceName, err)
+		fail("Failed to get workspace '%s': %v", workspaceName, err)
 		return
 	}
 
-	if ws.Status != "Running" {
-		log.Printf("Workspace '%s' is not running (status: %s)", workspaceName, ws.Status)
+	if ws.Status != kubernetes.PhaseRunning {
+		fail("Workspace '%s' is not running (status: %s)", workspaceName, ws.Status)
 		return
 	}
 
 	if ws.PodIP == "" {
-		log.Printf("Workspace '%s' has no IP address", workspaceName)
+		fail("Workspace '%s' has no IP address", workspaceName)
+		return
+	}
+
+	// Port forwards must pass the workspace's policy; read-only users may
+	// only forward local ports
+	policy := s.forwardPolicy(ws, sshConn.Permissions.Extensions["role"] == database.RoleReadOnly)
+
+	// Channel traffic in either direction counts as workspace activity. A
+	// certificate's force-command replaces whatever the client runs.
+	var rewrite requestRewriter
+	if command, ok := sshConn.Permissions.CriticalOptions[sshca.OptionForceCommand]; ok {
+		rewrite = forceCommandRewriter(command)
+	}
+	touch := func() { s.activity.touch(workspaceName) }
+	touch()
+	toWorkspace := s.metrics.bytes.WithLabelValues(workspaceName, "to_workspace")
+	fromWorkspace := s.metrics.bytes.WithLabelValues(workspaceName, "from_workspace")
+
+	// The exec backend serves the connection in the proxy
+	if s.config.Backend == BackendExec {
+		s.metrics.connections.WithLabelValues(connectionProxied).Inc()
+		(&execConn{
+			ctx:          ctx,
+			cluster:      s.k8sClient,
+			workspace:    workspaceName,
+			policy:       policy,
+			rewrite:      rewrite,
+			touch:        touch,
+			sent:         toWorkspace,
+			received:     fromWorkspace,
+			audit:        audit,
+			recordingDir: s.config.RecordingDir,
+			user:         s.config.ExecUser,
+		}).serve(chans, reqs)
+		log.Printf("Connection closed for workspace '%s'", workspaceName)
+		return
+	}
+
+	// Pin the host key generated for the workspace so a pod that took
+	// over its IP cannot impersonate it
+	if ws.HostKey == "" {
+		fail("Workspace '%s' has no published host key; restart it to generate one", workspaceName)
+		return
+	}
+	hostKey, err := kubernetes.ParseHostKey(ws.HostKey)
+	if err != nil {
+		fail("Workspace '%s': %v", workspaceName, err)
 		return
 	}
 
 	// Connect to the workspace pod
-	targetAddr := fmt.Sprintf("%s:22", ws.PodIP)
-	targetConn, err := net.Dial("tcp", targetAddr)
+	dialStart := time.Now()
+	dialFailed := func() {
+		s.metrics.dialSeconds.WithLabelValues("error").Observe(time.Since(dialStart).Seconds())
+	}
+	targetAddr := net.JoinHostPort(ws.PodIP, "22")
+	targetConn, err := s.dial(ctx, "tcp", targetAddr)
 	if err != nil {
-		log.Printf("Failed to connect to workspace '%s' at %s: %v", workspaceName, targetAddr, err)
+		dialFailed()
+		fail("Failed to connect to workspace '%s' at %s: %v", workspaceName, targetAddr, err)
 		return
 	}
 	defer targetConn.Close()
@@ -143,16 +405,34 @@ func (s *Server) handleConnection(ctx context.Context, netConn net.Conn) {
 	targetSSHConn, targetChans, targetReqs, err := ssh.NewClientConn(targetConn, targetAddr, &ssh.ClientConfig{
 		User: "dev",
 		Auth: []ssh.AuthMethod{
-			// Use the same key that was used to authenticate
-			ssh.PublicKeys(s.hostSigner),
+			// The current client key, then the previous one for workspaces
+			// not restarted since the last rotation
+			ssh.PublicKeys(s.clientSigners...),
 		},
-		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+		HostKeyCallback:   ssh.FixedHostKey(hostKey),
+		HostKeyAlgorithms: []string{hostKey.Type()},
 	})
 	if err != nil {
-		log.Printf("Failed to establish SSH connection to workspace '%s': %v", workspaceName, err)
+		dialFailed()
+		fail("Failed to establish SSH connection to workspace '%s': %v", workspaceName, err)
 		return
 	}
 	defer targetSSHConn.Close()
+	s.metrics.dialSeconds.WithLabelValues("success").Observe(time.Since(dialStart).Seconds())
+	s.metrics.connections.WithLabelValues(connectionProxied).Inc()
+
+	allowRequest := func(req *ssh.Request) bool {
+		desc, err := policy.globalRequest(req)
+		if desc == "" {
+			return true
+		}
+		if err != nil {
+			audit.record(database.AuditForwardRejected, "%s: %v", desc, err)
+			return false
+		}
+		audit.record(database.AuditForward, "%s", desc)
+		return true
+	}
 
 	// Proxy the connection
 	var wg sync.WaitGroup
@@ -161,57 +441,195 @@ func (s *Server) handleConnection(ctx context.Context, netConn net.Conn) {
 	// Proxy global requests
 	go func() {
 		defer wg.Done()
-		proxyRequests(reqs, targetSSHConn)
+		proxyRequests(reqs, targetSSHConn, allowRequest)
 	}()
 
 	go func() {
 		defer wg.Done()
-		proxyRequests(targetReqs, sshConn)
+		proxyRequests(targetReqs, sshConn, nil)
 	}()
 
 	// Proxy channels
-	go proxyChannels(chans, targetSSHConn)
-	go proxyChannels(targetChans, sshConn)
+	go (&channelProxy{
+		target:       targetSSHConn,
+		allow:        policy.clientChannel,
+		rewrite:      rewrite,
+		touch:        touch,
+		sent:         toWorkspace,
+		received:     fromWorkspace,
+		audit:        audit,
+		recordingDir: s.config.RecordingDir,
+		sftpMode:     s.config.SFTP,
+		serveSFTP: func(channel io.ReadWriteCloser) error {
+			return serveSFTP(ctx, s.k8sClient, workspaceName, s.config.ExecUser, channel)
+		},
+	}).serve(chans)
+	go (&channelProxy{
+		target:        sshConn,
+		allow:         allowAllChannels,
+		touch:         touch,
+		sent:          fromWorkspace,
+		received:      toWorkspace,
+		audit:         audit,
+		fromWorkspace: true,
+	}).serve(targetChans)
 
 	// Wait for connection to close
 	wg.Wait()
 	log.Printf("Connection closed for workspace '%s'", workspaceName)
 }
 
-// publicKeyCallback validates a public key for authentication
+// publicKeyCallback validates a public key or user certificate for
+// authentication
 func (s *Server) publicKeyCallback(conn ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
-	fingerprint := ssh.FingerprintSHA256(key)
 	workspaceName := conn.User()
 
-	log.Printf("Auth attempt for workspace '%s' with key %s", workspaceName, fingerprint)
+	log.Printf("Auth attempt for workspace '%s' with key %s", workspaceName, ssh.FingerprintSHA256(key))
 
-	// Look up the key in the database
-	sshKey, err := s.db.GetSSHKeyByFingerprint(fingerprint)
+	id, err := s.identify(key)
 	if err != nil {
-		log.Printf("Key not found: %s", fingerprint)
-		return nil, fmt.Errorf("unknown public key")
+		s.auditAuth(conn, "", key.Type()+" "+ssh.FingerprintSHA256(key), err)
+		if _, ok := key.(*ssh.Certificate); ok {
+			s.metrics.authFailed(authInvalidCertificate)
+		} else {
+			s.metrics.authFailed(authUnknownKey)
+		}
+		return nil, err
 	}
 
-	// Update last used timestamp
-	s.db.UpdateSSHKeyLastUsed(sshKey.ID)
-
-	log.Printf("Auth successful for workspace '%s' (user: %s, key: %s)", workspaceName, sshKey.UserID, sshKey.Name)
+	if err := id.checkSourceAddress(conn.RemoteAddr()); err != nil {
+		log.Printf("Rejected %s: %v", id.name, err)
+		s.auditAuth(conn, id.userID, id.name, err)
+		s.metrics.authFailed(authRestrictedCertificate)
+		return nil, fmt.Errorf("access denied")
+	}
 
-	return &ssh.Permissions{
+	perms := &ssh.Permissions{
+		// Returned critical options are enforced by the proxy: source-address
+		// above, force-command on sessions
+		CriticalOptions: id.criticalOptions,
 		Extensions: map[string]string{
-			"user-id":     sshKey.UserID,
-			"key-id":      sshKey.ID,
-			"fingerprint": fingerprint,
+			"user-id":     id.userID,
+			"key-id":      id.keyID,
+			"key-name":    id.name,
+			"fingerprint": id.fingerprint,
 		},
-	}, nil
+	}
+	if id.keyID == "" {
+		perms.Extensions["certificate"] = id.name
+	}
+
+	// Any registered key may run management commands; each command checks
+	// the user's access to the workspaces it touches
+	if workspaceName == managementUser {
+		if id.workspaces != nil {
+			log.Printf("Management denied for %s restricted to workspaces", id.name)
+			s.auditAuth(conn, id.userID, id.name, fmt.Errorf("certificate restricted to workspaces"))
+			s.metrics.authFailed(authRestrictedCertificate)
+			return nil, fmt.Errorf("access denied")
+		}
+		return perms, nil
+	}
+
+	// Check that the user owns or has been granted the workspace, and that
+	// a certificate is not restricted to other workspaces
+	role, err := s.workspaceRole(context.Background(), workspaceName, id.userID)
+	if err != nil || !id.allowsWorkspace(workspaceName) {
+		log.Printf("Access denied for workspace '%s' (user: %s, key: %s)", workspaceName, id.userID, id.name)
+		s.auditAuth(conn, id.userID, id.name, fmt.Errorf("no access to workspace"))
+		s.metrics.authFailed(authAccessDenied)
+		return nil, fmt.Errorf("access denied")
+	}
+
+	perms.Extensions["role"] = role
+
+	// A stopped workspace is started before authentication completes. That
+	// happens in a keyboard-interactive round, so the client is shown
+	// progress instead of hanging silently, and so nothing is looked up or
+	// waited for until the key's signature has been verified: this callback
+	// also runs for keys offered without one.
+	if s.config.WakeTimeout > 0 {
+		return nil, &ssh.PartialSuccessError{
+			Next: ssh.ServerAuthCallbacks{
+				KeyboardInteractiveCallback: s.wakeCallback(workspaceName, perms),
+			},
+		}
+	}
+
+	return perms, nil
 }
 
-// proxyRequests proxies SSH global requests
-func proxyRequests(reqs <-chan *ssh.Request, conn ssh.Conn) {
+// workspaceRole returns the role a user has on a workspace. Owners and
+// grants are recorded in the database; workspaces created with a kubeconfig
+// have no record there, so the owner annotation of the Workspace resource
+// is checked as a fallback. It returns sql.ErrNoRows if the user has no
+// access.
+func (s *Server) workspaceRole(ctx context.Context, workspaceName, userID string) (string, error) {
+	role, err := s.db.GetWorkspaceRole(workspaceName, userID)
+	if !errors.Is(err, sql.ErrNoRows) {
+		return role, err
+	}
+
+	// A recorded workspace has its owner in the database
+	if _, recordErr := s.db.GetWorkspace(workspaceName); recordErr == nil {
+		return "", err
+	}
+	ws, wsErr := s.k8sClient.GetWorkspace(ctx, workspaceName)
+	if wsErr != nil || ws.Owner == "" {
+		return "", err
+	}
+	user, userErr := s.db.GetUser(userID)
+	if userErr != nil || user.Username != ws.Owner {
+		return "", err
+	}
+	return database.RoleOwner, nil
+}
+
+// dial connects to a workspace's SSH server
+func (s *Server) dial(ctx context.Context, network, addr string) (net.Conn, error) {
+	if s.config.Dial != nil {
+		return s.config.Dial(ctx, network, addr)
+	}
+	var dialer net.Dialer
+	return dialer.DialContext(ctx, network, addr)
+}
+
+// authenticated logs and audits a connection that completed
+// authentication, and marks its key used. The public key callback also runs
+// for keys offered without a signature, so success is only known once the
+// handshake is over.
+func (s *Server) authenticated(conn *ssh.ServerConn) {
+	ext := conn.Permissions.Extensions
+	detail := ext["key-name"]
+	if conn.User() == managementUser {
+		log.Printf("Auth successful for management (user: %s, key: %s)", ext["user-id"], ext["key-name"])
+	} else {
+		log.Printf("Auth successful for workspace '%s' (user: %s, key: %s, role: %s)", conn.User(), ext["user-id"], ext["key-name"], ext["role"])
+		detail = fmt.Sprintf("%s as %s", ext["key-name"], ext["role"])
+	}
+	s.auditAuth(conn, ext["user-id"], detail, nil)
+
+	// Certificates have no registered key
+	if keyID := ext["key-id"]; keyID != "" {
+		if err := s.db.UpdateSSHKeyLastUsed(keyID); err != nil {
+			log.Printf("Failed to update last use of key %s: %v", keyID, err)
+		}
+	}
+}
+
+// proxyRequests proxies SSH global requests, refusing those allow rejects
+// if set
+func proxyRequests(reqs <-chan *ssh.Request, conn ssh.Conn, allow func(req *ssh.Request) bool) {
 	for req := range reqs {
 		if req == nil {
 			return
 		}
+		if allow != nil && !allow(req) {
+			if req.WantReply {
+				req.Reply(false, nil)
+			}
+			continue
+		}
 		ok, payload, err := conn.SendRequest(req.Type, req.WantReply, req.Payload)
 		if err != nil {
 			return
@@ -222,80 +640,219 @@ func proxyRequests(reqs <-chan *ssh.Request, conn ssh.Conn) {
 	}
 }
 
-// proxyChannels proxies SSH channels
-func proxyChannels(chans <-chan ssh.NewChannel, conn ssh.Conn) {
+// allowAllChannels permits every channel
+func allowAllChannels(ssh.NewChannel) error { return nil }
+
+// requestRewriter changes a channel request before it is proxied, returning
+// false to refuse it
+type requestRewriter func(req *ssh.Request) (reqType string, payload []byte, ok bool)
+
+// channelProxy proxies the channels one side of a connection opens to the
+// other side
+type channelProxy struct {
+	target ssh.Conn
+	// allow refuses channels with the reason
+	allow func(newChan ssh.NewChannel) error
+	// rewrite, if set, changes requests on session channels
+	rewrite requestRewriter
+	// touch is called whenever data flows
+	touch func()
+	// sent and received count the data written to the target and back
+	sent, received prometheus.Counter
+	audit          *auditLog
+	// fromWorkspace marks channels the workspace opens, such as remote
+	// forwards; only client sessions are recorded
+	fromWorkspace bool
+	// recordingDir enables asciicast recording of interactive sessions
+	recordingDir string
+	// sftpMode and serveSFTP let the proxy serve the sftp subsystem of
+	// client sessions itself
+	sftpMode  string
+	serveSFTP func(channel io.ReadWriteCloser) error
+}
+
+// serve proxies channels until chans is closed
+func (p *channelProxy) serve(chans <-chan ssh.NewChannel) {
 	for newChan := range chans {
 		if newChan == nil {
 			return
 		}
+		p.proxy(newChan)
+	}
+}
 
-		// Open a channel on the target
-		targetChan, targetReqs, err := conn.OpenChannel(newChan.ChannelType(), newChan.ExtraData())
-		if err != nil {
-			newChan.Reject(ssh.ConnectionFailed, err.Error())
-			continue
+// proxy opens a new channel on the target and proxies it
+func (p *channelProxy) proxy(newChan ssh.NewChannel) {
+	channel := p.audit.nextChannel()
+	desc := auditChannel(newChan)
+	if p.fromWorkspace {
+		desc += " from workspace"
+	}
+
+	if err := p.allow(newChan); err != nil {
+		p.audit.record(database.AuditChannelRejected, "channel %d: %s: %v", channel, desc, err)
+		newChan.Reject(ssh.Prohibited, err.Error())
+		return
+	}
+
+	// Open a channel on the target
+	targetChan, targetReqs, err := p.target.OpenChannel(newChan.ChannelType(), newChan.ExtraData())
+	if err != nil {
+		p.audit.record(database.AuditChannelRejected, "channel %d: %s: %v", channel, desc, err)
+		newChan.Reject(ssh.ConnectionFailed, err.Error())
+		return
+	}
+
+	// Accept the channel from the client
+	clientChan, clientReqs, err := newChan.Accept()
+	if err != nil {
+		targetChan.Close()
+		return
+	}
+	p.audit.record(database.AuditChannelOpen, "channel %d: %s", channel, desc)
+
+	// Account for the channel's traffic
+	var sent, received atomic.Int64
+	input := io.TeeReader(clientChan, byteCounter{p.sent, &sent})
+
+	// Client sessions are audited, and recorded if enabled
+	var (
+		rewrite  requestRewriter
+		observe  func(reqType string, payload []byte)
+		recorder *castRecorder
+		output   io.Writer = activityWriter{clientChan, p.touch}
+		send               = targetChan.SendRequest
+		start    *sessionStart
+	)
+	if newChan.ChannelType() == "session" && !p.fromWorkspace {
+		rewrite = p.rewrite
+		if p.recordingDir != "" {
+			recorder = newCastRecorder(p.recordingDir, p.audit, channel)
+			output = recorderWriter{output, recorder}
+		}
+		observe = func(reqType string, payload []byte) {
+			p.audit.auditRequest(channel, reqType, payload)
+			if recorder != nil {
+				recorder.request(reqType, payload)
+			}
 		}
 
-		// Accept the channel from the client
-		clientChan, clientReqs, err := newChan.Accept()
-		if err != nil {
-			targetChan.Close()
-			continue
+		// The proxy may serve the sftp subsystem itself
+		if p.sftpMode == SFTPProxy || p.sftpMode == SFTPFallback {
+			start = newSessionStart()
+			stream := channelStream{
+				Reader: input,
+				Writer: io.MultiWriter(activityWriter{clientChan, p.touch}, byteCounter{p.received, &received}),
+			}
+			send = p.sessionSender(channel, clientChan, stream, targetChan, start)
 		}
+	}
 
-		// Proxy channel data bidirectionally
-		go func() {
-			defer clientChan.Close()
-			defer targetChan.Close()
+	// Proxy channel data bidirectionally
+	go func() {
+		defer clientChan.Close()
+		defer targetChan.Close()
+		if recorder != nil {
+			defer recorder.Close()
+		}
 
-			var wg sync.WaitGroup
-			wg.Add(4)
+		// Account for the channel's traffic when it closes
+		defer func() {
+			p.audit.record(database.AuditChannelClose, "channel %d: %d bytes sent, %d received", channel, sent.Load(), received.Load())
+		}()
 
-			// Proxy data
-			go func() {
-				defer wg.Done()
-				io.Copy(targetChan, clientChan)
-				targetChan.CloseWrite()
-			}()
-			go func() {
-				defer wg.Done()
-				io.Copy(clientChan, targetChan)
-				clientChan.CloseWrite()
-			}()
+		var wg sync.WaitGroup
+		wg.Add(4)
+		inputDone := make(chan struct{})
+		outputDone := make(chan struct{})
 
-			// Proxy channel requests
-			go func() {
-				defer wg.Done()
-				proxyChannelRequests(clientReqs, targetChan)
-			}()
+		// Proxy data, and stderr from the target. Client input to a session
+		// the proxy may serve waits until the session starts.
+		go func() {
+			defer wg.Done()
+			defer close(inputDone)
+			if start != nil && start.wait() {
+				return
+			}
+			io.Copy(activityWriter{targetChan, p.touch}, input)
+			targetChan.CloseWrite()
+		}()
+		go func() {
+			defer wg.Done()
+			defer close(outputDone)
+			stderrDone := make(chan struct{})
 			go func() {
-				defer wg.Done()
-				proxyChannelRequests(targetReqs, clientChan)
+				defer close(stderrDone)
+				io.Copy(activityWriter{clientChan.Stderr(), p.touch}, io.TeeReader(targetChan.Stderr(), byteCounter{p.received, &received}))
 			}()
+			io.Copy(output, io.TeeReader(targetChan, byteCounter{p.received, &received}))
+			<-stderrDone
+			if start == nil || !start.servedByProxy() {
+				clientChan.CloseWrite()
+			}
+		}()
 
-			wg.Wait()
+		// Proxy channel requests. A side's requests end when it closes the
+		// channel; the other side's channel is closed in turn once the data
+		// already received has been passed on.
+		go func() {
+			defer wg.Done()
+			proxyChannelRequests(clientReqs, send, rewrite, observe)
+			// Release the input of a session that never started
+			if start != nil {
+				start.decide(false)
+			}
+			<-inputDone
+			targetChan.Close()
 		}()
-	}
+		go func() {
+			defer wg.Done()
+			proxyChannelRequests(targetReqs, clientChan.SendRequest, nil, nil)
+			<-outputDone
+			if start == nil || !start.servedByProxy() {
+				clientChan.Close()
+			}
+		}()
+
+		wg.Wait()
+	}()
 }
 
-// proxyChannelRequests proxies SSH channel requests
-func proxyChannelRequests(reqs <-chan *ssh.Request, ch ssh.Channel) {
+// proxyChannelRequests proxies SSH channel requests through send, passing
+// them through rewrite if set. observe, if set, sees each request as it is
+// forwarded.
+func proxyChannelRequests(reqs <-chan *ssh.Request, send func(reqType string, wantReply bool, payload []byte) (bool, error), rewrite requestRewriter, observe func(reqType string, payload []byte)) {
 	for req := range reqs {
 		if req == nil {
 			return
 		}
-		ok, err := ch.SendRequest(req.Type, req.WantReply, req.Payload)
+		reqType, payload := req.Type, req.Payload
+		if rewrite != nil {
+			var allowed bool
+			if reqType, payload, allowed = rewrite(req); !allowed {
+				if req.WantReply {
+					req.Reply(false, nil)
+				}
+				continue
+			}
+		}
+		if observe != nil {
+			observe(reqType, payload)
+		}
+		ok, err := send(reqType, req.WantReply, payload)
 		if err != nil {
 			return
 		}
+		// Unlike global requests, channel request replies carry no data
+		// (RFC 4254, section 5.4), so there is none to pass on
 		if req.WantReply {
 			req.Reply(ok, nil)
 		}
 	}
 }
 
-// loadOrGenerateHostKey loads an existing host key or generates a new one
-func loadOrGenerateHostKey(path string) (ssh.Signer, error) {
+// loadOrGenerateKey loads an existing private key or generates a new one
+func loadOrGenerateKey(path string) (ssh.Signer, error) {
 	// Try to load existing key
 	keyBytes, err := os.ReadFile(path)
 	if err == nil {
@@ -306,6 +863,6 @@ func loadOrGenerateHostKey(path string) (ssh.Signer, error) {
 	}
 
 	// Generate new key if loading failed
-	log.Printf("Generating new host key at %s", path)
+	log.Printf("Generating new key at %s", path)
 	return generateHostKey(path)
 }
@@ -0,0 +1,651 @@
+package sshproxy
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/sftp"
+	"github.com/rahulvramesh/justup/pkg/kubernetes"
+)
+
+const (
+	// SFTPUpstream passes the sftp subsystem to the workspace's SSH server
+	SFTPUpstream = "upstream"
+	// SFTPProxy serves the sftp subsystem in the proxy over the Kubernetes
+	// exec API
+	SFTPProxy = "proxy"
+	// SFTPFallback serves the sftp subsystem in the proxy when the
+	// workspace's SSH server refuses it
+	SFTPFallback = "fallback"
+)
+
+const (
+	// statFormat is the stat(1) format execFS parses: raw mode in hex, size,
+	// modification time, owner, group and name
+	statFormat = "%f %s %Y %u %g %n"
+	// readWindow is how far reads may jump back or ahead of an open file's
+	// stream before it is restarted at the new offset. Clients pipeline
+	// reads, so they arrive slightly out of order.
+	readWindow = 1 << 20
+	// maxPendingWrite bounds the out-of-order writes held back until the
+	// data before them arrives
+	maxPendingWrite = 8 << 20
+)
+
+// ValidSFTPMode reports whether mode is a known sftp subsystem mode
+func ValidSFTPMode(mode string) bool {
+	return mode == SFTPUpstream || mode == SFTPProxy || mode == SFTPFallback
+}
+
+// serveSFTP serves the sftp subsystem on a channel, running commands in the
+// workspace's container to carry out each request. This works with any
+// image that has a POSIX shell and coreutils or busybox, and needs no SSH
+// server. Commands run as user, like exec backend sessions; see asUser.
+func serveSFTP(ctx context.Context, cluster Cluster, workspaceName, user string, channel io.ReadWriteCloser) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	fsys := &execFS{
+		ctx:       ctx,
+		cluster:   cluster,
+		workspace: workspaceName,
+		user:      user,
+		writers:   make(map[*execWriter]bool),
+	}
+
+	// Relative paths resolve against the container's working directory
+	out, err := fsys.run("pwd", nil)
+	if err != nil {
+		return fmt.Errorf("failed to reach workspace: %w", err)
+	}
+
+	server := sftp.NewRequestServer(channel, sftp.Handlers{
+		FileGet:  fsys,
+		FilePut:  fsys,
+		FileCmd:  fsys,
+		FileList: fsys,
+	}, sftp.WithStartDirectory(strings.TrimSpace(string(out))))
+	defer server.Close()
+
+	if err := server.Serve(); err != nil && !errors.Is(err, io.EOF) {
+		return err
+	}
+	return nil
+}
+
+// execFS carries out SFTP requests with shell commands run in a workspace
+// container through the Kubernetes exec API
+type execFS struct {
+	ctx       context.Context
+	cluster   Cluster
+	workspace string
+	user      string
+
+	mu      sync.Mutex
+	writers map[*execWriter]bool // Files open for writing
+}
+
+// run runs a shell script in the workspace with args as its positional
+// parameters, returning its output
+func (f *execFS) run(script string, stdin io.Reader, args ...string) ([]byte, error) {
+	var stdout, stderr bytes.Buffer
+	err := f.cluster.Exec(f.ctx, f.workspace, kubernetes.ExecOptions{
+		Command: asUser(f.user, append([]string{"sh", "-c", script, "sh"}, args...)...),
+		Stdin:   stdin,
+		Stdout:  &stdout,
+		Stderr:  &stderr,
+	})
+	if err != nil {
+		return nil, commandError(err, stderr.String())
+	}
+	return stdout.Bytes(), nil
+}
+
+// start runs a shell script in the background, returning a function that
+// waits for it to exit. An io.PipeReader given as stdin is closed when the
+// script exits, so writes to it do not block.
+func (f *execFS) start(ctx context.Context, script string, stdin io.Reader, stdout io.Writer, args ...string) (wait func() error) {
+	done := make(chan error, 1)
+	go func() {
+		var stderr bytes.Buffer
+		err := f.cluster.Exec(ctx, f.workspace, kubernetes.ExecOptions{
+			Command: asUser(f.user, append([]string{"sh", "-c", script, "sh"}, args...)...),
+			Stdin:   stdin,
+			Stdout:  stdout,
+			Stderr:  &stderr,
+		})
+		if err != nil {
+			err = commandError(err, stderr.String())
+		}
+		if pipe, ok := stdin.(*io.PipeReader); ok {
+			pipe.CloseWithError(errOrClosed(err))
+		}
+		if pipe, ok := stdout.(*io.PipeWriter); ok {
+			pipe.CloseWithError(err)
+		}
+		done <- err
+	}()
+	return func() error { return <-done }
+}
+
+// commandError turns a failed command's error output into an error SFTP
+// clients understand
+func commandError(err error, stderr string) error {
+	msg := strings.TrimSpace(stderr)
+	if i := strings.LastIndexByte(msg, '\n'); i >= 0 {
+		msg = msg[i+1:]
+	}
+	switch {
+	case strings.Contains(msg, "No such file"), strings.Contains(msg, "can't cd"):
+		return &fs.PathError{Op: "exec", Path: msg, Err: fs.ErrNotExist}
+	case strings.Contains(msg, "Permission denied"):
+		return &fs.PathError{Op: "exec", Path: msg, Err: fs.ErrPermission}
+	case strings.Contains(msg, "File exists"), strings.Contains(msg, "cannot overwrite"):
+		return &fs.PathError{Op: "exec", Path: msg, Err: fs.ErrExist}
+	case msg != "":
+		return errors.New(msg)
+	}
+	if _, ok := kubernetes.ExitCode(err); ok {
+		return sftp.ErrSSHFxFailure
+	}
+	return err
+}
+
+func errOrClosed(err error) error {
+	if err == nil {
+		return io.ErrClosedPipe
+	}
+	return err
+}
+
+// Fileread opens a file for reading
+func (f *execFS) Fileread(r *sftp.Request) (io.ReaderAt, error) {
+	if err := f.flush(r.Filepath); err != nil {
+		return nil, err
+	}
+	if _, err := f.run(`[ -r "$1" ] && [ ! -d "$1" ] || cat -- "$1"`, nil, r.Filepath); err != nil {
+		return nil, err
+	}
+	return &execReader{fs: f, path: r.Filepath}, nil
+}
+
+// Filewrite opens a file for writing. Data is appended by a single command,
+// so writes must continue from where the file ends: either an empty file
+// or, when resuming an upload, the file's current size.
+func (f *execFS) Filewrite(r *sftp.Request) (io.WriterAt, error) {
+	flags := r.Pflags()
+	mode := "keep"
+	switch {
+	case flags.Excl:
+		mode = "excl"
+	case flags.Trunc:
+		mode = "trunc"
+	}
+
+	// Create or truncate the file first so errors are reported on open
+	out, err := f.run(`case "$2" in
+excl) set -C; : > "$1" ;;
+trunc) : > "$1" ;;
+*) : >> "$1" ;;
+esac && wc -c < "$1"`, nil, r.Filepath, mode)
+	if err != nil {
+		return nil, err
+	}
+	size, err := strconv.ParseInt(strings.TrimSpace(string(out)), 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("unexpected file size %q", out)
+	}
+
+	w := &execWriter{
+		fs:      f,
+		path:    r.Filepath,
+		pos:     size,
+		pending: make(map[int64][]byte),
+	}
+	f.mu.Lock()
+	f.writers[w] = true
+	f.mu.Unlock()
+	return w, nil
+}
+
+// flush makes the data written to files open at path visible to other
+// commands, such as one truncating the file after an upload
+func (f *execFS) flush(path string) error {
+	f.mu.Lock()
+	var writers []*execWriter
+	for w := range f.writers {
+		if w.path == path {
+			writers = append(writers, w)
+		}
+	}
+	f.mu.Unlock()
+
+	for _, w := range writers {
+		w.mu.Lock()
+		err := w.flush()
+		w.mu.Unlock()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Filecmd carries out requests that change files
+func (f *execFS) Filecmd(r *sftp.Request) error {
+	if err := f.flush(r.Filepath); err != nil {
+		return err
+	}
+
+	var err error
+	switch r.Method {
+	case "Setstat":
+		err = f.setstat(r)
+	case "Rename":
+		// SFTP version 3 renames refuse to replace an existing file
+		_, err = f.run(`if [ -e "$2" ] || [ -L "$2" ]; then echo "$2: File exists" >&2; exit 1; fi; mv -- "$1" "$2"`, nil, r.Filepath, r.Target)
+	case "Rmdir":
+		_, err = f.run(`rmdir -- "$1"`, nil, r.Filepath)
+	case "Remove":
+		_, err = f.run(`if [ -d "$1" ] && [ ! -L "$1" ]; then echo "$1: Is a directory" >&2; exit 1; fi; rm -- "$1"`, nil, r.Filepath)
+	case "Mkdir":
+		_, err = f.run(`mkdir -- "$1"`, nil, r.Filepath)
+	case "Link":
+		_, err = f.run(`ln -- "$1" "$2"`, nil, r.Filepath, r.Target)
+	case "Symlink":
+		// Filepath is the link's target and Target the link itself
+		_, err = f.run(`ln -s -- "$1" "$2"`, nil, r.Filepath, r.Target)
+	default:
+		err = sftp.ErrSSHFxOpUnsupported
+	}
+	return err
+}
+
+// PosixRename renames a file, replacing the target if it exists
+func (f *execFS) PosixRename(r *sftp.Request) error {
+	if err := f.flush(r.Filepath); err != nil {
+		return err
+	}
+	_, err := f.run(`mv -f -- "$1" "$2"`, nil, r.Filepath, r.Target)
+	return err
+}
+
+// setstat changes a file's size, permissions, owner or times
+func (f *execFS) setstat(r *sftp.Request) error {
+	attrs := r.Attributes()
+	flags := r.AttrFlags()
+
+	if flags.Size {
+		if _, err := f.run(`truncate -s "$2" -- "$1"`, nil, r.Filepath, strconv.FormatUint(attrs.Size, 10)); err != nil {
+			return err
+		}
+	}
+	if flags.Permissions {
+		perm := strconv.FormatUint(uint64(attrs.Mode&07777), 8)
+		if _, err := f.run(`chmod "$2" -- "$1"`, nil, r.Filepath, perm); err != nil {
+			return err
+		}
+	}
+	if flags.UidGid {
+		owner := fmt.Sprintf("%d:%d", attrs.UID, attrs.GID)
+		if _, err := f.run(`chown "$2" -- "$1"`, nil, r.Filepath, owner); err != nil {
+			return err
+		}
+	}
+	if flags.Acmodtime {
+		// Times are passed in UTC in a format GNU and busybox touch accept
+		const layout = "2006-01-02 15:04:05"
+		atime := time.Unix(int64(attrs.Atime), 0).UTC().Format(layout)
+		mtime := time.Unix(int64(attrs.Mtime), 0).UTC().Format(layout)
+		if _, err := f.run(`TZ=UTC0 touch -c -a -d "$2" -- "$1" && TZ=UTC0 touch -c -m -d "$3" -- "$1"`, nil, r.Filepath, atime, mtime); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Filelist lists a directory, stats a file following symlinks or reads a
+// symlink
+func (f *execFS) Filelist(r *sftp.Request) (sftp.ListerAt, error) {
+	switch r.Method {
+	case "List":
+		out, err := f.run(`cd -- "$1" || exit
+format=$2
+shift 2
+for f in .[!.]* ..?* *; do
+	if [ -e "$f" ] || [ -L "$f" ]; then set -- "$@" "$f"; fi
+done
+[ $# -eq 0 ] || exec stat -c "$format" -- "$@"`, nil, r.Filepath, statFormat)
+		if err != nil {
+			return nil, err
+		}
+		return parseStats(out)
+	case "Stat":
+		return f.stat(r.Filepath, "-L")
+	case "Readlink":
+		out, err := f.run(`readlink -- "$1"`, nil, r.Filepath)
+		if err != nil {
+			return nil, err
+		}
+		target := strings.TrimSuffix(string(out), "\n")
+		return listerAt{&fileInfo{name: target, mode: fs.ModeSymlink}}, nil
+	}
+	return nil, sftp.ErrSSHFxOpUnsupported
+}
+
+// Lstat stats a file without following symlinks
+func (f *execFS) Lstat(r *sftp.Request) (sftp.ListerAt, error) {
+	return f.stat(r.Filepath, "")
+}
+
+// stat runs stat(1) on a single file with an optional flag
+func (f *execFS) stat(path, flag string) (sftp.ListerAt, error) {
+	if err := f.flush(path); err != nil {
+		return nil, err
+	}
+	out, err := f.run(`if [ -n "$2" ]; then exec stat "$2" -c "$3" -- "$1"; fi; exec stat -c "$3" -- "$1"`, nil, path, flag, statFormat)
+	if err != nil {
+		return nil, err
+	}
+	return parseStats(out)
+}
+
+// parseStats parses the lines stat(1) printed in statFormat
+func parseStats(out []byte) (listerAt, error) {
+	var files listerAt
+	for _, line := range strings.Split(strings.TrimSuffix(string(out), "\n"), "\n") {
+		if line == "" {
+			continue
+		}
+		fields := strings.SplitN(line, " ", 6)
+		if len(fields) != 6 {
+			return nil, fmt.Errorf("unexpected stat output %q", line)
+		}
+		var values [5]uint64
+		for i, field := range fields[:5] {
+			base := 10
+			if i == 0 {
+				base = 16
+			}
+			value, err := strconv.ParseUint(field, base, 64)
+			if err != nil {
+				return nil, fmt.Errorf("unexpected stat output %q", line)
+			}
+			values[i] = value
+		}
+		files = append(files, &fileInfo{
+			name:  fields[5][strings.LastIndexByte(fields[5], '/')+1:],
+			mode:  unixFileMode(uint32(values[0])),
+			size:  int64(values[1]),
+			mtime: time.Unix(int64(values[2]), 0),
+			uid:   uint32(values[3]),
+			gid:   uint32(values[4]),
+		})
+	}
+	return files, nil
+}
+
+// unixFileMode converts a Unix st_mode to an os.FileMode
+func unixFileMode(mode uint32) os.FileMode {
+	fileMode := os.FileMode(mode & 0777)
+	switch mode & 0170000 {
+	case 0040000:
+		fileMode |= os.ModeDir
+	case 0120000:
+		fileMode |= os.ModeSymlink
+	case 0010000:
+		fileMode |= os.ModeNamedPipe
+	case 0140000:
+		fileMode |= os.ModeSocket
+	case 0060000:
+		fileMode |= os.ModeDevice
+	case 0020000:
+		fileMode |= os.ModeDevice | os.ModeCharDevice
+	}
+	if mode&04000 != 0 {
+		fileMode |= os.ModeSetuid
+	}
+	if mode&02000 != 0 {
+		fileMode |= os.ModeSetgid
+	}
+	if mode&01000 != 0 {
+		fileMode |= os.ModeSticky
+	}
+	return fileMode
+}
+
+// fileInfo describes a file in a workspace
+type fileInfo struct {
+	name     string
+	mode     os.FileMode
+	size     int64
+	mtime    time.Time
+	uid, gid uint32
+}
+
+func (fi *fileInfo) Name() string       { return fi.name }
+func (fi *fileInfo) Size() int64        { return fi.size }
+func (fi *fileInfo) Mode() os.FileMode  { return fi.mode }
+func (fi *fileInfo) ModTime() time.Time { return fi.mtime }
+func (fi *fileInfo) IsDir() bool        { return fi.mode.IsDir() }
+func (fi *fileInfo) Sys() interface{}   { return nil }
+func (fi *fileInfo) Uid() uint32        { return fi.uid }
+func (fi *fileInfo) Gid() uint32        { return fi.gid }
+
+// listerAt serves a listing that was read in full
+type listerAt []os.FileInfo
+
+func (l listerAt) ListAt(files []os.FileInfo, offset int64) (int, error) {
+	if offset >= int64(len(l)) {
+		return 0, io.EOF
+	}
+	n := copy(files, l[offset:])
+	if n < len(files) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// execReader reads a file by streaming it from a command, restarting the
+// command when a read falls outside the window around its position
+type execReader struct {
+	fs   *execFS
+	path string
+
+	mu     sync.Mutex
+	cancel context.CancelFunc
+	stream *io.PipeReader
+	wait   func() error
+	pos    int64  // Offset of the next byte the stream returns
+	window []byte // Data read before pos
+	eof    bool
+}
+
+func (r *execReader) ReadAt(p []byte, off int64) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	start := r.pos - int64(len(r.window))
+	if r.stream == nil || off < start || off > r.pos+readWindow {
+		r.restart(off)
+		start = off
+	}
+
+	// Read forward until the requested range is covered
+	buf := make([]byte, 32<<10)
+	for !r.eof && r.pos < off+int64(len(p)) {
+		n, err := r.stream.Read(buf)
+		r.window = append(r.window, buf[:n]...)
+		r.pos += int64(n)
+		if errors.Is(err, io.EOF) {
+			r.eof = true
+		} else if err != nil {
+			return 0, err
+		}
+	}
+
+	var n int
+	if off < r.pos {
+		n = copy(p, r.window[off-start:])
+	}
+
+	// Keep only the end of the window
+	if len(r.window) > readWindow {
+		r.window = append([]byte(nil), r.window[len(r.window)-readWindow:]...)
+	}
+
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// restart streams the file from off; the caller holds the lock
+func (r *execReader) restart(off int64) {
+	r.stop()
+
+	ctx, cancel := context.WithCancel(r.fs.ctx)
+	stream, stdout := io.Pipe()
+	r.cancel = cancel
+	r.stream = stream
+	r.wait = r.fs.start(ctx, `if [ "$2" -eq 0 ]; then exec cat -- "$1"; fi; exec tail -c +"$(($2 + 1))" -- "$1"`,
+		nil, stdout, r.path, strconv.FormatInt(off, 10))
+	r.pos = off
+	r.window = nil
+	r.eof = false
+}
+
+// stop ends the current stream; the caller holds the lock
+func (r *execReader) stop() {
+	if r.stream == nil {
+		return
+	}
+	r.cancel()
+	r.stream.Close()
+	r.wait()
+	r.stream = nil
+}
+
+func (r *execReader) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.stop()
+	return nil
+}
+
+// execWriter writes a file through a command appending its input, holding
+// back writes that arrive ahead of the data before them. The command runs
+// until the file is closed or flushed for another request to see it.
+type execWriter struct {
+	fs   *execFS
+	path string
+
+	mu      sync.Mutex
+	pipe    *io.PipeWriter // Input of the running command, if any
+	cancel  context.CancelFunc
+	wait    func() error
+	pos     int64 // Offset the next write to the command lands at
+	pending map[int64][]byte
+	held    int
+	err     error
+}
+
+func (w *execWriter) WriteAt(p []byte, off int64) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.err != nil {
+		return 0, w.err
+	}
+	switch {
+	case off < w.pos:
+		w.err = sftp.ErrSSHFxOpUnsupported
+		return 0, w.err
+	case off > w.pos:
+		if w.held+len(p) > maxPendingWrite {
+			w.err = sftp.ErrSSHFxOpUnsupported
+			return 0, w.err
+		}
+		w.pending[off] = append([]byte(nil), p...)
+		w.held += len(p)
+		return len(p), nil
+	}
+
+	if err := w.write(p); err != nil {
+		return 0, err
+	}
+
+	// Flush held back writes that are now contiguous
+	for {
+		data, ok := w.pending[w.pos]
+		if !ok {
+			break
+		}
+		delete(w.pending, w.pos)
+		w.held -= len(data)
+		if err := w.write(data); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+// write passes data to the command, starting one if none runs; the caller
+// holds the lock
+func (w *execWriter) write(data []byte) error {
+	if w.pipe == nil {
+		ctx, cancel := context.WithCancel(w.fs.ctx)
+		stdin, pipe := io.Pipe()
+		w.pipe = pipe
+		w.cancel = cancel
+		w.wait = w.fs.start(ctx, `exec cat >> "$1"`, stdin, nil, w.path)
+	}
+	if _, err := w.pipe.Write(data); err != nil {
+		w.err = err
+		return err
+	}
+	w.pos += int64(len(data))
+	return nil
+}
+
+// flush ends the command so the data written reaches the file; the caller
+// holds the lock
+func (w *execWriter) flush() error {
+	if w.pipe == nil {
+		return w.err
+	}
+	w.pipe.Close()
+	err := w.wait()
+	w.cancel()
+	w.pipe = nil
+	if err != nil && w.err == nil {
+		w.err = err
+	}
+	return w.err
+}
+
+func (w *execWriter) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.fs.mu.Lock()
+	delete(w.fs.writers, w)
+	w.fs.mu.Unlock()
+
+	if err := w.flush(); err != nil {
+		return err
+	}
+	if len(w.pending) > 0 {
+		return fmt.Errorf("write left a gap before offset %d", w.pos)
+	}
+	return nil
+}
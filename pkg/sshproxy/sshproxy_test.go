@@ -0,0 +1,391 @@
+package sshproxy_test
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/pkg/sftp"
+	"github.com/rahulvramesh/justup/pkg/database"
+	"github.com/rahulvramesh/justup/pkg/kubernetes"
+	"github.com/rahulvramesh/justup/pkg/sshproxy"
+	"github.com/rahulvramesh/justup/pkg/sshproxy/sshproxytest"
+	"golang.org/x/crypto/ssh"
+)
+
+const workspaceName = "myproject"
+
+// backends are the ways sessions reach workspaces, which should behave the
+// same to clients
+var backends = []string{sshproxy.BackendSSH, sshproxy.BackendExec}
+
+// newProxy starts a proxy with config serving one workspace, owned by a
+// user whose key is returned
+func newProxy(t *testing.T, config sshproxy.Config) (*sshproxytest.Proxy, *sshproxytest.Upstream, ssh.Signer) {
+	t.Helper()
+
+	upstream := sshproxytest.NewUpstream(t.TempDir())
+	t.Cleanup(func() { upstream.Close() })
+	proxy := sshproxytest.NewProxy(config)
+	t.Cleanup(func() { proxy.Close() })
+
+	proxy.Cluster.AddWorkspace(workspaceName, upstream)
+	return proxy, upstream, proxy.AddUser("alice", workspaceName)
+}
+
+// dial connects to the workspace through the proxy
+func dial(t *testing.T, proxy *sshproxytest.Proxy, signer ssh.Signer) *ssh.Client {
+	t.Helper()
+
+	client, err := proxy.Dial(workspaceName, signer)
+	if err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+	t.Cleanup(func() { client.Close() })
+	return client
+}
+
+// listenEcho starts a server on the loopback interface echoing what it
+// reads, returning its port
+func listenEcho(t *testing.T) int {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				io.Copy(conn, conn)
+			}()
+		}
+	}()
+	return listener.Addr().(*net.TCPAddr).Port
+}
+
+// roundTrip writes a line to conn and checks that it comes back
+func roundTrip(t *testing.T, conn net.Conn) {
+	t.Helper()
+	defer conn.Close()
+
+	if _, err := io.WriteString(conn, "ping\n"); err != nil {
+		t.Fatalf("failed to write: %v", err)
+	}
+	buf := make([]byte, 5)
+	if _, err := io.ReadFull(conn, buf); err != nil {
+		t.Fatalf("failed to read: %v", err)
+	}
+	if string(buf) != "ping\n" {
+		t.Errorf("read %q, want %q", buf, "ping\n")
+	}
+}
+
+func TestExec(t *testing.T) {
+	for _, backend := range backends {
+		t.Run(backend, func(t *testing.T) {
+			proxy, _, signer := newProxy(t, sshproxy.Config{Backend: backend})
+			client := dial(t, proxy, signer)
+
+			session, err := client.NewSession()
+			if err != nil {
+				t.Fatalf("failed to open session: %v", err)
+			}
+			defer session.Close()
+
+			if err := session.Setenv("GREETING", "hello"); err != nil {
+				t.Fatalf("failed to set environment: %v", err)
+			}
+			var stdout, stderr bytes.Buffer
+			session.Stdout = &stdout
+			session.Stderr = &stderr
+
+			err = session.Run(`echo "$GREETING"; echo oops >&2; exit 3`)
+			var exitErr *ssh.ExitError
+			if !errors.As(err, &exitErr) || exitErr.ExitStatus() != 3 {
+				t.Fatalf("got error %v, want exit status 3", err)
+			}
+			if stdout.String() != "hello\n" {
+				t.Errorf("stdout is %q, want %q", stdout.String(), "hello\n")
+			}
+			if stderr.String() != "oops\n" {
+				t.Errorf("stderr is %q, want %q", stderr.String(), "oops\n")
+			}
+		})
+	}
+}
+
+func TestShell(t *testing.T) {
+	for _, backend := range backends {
+		t.Run(backend, func(t *testing.T) {
+			proxy, _, signer := newProxy(t, sshproxy.Config{Backend: backend})
+			client := dial(t, proxy, signer)
+
+			session, err := client.NewSession()
+			if err != nil {
+				t.Fatalf("failed to open session: %v", err)
+			}
+			defer session.Close()
+
+			var stdout bytes.Buffer
+			session.Stdout = &stdout
+			session.Stdin = strings.NewReader("echo shell-$((40 + 2))\nexit 0\n")
+			if err := session.Shell(); err != nil {
+				t.Fatalf("failed to start shell: %v", err)
+			}
+			if err := session.Wait(); err != nil {
+				t.Fatalf("shell failed: %v", err)
+			}
+			if !strings.Contains(stdout.String(), "shell-42\n") {
+				t.Errorf("shell output %q lacks %q", stdout.String(), "shell-42")
+			}
+		})
+	}
+}
+
+func TestSFTP(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  sshproxy.Config
+		refuse  bool
+		byProxy bool
+		fails   bool
+	}{
+		{name: "upstream", config: sshproxy.Config{SFTP: sshproxy.SFTPUpstream}},
+		{name: "upstream refused", config: sshproxy.Config{SFTP: sshproxy.SFTPUpstream}, refuse: true, fails: true},
+		{name: "proxy", config: sshproxy.Config{SFTP: sshproxy.SFTPProxy}, byProxy: true},
+		{name: "fallback to upstream", config: sshproxy.Config{SFTP: sshproxy.SFTPFallback}},
+		{name: "fallback to proxy", config: sshproxy.Config{SFTP: sshproxy.SFTPFallback}, refuse: true, byProxy: true},
+		{name: "exec backend", config: sshproxy.Config{Backend: sshproxy.BackendExec}, refuse: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			proxy, upstream, signer := newProxy(t, tt.config)
+			upstream.RefuseSFTP(tt.refuse)
+			client := dial(t, proxy, signer)
+
+			sftpClient, err := sftp.NewClient(client)
+			if tt.fails {
+				if err == nil {
+					sftpClient.Close()
+					t.Fatal("sftp subsystem started, want it refused")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("failed to start sftp: %v", err)
+			}
+			defer sftpClient.Close()
+
+			f, err := sftpClient.Create("notes.txt")
+			if err != nil {
+				t.Fatalf("failed to create file: %v", err)
+			}
+			if _, err := f.Write([]byte("first line\n")); err != nil {
+				t.Fatalf("failed to write file: %v", err)
+			}
+			if err := f.Close(); err != nil {
+				t.Fatalf("failed to close file: %v", err)
+			}
+
+			data, err := os.ReadFile(filepath.Join(upstream.Dir, "notes.txt"))
+			if err != nil || string(data) != "first line\n" {
+				t.Fatalf("workspace has %q (%v), want %q", data, err, "first line\n")
+			}
+
+			if err := sftpClient.Rename("notes.txt", "renamed.txt"); err != nil {
+				t.Fatalf("failed to rename file: %v", err)
+			}
+			entries, err := sftpClient.ReadDir(".")
+			if err != nil {
+				t.Fatalf("failed to list directory: %v", err)
+			}
+			if len(entries) != 1 || entries[0].Name() != "renamed.txt" || entries[0].Size() != int64(len(data)) {
+				t.Errorf("listing is %v, want renamed.txt of %d bytes", entries, len(data))
+			}
+
+			r, err := sftpClient.Open("renamed.txt")
+			if err != nil {
+				t.Fatalf("failed to open file: %v", err)
+			}
+			read, err := io.ReadAll(r)
+			r.Close()
+			if err != nil || string(read) != "first line\n" {
+				t.Errorf("read %q (%v), want %q", read, err, "first line\n")
+			}
+
+			if err := sftpClient.Remove("renamed.txt"); err != nil {
+				t.Fatalf("failed to remove file: %v", err)
+			}
+			if _, err := os.Stat(filepath.Join(upstream.Dir, "renamed.txt")); !os.IsNotExist(err) {
+				t.Errorf("removed file still exists: %v", err)
+			}
+
+			// The exec backend serves every session in the proxy
+			if tt.config.Backend != sshproxy.BackendExec {
+				if byProxy := servedByProxy(t, proxy); byProxy != tt.byProxy {
+					t.Errorf("served by the proxy is %v, want %v", byProxy, tt.byProxy)
+				}
+			}
+		})
+	}
+}
+
+// servedByProxy reports whether the audit trail shows the proxy serving
+// sftp itself
+func servedByProxy(t *testing.T, proxy *sshproxytest.Proxy) bool {
+	t.Helper()
+
+	events, err := proxy.DB.ListAuditEvents(database.AuditFilter{Workspace: workspaceName, Event: database.AuditSubsystem})
+	if err != nil {
+		t.Fatalf("failed to list audit events: %v", err)
+	}
+	for _, event := range events {
+		if strings.Contains(event.Detail, "served by the proxy") {
+			return true
+		}
+	}
+	return false
+}
+
+func TestRemoteForwardReplyPayload(t *testing.T) {
+	proxy, _, signer := newProxy(t, sshproxy.Config{})
+	client := dial(t, proxy, signer)
+
+	// Listening on port 0 only works if the workspace's reply, carrying the
+	// port it chose, reaches the client
+	listener, err := client.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen in the workspace: %v", err)
+	}
+	defer listener.Close()
+
+	port := listener.Addr().(*net.TCPAddr).Port
+	if port == 0 {
+		t.Fatal("reply carried no port")
+	}
+
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		io.Copy(conn, conn)
+	}()
+
+	conn, err := net.Dial("tcp", net.JoinHostPort("127.0.0.1", strconv.Itoa(port)))
+	if err != nil {
+		t.Fatalf("failed to connect to the remote forward: %v", err)
+	}
+	roundTrip(t, conn)
+}
+
+func TestForwardingPolicy(t *testing.T) {
+	allowed := listenEcho(t)
+	other := listenEcho(t)
+
+	proxy, _, signer := newProxy(t, sshproxy.Config{})
+	proxy.Cluster.SetForwarding(workspaceName, &kubernetes.WorkspaceForwarding{
+		Local:  []string{strconv.Itoa(allowed)},
+		Remote: []string{},
+	})
+	client := dial(t, proxy, signer)
+
+	t.Run("allowed port", func(t *testing.T) {
+		conn, err := client.Dial("tcp", net.JoinHostPort("127.0.0.1", strconv.Itoa(allowed)))
+		if err != nil {
+			t.Fatalf("forward refused: %v", err)
+		}
+		roundTrip(t, conn)
+	})
+
+	t.Run("other port", func(t *testing.T) {
+		if conn, err := client.Dial("tcp", net.JoinHostPort("127.0.0.1", strconv.Itoa(other))); err == nil {
+			conn.Close()
+			t.Fatal("forward to a port off the allowlist was permitted")
+		}
+	})
+
+	t.Run("other host", func(t *testing.T) {
+		if conn, err := client.Dial("tcp", net.JoinHostPort("192.0.2.1", strconv.Itoa(allowed))); err == nil {
+			conn.Close()
+			t.Fatal("forward to a host other than localhost was permitted")
+		}
+	})
+
+	t.Run("remote", func(t *testing.T) {
+		if listener, err := client.Listen("tcp", "127.0.0.1:0"); err == nil {
+			listener.Close()
+			t.Fatal("remote forward was permitted with an empty allowlist")
+		}
+	})
+}
+
+func TestForwardingDisabled(t *testing.T) {
+	port := listenEcho(t)
+
+	for _, backend := range backends {
+		t.Run(backend, func(t *testing.T) {
+			proxy, _, signer := newProxy(t, sshproxy.Config{Backend: backend, DisableForwarding: true})
+			client := dial(t, proxy, signer)
+
+			if conn, err := client.Dial("tcp", net.JoinHostPort("127.0.0.1", strconv.Itoa(port))); err == nil {
+				conn.Close()
+				t.Fatal("local forward was permitted")
+			}
+			if listener, err := client.Listen("tcp", "127.0.0.1:0"); err == nil {
+				listener.Close()
+				t.Fatal("remote forward was permitted")
+			}
+		})
+	}
+}
+
+func TestReadOnlyForwarding(t *testing.T) {
+	port := listenEcho(t)
+
+	for _, backend := range backends {
+		t.Run(backend, func(t *testing.T) {
+			proxy, _, _ := newProxy(t, sshproxy.Config{Backend: backend})
+			signer := proxy.AddUser("bob")
+			bob, err := proxy.DB.GetUserByUsername("bob")
+			if err != nil {
+				t.Fatalf("failed to get user: %v", err)
+			}
+			if err := proxy.DB.GrantWorkspaceAccess(workspaceName, bob.ID, database.RoleReadOnly); err != nil {
+				t.Fatalf("failed to share workspace: %v", err)
+			}
+			client := dial(t, proxy, signer)
+
+			if session, err := client.NewSession(); err == nil {
+				session.Close()
+				t.Fatal("read-only user opened a session")
+			}
+			if listener, err := client.Listen("tcp", "127.0.0.1:0"); err == nil {
+				listener.Close()
+				t.Fatal("read-only user opened a remote forward")
+			}
+
+			conn, err := client.Dial("tcp", net.JoinHostPort("127.0.0.1", strconv.Itoa(port)))
+			if err != nil {
+				t.Fatalf("read-only user's local forward refused: %v", err)
+			}
+			roundTrip(t, conn)
+		})
+	}
+}
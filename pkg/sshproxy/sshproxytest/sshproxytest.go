@@ -0,0 +1,363 @@
+// Package sshproxytest runs the SSH proxy in-process against stand-in
+// workspaces, each an Upstream SSH server, for integration testing in the
+// manner of net/http/httptest
+package sshproxytest
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/rahulvramesh/justup/pkg/database"
+	"github.com/rahulvramesh/justup/pkg/kubernetes"
+	"github.com/rahulvramesh/justup/pkg/sshproxy"
+	"golang.org/x/crypto/ssh"
+	utilexec "k8s.io/client-go/util/exec"
+)
+
+// Proxy is an SSH proxy listening on a local port, with its state in a
+// temporary directory and its workspaces in a Cluster
+type Proxy struct {
+	// Addr is the address the proxy listens on
+	Addr string
+	// HostKey is the proxy's host key
+	HostKey ssh.PublicKey
+	// Cluster holds the proxy's workspaces
+	Cluster *Cluster
+	// DB is a handle on the proxy's database
+	DB *database.DB
+
+	dir    string
+	cancel context.CancelFunc
+	done   chan error
+}
+
+// NewProxy starts a proxy with config, whose paths, listen address,
+// cluster and dialer are filled in. Callers should Close it when done.
+func NewProxy(config sshproxy.Config) *Proxy {
+	dir, err := os.MkdirTemp("", "sshproxytest")
+	if err != nil {
+		panic(fmt.Sprintf("sshproxytest: failed to create directory: %v", err))
+	}
+
+	cluster := NewCluster()
+	config.HostKeyPath = filepath.Join(dir, "ssh_host_ed25519_key")
+	config.ClientKeyPath = filepath.Join(dir, "proxy_client_ed25519_key")
+	config.DatabasePath = filepath.Join(dir, "justup.db")
+	config.Cluster = cluster
+	config.Dial = cluster.Dial
+
+	server, err := sshproxy.NewServer(&config)
+	if err != nil {
+		panic(fmt.Sprintf("sshproxytest: failed to create proxy: %v", err))
+	}
+
+	hostKeyPEM, err := os.ReadFile(config.HostKeyPath)
+	if err != nil {
+		panic(fmt.Sprintf("sshproxytest: failed to read host key: %v", err))
+	}
+	hostKey, err := ssh.ParsePrivateKey(hostKeyPEM)
+	if err != nil {
+		panic(fmt.Sprintf("sshproxytest: failed to parse host key: %v", err))
+	}
+
+	db, err := database.Open(config.DatabasePath)
+	if err != nil {
+		panic(fmt.Sprintf("sshproxytest: failed to open database: %v", err))
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		panic(fmt.Sprintf("sshproxytest: failed to listen: %v", err))
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	p := &Proxy{
+		Addr:    listener.Addr().String(),
+		HostKey: hostKey.PublicKey(),
+		Cluster: cluster,
+		DB:      db,
+		dir:     dir,
+		cancel:  cancel,
+		done:    make(chan error, 1),
+	}
+	go func() {
+		p.done <- server.Serve(ctx, listener)
+	}()
+	return p
+}
+
+// AddUser registers a user owning workspaces, returning the key the user
+// authenticates with
+func (p *Proxy) AddUser(username string, workspaces ...string) ssh.Signer {
+	userID := uuid.New().String()
+	if err := p.DB.CreateUser(userID, username); err != nil {
+		panic(fmt.Sprintf("sshproxytest: failed to create user: %v", err))
+	}
+
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		panic(fmt.Sprintf("sshproxytest: failed to generate key: %v", err))
+	}
+	signer, err := ssh.NewSignerFromKey(key)
+	if err != nil {
+		panic(fmt.Sprintf("sshproxytest: failed to create key signer: %v", err))
+	}
+	err = p.DB.AddSSHKey(&database.SSHKey{
+		ID:          uuid.New().String(),
+		UserID:      userID,
+		Name:        username,
+		PublicKey:   string(ssh.MarshalAuthorizedKey(signer.PublicKey())),
+		Fingerprint: ssh.FingerprintSHA256(signer.PublicKey()),
+		CreatedAt:   time.Now(),
+	})
+	if err != nil {
+		panic(fmt.Sprintf("sshproxytest: failed to add key: %v", err))
+	}
+
+	for _, name := range workspaces {
+		err := p.DB.SaveWorkspace(&database.Workspace{
+			ID:        uuid.New().String(),
+			Name:      name,
+			UserID:    userID,
+			CreatedAt: time.Now(),
+		})
+		if err != nil {
+			panic(fmt.Sprintf("sshproxytest: failed to save workspace: %v", err))
+		}
+	}
+	return signer
+}
+
+// Dial connects to the proxy as user, which names a workspace or the
+// management user, authenticating with signer. The keyboard-interactive
+// round of a proxy that wakes workspaces is answered without prompts.
+func (p *Proxy) Dial(user string, signer ssh.Signer) (*ssh.Client, error) {
+	return ssh.Dial("tcp", p.Addr, &ssh.ClientConfig{
+		User: user,
+		Auth: []ssh.AuthMethod{
+			ssh.PublicKeys(signer),
+			ssh.KeyboardInteractive(func(name, instruction string, questions []string, echos []bool) ([]string, error) {
+				return make([]string, len(questions)), nil
+			}),
+		},
+		HostKeyCallback: ssh.FixedHostKey(p.HostKey),
+		Timeout:         10 * time.Second,
+	})
+}
+
+// Close stops the proxy and removes its state
+func (p *Proxy) Close() error {
+	p.cancel()
+	err := <-p.done
+	p.DB.Close()
+	os.RemoveAll(p.dir)
+	return err
+}
+
+// Cluster is an in-memory set of workspaces, each served by an Upstream.
+// Commands run through the exec API run locally in the workspace's Upstream
+// directory.
+type Cluster struct {
+	mu         sync.Mutex
+	workspaces map[string]*workspace
+	proxyKeys  string
+}
+
+type workspace struct {
+	kubernetes.Workspace
+	upstream *Upstream
+}
+
+// NewCluster returns an empty cluster
+func NewCluster() *Cluster {
+	return &Cluster{workspaces: make(map[string]*workspace)}
+}
+
+// AddWorkspace adds a running workspace served by upstream
+func (c *Cluster) AddWorkspace(name string, upstream *Upstream) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.workspaces[name] = &workspace{
+		Workspace: kubernetes.Workspace{
+			Name:    name,
+			Status:  kubernetes.PhaseRunning,
+			PodIP:   fmt.Sprintf("10.0.0.%d", len(c.workspaces)+1),
+			HostKey: string(ssh.MarshalAuthorizedKey(upstream.HostKey.PublicKey())),
+		},
+		upstream: upstream,
+	}
+}
+
+// SetForwarding sets a workspace's port forwarding policy
+func (c *Cluster) SetForwarding(name string, forwarding *kubernetes.WorkspaceForwarding) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if ws, ok := c.workspaces[name]; ok {
+		ws.Forwarding = forwarding
+	}
+}
+
+// ProxyKeys returns the authorized keys the proxy last published
+func (c *Cluster) ProxyKeys() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.proxyKeys
+}
+
+func (c *Cluster) lookup(name string) (*workspace, error) {
+	ws, ok := c.workspaces[name]
+	if !ok {
+		return nil, fmt.Errorf("workspace '%s' not found", name)
+	}
+	return ws, nil
+}
+
+// GetWorkspace returns a workspace
+func (c *Cluster) GetWorkspace(ctx context.Context, name string) (*kubernetes.Workspace, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	ws, err := c.lookup(name)
+	if err != nil {
+		return nil, err
+	}
+	workspace := ws.Workspace
+	return &workspace, nil
+}
+
+// ListWorkspaces returns all workspaces by name
+func (c *Cluster) ListWorkspaces(ctx context.Context, includeAll bool) ([]kubernetes.Workspace, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var workspaces []kubernetes.Workspace
+	for _, ws := range c.workspaces {
+		workspaces = append(workspaces, ws.Workspace)
+	}
+	sort.Slice(workspaces, func(i, j int) bool { return workspaces[i].Name < workspaces[j].Name })
+	return workspaces, nil
+}
+
+// ListWorkspaceResources returns no resources, so idle shutdown never
+// applies
+func (c *Cluster) ListWorkspaceResources(ctx context.Context) ([]kubernetes.WorkspaceResource, error) {
+	return nil, nil
+}
+
+// StartWorkspace marks a workspace running
+func (c *Cluster) StartWorkspace(ctx context.Context, name string, overrides kubernetes.StartOptions) error {
+	return c.setStatus(name, kubernetes.PhaseRunning)
+}
+
+// StopWorkspace marks a workspace stopped
+func (c *Cluster) StopWorkspace(ctx context.Context, name string) error {
+	return c.setStatus(name, kubernetes.PhaseStopped)
+}
+
+func (c *Cluster) setStatus(name, status string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	ws, err := c.lookup(name)
+	if err != nil {
+		return err
+	}
+	ws.Status = status
+	return nil
+}
+
+// RecordWorkspaceActivity does nothing
+func (c *Cluster) RecordWorkspaceActivity(ctx context.Context, name string, at time.Time) error {
+	return nil
+}
+
+// PublishProxyKeys records the proxy's authorized keys
+func (c *Cluster) PublishProxyKeys(ctx context.Context, authorizedKeys string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.proxyKeys = authorizedKeys
+	return nil
+}
+
+// Exec runs a command locally in the workspace's Upstream directory. A TTY
+// is not allocated.
+func (c *Cluster) Exec(ctx context.Context, name string, opts kubernetes.ExecOptions) error {
+	c.mu.Lock()
+	ws, err := c.lookup(name)
+	c.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	if len(opts.Command) == 0 {
+		return errors.New("no command")
+	}
+
+	cmd := exec.CommandContext(ctx, opts.Command[0], opts.Command[1:]...)
+	cmd.Dir = ws.upstream.Dir
+	cmd.Stdout = opts.Stdout
+	cmd.Stderr = opts.Stderr
+	if opts.TTY {
+		cmd.Stderr = opts.Stdout
+	}
+
+	status := runWithInput(cmd, opts.Stdin)
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	if status != 0 {
+		return utilexec.CodeExitError{Err: fmt.Errorf("command terminated with exit code %d", status), Code: status}
+	}
+	return nil
+}
+
+// DialPort connects to a local port, standing in for the port on the
+// workspace's loopback interface
+func (c *Cluster) DialPort(ctx context.Context, name string, port int) (io.ReadWriteCloser, error) {
+	c.mu.Lock()
+	_, err := c.lookup(name)
+	c.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	var dialer net.Dialer
+	return dialer.DialContext(ctx, "tcp", net.JoinHostPort("127.0.0.1", strconv.Itoa(port)))
+}
+
+// Dial connects to the Upstream of the workspace with the IP in addr
+func (c *Cluster) Dial(ctx context.Context, network, addr string) (net.Conn, error) {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	var upstream *Upstream
+	for _, ws := range c.workspaces {
+		if ws.PodIP == host {
+			upstream = ws.upstream
+		}
+	}
+	c.mu.Unlock()
+	if upstream == nil {
+		return nil, fmt.Errorf("no workspace at %s", host)
+	}
+
+	var dialer net.Dialer
+	return dialer.DialContext(ctx, network, upstream.Addr)
+}
@@ -0,0 +1,347 @@
+package sshproxytest
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"sync"
+	"sync/atomic"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+)
+
+// Upstream is a stand-in for a workspace's SSH server. It accepts any
+// public key, runs commands with sh(1) in Dir without a terminal, serves
+// the sftp subsystem from Dir and connects local and remote port forwards
+// on the loopback interface.
+type Upstream struct {
+	// Addr is the address the server listens on
+	Addr string
+	// Dir is where commands run and relative SFTP paths resolve
+	Dir string
+	// HostKey is the server's host key
+	HostKey ssh.Signer
+
+	config   *ssh.ServerConfig
+	listener net.Listener
+	noSFTP   atomic.Bool
+	wg       sync.WaitGroup
+}
+
+// NewUpstream starts a server working in dir. Callers should Close it when
+// done.
+func NewUpstream(dir string) *Upstream {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		panic(fmt.Sprintf("sshproxytest: failed to generate host key: %v", err))
+	}
+	hostKey, err := ssh.NewSignerFromKey(key)
+	if err != nil {
+		panic(fmt.Sprintf("sshproxytest: failed to create host key signer: %v", err))
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		panic(fmt.Sprintf("sshproxytest: failed to listen: %v", err))
+	}
+
+	u := &Upstream{
+		Addr:     listener.Addr().String(),
+		Dir:      dir,
+		HostKey:  hostKey,
+		listener: listener,
+		config: &ssh.ServerConfig{
+			PublicKeyCallback: func(ssh.ConnMetadata, ssh.PublicKey) (*ssh.Permissions, error) {
+				return nil, nil
+			},
+		},
+	}
+	u.config.AddHostKey(hostKey)
+
+	u.wg.Add(1)
+	go u.serve()
+	return u
+}
+
+// RefuseSFTP makes the server refuse the sftp subsystem, like an SSH server
+// without sftp-server installed
+func (u *Upstream) RefuseSFTP(refuse bool) {
+	u.noSFTP.Store(refuse)
+}
+
+// Close stops accepting connections
+func (u *Upstream) Close() error {
+	err := u.listener.Close()
+	u.wg.Wait()
+	return err
+}
+
+func (u *Upstream) serve() {
+	defer u.wg.Done()
+	for {
+		conn, err := u.listener.Accept()
+		if err != nil {
+			return
+		}
+		go u.handleConnection(conn)
+	}
+}
+
+func (u *Upstream) handleConnection(netConn net.Conn) {
+	defer netConn.Close()
+
+	conn, chans, reqs, err := ssh.NewServerConn(netConn, u.config)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	go handleGlobalRequests(conn, reqs)
+
+	for newChan := range chans {
+		switch newChan.ChannelType() {
+		case "session":
+			go u.handleSession(newChan)
+		case "direct-tcpip":
+			go handleDirectTCPIP(newChan)
+		default:
+			newChan.Reject(ssh.UnknownChannelType, "unsupported channel type")
+		}
+	}
+}
+
+// handleSession runs the shell, command or subsystem a session asks for
+func (u *Upstream) handleSession(newChan ssh.NewChannel) {
+	ch, reqs, err := newChan.Accept()
+	if err != nil {
+		return
+	}
+	defer ch.Close()
+
+	var env []string
+	for req := range reqs {
+		switch req.Type {
+		case "env":
+			var kv struct{ Name, Value string }
+			if ssh.Unmarshal(req.Payload, &kv) == nil {
+				env = append(env, kv.Name+"="+kv.Value)
+			}
+			req.Reply(true, nil)
+		case "pty-req", "window-change":
+			req.Reply(true, nil)
+		case "shell", "exec":
+			var command struct{ Command string }
+			if req.Type == "exec" && ssh.Unmarshal(req.Payload, &command) != nil {
+				req.Reply(false, nil)
+				continue
+			}
+			req.Reply(true, nil)
+			go ssh.DiscardRequests(reqs)
+			exitStatus(ch, u.run(ch, command.Command, env))
+			return
+		case "subsystem":
+			var subsystem struct{ Name string }
+			if ssh.Unmarshal(req.Payload, &subsystem) != nil || subsystem.Name != "sftp" || u.noSFTP.Load() {
+				req.Reply(false, nil)
+				continue
+			}
+			req.Reply(true, nil)
+			go ssh.DiscardRequests(reqs)
+			exitStatus(ch, u.serveSFTP(ch))
+			return
+		default:
+			req.Reply(false, nil)
+		}
+	}
+}
+
+// serveSFTP serves the sftp subsystem on a channel, returning its exit
+// status
+func (u *Upstream) serveSFTP(ch ssh.Channel) int {
+	server, err := sftp.NewServer(ch, sftp.WithServerWorkingDirectory(u.Dir))
+	if err != nil {
+		return 1
+	}
+	if err := server.Serve(); err != nil && !errors.Is(err, io.EOF) {
+		return 1
+	}
+	return 0
+}
+
+// run runs a command, or a shell if command is empty, on a channel
+func (u *Upstream) run(ch ssh.Channel, command string, env []string) int {
+	var args []string
+	if command != "" {
+		args = []string{"-c", command}
+	}
+	cmd := exec.Command("sh", args...)
+	cmd.Dir = u.Dir
+	cmd.Env = append(os.Environ(), env...)
+	cmd.Stdout = ch
+	cmd.Stderr = ch.Stderr()
+	return runWithInput(cmd, ch)
+}
+
+// runWithInput runs a command with input copied from stdin, returning its
+// exit status. Unlike setting cmd.Stdin, the command's exit does not wait
+// for stdin to be closed.
+func runWithInput(cmd *exec.Cmd, stdin io.Reader) int {
+	if stdin != nil {
+		pipe, err := cmd.StdinPipe()
+		if err != nil {
+			return 255
+		}
+		go func() {
+			io.Copy(pipe, stdin)
+			pipe.Close()
+		}()
+	}
+	err := cmd.Run()
+	var exitErr *exec.ExitError
+	switch {
+	case err == nil:
+		return 0
+	case errors.As(err, &exitErr):
+		return exitErr.ExitCode()
+	default:
+		return 255
+	}
+}
+
+func exitStatus(ch ssh.Channel, status int) {
+	ch.SendRequest("exit-status", false, ssh.Marshal(struct{ Status uint32 }{uint32(status)}))
+}
+
+// handleGlobalRequests serves remote port forwards, replying to a
+// tcpip-forward request with the port listened on, and refuses other
+// global requests. Forwards stop with the connection.
+func handleGlobalRequests(conn *ssh.ServerConn, reqs <-chan *ssh.Request) {
+	var mu sync.Mutex
+	listeners := make(map[string]net.Listener)
+	defer func() {
+		mu.Lock()
+		defer mu.Unlock()
+		for _, listener := range listeners {
+			listener.Close()
+		}
+	}()
+
+	for req := range reqs {
+		var forward struct {
+			Addr string
+			Port uint32
+		}
+		switch req.Type {
+		case "tcpip-forward":
+			if ssh.Unmarshal(req.Payload, &forward) != nil {
+				req.Reply(false, nil)
+				continue
+			}
+			listener, err := net.Listen("tcp", net.JoinHostPort("127.0.0.1", strconv.Itoa(int(forward.Port))))
+			if err != nil {
+				req.Reply(false, nil)
+				continue
+			}
+			port := uint32(listener.Addr().(*net.TCPAddr).Port)
+			mu.Lock()
+			listeners[net.JoinHostPort(forward.Addr, strconv.Itoa(int(port)))] = listener
+			mu.Unlock()
+			req.Reply(true, ssh.Marshal(struct{ Port uint32 }{port}))
+			go acceptForwards(conn, listener, forward.Addr, port)
+		case "cancel-tcpip-forward":
+			if ssh.Unmarshal(req.Payload, &forward) != nil {
+				req.Reply(false, nil)
+				continue
+			}
+			key := net.JoinHostPort(forward.Addr, strconv.Itoa(int(forward.Port)))
+			mu.Lock()
+			listener, ok := listeners[key]
+			delete(listeners, key)
+			mu.Unlock()
+			if ok {
+				listener.Close()
+			}
+			req.Reply(ok, nil)
+		default:
+			if req.WantReply {
+				req.Reply(false, nil)
+			}
+		}
+	}
+}
+
+// acceptForwards opens a forwarded-tcpip channel to the client for each
+// connection to a remote forward's listener
+func acceptForwards(conn ssh.Conn, listener net.Listener, addr string, port uint32) {
+	for {
+		local, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			defer local.Close()
+
+			origin := local.RemoteAddr().(*net.TCPAddr)
+			ch, reqs, err := conn.OpenChannel("forwarded-tcpip", ssh.Marshal(struct {
+				Addr       string
+				Port       uint32
+				OriginAddr string
+				OriginPort uint32
+			}{addr, port, origin.IP.String(), uint32(origin.Port)}))
+			if err != nil {
+				return
+			}
+			defer ch.Close()
+			go ssh.DiscardRequests(reqs)
+			pipe(ch, local)
+		}()
+	}
+}
+
+// pipe copies between a channel and a connection until either side is done
+func pipe(ch ssh.Channel, conn net.Conn) {
+	done := make(chan struct{}, 2)
+	go func() {
+		io.Copy(conn, ch)
+		done <- struct{}{}
+	}()
+	go func() {
+		io.Copy(ch, conn)
+		done <- struct{}{}
+	}()
+	<-done
+}
+
+// handleDirectTCPIP connects a local port forward
+func handleDirectTCPIP(newChan ssh.NewChannel) {
+	var target struct {
+		Addr     string
+		Port     uint32
+		OrigAddr string
+		OrigPort uint32
+	}
+	if err := ssh.Unmarshal(newChan.ExtraData(), &target); err != nil {
+		newChan.Reject(ssh.ConnectionFailed, "invalid forward")
+		return
+	}
+	conn, err := net.Dial("tcp", net.JoinHostPort(target.Addr, strconv.Itoa(int(target.Port))))
+	if err != nil {
+		newChan.Reject(ssh.ConnectionFailed, err.Error())
+		return
+	}
+	defer conn.Close()
+
+	ch, reqs, err := newChan.Accept()
+	if err != nil {
+		return
+	}
+	defer ch.Close()
+	go ssh.DiscardRequests(reqs)
+	pipe(ch, conn)
+}
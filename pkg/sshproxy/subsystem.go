@@ -0,0 +1,122 @@
+package sshproxy
+
+import (
+	"io"
+	"log"
+	"sync"
+
+	"github.com/rahulvramesh/justup/pkg/database"
+	"golang.org/x/crypto/ssh"
+)
+
+// sessionStart holds back a client session's input until its shell, command
+// or subsystem has started, so that the proxy can serve the session itself
+// instead of the workspace
+type sessionStart struct {
+	once    sync.Once
+	decided chan struct{}
+	byProxy bool
+}
+
+func newSessionStart() *sessionStart {
+	return &sessionStart{decided: make(chan struct{})}
+}
+
+// decide records who serves the session; only the first call counts
+func (s *sessionStart) decide(byProxy bool) {
+	s.once.Do(func() {
+		s.byProxy = byProxy
+		close(s.decided)
+	})
+}
+
+// wait blocks until the session starts, reporting whether the proxy
+// serves it
+func (s *sessionStart) wait() bool {
+	<-s.decided
+	return s.byProxy
+}
+
+// servedByProxy reports whether the session has started in the proxy
+func (s *sessionStart) servedByProxy() bool {
+	select {
+	case <-s.decided:
+		return s.byProxy
+	default:
+		return false
+	}
+}
+
+// isStartRequest reports whether a session request starts what runs in
+// the session
+func isStartRequest(reqType string) bool {
+	return reqType == "shell" || reqType == "exec" || reqType == "subsystem"
+}
+
+// subsystemName returns the name a subsystem request asks for
+func subsystemName(payload []byte) string {
+	var subsystem struct{ Name string }
+	if err := ssh.Unmarshal(payload, &subsystem); err != nil {
+		return ""
+	}
+	return subsystem.Name
+}
+
+// sessionSender returns the function sending a client session's requests
+// to the workspace. The sftp subsystem is served by the proxy instead, over
+// stream, if the proxy's SFTP mode calls for it; later requests on such a
+// session are refused.
+func (p *channelProxy) sessionSender(channel int, clientChan ssh.Channel, stream io.ReadWriteCloser, targetChan ssh.Channel, start *sessionStart) func(reqType string, wantReply bool, payload []byte) (bool, error) {
+	serve := func(reason string) {
+		p.audit.record(database.AuditSubsystem, "channel %d: sftp served by the proxy%s", channel, reason)
+		start.decide(true)
+		targetChan.Close()
+		go func() {
+			status := uint32(0)
+			if err := p.serveSFTP(stream); err != nil {
+				log.Printf("SFTP session on channel %d failed: %v", channel, err)
+				p.audit.record(database.AuditError, "channel %d: sftp: %v", channel, err)
+				status = 1
+			}
+			clientChan.SendRequest("exit-status", false, ssh.Marshal(struct{ Status uint32 }{status}))
+			clientChan.Close()
+		}()
+	}
+
+	return func(reqType string, wantReply bool, payload []byte) (bool, error) {
+		if start.servedByProxy() {
+			return false, nil
+		}
+
+		sftp := reqType == "subsystem" && subsystemName(payload) == "sftp"
+		if sftp && p.sftpMode == SFTPProxy {
+			serve("")
+			return true, nil
+		}
+
+		// Falling back needs the workspace's answer even if the client
+		// does not want one
+		fallback := sftp && p.sftpMode == SFTPFallback
+		ok, err := targetChan.SendRequest(reqType, wantReply || fallback, payload)
+		if err != nil {
+			return false, err
+		}
+		if fallback && !ok {
+			serve(" after the workspace refused it")
+			return true, nil
+		}
+		if isStartRequest(reqType) && (ok || !wantReply) {
+			start.decide(false)
+		}
+		return ok, nil
+	}
+}
+
+// channelStream is the data of a session channel the proxy serves; closing
+// it leaves the channel open to send the exit status
+type channelStream struct {
+	io.Reader
+	io.Writer
+}
+
+func (channelStream) Close() error { return nil }
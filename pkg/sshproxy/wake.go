@@ -0,0 +1,161 @@
+package sshproxy
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"time"
+
+	"github.com/rahulvramesh/justup/pkg/database"
+	"github.com/rahulvramesh/justup/pkg/kubernetes"
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	// wakePollInterval is how often a waking workspace's status is checked
+	wakePollInterval = 2 * time.Second
+	// wakeProgressInterval is how often progress is reported to the client
+	// while the workspace status is unchanged
+	wakeProgressInterval = 10 * time.Second
+)
+
+// needsWake reports whether a connection to the workspace has to start it
+// first. Workspaces that are not found may still be restorable from a kept
+// volume, so they are woken too; the start fails if there is nothing to
+// restore.
+func (s *Server) needsWake(ctx context.Context, workspaceName string) bool {
+	if s.config.WakeTimeout <= 0 {
+		return false
+	}
+	ws, err := s.k8sClient.GetWorkspace(ctx, workspaceName)
+	return err != nil || ws.Status != kubernetes.PhaseRunning
+}
+
+// wakeCallback returns a keyboard-interactive callback that completes
+// authentication with perms at once if the workspace is running. Otherwise
+// it starts the workspace, reports progress to the client as instructions
+// without prompts, and completes authentication once the workspace accepts
+// SSH connections.
+func (s *Server) wakeCallback(workspaceName string, perms *ssh.Permissions) func(ssh.ConnMetadata, ssh.KeyboardInteractiveChallenge) (*ssh.Permissions, error) {
+	return func(conn ssh.ConnMetadata, challenge ssh.KeyboardInteractiveChallenge) (*ssh.Permissions, error) {
+		if !s.needsWake(context.Background(), workspaceName) {
+			return perms, nil
+		}
+
+		say := func(format string, args ...interface{}) {
+			// Progress is best effort; the client may not display it
+			challenge("", fmt.Sprintf(format, args...), nil, nil)
+		}
+
+		// Starting a workspace needs the role 'justup start' needs
+		if perms.Extensions["role"] == database.RoleReadOnly {
+			log.Printf("Not waking workspace '%s' for read-only user %s", workspaceName, perms.Extensions["user-id"])
+			err := fmt.Errorf("workspace is stopped and read-only access cannot start it")
+			s.auditAuth(conn, perms.Extensions["user-id"], perms.Extensions["key-name"], err)
+			s.metrics.authFailed(authAccessDenied)
+			say("Workspace '%s' is stopped, and read-only access cannot start it; ask its owner or a collaborator to start it.", workspaceName)
+			return nil, err
+		}
+
+		// The handshake may last as long as the workspace takes to start
+		s.handshakes.extend(conn.RemoteAddr(), s.config.HandshakeTimeout+s.config.WakeTimeout)
+
+		ctx, cancel := context.WithTimeout(context.Background(), s.config.WakeTimeout)
+		defer cancel()
+
+		if err := s.wakeWorkspace(ctx, workspaceName, say); err != nil {
+			log.Printf("Failed to wake workspace '%s': %v", workspaceName, err)
+			s.metrics.authFailed(authWakeFailed)
+			say("Failed to start workspace '%s': %v", workspaceName, err)
+			return nil, err
+		}
+
+		say("Workspace '%s' is running.", workspaceName)
+		return perms, nil
+	}
+}
+
+// wakeWorkspace starts a workspace unless it is already starting, then waits
+// until it is running and ready for sessions
+func (s *Server) wakeWorkspace(ctx context.Context, workspaceName string, say func(string, ...interface{})) error {
+	status := ""
+	if ws, err := s.k8sClient.GetWorkspace(ctx, workspaceName); err == nil {
+		status = ws.Status
+	}
+
+	switch status {
+	case kubernetes.PhaseRunning, kubernetes.PhaseStarting:
+		say("Waiting for workspace '%s' to start...", workspaceName)
+	case kubernetes.PhaseFailed, kubernetes.StatusOrphaned:
+		return fmt.Errorf("workspace is %s; check it with 'justup list'", status)
+	default:
+		log.Printf("Waking workspace '%s' (status: %s)", workspaceName, status)
+		say("Workspace '%s' is stopped; starting it...", workspaceName)
+
+		if err := s.k8sClient.StartWorkspace(ctx, workspaceName, kubernetes.StartOptions{}); err != nil {
+			// A concurrent connection may have started it first
+			ws, getErr := s.k8sClient.GetWorkspace(ctx, workspaceName)
+			if getErr != nil || (ws.Status != kubernetes.PhaseStarting && ws.Status != kubernetes.PhaseRunning) {
+				return err
+			}
+		} else if err := s.db.MarkWorkspaceStarted(workspaceName); err != nil {
+			log.Printf("Failed to record start of workspace '%s': %v", workspaceName, err)
+		}
+	}
+
+	started := time.Now()
+	lastReport := started
+	ticker := time.NewTicker(wakePollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("timed out after %s waiting for workspace to start", s.config.WakeTimeout)
+		case <-ticker.C:
+		}
+
+		ws, err := s.k8sClient.GetWorkspace(ctx, workspaceName)
+		if err != nil {
+			// The restored resource may not be visible yet
+			continue
+		}
+
+		if ws.Status == kubernetes.PhaseFailed {
+			return fmt.Errorf("workspace failed to start")
+		}
+
+		if ws.Status == kubernetes.PhaseRunning && ws.PodIP != "" && s.workspaceReady(ctx, ws) {
+			log.Printf("Workspace '%s' woke in %s", workspaceName, time.Since(started).Round(time.Second))
+			return nil
+		}
+
+		if time.Since(lastReport) >= wakeProgressInterval {
+			say("Still starting (status: %s, %s elapsed)...", ws.Status, time.Since(started).Round(time.Second))
+			lastReport = time.Now()
+		}
+	}
+}
+
+// workspaceReady reports whether a running workspace accepts sessions. The
+// exec backend needs nothing more than the running pod.
+func (s *Server) workspaceReady(ctx context.Context, ws *kubernetes.Workspace) bool {
+	if s.config.Backend == BackendExec {
+		return true
+	}
+	return ws.HostKey != "" && s.sshReady(ctx, ws.PodIP)
+}
+
+// sshReady reports whether the workspace's SSH server accepts connections;
+// the pod is reported running before sshd listens
+func (s *Server) sshReady(ctx context.Context, podIP string) bool {
+	ctx, cancel := context.WithTimeout(ctx, wakePollInterval)
+	defer cancel()
+	conn, err := s.dial(ctx, "tcp", net.JoinHostPort(podIP, "22"))
+	if err != nil {
+		return false
+	}
+	conn.Close()
+	return true
+}
@@ -0,0 +1,89 @@
+package sshproxy_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/rahulvramesh/justup/pkg/database"
+	"github.com/rahulvramesh/justup/pkg/kubernetes"
+	"github.com/rahulvramesh/justup/pkg/sshproxy"
+	"github.com/rahulvramesh/justup/pkg/sshproxy/sshproxytest"
+)
+
+// workspaceStatus returns the status of the proxy's workspace
+func workspaceStatus(t *testing.T, proxy *sshproxytest.Proxy) string {
+	t.Helper()
+
+	ws, err := proxy.Cluster.GetWorkspace(context.Background(), workspaceName)
+	if err != nil {
+		t.Fatalf("failed to get workspace: %v", err)
+	}
+	return ws.Status
+}
+
+func TestWake(t *testing.T) {
+	for _, backend := range backends {
+		t.Run(backend, func(t *testing.T) {
+			proxy, _, signer := newProxy(t, sshproxy.Config{Backend: backend, WakeTimeout: time.Minute})
+			if err := proxy.Cluster.StopWorkspace(context.Background(), workspaceName); err != nil {
+				t.Fatalf("failed to stop workspace: %v", err)
+			}
+
+			client := dial(t, proxy, signer)
+			if status := workspaceStatus(t, proxy); status != kubernetes.PhaseRunning {
+				t.Errorf("workspace is %s after connecting, want %s", status, kubernetes.PhaseRunning)
+			}
+
+			session, err := client.NewSession()
+			if err != nil {
+				t.Fatalf("failed to open session: %v", err)
+			}
+			defer session.Close()
+			if out, err := session.Output("echo woke"); err != nil || string(out) != "woke\n" {
+				t.Errorf("got output %q (%v), want %q", out, err, "woke\n")
+			}
+
+			record, err := proxy.DB.GetWorkspace(workspaceName)
+			if err != nil {
+				t.Fatalf("failed to get workspace record: %v", err)
+			}
+			if record.LastStartedAt == nil {
+				t.Error("wake was not recorded as a start")
+			}
+		})
+	}
+}
+
+func TestWakeReadOnly(t *testing.T) {
+	proxy, _, _ := newProxy(t, sshproxy.Config{WakeTimeout: time.Minute})
+	signer := proxy.AddUser("bob")
+	bob, err := proxy.DB.GetUserByUsername("bob")
+	if err != nil {
+		t.Fatalf("failed to get user: %v", err)
+	}
+	if err := proxy.DB.GrantWorkspaceAccess(workspaceName, bob.ID, database.RoleReadOnly); err != nil {
+		t.Fatalf("failed to share workspace: %v", err)
+	}
+	if err := proxy.Cluster.StopWorkspace(context.Background(), workspaceName); err != nil {
+		t.Fatalf("failed to stop workspace: %v", err)
+	}
+
+	if client, err := proxy.Dial(workspaceName, signer); err == nil {
+		client.Close()
+		t.Fatal("read-only user connected to a stopped workspace")
+	}
+	if status := workspaceStatus(t, proxy); status != kubernetes.PhaseStopped {
+		t.Errorf("workspace is %s, want it left %s", status, kubernetes.PhaseStopped)
+	}
+
+	// Once someone else starts it, read-only access works as before
+	if err := proxy.Cluster.StartWorkspace(context.Background(), workspaceName, kubernetes.StartOptions{}); err != nil {
+		t.Fatalf("failed to start workspace: %v", err)
+	}
+	client, err := proxy.Dial(workspaceName, signer)
+	if err != nil {
+		t.Fatalf("read-only user failed to connect to a running workspace: %v", err)
+	}
+	client.Close()
+}